@@ -0,0 +1,170 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// AuditSettings turns on the drop audit: one compact record per line the
+// agent discarded, so sampling decisions can be accounted for afterwards
+//
+//	audit:
+//	  enabled: true
+//	  path: /var/lib/stackmonitor-agent/drop-audit.log
+//	  max_size_mb: 10
+type AuditSettings struct {
+	Enabled bool   `yaml:"enabled"`
+	Path    string `yaml:"path"` // default defaultAuditPath
+	// MaxSizeMB bounds the file (default 10); a full file is moved to <path>.1,
+	// replacing the previous one, so at most twice this is kept on disk
+	MaxSizeMB int `yaml:"max_size_mb"`
+}
+
+const (
+	defaultAuditPath      = "/var/lib/stackmonitor-agent/drop-audit.log"
+	defaultAuditMaxSizeMB = 10
+)
+
+// Why a line was dropped
+const (
+	dropSampled       = "sampled"        // lost the sampling draw at its level or content-rule rate
+	dropParseFailed   = "parse_failed"   // no format matched, bad timestamp, or the exec parser failed
+	dropParserDropped = "parser_dropped" // the exec parser answered {}
+)
+
+// auditRecord is one line of the audit file. The message itself is never
+// written, only a hash, so the audit can't leak what sampling was meant to drop.
+type auditRecord struct {
+	Time   string   `json:"time"` // when the line was dropped
+	Source string   `json:"source"`
+	Level  string   `json:"level,omitempty"` // empty when parsing failed before a level was known
+	Reason string   `json:"reason"`
+	Rate   *float64 `json:"rate,omitempty"` // the sampling rate that dropped it
+	Hash   string   `json:"hash"`           // first 16 hex digits of the message's SHA-256 (the raw line when unparsed)
+}
+
+// dropAudit appends audit records. Settings are passed with every record, so
+// a config reload that enables, moves or disables the audit applies at once.
+type dropAudit struct {
+	mu     sync.Mutex
+	file   *os.File
+	path   string
+	size   int64
+	failed bool // last write failed; logged once until a write succeeds
+
+	active  atomic.Bool // file is set; lets the disabled audit skip the lock
+	records atomic.Uint64
+	errors  atomic.Uint64
+}
+
+// auditDrop records a line parseLog discarded; rate is set for sampling drops
+func (a *Agent) auditDrop(cfg *AgentConfig, source, level, reason string, rate *float64, text string) {
+	a.audit.record(cfg.Audit, a.clock.Now(), source, level, reason, rate, text)
+}
+
+func hashMessage(text string) string {
+	sum := sha256.Sum256([]byte(text))
+	return hex.EncodeToString(sum[:8])
+}
+
+// record writes one audit record if the audit is enabled
+func (d *dropAudit) record(settings AuditSettings, now time.Time, source, level, reason string, rate *float64, text string) {
+	if !settings.Enabled && !d.active.Load() {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if !settings.Enabled {
+		d.close()
+		return
+	}
+
+	line, err := json.Marshal(auditRecord{
+		Time: now.UTC().Format(time.RFC3339Nano), Source: source, Level: level,
+		Reason: reason, Rate: rate, Hash: hashMessage(text),
+	})
+	if err != nil {
+		d.fail(err)
+		return
+	}
+	line = append(line, '\n')
+
+	path := settings.Path
+	if path == "" {
+		path = defaultAuditPath
+	}
+	maxBytes := int64(settings.MaxSizeMB) << 20
+	if maxBytes <= 0 {
+		maxBytes = defaultAuditMaxSizeMB << 20
+	}
+	if d.file != nil && d.path != path {
+		d.close()
+	}
+	if d.file != nil && d.size+int64(len(line)) > maxBytes {
+		d.close()
+		if err := os.Rename(path, path+".1"); err != nil {
+			d.fail(err)
+			return
+		}
+	}
+	if d.file == nil {
+		if err := d.open(path); err != nil {
+			d.fail(err)
+			return
+		}
+	}
+
+	n, err := d.file.Write(line)
+	d.size += int64(n)
+	if err != nil {
+		d.fail(err)
+		return
+	}
+	d.records.Add(1)
+	if d.failed {
+		d.failed = false
+		log.Printf("✅ Drop audit writing to %s again", path)
+	}
+}
+
+func (d *dropAudit) open(path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o640)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	d.file, d.path, d.size = f, path, info.Size()
+	d.active.Store(true)
+	return nil
+}
+
+func (d *dropAudit) close() {
+	if d.file != nil {
+		d.file.Close()
+		d.file = nil
+		d.active.Store(false)
+	}
+}
+
+// fail counts a lost record; a failing disk would log on every drop otherwise
+func (d *dropAudit) fail(err error) {
+	d.errors.Add(1)
+	if !d.failed {
+		d.failed = true
+		log.Printf("⚠️ Drop audit record lost: %v", err)
+	}
+}
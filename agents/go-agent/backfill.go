@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultBackfillGlob finds rotated siblings such as application.log.1 and application.log.2.gz
+	defaultBackfillGlob     = "{file}.*"
+	defaultBackfillMaxAge   = 24 * time.Hour
+	defaultBackfillMaxFiles = 5
+	backfillStateFile       = "backfill-state.json"
+)
+
+// backfiller reads rotated (optionally gzipped) copies of a source before it is
+// tailed, recovering lines written while the agent was down. It checkpoints the
+// newest rotated file read per source so a restart doesn't ingest it again.
+type backfiller struct {
+	glob     string        // "{file}" is replaced by the source path
+	maxAge   time.Duration // rotated files last modified before now-maxAge are skipped
+	maxFiles int           // at most this many rotated files per source, newest kept
+	stateDir string
+
+	mu         sync.Mutex
+	checkpoint map[string]int64 // source -> mtime (unix nanos) of the newest rotated file read
+}
+
+func newBackfiller(glob string, maxAge time.Duration, maxFiles int, stateDir string) *backfiller {
+	b := &backfiller{
+		glob:       glob,
+		maxAge:     maxAge,
+		maxFiles:   maxFiles,
+		stateDir:   stateDir,
+		checkpoint: make(map[string]int64),
+	}
+	raw, err := os.ReadFile(filepath.Join(stateDir, backfillStateFile))
+	if err == nil {
+		if err := json.Unmarshal(raw, &b.checkpoint); err != nil {
+			log.Printf("⚠️  Ignoring corrupt backfill state: %v", err)
+			b.checkpoint = make(map[string]int64)
+		}
+	}
+	return b
+}
+
+// rotatedFile is a backfill candidate
+type rotatedFile struct {
+	path    string
+	modTime time.Time
+}
+
+// candidates lists the rotated files of source still to backfill, oldest first
+func (b *backfiller) candidates(source string, now time.Time) ([]rotatedFile, error) {
+	matches, err := filepath.Glob(strings.ReplaceAll(b.glob, "{file}", source))
+	if err != nil {
+		return nil, err
+	}
+
+	b.mu.Lock()
+	done := b.checkpoint[source]
+	b.mu.Unlock()
+
+	var files []rotatedFile
+	for _, path := range matches {
+		if path == source {
+			continue
+		}
+		info, err := os.Stat(path)
+		if err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+		if now.Sub(info.ModTime()) > b.maxAge || info.ModTime().UnixNano() <= done {
+			continue
+		}
+		files = append(files, rotatedFile{path: path, modTime: info.ModTime()})
+	}
+
+	sort.Slice(files, func(i, j int) bool { return files[i].modTime.Before(files[j].modTime) })
+	if len(files) > b.maxFiles {
+		files = files[len(files)-b.maxFiles:]
+	}
+	return files, nil
+}
+
+// fieldBackfill marks entries read from rotated files; the ingestion service
+// does not deduplicate them (DEDUP_BYPASS_PATHS)
+const fieldBackfill = "backfill"
+
+// run backfills every candidate of source through emit, checkpointing after
+// each file; split is the source's record framing
+func (b *backfiller) run(source string, split bufio.SplitFunc, emit func(line string)) {
+	files, err := b.candidates(source, time.Now())
+	if err != nil {
+		log.Printf("Backfill glob for %s failed: %v", source, err)
+		return
+	}
+	for _, f := range files {
+		lines, err := readRotated(f.path, split, emit)
+		if err != nil {
+			// Keep going: a truncated .gz still yields the lines before the damage
+			log.Printf("⚠️  Backfill of %s stopped early: %v", f.path, err)
+		}
+		log.Printf("Backfilled %d lines from %s", lines, f.path)
+		b.markDone(source, f.modTime)
+	}
+}
+
+// readRotated feeds each record of a plain or gzipped file to emit
+func readRotated(path string, split bufio.SplitFunc, emit func(line string)) (int, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	var r io.Reader = file
+	if strings.HasSuffix(path, ".gz") {
+		gz, err := gzip.NewReader(file)
+		if err != nil {
+			return 0, fmt.Errorf("gzip: %w", err)
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Split(split)
+	scanner.Buffer(make([]byte, 64<<10), maxRecordBytes)
+	lines := 0
+	for scanner.Scan() {
+		emit(scanner.Text())
+		lines++
+	}
+	return lines, scanner.Err()
+}
+
+// markDone advances the checkpoint for source and persists it
+func (b *backfiller) markDone(source string, modTime time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if modTime.UnixNano() <= b.checkpoint[source] {
+		return
+	}
+	b.checkpoint[source] = modTime.UnixNano()
+
+	raw, err := json.Marshal(b.checkpoint)
+	if err != nil {
+		return
+	}
+	if err := os.MkdirAll(b.stateDir, 0o755); err != nil {
+		log.Printf("⚠️  Failed to save backfill state: %v", err)
+		return
+	}
+	tmp := filepath.Join(b.stateDir, backfillStateFile+".tmp")
+	if err := os.WriteFile(tmp, raw, 0o644); err != nil {
+		log.Printf("⚠️  Failed to save backfill state: %v", err)
+		return
+	}
+	if err := os.Rename(tmp, filepath.Join(b.stateDir, backfillStateFile)); err != nil {
+		log.Printf("⚠️  Failed to save backfill state: %v", err)
+	}
+}
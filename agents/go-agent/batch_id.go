@@ -0,0 +1,32 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// Batch IDs identify a batch together with the agent ID (acks, in-flight
+// tracking, the ingestion service's logs). A counter starting at 0 on every
+// start reuses IDs after a restart, so by default (BATCH_ID_BASE=time) the
+// counter starts at the start time in milliseconds shifted left by
+// batchIDSeqBits. IDs then keep increasing across restarts unless an agent
+// sends more than 2^20 batches per millisecond of uptime; BATCH_ID_BASE=zero
+// restores counting from 1.
+const (
+	batchIDBaseTime = "time"
+	batchIDBaseZero = "zero"
+
+	batchIDSeqBits = 20
+)
+
+// initialBatchID returns the value the batch counter starts from; the first
+// batch gets the next one
+func initialBatchID(base string, start time.Time) (int64, error) {
+	switch base {
+	case "", batchIDBaseTime:
+		return start.UnixMilli() << batchIDSeqBits, nil
+	case batchIDBaseZero:
+		return 0, nil
+	}
+	return 0, fmt.Errorf("unknown batch ID base %q (want %s or %s)", base, batchIDBaseTime, batchIDBaseZero)
+}
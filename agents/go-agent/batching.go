@@ -0,0 +1,135 @@
+package main
+
+import (
+	"log"
+	"strings"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+	logpb "stackmonitor.com/go-agent/logproto"
+)
+
+// batchSender flushes its buffer when the first of these is reached: the
+// entry count, agent_settings.batch_size_kb of serialized entries, or the
+// agent_settings.batch_window tick. The byte budget keeps
+// batches of large entries (stack traces) well under the gRPC limit while
+// small access-log lines still fill a batch quickly.
+const (
+	maxBatchLogs       = 100
+	defaultBatchSizeKB = 64
+	defaultBatchWindow = 10 * time.Second
+)
+
+// batchMaxBytes is batch_size_kb in bytes, never above the gRPC message limit
+func (a *Agent) batchMaxBytes() int {
+	a.mu.RLock()
+	kb := a.config.AgentSettings.BatchSizeKB
+	a.mu.RUnlock()
+	if kb <= 0 {
+		kb = defaultBatchSizeKB
+	}
+	return min(kb*1024, a.maxMsgBytes)
+}
+
+// batchWindow is batch_window, falling back to the default when unset or invalid
+func (a *Agent) batchWindow() time.Duration {
+	a.mu.RLock()
+	raw := a.config.AgentSettings.BatchWindow
+	a.mu.RUnlock()
+	if raw == "" {
+		return defaultBatchWindow
+	}
+	d, err := time.ParseDuration(raw)
+	if err != nil || d <= 0 {
+		log.Printf("⚠️ Ignoring invalid batch_window %q", raw)
+		return defaultBatchWindow
+	}
+	return d
+}
+
+// Severity order for agent_settings.flush_on_level
+var levelSeverity = map[string]int{"DEBUG": 0, "INFO": 1, "WARN": 2, "ERROR": 3}
+
+const defaultFlushCoalesce = 250 * time.Millisecond
+
+// flushPolicy returns the level at or above which an entry flushes the
+// buffer early ("" when flush_on_level is unset) and how long such a flush
+// waits for more entries (flush_coalesce). The wait keeps an error storm to
+// one batch per window instead of a batch per error.
+func (a *Agent) flushPolicy() (string, time.Duration) {
+	a.mu.RLock()
+	level := strings.ToUpper(a.config.AgentSettings.FlushOnLevel)
+	raw := a.config.AgentSettings.FlushCoalesce
+	a.mu.RUnlock()
+	if _, ok := levelSeverity[level]; !ok {
+		if level != "" {
+			log.Printf("⚠️ Ignoring unknown flush_on_level %q", level)
+		}
+		return "", 0
+	}
+	if raw == "" {
+		return level, defaultFlushCoalesce
+	}
+	d, err := time.ParseDuration(raw)
+	if err != nil || d < 0 {
+		log.Printf("⚠️ Ignoring invalid flush_coalesce %q", raw)
+		return level, defaultFlushCoalesce
+	}
+	return level, d
+}
+
+// urgent reports whether an entry's level triggers an early flush
+func urgent(level, threshold string) bool {
+	if threshold == "" {
+		return false
+	}
+	severity, ok := levelSeverity[level]
+	return ok && severity >= levelSeverity[threshold]
+}
+
+// batchBuffer is batchSender's pending batch with its serialized size
+type batchBuffer struct {
+	entries []*logpb.LogEntry
+	bytes   int
+}
+
+// add appends entry. When entry would push a non-empty buffer past maxBytes,
+// the buffer is returned to be sent first and entry starts the next one.
+func (b *batchBuffer) add(entry *logpb.LogEntry, maxBytes int) []*logpb.LogEntry {
+	size := proto.Size(entry)
+	var flushed []*logpb.LogEntry
+	if len(b.entries) > 0 && b.bytes+size > maxBytes {
+		flushed = b.take()
+	}
+	b.entries = append(b.entries, entry)
+	b.bytes += size
+	return flushed
+}
+
+// full reports whether the buffer reached the count or byte threshold
+func (b *batchBuffer) full(maxBytes int) bool {
+	return len(b.entries) >= maxBatchLogs || b.bytes >= maxBytes
+}
+
+// take empties the buffer and returns what it held
+func (b *batchBuffer) take() []*logpb.LogEntry {
+	entries := b.entries
+	b.entries = make([]*logpb.LogEntry, 0, maxBatchLogs)
+	b.bytes = 0
+	return entries
+}
+
+// sendAll sends entries in as many batches as the thresholds require
+func (a *Agent) sendAll(entries []*logpb.LogEntry) {
+	maxBytes := a.batchMaxBytes()
+	var b batchBuffer
+	for _, entry := range entries {
+		if flushed := b.add(entry, maxBytes); flushed != nil {
+			a.sendBatch(flushed)
+		}
+		if b.full(maxBytes) {
+			a.sendBatch(b.take())
+		}
+	}
+	a.sendBatch(b.take())
+}
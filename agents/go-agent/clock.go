@@ -0,0 +1,19 @@
+package main
+
+import "time"
+
+// Clock is where the agent reads the time. Parsing, batching, acks and
+// retries go through it instead of calling time.Now directly, so
+// time-dependent behavior can run against a controlled clock.
+type Clock interface {
+	Now() time.Time
+	Since(t time.Time) time.Duration
+	After(d time.Duration) <-chan time.Time
+}
+
+// realClock is the wall clock, the default everywhere
+type realClock struct{}
+
+func (realClock) Now() time.Time                         { return time.Now() }
+func (realClock) Since(t time.Time) time.Duration        { return time.Since(t) }
+func (realClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
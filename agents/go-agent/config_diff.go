@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+)
+
+// samplingDiff summarizes how a reload changes sampling, the settings that
+// most often change during a rollout, e.g.
+//
+//	base_rates.INFO 1->0.5, +service_rates.nginx.INFO=1, +content_rules["timeout"]=1, -content_rules[priority="low"]
+//
+// It returns nil when sampling is unchanged.
+func samplingDiff(old, new *AgentConfig) []string {
+	diff := ratesDiff("base_rates", old.Sampling.BaseRates, new.Sampling.BaseRates)
+	for _, service := range sortedKeys(old.Sampling.ServiceRates, new.Sampling.ServiceRates) {
+		diff = append(diff, ratesDiff("service_rates."+service, old.Sampling.ServiceRates[service], new.Sampling.ServiceRates[service])...)
+	}
+
+	// Content rules are matched in order, so compare positionally first
+	oldRules, newRules := old.Sampling.ContentRules, new.Sampling.ContentRules
+	same := len(oldRules) == len(newRules)
+	for i := 0; same && i < len(oldRules); i++ {
+		same = oldRules[i].key() == newRules[i].key() && oldRules[i].Rate == newRules[i].Rate
+	}
+	if same {
+		return diff
+	}
+	oldRates := make(map[string]float64, len(oldRules))
+	for _, rule := range oldRules {
+		if _, dup := oldRates[rule.key()]; !dup {
+			oldRates[rule.key()] = rule.Rate
+		}
+	}
+	newRates := make(map[string]float64, len(newRules))
+	changed := false
+	for _, rule := range newRules {
+		key := rule.key()
+		if _, dup := newRates[key]; dup {
+			continue
+		}
+		newRates[key] = rule.Rate
+		before, ok := oldRates[key]
+		switch {
+		case !ok:
+			diff = append(diff, fmt.Sprintf("+content_rules[%s]=%g", key, rule.Rate))
+			changed = true
+		case before != rule.Rate:
+			diff = append(diff, fmt.Sprintf("content_rules[%s] %g->%g", key, before, rule.Rate))
+			changed = true
+		}
+	}
+	for _, rule := range oldRules {
+		if _, ok := newRates[rule.key()]; !ok {
+			diff = append(diff, fmt.Sprintf("-content_rules[%s]", rule.key()))
+			newRates[rule.key()] = 0 // report duplicates once
+			changed = true
+		}
+	}
+	if !changed {
+		diff = append(diff, "content_rules reordered")
+	}
+	return diff
+}
+
+// ratesDiff compares one map of level rates, reported under name
+func ratesDiff(name string, old, new map[string]float64) []string {
+	var diff []string
+	for _, level := range sortedKeys(old, new) {
+		before, hadBefore := old[level]
+		after, hasAfter := new[level]
+		switch {
+		case !hadBefore:
+			diff = append(diff, fmt.Sprintf("+%s.%s=%g", name, level, after))
+		case !hasAfter:
+			diff = append(diff, fmt.Sprintf("-%s.%s", name, level))
+		case before != after:
+			diff = append(diff, fmt.Sprintf("%s.%s %g->%g", name, level, before, after))
+		}
+	}
+	return diff
+}
+
+// sortedKeys returns the keys of both maps, sorted
+func sortedKeys[V any](a, b map[string]V) []string {
+	seen := make(map[string]bool, len(a)+len(b))
+	for key := range a {
+		seen[key] = true
+	}
+	for key := range b {
+		seen[key] = true
+	}
+	keys := make([]string, 0, len(seen))
+	for key := range seen {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
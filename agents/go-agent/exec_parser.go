@@ -0,0 +1,262 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The exec parser hands lines of formats no regex can express (proprietary
+// appliances and the like) to an external program:
+//
+//	services:
+//	  sources:
+//	    /logs/firewall.log:
+//	      parser: exec
+//	      command: [/opt/parsers/firewall, --strict]
+//	      timeout: 500ms
+//
+// The program is started once and kept running. The agent writes each line
+// to its stdin and expects exactly one line of JSON back on stdout:
+//
+//	{"timestamp": "2025-11-02T07:10:29Z", "level": "ERROR", "service": "firewall",
+//	 "message": "blocked 10.0.0.7", "fields": {"rule": "42"}}
+//
+// timestamp is RFC 3339 (empty means the read time), an empty level is
+// inferred from the message, an empty service is named after the file, and
+// an object without a message ({}) drops the line. Anything the program
+// writes to stderr ends up in the agent's log.
+//
+// A line the program doesn't answer within the timeout, a crash or a reply
+// that isn't valid JSON counts in exec_parse_errors and drops the line; the
+// program is restarted on the next line. After execMaxFailures failures in a
+// row the source's lines are dropped for execSuspend without calling the
+// program, so a broken parser can't stall the tailer.
+const (
+	defaultExecTimeout = time.Second
+	execMaxFailures    = 5
+	execSuspend        = 30 * time.Second
+)
+
+var errExecSuspended = errors.New("exec parser suspended after repeated failures")
+
+// execOutput is the JSON an exec parser answers with
+type execOutput struct {
+	Timestamp string            `json:"timestamp"`
+	Level     string            `json:"level"`
+	Service   string            `json:"service"`
+	Message   string            `json:"message"`
+	Fields    map[string]string `json:"fields"`
+}
+
+// execParser runs one parser program. Calls are serialized, so a source
+// always has at most one line in flight.
+type execParser struct {
+	command []string
+	timeout time.Duration
+
+	mu             sync.Mutex
+	cmd            *exec.Cmd
+	stdin          *os.File
+	out            chan string   // stdout lines; closed when the program exits
+	done           chan struct{} // closed when the program is stopped
+	failures       int           // consecutive failures
+	suspendedUntil time.Time
+}
+
+func newExecParser(command []string, timeout time.Duration) *execParser {
+	return &execParser{command: command, timeout: timeout}
+}
+
+// parse sends line to the program. A nil output with a nil error means the
+// program asked for the line to be dropped.
+func (p *execParser) parse(line string) (*execOutput, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if time.Now().Before(p.suspendedUntil) {
+		return nil, errExecSuspended
+	}
+	reply, err := p.roundTrip(line)
+	var out execOutput
+	if err != nil {
+		// Out of step with the program: start over with a fresh process
+		p.stop()
+	} else if err = json.Unmarshal([]byte(reply), &out); err != nil {
+		err = fmt.Errorf("invalid reply %q: %w", reply, err)
+	}
+	if err != nil {
+		p.failures++
+		if p.failures == 1 {
+			log.Printf("⚠️  Exec parser %s: %v", p.command[0], err)
+		}
+		if p.failures >= execMaxFailures {
+			log.Printf("⚠️  Exec parser %s failed %d times in a row, dropping its lines for %s", p.command[0], p.failures, execSuspend)
+			p.failures = 0
+			p.suspendedUntil = time.Now().Add(execSuspend)
+			p.stop()
+		}
+		return nil, err
+	}
+	p.failures = 0
+	if out.Message == "" {
+		return nil, nil
+	}
+	return &out, nil
+}
+
+// roundTrip writes one line and waits for one reply, both bounded by the timeout
+func (p *execParser) roundTrip(line string) (string, error) {
+	if p.cmd == nil {
+		if err := p.start(); err != nil {
+			return "", err
+		}
+	}
+	deadline := time.Now().Add(p.timeout)
+	// A program that stops reading would otherwise block the write once the pipe fills
+	p.stdin.SetWriteDeadline(deadline)
+	if _, err := io.WriteString(p.stdin, line+"\n"); err != nil {
+		return "", fmt.Errorf("write: %w", err)
+	}
+
+	timer := time.NewTimer(time.Until(deadline))
+	defer timer.Stop()
+	select {
+	case reply, ok := <-p.out:
+		if !ok {
+			return "", errors.New("program exited")
+		}
+		return reply, nil
+	case <-timer.C:
+		return "", fmt.Errorf("no reply within %s", p.timeout)
+	}
+}
+
+func (p *execParser) start() error {
+	cmd := exec.Command(p.command[0], p.command[1:]...)
+	cmd.Stderr = os.Stderr
+	// os.Pipe rather than StdinPipe, so writes can have a deadline
+	stdinR, stdinW, err := os.Pipe()
+	if err != nil {
+		return fmt.Errorf("start %s: %w", p.command[0], err)
+	}
+	cmd.Stdin = stdinR
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		stdinR.Close()
+		stdinW.Close()
+		return fmt.Errorf("start %s: %w", p.command[0], err)
+	}
+	if err := cmd.Start(); err != nil {
+		stdinR.Close()
+		stdinW.Close()
+		return fmt.Errorf("start %s: %w", p.command[0], err)
+	}
+	stdinR.Close()
+
+	out := make(chan string)
+	done := make(chan struct{})
+	go func() {
+		defer close(out)
+		scanner := bufio.NewScanner(stdout)
+		scanner.Buffer(make([]byte, 64<<10), 1<<20)
+		for scanner.Scan() {
+			select {
+			case out <- scanner.Text():
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	p.cmd, p.stdin, p.out, p.done = cmd, stdinW, out, done
+	log.Printf("Started exec parser: %s", strings.Join(p.command, " "))
+	return nil
+}
+
+// stop kills the program, if running; the next parse starts a new one
+func (p *execParser) stop() {
+	if p.cmd == nil {
+		return
+	}
+	close(p.done)
+	p.stdin.Close()
+	p.cmd.Process.Kill()
+	go p.cmd.Wait()
+	p.cmd = nil
+}
+
+func (p *execParser) close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.stop()
+}
+
+// entryTime is the reply's timestamp, or now when the program left it empty
+func (o *execOutput) entryTime() (time.Time, error) {
+	if o.Timestamp == "" {
+		return time.Now(), nil
+	}
+	return time.Parse(time.RFC3339Nano, o.Timestamp)
+}
+
+// execParsers keeps one running parser per exec source across config reloads
+type execParsers struct {
+	mu       sync.Mutex
+	bySource map[string]*execParser
+}
+
+func newExecParsers() *execParsers {
+	return &execParsers{bySource: make(map[string]*execParser)}
+}
+
+// get returns the parser for source, replacing it if its command or timeout changed
+func (r *execParsers) get(source string, settings SourceSettings) *execParser {
+	timeout := defaultExecTimeout
+	if d, err := time.ParseDuration(settings.Timeout); err == nil && d > 0 {
+		timeout = d
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	p := r.bySource[source]
+	if p != nil && slices.Equal(p.command, settings.Command) && p.timeout == timeout {
+		return p
+	}
+	if p != nil {
+		go p.close()
+	}
+	p = newExecParser(settings.Command, timeout)
+	r.bySource[source] = p
+	return p
+}
+
+// prune stops parsers of sources that are no longer exec-parsed
+func (r *execParsers) prune(sources map[string]SourceSettings) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for source, p := range r.bySource {
+		if sources[source].Parser != parserExec {
+			go p.close()
+			delete(r.bySource, source)
+		}
+	}
+}
+
+func (r *execParsers) closeAll() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for source, p := range r.bySource {
+		p.close()
+		delete(r.bySource, source)
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"log"
+	"os"
+	"os/signal"
+
+	logpb "stackmonitor.com/go-agent/logproto"
+)
+
+// SIGUSR1 makes batchSender send what it holds right away instead of waiting
+// for the batch window or a full batch, e.g. to see a test line arrive now:
+//
+//	docker compose kill -s SIGUSR1 go-agent
+//
+// FLUSH_ON_SIGUSR1=false ignores the signal.
+
+// notifyFlush returns a channel receiving flush requests, or nil (never fires)
+// when disabled or unsupported on this platform. It buffers one signal, so
+// signals arriving during a flush coalesce into a single follow-up flush.
+func notifyFlush(enabled bool) <-chan os.Signal {
+	if !enabled || flushSignal == nil {
+		return nil
+	}
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, flushSignal)
+	return ch
+}
+
+// forceFlush sends buffer plus whatever is already queued in logChan. It runs
+// on the batchSender goroutine, so it never overlaps a ticker-driven flush.
+func (a *Agent) forceFlush(buffer []*logpb.LogEntry) {
+	// Only take what is queued now; enqueue may drain concurrently under drop_oldest
+drain:
+	for n := len(a.logChan); n > 0; n-- {
+		select {
+		case entry := <-a.logChan:
+			buffer = append(buffer, entry)
+		default:
+			break drain
+		}
+	}
+
+	log.Printf("🚿 SIGUSR1: forcing flush of %d buffered logs", len(buffer))
+	a.forcedFlushes.Add(1)
+	a.sendAll(buffer)
+}
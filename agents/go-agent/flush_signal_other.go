@@ -0,0 +1,8 @@
+//go:build !unix
+
+package main
+
+import "os"
+
+// flushSignal is nil where SIGUSR1 doesn't exist, which disables forced flushes
+var flushSignal os.Signal
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	mrand "math/rand"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	logpb "stackmonitor.com/go-agent/logproto"
+)
+
+// defaultGenerateMix is the level mix used by --generate when --generate-mix is not set
+const defaultGenerateMix = "INFO=80,WARN=15,ERROR=5"
+
+// levelWeight is one entry of a generator level mix
+type levelWeight struct {
+	level  string
+	weight int
+}
+
+// parseLevelMix parses "LEVEL=weight,..." (e.g. INFO=80,WARN=15,ERROR=5)
+func parseLevelMix(raw string) ([]levelWeight, error) {
+	var mix []levelWeight
+	for _, part := range strings.Split(raw, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		level, weightStr, ok := strings.Cut(part, "=")
+		if !ok {
+			return nil, fmt.Errorf("mix entry %q: want LEVEL=weight", part)
+		}
+		weight, err := strconv.Atoi(weightStr)
+		if err != nil || weight < 0 {
+			return nil, fmt.Errorf("mix entry %q: weight must be a non-negative integer", part)
+		}
+		mix = append(mix, levelWeight{level: strings.ToUpper(strings.TrimSpace(level)), weight: weight})
+	}
+	total := 0
+	for _, lw := range mix {
+		total += lw.weight
+	}
+	if total == 0 {
+		return nil, fmt.Errorf("level mix %q has no positive weights", raw)
+	}
+	sort.Slice(mix, func(i, j int) bool { return mix[i].level < mix[j].level })
+	return mix, nil
+}
+
+// pickLevel draws a level according to the mix weights
+func pickLevel(mix []levelWeight, rng *mrand.Rand) string {
+	total := 0
+	for _, lw := range mix {
+		total += lw.weight
+	}
+	n := rng.Intn(total)
+	for _, lw := range mix {
+		if n < lw.weight {
+			return lw.level
+		}
+		n -= lw.weight
+	}
+	return mix[len(mix)-1].level
+}
+
+var generatedMessages = map[string][]string{
+	"INFO":  {"Request completed in 12ms", "User session refreshed", "Cache hit for key user_1234"},
+	"WARN":  {"Slow query detected: 850ms", "Retrying request to inventory-service", "Cache miss for key user_1234"},
+	"ERROR": {"Database timeout: host=db-replica-2, timeout=5000ms", "Connection refused to payment-gateway:443", "NullPointerException in OrderController"},
+	"DEBUG": {"Entering checkout handler", "Loaded 42 feature flags"},
+}
+
+// generateLoad synthesizes ratePerSec log entries per second with the given level
+// mix and feeds them into logChan, so they go through the normal batchSender
+// path. Tailing is not started in this mode. It reports throughput and ack
+// latency every reportEvery until the process exits.
+func (a *Agent) generateLoad(ratePerSec int, mix []levelWeight, reportEvery time.Duration) {
+	log.Printf("🏋️ Load generator: %d logs/sec, mix %v", ratePerSec, mix)
+
+	// Emit in 10ms ticks so the rate is smooth rather than one burst per second
+	const tick = 10 * time.Millisecond
+	perTick := float64(ratePerSec) * tick.Seconds()
+	ticker := time.NewTicker(tick)
+	defer ticker.Stop()
+	report := time.NewTicker(reportEvery)
+	defer report.Stop()
+
+	rng := mrand.New(mrand.NewSource(time.Now().UnixNano()))
+	var owed float64
+	var generated, lastGenerated uint64
+	var lastAcks, lastAckNanos uint64
+	lastReport := time.Now()
+
+	for {
+		select {
+		case <-ticker.C:
+			owed += perTick
+			for ; owed >= 1; owed-- {
+				level := pickLevel(mix, rng)
+				messages := generatedMessages[level]
+				if len(messages) == 0 {
+					messages = generatedMessages["INFO"]
+				}
+				now := time.Now()
+				a.logChan <- &logpb.LogEntry{
+					TimestampNs: now.UnixNano(),
+					Level:       level,
+					Message:     messages[rng.Intn(len(messages))],
+					Source:      "generator",
+					Fields: map[string]string{
+						"service":  "load-generator",
+						"trace_id": fmt.Sprintf("gen-%d", now.UnixNano()),
+					},
+					AgentId: a.id,
+				}
+				a.logsProcessed.Add(1)
+				generated++
+			}
+		case now := <-report.C:
+			elapsed := now.Sub(lastReport).Seconds()
+			acks, ackNanos := a.acksReceived.Load(), a.ackLatencyNanos.Load()
+			avgAck := "n/a"
+			if acks > lastAcks {
+				avgAck = time.Duration((ackNanos - lastAckNanos) / (acks - lastAcks)).Round(time.Microsecond).String()
+			}
+			log.Printf("🏋️ generated %.0f logs/sec (target %d), batches sent %d, failed %d, avg ack latency %s",
+				float64(generated-lastGenerated)/elapsed, ratePerSec, a.batchesSent.Load(), a.batchesFailed.Load(), avgAck)
+			lastGenerated, lastAcks, lastAckNanos, lastReport = generated, acks, ackNanos, now
+		}
+	}
+}
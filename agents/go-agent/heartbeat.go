@@ -0,0 +1,61 @@
+package main
+
+import (
+	"log"
+	"os"
+	"time"
+
+	logpb "stackmonitor.com/go-agent/logproto"
+)
+
+// agentVersion is reported with every batch and heartbeat; release builds set it with
+// -ldflags "-X main.agentVersion=1.2.3"
+var agentVersion = "dev"
+
+// HEARTBEAT_INTERVAL (default 30s, 0 disables) is how often an idle agent tells
+// ingestion it is alive. The api-server marks agents offline once their last
+// batch or heartbeat is older than AGENT_OFFLINE_AFTER, so keep it well below that.
+const defaultHeartbeatInterval = 30 * time.Second
+
+// Batch metadata keys read by the ingestion-service's agent registry
+const (
+	metaHeartbeat    = "heartbeat"
+	metaHostname     = "hostname"
+	metaAgentVersion = "agent_version"
+)
+
+func agentHostname() string {
+	host, err := os.Hostname()
+	if err != nil {
+		log.Printf("⚠️  Could not determine hostname: %v", err)
+		return "unknown"
+	}
+	return host
+}
+
+// identify stamps the agent's hostname and version on a batch
+func (a *Agent) identify(batch *logpb.LogBatch) {
+	batch.Metadata[metaHostname] = a.hostname
+	batch.Metadata[metaAgentVersion] = agentVersion
+}
+
+// sendHeartbeat sends an empty batch flagged as a heartbeat, unless a real
+// batch went out within the interval (which already counts as one). Ingestion
+// records it without acking, so it takes no batch ID. Only batchSender calls
+// this, keeping stream.Send on a single goroutine.
+func (a *Agent) sendHeartbeat() {
+	if a.clock.Since(time.Unix(a.lastBatchTime.Load(), 0)) < a.heartbeatInterval {
+		return
+	}
+	batch := &logpb.LogBatch{
+		AgentId:     a.id,
+		TimestampMs: a.clock.Now().UnixMilli(),
+		Metadata:    map[string]string{metaHeartbeat: "true"},
+	}
+	a.identify(batch)
+	if err := a.stream.Send(batch); err != nil {
+		log.Printf("Failed to send heartbeat: %v", err)
+		return
+	}
+	a.heartbeatsSent.Add(1)
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	logpb "stackmonitor.com/go-agent/logproto"
+)
+
+const (
+	// Batches that may await an ack at once, unless agent_settings.max_in_flight is set
+	defaultMaxInFlight = 16
+	// A batch unacked for this long is assumed lost and stops holding a slot
+	inFlightAckTimeout = 30 * time.Second
+)
+
+// inFlight counts batches sent but not yet acked. batchSender takes a slot
+// before every send, so once max_in_flight batches are outstanding it blocks
+// until an ack arrives; logChan then fills up and the overflow policy decides
+// what the tailers drop, instead of the agent piling batches onto a slow server.
+type inFlight struct {
+	mu       sync.Mutex
+	n        int
+	released chan struct{} // signalled on every release
+}
+
+func newInFlight() *inFlight {
+	return &inFlight{released: make(chan struct{}, 1)}
+}
+
+// tryAcquire takes a slot if fewer than limit are in use
+func (f *inFlight) tryAcquire(limit int) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.n >= limit {
+		return false
+	}
+	f.n++
+	return true
+}
+
+func (f *inFlight) release() {
+	f.mu.Lock()
+	f.n--
+	f.mu.Unlock()
+	select {
+	case f.released <- struct{}{}:
+	default:
+	}
+}
+
+func (f *inFlight) count() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.n
+}
+
+// maxInFlight is agent_settings.max_in_flight, read per send so reloads apply
+func (a *Agent) maxInFlight() int {
+	a.mu.RLock()
+	limit := a.config.AgentSettings.MaxInFlight
+	a.mu.RUnlock()
+	if limit <= 0 {
+		return defaultMaxInFlight
+	}
+	return limit
+}
+
+// acquireSendSlot blocks until another batch may be sent. Acks that never
+// arrive (e.g. the server restarted mid-batch) would otherwise block forever,
+// so every inFlightAckTimeout of waiting expires the batches unacked that long.
+func (a *Agent) acquireSendSlot() {
+	limit := a.maxInFlight()
+	if a.inFlight.tryAcquire(limit) {
+		return
+	}
+	a.inFlightWaits.Add(1)
+	start := a.clock.Now()
+	expire := a.clock.After(inFlightAckTimeout)
+	for !a.inFlight.tryAcquire(limit) {
+		select {
+		case <-a.inFlight.released:
+		case <-expire:
+			a.expireUnacked(inFlightAckTimeout)
+			expire = a.clock.After(inFlightAckTimeout)
+		}
+	}
+	if waited := a.clock.Since(start); waited > time.Second {
+		log.Printf("⚠️ Waited %v for one of %d in-flight batches to be acked", waited.Round(time.Millisecond), limit)
+	}
+}
+
+// sentBatch is a batch awaiting its ack
+type sentBatch struct {
+	at   time.Time
+	logs []*logpb.LogEntry // kept to resend it if the server turns it away, see throttle.go
+}
+
+// ackBatch frees the slot of an acked batch; false for unknown or expired IDs
+func (a *Agent) ackBatch(batchID int64) (sentBatch, bool) {
+	sent, ok := a.batchSentAt.LoadAndDelete(batchID)
+	if !ok {
+		return sentBatch{}, false
+	}
+	a.inFlight.release()
+	return sent.(sentBatch), true
+}
+
+// expireUnacked gives up on batches sent more than maxAge ago
+func (a *Agent) expireUnacked(maxAge time.Duration) {
+	expired := 0
+	a.batchSentAt.Range(func(id, sent any) bool {
+		if a.clock.Since(sent.(sentBatch).at) > maxAge {
+			if _, ok := a.ackBatch(id.(int64)); ok {
+				expired++
+			}
+		}
+		return true
+	})
+	if expired > 0 {
+		a.acksExpired.Add(uint64(expired))
+		log.Printf("⚠️ Gave up waiting for acks of %d batch(es) sent over %v ago", expired, maxAge)
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	configpb "stackmonitor.com/go-agent/configproto"
+)
+
+// loadInitialConfig fetches the config before any tailer starts, retrying
+// transient failures with backoff. Until a config is loaded the agent has no
+// sampling or parsing rules and keeps every line, so a config-service hiccup
+// at startup must not silently go unnoticed.
+func (a *Agent) loadInitialConfig(retry *RetryConfig) error {
+	return RetryWithBackoff(context.Background(), retry, "initial config fetch", func() error {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		resp, err := a.configClient.GetConfig(ctx, &configpb.ConfigRequest{AgentId: a.id})
+		if err != nil {
+			return err
+		}
+		if len(resp.ConfigPayload) == 0 {
+			return fmt.Errorf("config service returned an empty config")
+		}
+		var cfg AgentConfig
+		if err := yaml.Unmarshal(resp.ConfigPayload, &cfg); err != nil {
+			return fmt.Errorf("invalid config version %s: %w", resp.Version, err)
+		}
+		cfg.Services.sanitize()
+		sanitizeRates(&cfg)
+		compileRules(cfg.Sampling.ContentRules)
+
+		a.mu.Lock()
+		a.config = &cfg
+		a.configVersion = resp.Version
+		a.setCompressionLevel(cfg.AgentSettings.CompressionLevel)
+		a.mu.Unlock()
+		a.configLoaded.Store(true)
+		log.Printf("Loaded initial config version: %s", resp.Version)
+		return nil
+	})
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"google.golang.org/grpc/keepalive"
+)
+
+// gRPC keepalive for the ingestion connection. Load balancers and NATs drop
+// idle TCP flows without telling either end, so with little log traffic the
+// StreamLogs stream could die unnoticed until the next send failed. Pinging
+// keeps the flow alive and detects a dead peer within time+timeout:
+//
+//   - GRPC_KEEPALIVE_TIME: ping after this long without activity (default 30s,
+//     0 disables; gRPC raises anything below 10s to 10s)
+//   - GRPC_KEEPALIVE_TIMEOUT: drop the connection when a ping isn't answered
+//     within this (default 10s)
+//   - GRPC_KEEPALIVE_PERMIT_WITHOUT_STREAM: also ping while no stream is open
+//     (default true)
+//
+// The ingestion-service must permit pings this frequent (its
+// GRPC_KEEPALIVE_MIN_TIME), or it closes the connection with too_many_pings.
+const (
+	defaultKeepaliveTime    = 30 * time.Second
+	defaultKeepaliveTimeout = 10 * time.Second
+)
+
+// keepaliveFromEnv returns the client keepalive parameters; ok is false when disabled
+func keepaliveFromEnv() (params keepalive.ClientParameters, ok bool, err error) {
+	params = keepalive.ClientParameters{
+		Time:                defaultKeepaliveTime,
+		Timeout:             defaultKeepaliveTimeout,
+		PermitWithoutStream: true,
+	}
+	if v := os.Getenv("GRPC_KEEPALIVE_TIME"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			return params, false, fmt.Errorf("GRPC_KEEPALIVE_TIME: %q is not a duration such as 30s (0 disables)", v)
+		}
+		params.Time = d
+	}
+	if v := os.Getenv("GRPC_KEEPALIVE_TIMEOUT"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return params, false, fmt.Errorf("GRPC_KEEPALIVE_TIMEOUT: %q is not a positive duration", v)
+		}
+		params.Timeout = d
+	}
+	if v := os.Getenv("GRPC_KEEPALIVE_PERMIT_WITHOUT_STREAM"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return params, false, fmt.Errorf("GRPC_KEEPALIVE_PERMIT_WITHOUT_STREAM: %q is not true or false", v)
+		}
+		params.PermitWithoutStream = b
+	}
+	return params, params.Time > 0, nil
+}
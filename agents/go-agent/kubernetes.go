@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Where pod metadata is mounted with a downwardAPI volume, unless
+// K8S_DOWNWARD_API_DIR says otherwise. The volume can't expose the node
+// name, so NODE_NAME has to come from an env var (fieldRef spec.nodeName).
+const defaultDownwardAPIDir = "/etc/podinfo"
+
+// Pod labels that change on every rollout and would only add noise to entries
+var volatileLabels = map[string]bool{
+	"controller-revision-hash": true,
+	"pod-template-generation":  true,
+	"pod-template-hash":        true,
+}
+
+// k8sIdentity is what the agent knows about the pod it runs in; empty
+// outside Kubernetes
+type k8sIdentity struct {
+	Node      string
+	Pod       string
+	Namespace string
+	Labels    map[string]string
+}
+
+// loadK8sIdentity reads NODE_NAME, POD_NAME and POD_NAMESPACE, falling back
+// to the downward API files name, namespace and labels in dir
+func loadK8sIdentity(dir string) k8sIdentity {
+	id := k8sIdentity{
+		Node:      os.Getenv("NODE_NAME"),
+		Pod:       os.Getenv("POD_NAME"),
+		Namespace: os.Getenv("POD_NAMESPACE"),
+	}
+	if id.Pod == "" {
+		id.Pod = readDownwardFile(dir, "name")
+	}
+	if id.Namespace == "" {
+		id.Namespace = readDownwardFile(dir, "namespace")
+	}
+	id.Labels = readDownwardLabels(filepath.Join(dir, "labels"))
+	return id
+}
+
+func readDownwardFile(dir, name string) string {
+	raw, err := os.ReadFile(filepath.Join(dir, name))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(raw))
+}
+
+// readDownwardLabels parses the labels file: one key="value" per line
+func readDownwardLabels(path string) map[string]string {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+
+	labels := make(map[string]string)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		key, quoted, ok := strings.Cut(scanner.Text(), "=")
+		if !ok || volatileLabels[key] {
+			continue
+		}
+		value, err := strconv.Unquote(quoted)
+		if err != nil {
+			value = quoted
+		}
+		labels[key] = value
+	}
+	return labels
+}
+
+// agentID names a DaemonSet agent after its node, which stays the same across
+// pod restarts, or else after its pod; "" when neither is known
+func (id k8sIdentity) agentID() string {
+	switch {
+	case id.Node != "":
+		return "go-agent-" + id.Node
+	case id.Pod != "":
+		return "go-agent-" + id.Pod
+	}
+	return ""
+}
+
+// fields are added to every entry: k8s_node, k8s_pod, k8s_namespace and
+// k8s_label_<key> per pod label, with the key's / . and - turned into _
+func (id k8sIdentity) fields() map[string]string {
+	fields := make(map[string]string)
+	if id.Node != "" {
+		fields["k8s_node"] = id.Node
+	}
+	if id.Pod != "" {
+		fields["k8s_pod"] = id.Pod
+	}
+	if id.Namespace != "" {
+		fields["k8s_namespace"] = id.Namespace
+	}
+	for key, value := range id.Labels {
+		fields["k8s_label_"+strings.NewReplacer("/", "_", ".", "_", "-", "_").Replace(key)] = value
+	}
+	return fields
+}
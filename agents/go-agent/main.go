@@ -1,18 +1,18 @@
 package main
 
 import (
-	"bufio"
 	"context"
-	"crypto/rand"
 	"encoding/json"
+	"flag"
 	"fmt"
 	"io"
 	"log"
-	"math/big"
 	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"regexp"
+	"strconv"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -36,14 +36,29 @@ type AgentConfig struct {
 		PollInterval string `yaml:"poll_interval"`
 		BatchSizeKB  int    `yaml:"batch_size_kb"`
 		BatchWindow  string `yaml:"batch_window"`
+		// Batches smaller than this are sent uncompressed (default 512)
+		CompressionMinBytes int `yaml:"compression_min_bytes"`
+		// Send uncompressed unless ZSTD shrinks the batch by at least this ratio (default 1.1)
+		CompressionMinRatio float64 `yaml:"compression_min_ratio"`
+		// ZSTD encoder level: fastest, default, better or best (default "default")
+		CompressionLevel string `yaml:"compression_level"`
+		// Batches awaiting an ack before sending blocks (default 16), see inflight.go
+		MaxInFlight int `yaml:"max_in_flight"`
+		// Entries at or above this level flush the buffer within flush_coalesce
+		// (default 250ms) instead of waiting for batch_window; unset disables
+		FlushOnLevel  string `yaml:"flush_on_level"`
+		FlushCoalesce string `yaml:"flush_coalesce"`
 	} `yaml:"agent_settings"`
 	Sampling struct {
 		BaseRates map[string]float64 `yaml:"base_rates"`
-		ContentRules []struct {
-			Pattern string  `yaml:"pattern"`
-			Rate    float64 `yaml:"rate"`
-		} `yaml:"content_rules"`
+		// Service -> level (or "default") -> rate, ahead of base_rates; see sampleRate
+		ServiceRates map[string]map[string]float64 `yaml:"service_rates"`
+		ContentRules []ContentRule `yaml:"content_rules"` // see sampling_rules.go
 	} `yaml:"sampling"`
+	Services ServiceMapping `yaml:"services"`
+	Parsing  ParsingSettings `yaml:"parsing"`
+	Audit    AuditSettings   `yaml:"audit"` // see audit.go
+	RemoteSources []RemoteSource `yaml:"remote_sources"` // see remote_sources.go
 }
 
 type Agent struct {
@@ -56,24 +71,69 @@ type Agent struct {
 	logChan         chan *logpb.LogEntry
 	stream          logpb.LogIngestion_StreamLogsClient
 	conn            *grpc.ClientConn
-	batchID         int64
-	encoder         *zstd.Encoder
+	batchID         int64 // last batch ID sent, see batch_id.go
+	encoder         *zstd.Encoder // replaced under mu when compression_level changes
+	encoderLevel    zstd.EncoderLevel
+	sampler         sampler // cryptoSampler unless SAMPLING_SEED is set
+	maxMsgBytes     int // gRPC max message size; larger batches are split
+	backfill        *backfiller // nil unless BACKFILL_ROTATED=true
+	configLongPoll  time.Duration // GetConfig long-poll wait; 0 polls every 60s
+	overflowPolicy  string // what the live tail drops when logChan is full
+	hostname        string
+	k8sFields       map[string]string // added to every entry, see kubernetes.go; never modified
+	heartbeatInterval time.Duration // 0 disables heartbeats, see heartbeat.go
+	flushOnSignal   bool // SIGUSR1 forces a flush, see flush.go
+	execParsers     *execParsers // running exec parser programs, by source
+	tailers         *tailerLimiter // MAX_TAILERS slots, see tailers.go
+	remotes         *remoteSources // nil in load-generator mode, see remote_sources.go
+	wal             *wal           // nil unless WAL_ENABLED=true, see wal.go
+	walReplay       []walBatch     // unacked batches recovered on startup, sent first
+	clock           Clock        // realClock outside tests, see clock.go
 	
 	// Metrics
 	logsProcessed   atomic.Uint64
 	logsSampled     atomic.Uint64
+	logsLevelInferred atomic.Uint64
+	logsTruncated   atomic.Uint64 // messages cut to parsing.max_message_bytes
+	logsDropped     atomic.Uint64 // lost to a full logChan, see overflow.go
 	batchesSent     atomic.Uint64
 	batchesFailed   atomic.Uint64
+	heartbeatsSent  atomic.Uint64
+	execParseErrors atomic.Uint64 // exec parser timeouts, crashes and bad replies
+	audit           *dropAudit    // records dropped lines when audit.enabled, see audit.go
+	forcedFlushes   atomic.Uint64 // SIGUSR1 flushes
+	urgentFlushes   atomic.Uint64 // early flushes for flush_on_level entries
 	bytesCompressed atomic.Uint64
 	bytesOriginal   atomic.Uint64
+	compressionSkipped atomic.Uint64
+	oversizeSplits  atomic.Uint64
+	acksReceived    atomic.Uint64
+	ackLatencyNanos atomic.Uint64 // sum over acksReceived
+	batchSentAt     sync.Map      // batch ID -> sentBatch, until acked
+	throttle        throttle      // pause requested by the ingestion service, see throttle.go
+	inFlight        *inFlight     // one slot per batchSentAt entry
+	inFlightWaits   atomic.Uint64 // sends that blocked on max_in_flight
+	acksExpired     atomic.Uint64 // batches given up on after inFlightAckTimeout
+	oversizeDropped atomic.Uint64
 	startTime       time.Time
 	healthy         atomic.Bool
 	lastBatchTime   atomic.Int64
+	configReloads   atomic.Uint64
+	configLoaded    atomic.Bool // false while running on built-in defaults
+	lastReloadTime  atomic.Int64 // unix seconds, 0 until the first reload
 }
 
+const (
+	defaultCompressionMinBytes = 512
+	defaultCompressionMinRatio = 1.1
+	// Matches gRPC's default; override with GRPC_MAX_MSG_BYTES (must agree with ingestion-service)
+	defaultMaxMsgBytes = 4 << 20
+)
+
 var appLogRegex = regexp.MustCompile(`^\[([^\]]+)\]\s+\[(\S+)\]\s+\[([^\]]+)\]\s+(.*)`)
 var tomcatLogRegex = regexp.MustCompile(`^(\d{2}-[A-Za-z]{3}-\d{4}\s+\d{2}:\d{2}:\d{2}\.\d{3})\s+(\S+)\s+\[([^\]]+)\]\s+(.*)`)
-var nginxLogRegex = regexp.MustCompile(`^(\S+)\s+-\s+-\s+\[([^\]]+)\]\s+"(\S+)\s+(\S+)\s+(\S+)"\s+(\d+)\s+(\d+)\s+"([^"]+)"\s+"([^"]+)"`)
+// Combined format, optionally followed by timing: rt=$request_time urt="$upstream_response_time"
+var nginxLogRegex = regexp.MustCompile(`^(\S+)\s+-\s+-\s+\[([^\]]+)\]\s+"(\S+)\s+(\S+)\s+(\S+)"\s+(\d+)\s+(\d+)\s+"([^"]+)"\s+"([^"]+)"(?:\s+rt=([\d.]+))?(?:\s+urt="?([\d.]+|-)[^"\s]*"?)?`)
 
 func (a *Agent) parseLog(line, source string) *logpb.LogEntry {
 	line = strings.TrimSpace(line)
@@ -84,8 +144,53 @@ func (a *Agent) parseLog(line, source string) *logpb.LogEntry {
 	var t time.Time
 	var level, service, message string
 	var err error
+	timing := map[string]string{} // nginx request/upstream times in seconds, when logged
+	inferred := false // no [level] field: level guessed from keywords, see inferLevel in parsing.go
+	var extra map[string]string // fields returned by an exec parser
+
+	// Config is swapped wholesale on reload, never mutated, so the pointer is safe to keep
+	a.mu.RLock()
+	cfg := a.config
+	a.mu.RUnlock()
+	pinned := cfg.Services.Sources[source].Parser
 
-	if matches := appLogRegex.FindStringSubmatch(line); matches != nil {
+	if pinned == parserRaw {
+		// Shipped as is, to be structured later; the level was defaulted by sanitize
+		t = a.clock.Now()
+		level = cfg.Services.Sources[source].Level
+		service = cfg.Services.defaultService(source, serviceFromSource(source))
+		message = line
+	} else if pinned == parserExec || pinned == parserJSON {
+		var parsed *execOutput
+		var perr error
+		if pinned == parserExec {
+			parsed, perr = a.execParsers.get(source, cfg.Services.Sources[source]).parse(line)
+			if perr != nil {
+				a.execParseErrors.Add(1)
+			}
+		} else {
+			parsed, perr = parseJSONRecord(line)
+		}
+		if perr != nil {
+			a.auditDrop(cfg, source, "", dropParseFailed, nil, line)
+			return nil
+		}
+		if parsed == nil {
+			a.auditDrop(cfg, source, "", dropParserDropped, nil, line)
+			return nil // dropped by the program
+		}
+		t, err = parsed.entryTime()
+		level = strings.ToUpper(parsed.Level)
+		if level == "" {
+			level = inferLevel(parsed.Message)
+		}
+		service = parsed.Service
+		if service == "" {
+			service = cfg.Services.defaultService(source, serviceFromSource(source))
+		}
+		message = parsed.Message
+		extra = parsed.Fields
+	} else if matches := matchFormat(pinned, parserApp, appLogRegex, line); matches != nil {
 		// Parse timestamp format: 2025-11-02T07:10:29.920971
 		t, err = time.Parse("2006-01-02T15:04:05.000000", matches[1])
 		if err != nil {
@@ -96,7 +201,7 @@ func (a *Agent) parseLog(line, source string) *logpb.LogEntry {
 			service = matches[3]
 			message = matches[4]
 		}
-	} else if matches := tomcatLogRegex.FindStringSubmatch(line); matches != nil {
+	} else if matches := matchFormat(pinned, parserTomcat, tomcatLogRegex, line); matches != nil {
 		t, err = time.Parse("02-Jan-2006 15:04:05.000", matches[1])
 		if err == nil {
 			levelStr := matches[2]
@@ -108,10 +213,10 @@ func (a *Agent) parseLog(line, source string) *logpb.LogEntry {
 			default:
 				level = "INFO"
 			}
-			service = "tomcat"
+			service = cfg.Services.defaultService(source, "tomcat")
 			message = matches[4]
 		}
-	} else if matches := nginxLogRegex.FindStringSubmatch(line); matches != nil {
+	} else if matches := matchFormat(pinned, parserNginx, nginxLogRegex, line); matches != nil {
 		t, err = time.Parse("02/Jan/2006:15:04:05 -0700", matches[2])
 		if err == nil {
 			statusCode := matches[6]
@@ -124,81 +229,144 @@ func (a *Agent) parseLog(line, source string) *logpb.LogEntry {
 			} else {
 				level = "INFO"
 			}
-			service = "nginx"
+			service = cfg.Services.defaultService(source, "nginx")
 			message = fmt.Sprintf("%s %s %s - Status: %s", matches[3], matches[4], matches[5], statusCode)
+			if matches[10] != "" {
+				timing["request_time"] = matches[10]
+			}
+			if matches[11] != "" && matches[11] != "-" {
+				timing["upstream_response_time"] = matches[11]
+			}
 		}
+	} else if cfg.Parsing.InferLevels && (pinned == "" || pinned == parserApp) {
+		// Unstructured line: there is no timestamp to parse, so use the read time
+		t = a.clock.Now()
+		level = inferLevel(line)
+		service = cfg.Services.defaultService(source, serviceFromSource(source))
+		message = line
+		inferred = true
 	}
 
 	if err != nil || t.IsZero() {
+		a.auditDrop(cfg, source, level, dropParseFailed, nil, line)
 		return nil
 	}
+	service = cfg.Services.canonicalService(source, service)
 
-	a.mu.RLock()
-	rate, ok := a.config.Sampling.BaseRates[level]
-	if !ok {
-		rate = 1.0  // Default to 100% sampling
+	// Fields are populated before sampling so field rules can match them
+	fields := map[string]string{
+		"service":  service,
+		"trace_id": fmt.Sprintf("trace-%d", a.clock.Now().UnixNano()),
 	}
-	
-	for _, rule := range a.config.Sampling.ContentRules {
-		if strings.Contains(message, rule.Pattern) {
-			rate = rule.Rate
-			break
+	for k, v := range timing {
+		fields[k] = v
+	}
+	for k, v := range extra {
+		if k != "service" { // the canonical service above wins
+			fields[k] = v
 		}
 	}
-	a.mu.RUnlock()
-
-	if rate < 1.0 {
-		n, _ := rand.Int(rand.Reader, big.NewInt(100))
-		if n.Int64() > int64(rate*100) {
-			a.logsSampled.Add(1)
-			return nil
+	for k, v := range a.k8sFields {
+		if _, ok := fields[k]; !ok {
+			fields[k] = v
 		}
 	}
+	if inferred {
+		fields["level_inferred"] = "true"
+	}
+	applyTraceContext(fields, line)
+
+	skipSampling := pinned == parserRaw && cfg.Services.Sources[source].SkipSampling
+	if rate := sampleRate(cfg, service, level, message, fields); !skipSampling && !a.sampler.keep(rate) {
+		a.logsSampled.Add(1)
+		a.auditDrop(cfg, source, level, dropSampled, &rate, message)
+		return nil
+	}
+
+	// Truncate after sampling so content rules still see the whole message
+	maxMessage := cfg.Parsing.MaxMessageBytes
+	if maxMessage == 0 {
+		maxMessage = defaultMaxMessageBytes
+	}
+	originalLength := len(message)
+	message, truncated := truncateMessage(message, maxMessage)
 
 	a.logsProcessed.Add(1)
-	
+	if inferred {
+		a.logsLevelInferred.Add(1)
+	}
+	if truncated {
+		fields["truncated"] = "true"
+		fields["original_length"] = strconv.Itoa(originalLength)
+		a.logsTruncated.Add(1)
+	}
+
 	return &logpb.LogEntry{
 		TimestampNs: t.UnixNano(),
 		Level:       level,
 		Message:     message,
 		Source:      source,
-		Fields: map[string]string{
-			"service":  service,
-			"trace_id": fmt.Sprintf("trace-%d", time.Now().UnixNano()),
-		},
-		AgentId: a.id,
+		Fields:      fields,
+		AgentId:     a.id,
 	}
 }
 
 func (a *Agent) tailFile(path string) {
-	file, err := os.Open(path)
+	// Waits while MAX_TAILERS files are already tailed, see tailers.go
+	a.tailers.acquire()
+	defer a.tailers.release()
+
+	// Recover history from rotated copies before reading the live file
+	if a.backfill != nil {
+		a.backfill.run(path, a.framerFor(path).split, func(line string) {
+			if entry := a.parseLog(line, path); entry != nil {
+				// Lets the ingestion service skip its live dedup window for history
+				entry.Fields[fieldBackfill] = "true"
+				a.logChan <- entry
+			}
+		})
+	}
+
+	var file *os.File
+	err := a.openWithBackoff("Failed to open", path, func() (err error) {
+		file, err = os.Open(path)
+		return err
+	})
 	if err != nil {
 		log.Printf("Failed to open %s: %v", path, err)
 		return
 	}
 	defer file.Close()
 
-	// Read existing logs first
-	scanner := bufio.NewScanner(file)
+	// Read existing logs first. History already on disk can wait for room in
+	// logChan, so these sends block; only the live tail below may drop.
+	// A record still being written when the history ends is kept for the tail
+	var records recordReader // see records.go
 	lineCount := 0
-	for scanner.Scan() {
-		line := scanner.Text()
-		if entry := a.parseLog(line, path); entry != nil {
+	err = records.readAll(file, a.framerFor(path), func(record string) {
+		if entry := a.parseLog(record, path); entry != nil {
 			a.logChan <- entry
 			lineCount++
 		}
+	})
+	if err != nil {
+		log.Printf("Failed to read %s: %v", path, err)
 	}
 	log.Printf("Processed %d existing logs from %s", lineCount, path)
 
 	// Now watch for new lines
-	watcher, err := fsnotify.NewWatcher()
+	var watcher *fsnotify.Watcher
+	err = a.openWithBackoff("Failed to create watcher for", path, func() (err error) {
+		watcher, err = fsnotify.NewWatcher()
+		return err
+	})
 	if err != nil {
 		log.Printf("Failed to create watcher for %s: %v", path, err)
 		return
 	}
 	defer watcher.Close()
 
-	if err := watcher.Add(path); err != nil {
+	if err := a.openWithBackoff("Failed to watch", path, func() error { return watcher.Add(path) }); err != nil {
 		log.Printf("Failed to watch %s: %v", path, err)
 		return
 	}
@@ -207,21 +375,13 @@ func (a *Agent) tailFile(path string) {
 		select {
 		case event := <-watcher.Events:
 			if event.Op&fsnotify.Write == fsnotify.Write {
-				data := make([]byte, 4096)
-				n, err := file.Read(data)
-				if err != nil && err != io.EOF {
-					continue
-				}
-				if n > 0 {
-					lines := strings.Split(string(data[:n]), "\n")
-					for _, line := range lines {
-						if line != "" {
-							entry := a.parseLog(line, path)
-							if entry != nil {
-								a.logChan <- entry
-							}
-						}
+				err := records.readAll(file, a.framerFor(path), func(record string) {
+					if entry := a.parseLog(record, path); entry != nil {
+						a.enqueue(entry) // never stall the live tail
 					}
+				})
+				if err != nil {
+					log.Printf("Failed to read %s: %v", path, err)
 				}
 			}
 		case err := <-watcher.Errors:
@@ -238,9 +398,32 @@ func (a *Agent) batchSender() {
 	}
 	a.stream = stream
 
-	ticker := time.NewTicker(10 * time.Second)
+	window := a.batchWindow()
+	ticker := time.NewTicker(window)
 	defer ticker.Stop()
-	buffer := make([]*logpb.LogEntry, 0, 100)
+	var buffer batchBuffer // see batching.go
+
+	// A nil channel never fires, so a disabled heartbeat just drops out of the select
+	var heartbeat <-chan time.Time
+	if a.heartbeatInterval > 0 {
+		heartbeatTicker := time.NewTicker(a.heartbeatInterval)
+		defer heartbeatTicker.Stop()
+		heartbeat = heartbeatTicker.C
+	}
+	// Batches a crash left unacked go out before anything new
+	for _, b := range a.walReplay {
+		a.sendBatch(b.logs)
+		a.wal.replayed(b.seq)
+	}
+	if len(a.walReplay) > 0 {
+		log.Printf("Replayed %d unacked batch(es) from the WAL", len(a.walReplay))
+		a.walReplay = nil
+	}
+
+	forceFlush := notifyFlush(a.flushOnSignal)
+	// Set while an urgent entry waits out flush_coalesce; nil otherwise
+	var urgentFlush <-chan time.Time
+	flushLevel, coalesce := a.flushPolicy()
 
 	go func() {
 		for {
@@ -252,6 +435,28 @@ func (a *Agent) batchSender() {
 				log.Printf("Error receiving ack: %v", err)
 				return
 			}
+			sent, ok := a.ackBatch(ack.BatchId)
+			if ok {
+				a.acksReceived.Add(1)
+				a.ackLatencyNanos.Add(uint64(a.clock.Since(sent.at)))
+			}
+			// Not stored: pause sending, then resend it, see throttle.go
+			if ack.Status == logpb.AckStatus_RETRY {
+				retryAfter := time.Duration(ack.RetryAfterMs) * time.Millisecond
+				if retryAfter <= 0 {
+					retryAfter = defaultRetryBackoff
+				}
+				// An untracked batch (its ack timed out) has nothing left to resend
+				var logs []*logpb.LogEntry
+				if ok {
+					logs = sent.logs
+				}
+				a.throttle.reject(ack.BatchId, logs, retryAfter, a.clock.Now())
+			}
+			// RETRY leaves the batch in the WAL for the next start (or the resend)
+			if a.wal != nil && ack.Status != logpb.AckStatus_RETRY {
+				a.wal.ack(ack.BatchId)
+			}
 			log.Printf("Received ack for batch %d: %s", ack.BatchId, ack.Message)
 		}
 	}()
@@ -259,16 +464,34 @@ func (a *Agent) batchSender() {
 	for {
 		select {
 		case entry := <-a.logChan:
-			buffer = append(buffer, entry)
-			if len(buffer) >= 100 {
-				a.sendBatch(buffer)
-				buffer = make([]*logpb.LogEntry, 0, 100)
+			maxBytes := a.batchMaxBytes()
+			if flushed := buffer.add(entry, maxBytes); flushed != nil {
+				a.sendBatch(flushed)
+			}
+			if buffer.full(maxBytes) {
+				a.sendBatch(buffer.take())
+			}
+			if urgentFlush == nil && urgent(entry.Level, flushLevel) {
+				urgentFlush = a.clock.After(coalesce)
+			}
+		case <-urgentFlush:
+			urgentFlush = nil
+			if entries := buffer.take(); len(entries) > 0 {
+				a.urgentFlushes.Add(1)
+				a.sendBatch(entries)
 			}
 		case <-ticker.C:
-			if len(buffer) > 0 {
-				a.sendBatch(buffer)
-				buffer = make([]*logpb.LogEntry, 0, 100)
+			a.sendBatch(buffer.take())
+			// Pick up a batch_window or flush policy changed by a config reload
+			if w := a.batchWindow(); w != window {
+				window = w
+				ticker.Reset(window)
 			}
+			flushLevel, coalesce = a.flushPolicy()
+		case <-heartbeat:
+			a.sendHeartbeat()
+		case <-forceFlush:
+			a.forceFlush(buffer.take())
 		}
 	}
 }
@@ -277,9 +500,65 @@ func (a *Agent) sendBatch(logs []*logpb.LogEntry) {
 	if len(logs) == 0 {
 		return
 	}
+	a.waitOutThrottle()
 
+	batch, originalSize := a.buildBatch(logs)
+	batch.BatchId = a.batchID + 1
+
+	// A batch over the gRPC limit would kill the whole stream, so split it first
+	if size := proto.Size(batch); size > a.maxMsgBytes {
+		if len(logs) == 1 {
+			log.Printf("Dropping log entry from %s: %d bytes exceeds max message size %d", logs[0].Source, size, a.maxMsgBytes)
+			a.oversizeDropped.Add(1)
+			return
+		}
+		log.Printf("Batch of %d logs is %d bytes (max %d), splitting", len(logs), size, a.maxMsgBytes)
+		a.oversizeSplits.Add(1)
+		mid := len(logs) / 2
+		a.sendBatch(logs[:mid])
+		a.sendBatch(logs[mid:])
+		return
+	}
 	a.batchID++
-	
+	compression := batch.Compression
+	payload := batch.CompressedPayload
+	if compression == logpb.CompressionType_NONE {
+		a.compressionSkipped.Add(1)
+	}
+
+	if a.wal != nil {
+		if err := a.wal.append(batch.BatchId, logs); err != nil {
+			log.Printf("⚠️  WAL write failed, sending batch %d unprotected: %v", batch.BatchId, err)
+		}
+	}
+
+	// Blocks while max_in_flight batches await acks
+	a.acquireSendSlot()
+	a.batchSentAt.Store(batch.BatchId, sentBatch{at: a.clock.Now(), logs: logs})
+	if err := a.stream.Send(batch); err != nil {
+		log.Printf("Failed to send batch: %v", err)
+		a.ackBatch(batch.BatchId)
+		a.batchesFailed.Add(1)
+	} else {
+		sentSize := originalSize
+		if compression == logpb.CompressionType_ZSTD {
+			sentSize = len(payload)
+			ratio := float64(originalSize) / float64(sentSize)
+			log.Printf("Sent batch %d with %d logs (compressed %d->%d bytes, %.2fx)", 
+				a.batchID, len(logs), originalSize, sentSize, ratio)
+		} else {
+			log.Printf("Sent batch %d with %d logs (uncompressed, %d bytes)", a.batchID, len(logs), originalSize)
+		}
+		a.batchesSent.Add(1)
+		a.bytesOriginal.Add(uint64(originalSize))
+		a.bytesCompressed.Add(uint64(sentSize))
+		a.lastBatchTime.Store(a.clock.Now().Unix())
+		a.healthy.Store(true)
+	}
+}
+
+// buildBatch serializes and compresses logs into a batch (BatchId left unset)
+func (a *Agent) buildBatch(logs []*logpb.LogEntry) (*logpb.LogBatch, int) {
 	// Serialize logs to bytes
 	var logBytes []byte
 	for _, log := range logs {
@@ -292,33 +571,71 @@ func (a *Agent) sendBatch(logs []*logpb.LogEntry) {
 	
 	originalSize := len(logBytes)
 	
-	// Compress with ZSTD
-	compressed := a.encoder.EncodeAll(logBytes, make([]byte, 0, len(logBytes)))
+	// Compress with ZSTD unless the batch is too small or compression doesn't pay off
+	compression, payload := a.compressPayload(logBytes)
 	
 	batch := &logpb.LogBatch{
 		AgentId:           a.id,
-		BatchId:           a.batchID,
-		TimestampMs:       time.Now().UnixMilli(),
+		TimestampMs:       a.clock.Now().UnixMilli(),
 		Logs:              logs, // Keep for backward compat
-		Compression:       logpb.CompressionType_ZSTD,
-		CompressedPayload: compressed,
+		Compression:       compression,
+		CompressedPayload: payload,
 		OriginalSize:      int32(originalSize),
 		Metadata:          make(map[string]string),
 	}
+	a.identify(batch)
+	return batch, originalSize
+}
 
-	if err := a.stream.Send(batch); err != nil {
-		log.Printf("Failed to send batch: %v", err)
-		a.batchesFailed.Add(1)
-	} else {
-		ratio := float64(originalSize) / float64(len(compressed))
-		log.Printf("Sent batch %d with %d logs (compressed %d->%d bytes, %.2fx)", 
-			a.batchID, len(logs), originalSize, len(compressed), ratio)
-		a.batchesSent.Add(1)
-		a.bytesOriginal.Add(uint64(originalSize))
-		a.bytesCompressed.Add(uint64(len(compressed)))
-		a.lastBatchTime.Store(time.Now().Unix())
-		a.healthy.Store(true)
+// compressPayload ZSTD-compresses the serialized batch, falling back to NONE when
+// the batch is below the size floor or compression doesn't reach the minimum ratio
+func (a *Agent) compressPayload(logBytes []byte) (logpb.CompressionType, []byte) {
+	a.mu.RLock()
+	minBytes := a.config.AgentSettings.CompressionMinBytes
+	minRatio := a.config.AgentSettings.CompressionMinRatio
+	encoder := a.encoder
+	a.mu.RUnlock()
+	if minBytes <= 0 {
+		minBytes = defaultCompressionMinBytes
+	}
+	if minRatio <= 0 {
+		minRatio = defaultCompressionMinRatio
+	}
+
+	if len(logBytes) < minBytes {
+		return logpb.CompressionType_NONE, nil
+	}
+
+	compressed := encoder.EncodeAll(logBytes, make([]byte, 0, len(logBytes)))
+	if len(compressed) == 0 || float64(len(logBytes))/float64(len(compressed)) < minRatio {
+		return logpb.CompressionType_NONE, nil
+	}
+	return logpb.CompressionType_ZSTD, compressed
+}
+
+// setCompressionLevel re-creates the encoder when compression_level names a
+// different level; an unknown name keeps the default. The caller holds mu.
+// Batches being compressed keep the encoder they started with.
+func (a *Agent) setCompressionLevel(name string) {
+	level := zstd.SpeedDefault
+	if name != "" {
+		ok, parsed := zstd.EncoderLevelFromString(name)
+		if !ok {
+			log.Printf("⚠️  Unknown compression_level %q (want fastest, default, better or best), using default", name)
+		} else {
+			level = parsed
+		}
+	}
+	if level == a.encoderLevel {
+		return
+	}
+	encoder, err := zstd.NewWriter(nil, zstd.WithEncoderLevel(level))
+	if err != nil {
+		log.Printf("⚠️  Failed to create %s zstd encoder, keeping %s: %v", level, a.encoderLevel, err)
+		return
 	}
+	log.Printf("ZSTD compression level %s -> %s", a.encoderLevel, level)
+	a.encoder, a.encoderLevel = encoder, level
 }
 
 // HTTP handler for health checks
@@ -326,7 +643,7 @@ func (a *Agent) healthHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	
 	lastBatch := time.Unix(a.lastBatchTime.Load(), 0)
-	timeSinceLast := time.Since(lastBatch)
+	timeSinceLast := a.clock.Since(lastBatch)
 	healthy := a.healthy.Load() && timeSinceLast < 2*time.Minute
 	
 	status := "healthy"
@@ -339,7 +656,7 @@ func (a *Agent) healthHandler(w http.ResponseWriter, r *http.Request) {
 	response := map[string]interface{}{
 		"status":           status,
 		"agent_id":         a.id,
-		"uptime_seconds":   time.Since(a.startTime).Seconds(),
+		"uptime_seconds":   a.clock.Since(a.startTime).Seconds(),
 		"last_batch_ago":   timeSinceLast.Seconds(),
 		"config_version":   a.configVersion,
 		"log_chan_size":    len(a.logChan),
@@ -353,7 +670,7 @@ func (a *Agent) healthHandler(w http.ResponseWriter, r *http.Request) {
 func (a *Agent) metricsHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	
-	uptime := time.Since(a.startTime).Seconds()
+	uptime := a.clock.Since(a.startTime).Seconds()
 	logsProcessed := a.logsProcessed.Load()
 	bytesOriginal := a.bytesOriginal.Load()
 	bytesCompressed := a.bytesCompressed.Load()
@@ -362,20 +679,74 @@ func (a *Agent) metricsHandler(w http.ResponseWriter, r *http.Request) {
 	if bytesCompressed > 0 {
 		compressionRatio = float64(bytesOriginal) / float64(bytesCompressed)
 	}
+
+	a.mu.RLock()
+	configVersion := a.configVersion
+	compressionLevel := a.encoderLevel.String()
+	a.mu.RUnlock()
+	lastReload := ""
+	if t := a.lastReloadTime.Load(); t > 0 {
+		lastReload = time.Unix(t, 0).UTC().Format(time.RFC3339)
+	}
+
+	avgBatchBytes := 0.0
+	if sent := a.batchesSent.Load(); sent > 0 {
+		avgBatchBytes = float64(bytesOriginal) / float64(sent)
+	}
+
+	avgAckLatencyMs := 0.0
+	if acks := a.acksReceived.Load(); acks > 0 {
+		avgAckLatencyMs = float64(a.ackLatencyNanos.Load()) / float64(acks) / 1e6
+	}
 	
 	response := map[string]interface{}{
 		"agent_id":           a.id,
 		"uptime_seconds":     uptime,
 		"logs_processed":     logsProcessed,
 		"logs_sampled":       a.logsSampled.Load(),
+		"logs_level_inferred": a.logsLevelInferred.Load(),
+		"logs_truncated":     a.logsTruncated.Load(),
+		"logs_dropped":       a.logsDropped.Load(),
+		"overflow_policy":    a.overflowPolicy,
 		"batches_sent":       a.batchesSent.Load(),
 		"batches_failed":     a.batchesFailed.Load(),
+		"heartbeats_sent":    a.heartbeatsSent.Load(),
+		"exec_parse_errors":  a.execParseErrors.Load(),
+		"drops_audited":      a.audit.records.Load(),
+		"audit_errors":       a.audit.errors.Load(),
+		"forced_flushes":     a.forcedFlushes.Load(),
+		"urgent_flushes":     a.urgentFlushes.Load(),
+		"agent_version":      agentVersion,
 		"bytes_original":     bytesOriginal,
 		"bytes_compressed":   bytesCompressed,
 		"compression_ratio":  compressionRatio,
+		"compression_level":  compressionLevel,
+		"compression_skipped": a.compressionSkipped.Load(),
+		"avg_batch_bytes":    avgBatchBytes,
+		"batch_max_bytes":    a.batchMaxBytes(),
+		"oversize_splits":    a.oversizeSplits.Load(),
+		"oversize_dropped":   a.oversizeDropped.Load(),
+		"acks_received":      a.acksReceived.Load(),
+		"avg_ack_latency_ms": avgAckLatencyMs,
+		"active_tailers":     a.tailers.active.Load(),
+		"queued_tailers":     a.tailers.queued.Load(),
+		"max_tailers":        cap(a.tailers.slots),
+		"fd_backoffs":        a.tailers.fdBackoffs.Load(),
+		"remote_sources":     remoteStats(a.remotes),
+		"batches_in_flight":  a.inFlight.count(),
+		"wal":                walStats(a.wal),
+		"max_in_flight":      a.maxInFlight(),
+		"in_flight_waits":    a.inFlightWaits.Load(),
+		"acks_expired":       a.acksExpired.Load(),
+		"send_pauses":        a.throttle.pauses.Load(),
+		"send_paused":        a.throttle.paused(a.clock.Now()),
 		"logs_per_second":    float64(logsProcessed) / uptime,
 		"log_chan_size":      len(a.logChan),
 		"log_chan_capacity":  cap(a.logChan),
+		"config_version":     configVersion,
+		"config_reloads":     a.configReloads.Load(),
+		"config_loaded":      a.configLoaded.Load(),
+		"last_config_reload": lastReload,
 	}
 	
 	json.NewEncoder(w).Encode(response)
@@ -390,35 +761,91 @@ func (a *Agent) configPoller() {
 		currentVersion := a.configVersion
 		a.mu.RUnlock()
 
-		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		// With long-polling the server holds the call until the config changes
+		start := a.clock.Now()
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second+a.configLongPoll)
 		resp, err := a.configClient.GetConfig(ctx, &configpb.ConfigRequest{
 			AgentId:             a.id,
 			CurrentConfigVersion: currentVersion,
+			WaitSeconds:          int32(a.configLongPoll / time.Second),
 		})
 		cancel()
 
+		changed := false
 		if err != nil {
 			log.Printf("Failed to get config: %v", err)
 		} else if resp.Version != currentVersion && len(resp.ConfigPayload) > 0 {
+			changed = true
 			var newConfig AgentConfig
 			if err := yaml.Unmarshal(resp.ConfigPayload, &newConfig); err == nil {
+				newConfig.Services.sanitize()
+				sanitizeRates(&newConfig)
+				compileRules(newConfig.Sampling.ContentRules)
 				a.mu.Lock()
+				oldConfig := a.config
 				a.config = &newConfig
 				a.configVersion = resp.Version
+				a.setCompressionLevel(newConfig.AgentSettings.CompressionLevel)
 				a.mu.Unlock()
-				log.Printf("Config reloaded to version %s", newConfig.Version)
+				if !a.configLoaded.Swap(true) {
+					log.Printf("✅ Config version %s loaded, built-in defaults no longer in use", resp.Version)
+				}
+				a.execParsers.prune(newConfig.Services.Sources)
+				a.remotes.sync(newConfig.RemoteSources)
+				a.configReloads.Add(1)
+				a.lastReloadTime.Store(a.clock.Now().Unix())
+				if diff := samplingDiff(oldConfig, &newConfig); len(diff) > 0 {
+					log.Printf("Config reloaded to version %s, sampling: %s", newConfig.Version, strings.Join(diff, ", "))
+				} else {
+					log.Printf("Config reloaded to version %s, sampling unchanged", newConfig.Version)
+				}
 			}
 		}
 
+		// Poll again right away after a long-poll, unless the server answered an
+		// unchanged version instantly (no long-poll support): then use the ticker
+		if a.configLongPoll > 0 && err == nil && (changed || a.clock.Since(start) >= time.Second) {
+			continue
+		}
 		<-ticker.C
 	}
 }
 
 func main() {
+	selfTest := flag.Bool("selftest", false, "check connectivity to the config and ingestion services, then exit")
+	generate := flag.Int("generate", 0, "benchmark mode: synthesize N logs/sec instead of tailing files")
+	generateMix := flag.String("generate-mix", defaultGenerateMix, "level mix for --generate, as LEVEL=weight,...")
+	flag.Parse()
+
+	var levelMix []levelWeight
+	if *generate > 0 {
+		var err error
+		if levelMix, err = parseLevelMix(*generateMix); err != nil {
+			log.Fatalf("Invalid --generate-mix: %v", err)
+		}
+	}
+
+	// In Kubernetes the agent names itself after its node or pod, see kubernetes.go
+	downwardDir := os.Getenv("K8S_DOWNWARD_API_DIR")
+	if downwardDir == "" {
+		downwardDir = defaultDownwardAPIDir
+	}
+	k8s := loadK8sIdentity(downwardDir)
 	agentID := os.Getenv("AGENT_ID")
+	if agentID == "" {
+		agentID = k8s.agentID()
+	}
 	if agentID == "" {
 		agentID = fmt.Sprintf("go-agent-%d", time.Now().Unix())
 	}
+	k8sFields := k8s.fields()
+	if len(k8sFields) > 0 {
+		log.Printf("Running in Kubernetes as %s (node %q, pod %q, namespace %q)", agentID, k8s.Node, k8s.Pod, k8s.Namespace)
+	}
+	hostname := k8s.Node
+	if hostname == "" {
+		hostname = agentHostname()
+	}
 
 	configURL := os.Getenv("CONFIG_URL")
 	if configURL == "" {
@@ -430,6 +857,26 @@ func main() {
 		ingestionURL = "ingestion-service:50051"
 	}
 
+	// RETRY_TRANSIENT_PATTERNS adds environment-specific transient errors, e.g. a proxy's
+	// "upstream connect error", to the substrings that make an error retryable
+	retryTransientPatterns = parseTransientPatterns(os.Getenv("RETRY_TRANSIENT_PATTERNS"))
+
+	if *selfTest {
+		if !runSelfTest(agentID, configURL, ingestionURL) {
+			os.Exit(1)
+		}
+		return
+	}
+
+	maxMsgBytes := defaultMaxMsgBytes
+	if sizeEnv := os.Getenv("GRPC_MAX_MSG_BYTES"); sizeEnv != "" {
+		size, err := strconv.Atoi(sizeEnv)
+		if err != nil || size <= 0 {
+			log.Fatalf("Invalid GRPC_MAX_MSG_BYTES: %q", sizeEnv)
+		}
+		maxMsgBytes = size
+	}
+
 	configConn, err := grpc.Dial(configURL, grpc.WithTransportCredentials(insecure.NewCredentials()))
 	if err != nil {
 		log.Fatalf("Failed to connect to config service: %v", err)
@@ -437,7 +884,22 @@ func main() {
 	defer configConn.Close()
 	configClient := configpb.NewConfigServiceClient(configConn)
 
-	ingestionConn, err := grpc.Dial(ingestionURL, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	ingestionOpts := []grpc.DialOption{
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithDefaultCallOptions(
+			grpc.MaxCallSendMsgSize(maxMsgBytes),
+			grpc.MaxCallRecvMsgSize(maxMsgBytes),
+		),
+	}
+	keepaliveParams, keepaliveOn, err := keepaliveFromEnv()
+	if err != nil {
+		log.Fatalf("Invalid keepalive settings: %v", err)
+	}
+	if keepaliveOn {
+		ingestionOpts = append(ingestionOpts, grpc.WithKeepaliveParams(keepaliveParams))
+		log.Printf("gRPC keepalive: ping after %s idle, timeout %s", keepaliveParams.Time, keepaliveParams.Timeout)
+	}
+	ingestionConn, err := grpc.Dial(ingestionURL, ingestionOpts...)
 	if err != nil {
 		log.Fatalf("Failed to connect to ingestion service: %v", err)
 	}
@@ -449,6 +911,135 @@ func main() {
 		log.Fatalf("Failed to create zstd encoder: %v", err)
 	}
 
+	// SAMPLING_SEED makes sampling reproducible (same seed + same input = same kept lines)
+	var logSampler sampler = cryptoSampler{}
+	if seedEnv := os.Getenv("SAMPLING_SEED"); seedEnv != "" {
+		seed, err := strconv.ParseInt(seedEnv, 10, 64)
+		if err != nil {
+			log.Fatalf("Invalid SAMPLING_SEED: %q", seedEnv)
+		}
+		logSampler = newSeededSampler(seed)
+		log.Printf("Using seeded sampling (seed %d)", seed)
+	}
+
+	stateDir := os.Getenv("AGENT_STATE_DIR")
+	if stateDir == "" {
+		stateDir = "/var/lib/stackmonitor-agent"
+	}
+
+	// BACKFILL_ROTATED=true reads recent rotated/.gz siblings of each log file on startup
+	var rotatedBackfill *backfiller
+	if os.Getenv("BACKFILL_ROTATED") == "true" {
+		glob := os.Getenv("BACKFILL_GLOB")
+		if glob == "" {
+			glob = defaultBackfillGlob
+		}
+		maxAge := defaultBackfillMaxAge
+		if v := os.Getenv("BACKFILL_MAX_AGE"); v != "" {
+			d, err := time.ParseDuration(v)
+			if err != nil || d <= 0 {
+				log.Fatalf("Invalid BACKFILL_MAX_AGE: %q", v)
+			}
+			maxAge = d
+		}
+		maxFiles := defaultBackfillMaxFiles
+		if v := os.Getenv("BACKFILL_MAX_FILES"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n <= 0 {
+				log.Fatalf("Invalid BACKFILL_MAX_FILES: %q", v)
+			}
+			maxFiles = n
+		}
+		rotatedBackfill = newBackfiller(glob, maxAge, maxFiles, stateDir)
+		log.Printf("Backfilling rotated files matching %s (max age %v, max %d files)", glob, maxAge, maxFiles)
+	}
+
+	// CONFIG_LONG_POLL (e.g. 55s) has the config service hold GetConfig until the config changes
+	var configLongPoll time.Duration
+	if v := os.Getenv("CONFIG_LONG_POLL"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < time.Second {
+			log.Fatalf("Invalid CONFIG_LONG_POLL: %q (want a duration of at least 1s)", v)
+		}
+		configLongPoll = d
+	}
+
+	overflowPolicy, err := parseOverflowPolicy(os.Getenv("LOG_OVERFLOW_POLICY"))
+	if err != nil {
+		log.Fatalf("Invalid LOG_OVERFLOW_POLICY: %v", err)
+	}
+
+	heartbeatInterval := defaultHeartbeatInterval
+	if v := os.Getenv("HEARTBEAT_INTERVAL"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			log.Fatalf("Invalid HEARTBEAT_INTERVAL: %q (want a duration such as 30s, or 0 to disable)", v)
+		}
+		heartbeatInterval = d
+	}
+
+	flushOnSignal := true
+	if v := os.Getenv("FLUSH_ON_SIGUSR1"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			log.Fatalf("Invalid FLUSH_ON_SIGUSR1: %q (want true or false)", v)
+		}
+		flushOnSignal = b
+	}
+
+	// WAL_ENABLED=true keeps batches on disk until acked, see wal.go
+	var batchWAL *wal
+	var walReplay []walBatch
+	if v := os.Getenv("WAL_ENABLED"); v != "" {
+		enabled, err := strconv.ParseBool(v)
+		if err != nil {
+			log.Fatalf("Invalid WAL_ENABLED: %q (want true or false)", v)
+		}
+		if enabled {
+			maxMB := defaultWALMaxMB
+			if v := os.Getenv("WAL_MAX_MB"); v != "" {
+				n, err := strconv.Atoi(v)
+				if err != nil || n <= 0 {
+					log.Fatalf("Invalid WAL_MAX_MB: %q (want a positive size in MB)", v)
+				}
+				maxMB = n
+			}
+			batchWAL, walReplay, err = openWAL(filepath.Join(stateDir, "wal"), int64(maxMB)<<20)
+			if err != nil {
+				log.Fatalf("Failed to open WAL: %v", err)
+			}
+			log.Printf("WAL enabled in %s (max %d MB, %d unacked batch(es) to replay)", filepath.Join(stateDir, "wal"), maxMB, len(walReplay))
+		}
+	}
+
+	maxTailers := defaultMaxTailers
+	if v := os.Getenv("MAX_TAILERS"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			log.Fatalf("Invalid MAX_TAILERS: %q (want a positive number of files)", v)
+		}
+		maxTailers = n
+	}
+
+	var clock Clock = realClock{}
+	startTime := clock.Now()
+
+	// BATCH_ID_BASE=time (default) keeps batch IDs unique across restarts
+	batchID, err := initialBatchID(os.Getenv("BATCH_ID_BASE"), startTime)
+	if err != nil {
+		log.Fatalf("Invalid BATCH_ID_BASE: %v", err)
+	}
+
+	// REQUIRE_INITIAL_CONFIG=true exits instead of tailing with built-in defaults
+	requireConfig := false
+	if v := os.Getenv("REQUIRE_INITIAL_CONFIG"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			log.Fatalf("Invalid REQUIRE_INITIAL_CONFIG: %q (want true or false)", v)
+		}
+		requireConfig = b
+	}
+
 	agent := &Agent{
 		id:              agentID,
 		configClient:    configClient,
@@ -457,24 +1048,37 @@ func main() {
 		logChan:         make(chan *logpb.LogEntry, 1000),
 		config:          &AgentConfig{},
 		encoder:         encoder,
-		startTime:       time.Now(),
+		encoderLevel:    zstd.SpeedDefault,
+		maxMsgBytes:     maxMsgBytes,
+		sampler:         logSampler,
+		backfill:        rotatedBackfill,
+		configLongPoll:  configLongPoll,
+		overflowPolicy:  overflowPolicy,
+		hostname:        hostname,
+		k8sFields:       k8sFields,
+		heartbeatInterval: heartbeatInterval,
+		flushOnSignal:   flushOnSignal,
+		execParsers:     newExecParsers(),
+		tailers:         newTailerLimiter(maxTailers),
+		wal:             batchWAL,
+		walReplay:       walReplay,
+		audit:           &dropAudit{},
+		inFlight:        newInFlight(),
+		clock:           clock,
+		batchID:         batchID,
+		startTime:       startTime,
 	}
 	agent.healthy.Store(false)
+	if *generate == 0 {
+		agent.remotes = newRemoteSources(agent)
+	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	resp, err := configClient.GetConfig(ctx, &configpb.ConfigRequest{
-		AgentId:             agentID,
-		CurrentConfigVersion: "",
-	})
-	cancel()
-
-	if err == nil && len(resp.ConfigPayload) > 0 {
-		var cfg AgentConfig
-		if err := yaml.Unmarshal(resp.ConfigPayload, &cfg); err == nil {
-			agent.config = &cfg
-			agent.configVersion = resp.Version
-			log.Printf("Loaded initial config version: %s", resp.Version)
+	// Tailers only start once this returns, so sampling rules apply from the first line
+	if err := agent.loadInitialConfig(DefaultRetryConfig()); err != nil {
+		if requireConfig {
+			log.Fatalf("Initial config unavailable: %v", err)
 		}
+		log.Printf("⚠️ Initial config unavailable (%v): tailing with built-in defaults, every line kept, until a config poll succeeds", err)
 	}
 
 	go agent.configPoller()
@@ -500,18 +1104,28 @@ func main() {
 		}
 	}()
 
-	// Start tailing log files
-	logFiles := []string{"/logs/application.log", "/logs/tomcat.log", "/logs/nginx.log"}
-	for _, file := range logFiles {
-		if _, err := os.Stat(file); err == nil {
-			go agent.tailFile(file)
-			log.Printf("Started tailing %s", file)
-		} else {
-			log.Printf("Log file %s not found, skipping", file)
+	if *generate > 0 {
+		// Benchmark mode: synthetic load through the normal batching path, no tailing
+		go agent.generateLoad(*generate, levelMix, 10*time.Second)
+		log.Println("Go agent started in load-generator mode")
+	} else {
+		// Start tailing log files
+		logFiles := []string{"/logs/application.log", "/logs/tomcat.log", "/logs/nginx.log"}
+		for _, file := range logFiles {
+			if _, err := os.Stat(file); err == nil {
+				go agent.tailFile(file)
+				log.Printf("Started tailing %s", file)
+			} else {
+				log.Printf("Log file %s not found, skipping", file)
+			}
 		}
-	}
+		agent.mu.RLock()
+		remoteSources := agent.config.RemoteSources
+		agent.mu.RUnlock()
+		agent.remotes.sync(remoteSources)
 
-	log.Println("Go agent started. Waiting for logs...")
+		log.Println("Go agent started. Waiting for logs...")
+	}
 	
 	// Setup graceful shutdown
 	sigChan := make(chan os.Signal, 1)
@@ -527,6 +1141,9 @@ func main() {
 		log.Printf("HTTP server shutdown error: %v", err)
 	}
 	
+	agent.execParsers.closeAll()
+	agent.remotes.stopAll()
+
 	// Close gRPC connections
 	if agent.conn != nil {
 		agent.conn.Close()
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+
+	logpb "stackmonitor.com/go-agent/logproto"
+)
+
+// LOG_OVERFLOW_POLICY decides what a tailer does when logChan is full, i.e.
+// when batchSender can't keep up (typically because ingestion is unreachable).
+// Either way the tailer keeps reading its file instead of stalling.
+//
+//   - drop_oldest (default): discard the oldest queued entry to make room, so
+//     the freshest logs get through once ingestion recovers
+//   - drop_newest: discard the incoming entry and keep what is already queued
+const (
+	overflowDropOldest = "drop_oldest"
+	overflowDropNewest = "drop_newest"
+)
+
+// parseOverflowPolicy validates LOG_OVERFLOW_POLICY (empty means drop_oldest)
+func parseOverflowPolicy(raw string) (string, error) {
+	switch raw {
+	case "", overflowDropOldest:
+		return overflowDropOldest, nil
+	case overflowDropNewest:
+		return overflowDropNewest, nil
+	}
+	return "", fmt.Errorf("unknown overflow policy %q (want %s or %s)", raw, overflowDropOldest, overflowDropNewest)
+}
+
+// enqueue hands an entry to batchSender without ever blocking the caller.
+// Entries lost to a full channel are counted in logsDropped.
+func (a *Agent) enqueue(entry *logpb.LogEntry) {
+	select {
+	case a.logChan <- entry:
+		return
+	default:
+	}
+
+	if a.overflowPolicy == overflowDropNewest {
+		a.logsDropped.Add(1)
+		return
+	}
+
+	// Make room by discarding the oldest entry. batchSender may drain the
+	// channel concurrently, so both steps stay non-blocking.
+	select {
+	case <-a.logChan:
+		a.logsDropped.Add(1)
+	default:
+	}
+	select {
+	case a.logChan <- entry:
+	default:
+		a.logsDropped.Add(1)
+	}
+}
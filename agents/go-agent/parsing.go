@@ -0,0 +1,62 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+)
+
+// ParsingSettings tunes how lines are turned into entries
+//
+//	parsing:
+//	  infer_levels: true
+//	  max_message_bytes: 32768
+type ParsingSettings struct {
+	// InferLevels ingests app lines without a [level] field (plain println-style
+	// output) instead of dropping them, guessing the level from keywords.
+	// Applies to sources pinned to the app parser and to unpinned sources.
+	InferLevels bool `yaml:"infer_levels"`
+	// MaxMessageBytes bounds stored messages (default 32768, -1 disables);
+	// longer ones are cut, marked and flagged truncated=true
+	MaxMessageBytes int `yaml:"max_message_bytes"`
+}
+
+const (
+	defaultMaxMessageBytes = 32 << 10
+	truncationMarker       = "...[truncated]"
+)
+
+// truncateMessage cuts message to at most max bytes including the marker,
+// backing off to a rune boundary so the result stays valid UTF-8
+func truncateMessage(message string, max int) (string, bool) {
+	if max <= 0 || len(message) <= max {
+		return message, false
+	}
+	cut := max - len(truncationMarker)
+	if cut < 0 {
+		cut = 0
+	}
+	for cut > 0 && !utf8.RuneStart(message[cut]) {
+		cut--
+	}
+	return message[:cut] + truncationMarker, true
+}
+
+// inferLevel guesses a level from keywords: error/exception -> ERROR, warn -> WARN, else INFO
+func inferLevel(line string) string {
+	lower := strings.ToLower(line)
+	switch {
+	case strings.Contains(lower, "error"), strings.Contains(lower, "exception"):
+		return "ERROR"
+	case strings.Contains(lower, "warn"):
+		return "WARN"
+	}
+	return "INFO"
+}
+
+// serviceFromSource names the service after the log file, e.g. /logs/checkout.log -> checkout.
+// services.sources and aliases still apply on top of it.
+func serviceFromSource(source string) string {
+	base := filepath.Base(source)
+	return strings.TrimSuffix(base, filepath.Ext(base))
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+)
+
+// Record framing cuts a source's bytes into the records parseLog sees. By
+// default a record ends at \n (a trailing \r is trimmed with the rest of the
+// surrounding whitespace); services.sources.<path>.delimiter sets another
+// separator, e.g. "\x1e". Sources pinned to the json parser are cut after each
+// complete top-level object instead, so pretty-printed objects spanning
+// several lines stay one record:
+//
+//	services:
+//	  sources:
+//	    /logs/legacy.log: {delimiter: "\x1e"}
+//	    /logs/api.json: {parser: json}
+//
+// A record is only parsed once its delimiter, or the end of its object, has
+// been written; records longer than maxRecordBytes are cut there.
+const maxRecordBytes = 1 << 20
+
+// recordFramer splits a stream into records, see split
+type recordFramer struct {
+	delim []byte // ignored when json is set
+	json  bool
+}
+
+// framerFor returns the framing configured for source
+func (a *Agent) framerFor(source string) recordFramer {
+	a.mu.RLock()
+	settings := a.config.Services.Sources[source]
+	a.mu.RUnlock()
+	if settings.Parser == parserJSON {
+		return recordFramer{json: true}
+	}
+	if settings.Delimiter != "" {
+		return recordFramer{delim: []byte(settings.Delimiter)}
+	}
+	return recordFramer{delim: []byte("\n")}
+}
+
+// split is a bufio.SplitFunc; at EOF an unfinished record is returned as is
+func (f recordFramer) split(data []byte, atEOF bool) (int, []byte, error) {
+	start := 0
+	if f.json {
+		// Whitespace between objects belongs to no record
+		for start < len(data) && isJSONSpace(data[start]) {
+			start++
+		}
+		if start == len(data) {
+			return start, nil, nil
+		}
+		if data[start] == '{' {
+			if end := jsonObjectEnd(data[start:]); end > 0 {
+				return start + end, data[start : start+end], nil
+			}
+			return f.incomplete(data, start, atEOF)
+		}
+		// Not an object: pass the line on so it is dropped as unparseable
+	}
+
+	delim := f.delim
+	if f.json {
+		delim = []byte("\n")
+	}
+	if i := bytes.Index(data[start:], delim); i >= 0 {
+		return start + i + len(delim), data[start : start+i], nil
+	}
+	return f.incomplete(data, start, atEOF)
+}
+
+// incomplete asks for more data unless the record can't get any, or is too long
+func (f recordFramer) incomplete(data []byte, start int, atEOF bool) (int, []byte, error) {
+	if (atEOF && start < len(data)) || len(data)-start >= maxRecordBytes {
+		return len(data), data[start:], nil
+	}
+	return start, nil, nil
+}
+
+func isJSONSpace(b byte) bool {
+	return b == ' ' || b == '\t' || b == '\n' || b == '\r'
+}
+
+// jsonObjectEnd returns the length of the object data starts with, 0 when it
+// hasn't been closed yet. Braces inside strings don't count.
+func jsonObjectEnd(data []byte) int {
+	depth := 0
+	inString, escaped := false, false
+	for i, b := range data {
+		switch {
+		case escaped:
+			escaped = false
+		case inString:
+			if b == '\\' {
+				escaped = true
+			} else if b == '"' {
+				inString = false
+			}
+		case b == '"':
+			inString = true
+		case b == '{' || b == '[':
+			depth++
+		case b == '}' || b == ']':
+			depth--
+			if depth == 0 {
+				return i + 1
+			}
+		}
+	}
+	return 0
+}
+
+// recordReader cuts a growing file into records, keeping a partly written
+// record until the rest of it arrives
+type recordReader struct {
+	buf     []byte
+	pending []byte
+}
+
+// readAll reads r to EOF and hands every complete record to emit
+func (rr *recordReader) readAll(r io.Reader, f recordFramer, emit func(record string)) error {
+	if rr.buf == nil {
+		rr.buf = make([]byte, 32<<10)
+	}
+	for {
+		n, err := r.Read(rr.buf)
+		if n > 0 {
+			rr.pending = append(rr.pending, rr.buf[:n]...)
+			for len(rr.pending) > 0 {
+				advance, token, _ := f.split(rr.pending, false)
+				if advance == 0 {
+					break
+				}
+				if token != nil {
+					emit(string(token))
+				}
+				rr.pending = rr.pending[advance:]
+			}
+			if len(rr.pending) == 0 {
+				rr.pending = nil
+			}
+		}
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// parseJSONRecord reads one object of a json source, which is in the exec
+// parser's reply format (timestamp, level, service, message, fields)
+func parseJSONRecord(record string) (*execOutput, error) {
+	var out execOutput
+	if err := json.Unmarshal([]byte(record), &out); err != nil {
+		return nil, err
+	}
+	if out.Message == "" {
+		return nil, errors.New("no message")
+	}
+	return &out, nil
+}
@@ -0,0 +1,440 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// Remote sources collect logs from hosts that can't run the agent, listed in
+// remote_sources of the config:
+//
+//	remote_sources:
+//	  - type: http
+//	    url: http://legacy-billing:8080/logs/app.log
+//	    interval: 10s
+//	    headers: {Authorization: "Bearer ..."}
+//	  - type: ssh
+//	    host: legacy-crm:22
+//	    user: logs
+//	    key_file: /var/lib/stackmonitor-agent/ssh/id_ed25519
+//	    known_hosts: /var/lib/stackmonitor-agent/ssh/known_hosts
+//	    path: /var/log/crm/app.log
+//
+// Their records go through parseLog like a tailed file's, under the source
+// name (the URL, ssh://user@host/path, or name when set), so services.sources
+// overrides such as parser or delimiter apply to that name.
+//
+// An http source is polled every interval with a Range request from the last
+// offset. A server that ignores Range sends the whole file and the part
+// already read is skipped; a file shorter than the offset was rotated and is
+// read again from the start. An ssh source runs tail -F on the remote file
+// and, after a dropped connection, resumes at the byte it stopped at; host
+// keys are always checked against known_hosts.
+//
+// A failed poll or connection is retried with RetryWithBackoff, then again
+// every remoteRetryPause. Records wait for room in logChan rather than being
+// dropped: the backlog stays on the remote host. Config reloads start, restart
+// and stop sources as the list changes.
+const (
+	remoteHTTP = "http"
+	remoteSSH  = "ssh"
+
+	defaultRemoteInterval = 10 * time.Second
+	remoteRetryPause      = 30 * time.Second
+	remoteDialTimeout     = 10 * time.Second
+	remoteHTTPTimeout     = time.Minute
+	sshKeepaliveInterval  = 30 * time.Second
+)
+
+// RemoteSource is one entry of remote_sources
+type RemoteSource struct {
+	Type string `yaml:"type"` // http or ssh
+	// Name replaces the derived source name entries carry
+	Name string `yaml:"name"`
+
+	// http: the file's URL, the time between polls (default 10s) and extra request headers
+	URL      string            `yaml:"url"`
+	Interval string            `yaml:"interval"`
+	Headers  map[string]string `yaml:"headers"`
+
+	// ssh: host[:port] (port 22 by default), login, private key and
+	// known_hosts files (mounted into the container), and the remote file
+	Host       string `yaml:"host"`
+	User       string `yaml:"user"`
+	KeyFile    string `yaml:"key_file"`
+	KnownHosts string `yaml:"known_hosts"`
+	Path       string `yaml:"path"`
+}
+
+// source is the name entries carry
+func (s RemoteSource) source() string {
+	switch {
+	case s.Name != "":
+		return s.Name
+	case s.Type == remoteSSH:
+		return "ssh://" + s.User + "@" + s.Host + "/" + strings.TrimPrefix(s.Path, "/")
+	}
+	return s.URL
+}
+
+// validate reports why the source can't run
+func (s RemoteSource) validate() error {
+	switch s.Type {
+	case remoteHTTP:
+		u, err := url.Parse(s.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("url %q is not an http(s) URL", s.URL)
+		}
+		if _, err := s.interval(); err != nil {
+			return err
+		}
+	case remoteSSH:
+		var missing []string
+		for field, value := range map[string]string{"host": s.Host, "user": s.User, "key_file": s.KeyFile, "known_hosts": s.KnownHosts, "path": s.Path} {
+			if value == "" {
+				missing = append(missing, field)
+			}
+		}
+		if len(missing) > 0 {
+			sort.Strings(missing)
+			return fmt.Errorf("ssh source needs %s", strings.Join(missing, ", "))
+		}
+	default:
+		return fmt.Errorf("unknown type %q (want %s or %s)", s.Type, remoteHTTP, remoteSSH)
+	}
+	return nil
+}
+
+func (s RemoteSource) interval() (time.Duration, error) {
+	if s.Interval == "" {
+		return defaultRemoteInterval, nil
+	}
+	d, err := time.ParseDuration(s.Interval)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("invalid interval %q", s.Interval)
+	}
+	return d, nil
+}
+
+// remoteSources runs one follower per configured remote source
+type remoteSources struct {
+	agent *Agent
+
+	mu      sync.Mutex
+	running map[string]*remoteFollower // by source name
+}
+
+// remoteFollower is one running source and its /metrics counters
+type remoteFollower struct {
+	settings RemoteSource
+	cancel   context.CancelFunc
+
+	connected atomic.Bool   // an ssh session is open
+	records   atomic.Uint64 // records handed to parseLog
+	failures  atomic.Uint64 // polls or connections given up on
+	lastError atomic.Value  // string
+}
+
+func newRemoteSources(a *Agent) *remoteSources {
+	return &remoteSources{agent: a, running: make(map[string]*remoteFollower)}
+}
+
+// sync starts, restarts and stops followers to match sources; invalid
+// sources are logged and skipped. A nil receiver (load-generator mode) does nothing.
+func (r *remoteSources) sync(sources []RemoteSource) {
+	if r == nil {
+		return
+	}
+	wanted := make(map[string]RemoteSource, len(sources))
+	for _, s := range sources {
+		if err := s.validate(); err != nil {
+			log.Printf("⚠️  Ignoring remote source %s: %v", s.source(), err)
+			continue
+		}
+		if _, dup := wanted[s.source()]; dup {
+			log.Printf("⚠️  Ignoring duplicate remote source %s", s.source())
+			continue
+		}
+		wanted[s.source()] = s
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for name, f := range r.running {
+		if s, ok := wanted[name]; !ok || !reflect.DeepEqual(s, f.settings) {
+			f.cancel()
+			delete(r.running, name)
+		}
+	}
+	for name, s := range wanted {
+		if _, ok := r.running[name]; ok {
+			continue
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		f := &remoteFollower{settings: s, cancel: cancel}
+		r.running[name] = f
+		go r.agent.followRemote(ctx, f)
+	}
+}
+
+func (r *remoteSources) stopAll() {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for name, f := range r.running {
+		f.cancel()
+		delete(r.running, name)
+	}
+}
+
+// remoteStats is the remote_sources section of /metrics
+func remoteStats(r *remoteSources) []map[string]interface{} {
+	if r == nil {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	names := make([]string, 0, len(r.running))
+	for name := range r.running {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	out := make([]map[string]interface{}, 0, len(names))
+	for _, name := range names {
+		f := r.running[name]
+		lastError, _ := f.lastError.Load().(string)
+		out = append(out, map[string]interface{}{
+			"source":     name,
+			"type":       f.settings.Type,
+			"connected":  f.connected.Load(),
+			"records":    f.records.Load(),
+			"failures":   f.failures.Load(),
+			"last_error": lastError,
+		})
+	}
+	return out
+}
+
+// followRemote reads one remote source until ctx is cancelled
+func (a *Agent) followRemote(ctx context.Context, f *remoteFollower) {
+	src := f.settings
+	name := src.source()
+	log.Printf("Started following remote source %s", name)
+	defer log.Printf("Stopped following remote source %s", name)
+
+	// Records wait for room in logChan; the remote host keeps the backlog
+	emit := func(record string) {
+		f.records.Add(1)
+		if entry := a.parseLog(record, name); entry != nil {
+			select {
+			case a.logChan <- entry:
+			case <-ctx.Done():
+			}
+		}
+	}
+	interval, _ := src.interval()
+	retry := DefaultRetryConfig()
+	retry.Clock = a.clock
+
+	// Kept across polls and reconnects: the next read starts at offset, and a
+	// record cut off by the end of a read is completed by the next one
+	var records recordReader
+	var offset int64
+	for {
+		err := RetryWithBackoff(ctx, retry, "remote source "+name, func() error {
+			if src.Type == remoteSSH {
+				return a.followSSH(ctx, f, &records, &offset, emit)
+			}
+			return a.pollHTTP(ctx, src, &records, &offset, emit)
+		})
+		if ctx.Err() != nil {
+			return
+		}
+		wait := interval
+		if src.Type == remoteSSH {
+			wait = remoteRetryPause
+		}
+		if err != nil {
+			f.failures.Add(1)
+			f.lastError.Store(err.Error())
+			log.Printf("⚠️  Remote source %s: %v; trying again in %s", name, err, remoteRetryPause)
+			wait = remoteRetryPause
+		}
+		select {
+		case <-a.clock.After(wait):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// countingReader adds the bytes read to *n
+type countingReader struct {
+	r io.Reader
+	n *int64
+}
+
+func (c countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	*c.n += int64(n)
+	return n, err
+}
+
+var remoteHTTPClient = &http.Client{Timeout: remoteHTTPTimeout}
+
+// pollHTTP reads what was appended to the remote file since offset
+func (a *Agent) pollHTTP(ctx context.Context, src RemoteSource, records *recordReader, offset *int64, emit func(string)) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, src.URL, nil)
+	if err != nil {
+		return err
+	}
+	for k, v := range src.Headers {
+		req.Header.Set(k, v)
+	}
+	if *offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", *offset))
+	}
+	resp, err := remoteHTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body := io.Reader(resp.Body)
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+	case http.StatusOK:
+		// Range ignored: skip what was already read
+		if skipped, err := io.CopyN(io.Discard, resp.Body, *offset); err != nil {
+			if !errors.Is(err, io.EOF) {
+				return err
+			}
+			log.Printf("Remote source %s shrank from %d to %d bytes, reading it again from the start", src.source(), *offset, skipped)
+			*offset, records.pending = 0, nil
+			return nil
+		}
+	case http.StatusRequestedRangeNotSatisfiable:
+		// Nothing past offset; Content-Range "bytes */<size>" tells whether
+		// the file shrank below it
+		var size int64
+		if _, err := fmt.Sscanf(resp.Header.Get("Content-Range"), "bytes */%d", &size); err == nil && size < *offset {
+			log.Printf("Remote source %s shrank from %d to %d bytes, reading it again from the start", src.source(), *offset, size)
+			*offset, records.pending = 0, nil
+		}
+		return nil
+	default:
+		return fmt.Errorf("GET %s: %s", src.URL, resp.Status)
+	}
+	return records.readAll(countingReader{body, offset}, a.framerFor(src.source()), emit)
+}
+
+// followSSH tails the remote file from offset until the session ends
+func (a *Agent) followSSH(ctx context.Context, f *remoteFollower, records *recordReader, offset *int64, emit func(string)) error {
+	src := f.settings
+	config, err := src.sshConfig()
+	if err != nil {
+		return err
+	}
+	addr := src.Host
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(addr, "22")
+	}
+	dialer := net.Dialer{Timeout: remoteDialTimeout}
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return err
+	}
+	c, chans, reqs, err := ssh.NewClientConn(conn, addr, config)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	client := ssh.NewClient(c, chans, reqs)
+	defer client.Close()
+	// Closing the client ends the read below, on cancel or a dead connection
+	stop := context.AfterFunc(ctx, func() { client.Close() })
+	defer stop()
+	go sshKeepalive(client, a.clock)
+
+	session, err := client.NewSession()
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+	stdout, err := session.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	// tail -c +N starts at byte N (1-based); -F follows the file across rotation
+	cmd := fmt.Sprintf("tail -c +%d -F %s", *offset+1, shellQuote(src.Path))
+	if err := session.Start(cmd); err != nil {
+		return err
+	}
+	f.connected.Store(true)
+	defer f.connected.Store(false)
+	log.Printf("Connected to remote source %s", src.source())
+
+	if err := records.readAll(countingReader{stdout, offset}, a.framerFor(src.source()), emit); err != nil {
+		return err
+	}
+	if err := session.Wait(); err != nil {
+		return fmt.Errorf("remote tail: %w", err)
+	}
+	return errors.New("remote tail exited")
+}
+
+func (s RemoteSource) sshConfig() (*ssh.ClientConfig, error) {
+	key, err := os.ReadFile(s.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+	signer, err := ssh.ParsePrivateKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("key_file %s: %w", s.KeyFile, err)
+	}
+	hostKeys, err := knownhosts.New(s.KnownHosts)
+	if err != nil {
+		return nil, fmt.Errorf("known_hosts %s: %w", s.KnownHosts, err)
+	}
+	return &ssh.ClientConfig{
+		User:            s.User,
+		Auth:            []ssh.AuthMethod{ssh.PublicKeys(signer)},
+		HostKeyCallback: hostKeys,
+		Timeout:         remoteDialTimeout,
+	}, nil
+}
+
+// sshKeepalive closes the client once the server stops answering, so a
+// silently dropped connection doesn't leave the read blocked forever
+func sshKeepalive(client *ssh.Client, clock Clock) {
+	for {
+		<-clock.After(sshKeepaliveInterval)
+		if _, _, err := client.SendRequest("keepalive@openssh.com", true, nil); err != nil {
+			client.Close()
+			return
+		}
+	}
+}
+
+// shellQuote quotes s for the remote shell
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"log"
 	"math"
+	"strings"
 	"sync"
 	"time"
 
@@ -21,6 +22,37 @@ type RetryConfig struct {
 	MaxDelay    time.Duration
 	Multiplier  float64
 	JitterRange float64
+	// Extra error substrings treated as transient, on top of defaultTransientPatterns
+	TransientPatterns []string
+	// Clock times the waits between attempts; nil means realClock
+	Clock Clock
+}
+
+// defaultTransientPatterns are error substrings that always mean "try again"
+var defaultTransientPatterns = []string{
+	"connection refused",
+	"connection reset",
+	"broken pipe",
+	"timeout",
+	"deadline exceeded",
+	"temporary failure",
+	"try again",
+}
+
+// retryTransientPatterns holds RETRY_TRANSIENT_PATTERNS, set by main before any
+// retries run, so every DefaultRetryConfig picks up the operator's patterns
+var retryTransientPatterns []string
+
+// parseTransientPatterns splits a comma-separated list of error substrings,
+// e.g. "upstream connect error,no healthy upstream"
+func parseTransientPatterns(raw string) []string {
+	var patterns []string
+	for _, pattern := range strings.Split(raw, ",") {
+		if pattern = strings.TrimSpace(pattern); pattern != "" {
+			patterns = append(patterns, pattern)
+		}
+	}
+	return patterns
 }
 
 // DefaultRetryConfig returns sensible defaults
@@ -31,21 +63,26 @@ func DefaultRetryConfig() *RetryConfig {
 		MaxDelay:    30 * time.Second,
 		Multiplier:  2.0,
 		JitterRange: 0.1,
+		TransientPatterns: retryTransientPatterns,
 	}
 }
 
 // RetryWithBackoff executes a function with exponential backoff
 func RetryWithBackoff(ctx context.Context, config *RetryConfig, operation string, fn func() error) error {
 	var lastErr error
+	clock := config.Clock
+	if clock == nil {
+		clock = realClock{}
+	}
 	
 	for attempt := 0; attempt <= config.MaxRetries; attempt++ {
 		if attempt > 0 {
-			delay := calculateBackoff(attempt, config)
+			delay := calculateBackoff(attempt, config, clock)
 			log.Printf("Retry %d/%d for %s after %v (last error: %v)", 
 				attempt, config.MaxRetries, operation, delay, lastErr)
 			
 			select {
-			case <-time.After(delay):
+			case <-clock.After(delay):
 			case <-ctx.Done():
 				return ctx.Err()
 			}
@@ -60,7 +97,7 @@ func RetryWithBackoff(ctx context.Context, config *RetryConfig, operation string
 		}
 		
 		// Check if error is retryable
-		if !isRetryable(lastErr) {
+		if !isRetryable(lastErr, config.TransientPatterns...) {
 			log.Printf("Non-retryable error for %s: %v", operation, lastErr)
 			return lastErr
 		}
@@ -70,7 +107,7 @@ func RetryWithBackoff(ctx context.Context, config *RetryConfig, operation string
 }
 
 // calculateBackoff returns the delay for a given attempt with jitter
-func calculateBackoff(attempt int, config *RetryConfig) time.Duration {
+func calculateBackoff(attempt int, config *RetryConfig, clock Clock) time.Duration {
 	// Exponential backoff: baseDelay * (multiplier ^ attempt)
 	delay := float64(config.BaseDelay) * math.Pow(config.Multiplier, float64(attempt-1))
 	
@@ -80,14 +117,15 @@ func calculateBackoff(attempt int, config *RetryConfig) time.Duration {
 	}
 	
 	// Add jitter (±10% by default)
-	jitter := delay * config.JitterRange * (2*float64(time.Now().UnixNano()%1000)/1000 - 1)
+	jitter := delay * config.JitterRange * (2*float64(clock.Now().UnixNano()%1000)/1000 - 1)
 	delay += jitter
 	
 	return time.Duration(delay)
 }
 
-// isRetryable determines if an error should be retried
-func isRetryable(err error) bool {
+// isRetryable determines if an error should be retried. Errors without a
+// gRPC status are retried when they contain a default or extra transient pattern.
+func isRetryable(err error, extraPatterns ...string) bool {
 	if err == nil {
 		return false
 	}
@@ -106,46 +144,24 @@ func isRetryable(err error) bool {
 	
 	// Check for common transient errors
 	errStr := err.Error()
-	transientPatterns := []string{
-		"connection refused",
-		"connection reset",
-		"broken pipe",
-		"timeout",
-		"deadline exceeded",
-		"temporary failure",
-		"try again",
-	}
-	
-	for _, pattern := range transientPatterns {
-		if contains(errStr, pattern) {
-			return true
+	for _, patterns := range [][]string{defaultTransientPatterns, extraPatterns} {
+		for _, pattern := range patterns {
+			if strings.Contains(errStr, pattern) {
+				return true
+			}
 		}
 	}
 	
 	return false
 }
 
-func contains(s, substr string) bool {
-	return len(s) >= len(substr) && (s == substr || len(s) > len(substr) && 
-		(s[:len(substr)] == substr || s[len(s)-len(substr):] == substr || 
-		len(s) > len(substr)*2 && findSubstring(s, substr)))
-}
-
-func findSubstring(s, substr string) bool {
-	for i := 0; i <= len(s)-len(substr); i++ {
-		if s[i:i+len(substr)] == substr {
-			return true
-		}
-	}
-	return false
-}
-
 // CircuitBreaker implements the circuit breaker pattern
 type CircuitBreaker struct {
 	name          string
 	maxFailures   int
 	resetTimeout  time.Duration
 	halfOpenMax   int
+	clock         Clock
 	
 	mu            sync.RWMutex
 	state         CircuitState
@@ -182,6 +198,7 @@ func NewCircuitBreaker(name string, maxFailures int, resetTimeout time.Duration)
 		maxFailures:  maxFailures,
 		resetTimeout: resetTimeout,
 		halfOpenMax:  3,
+		clock:        realClock{},
 		state:        StateClosed,
 	}
 }
@@ -204,7 +221,7 @@ func (cb *CircuitBreaker) beforeRequest() error {
 	switch cb.state {
 	case StateOpen:
 		// Check if we should transition to half-open
-		if time.Since(cb.lastFailTime) > cb.resetTimeout {
+		if cb.clock.Since(cb.lastFailTime) > cb.resetTimeout {
 			log.Printf("Circuit breaker '%s': Transitioning to HALF_OPEN", cb.name)
 			cb.state = StateHalfOpen
 			cb.halfOpenCount = 0
@@ -232,7 +249,7 @@ func (cb *CircuitBreaker) afterRequest(err error) {
 	
 	if err != nil {
 		cb.failures++
-		cb.lastFailTime = time.Now()
+		cb.lastFailTime = cb.clock.Now()
 		
 		switch cb.state {
 		case StateClosed:
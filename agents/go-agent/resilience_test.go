@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const envoyError = "upstream connect error or disconnect/reset before headers"
+
+func TestIsRetryable(t *testing.T) {
+	tests := []struct {
+		name  string
+		err   error
+		extra []string
+		want  bool
+	}{
+		{name: "nil", err: nil, want: false},
+		{name: "default pattern", err: errors.New("dial tcp 10.0.0.1:50051: connection refused"), want: true},
+		{name: "wrapped default pattern", err: fmt.Errorf("send batch: %w", errors.New("write: broken pipe")), want: true},
+		{name: "unknown error", err: errors.New(envoyError), want: false},
+		{name: "custom pattern", err: errors.New(envoyError), extra: []string{"upstream connect error"}, want: true},
+		{name: "custom patterns that don't match", err: errors.New(envoyError), extra: []string{"no healthy upstream"}, want: false},
+		{name: "gRPC unavailable", err: status.Error(codes.Unavailable, "ingestion restarting"), want: true},
+		{name: "gRPC resource exhausted", err: status.Error(codes.ResourceExhausted, "slow down"), want: true},
+		// A gRPC status decides on its own: its message isn't matched against patterns
+		{name: "gRPC invalid argument", err: status.Error(codes.InvalidArgument, "upstream connect error"),
+			extra: []string{"upstream connect error"}, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isRetryable(tt.err, tt.extra...); got != tt.want {
+				t.Errorf("isRetryable(%v, %q) = %v, want %v", tt.err, tt.extra, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseTransientPatterns(t *testing.T) {
+	tests := []struct {
+		raw  string
+		want []string
+	}{
+		{raw: "", want: nil},
+		{raw: "upstream connect error", want: []string{"upstream connect error"}},
+		{raw: " upstream connect error , no healthy upstream ,", want: []string{"upstream connect error", "no healthy upstream"}},
+		{raw: ", ,", want: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.raw, func(t *testing.T) {
+			if got := parseTransientPatterns(tt.raw); !slices.Equal(got, tt.want) {
+				t.Errorf("parseTransientPatterns(%q) = %q, want %q", tt.raw, got, tt.want)
+			}
+		})
+	}
+}
+
+// instantClock never waits, so retry loops run without sleeping
+type instantClock struct{}
+
+func (instantClock) Now() time.Time                  { return time.Unix(0, 0) }
+func (instantClock) Since(t time.Time) time.Duration { return 0 }
+func (instantClock) After(d time.Duration) <-chan time.Time {
+	ch := make(chan time.Time, 1)
+	ch <- time.Unix(0, 0)
+	return ch
+}
+
+func TestRetryWithBackoffCustomPattern(t *testing.T) {
+	tests := []struct {
+		name      string
+		patterns  []string
+		wantCalls int
+		wantErr   bool
+	}{
+		{name: "unknown error gives up at once", patterns: nil, wantCalls: 1, wantErr: true},
+		{name: "custom pattern retries until success", patterns: []string{"upstream connect error"}, wantCalls: 3, wantErr: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := DefaultRetryConfig()
+			config.TransientPatterns = tt.patterns
+			config.Clock = instantClock{}
+
+			calls := 0
+			err := RetryWithBackoff(context.Background(), config, "send", func() error {
+				calls++
+				if calls < 3 {
+					return errors.New(envoyError)
+				}
+				return nil
+			})
+			if calls != tt.wantCalls {
+				t.Errorf("%d calls, want %d", calls, tt.wantCalls)
+			}
+			if (err != nil) != tt.wantErr {
+				t.Errorf("err = %v, want error: %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	crand "crypto/rand"
+	"math/big"
+	mrand "math/rand"
+	"sync"
+)
+
+// sampler decides whether a log line survives sampling at the given rate (0..1).
+// The Agent takes it as a dependency so sampling can be made deterministic.
+type sampler interface {
+	keep(rate float64) bool
+}
+
+// sampleResolution is the granularity of a sampling draw
+const sampleResolution = 1_000_000
+
+// keepDraw applies a rate to a uniform draw in [0, sampleResolution)
+func keepDraw(rate float64, draw int64) bool {
+	if rate >= 1.0 {
+		return true
+	}
+	if rate <= 0 {
+		return false
+	}
+	return draw < int64(rate*sampleResolution)
+}
+
+// cryptoSampler is the default: draws come from crypto/rand and are not reproducible
+type cryptoSampler struct{}
+
+func (cryptoSampler) keep(rate float64) bool {
+	n, err := crand.Int(crand.Reader, big.NewInt(sampleResolution))
+	if err != nil {
+		return true // never lose logs because the entropy source failed
+	}
+	return keepDraw(rate, n.Int64())
+}
+
+// seededSampler draws from a seeded math/rand source, so the same seed and the
+// same sequence of lines always keep the same subset (SAMPLING_SEED)
+type seededSampler struct {
+	mu  sync.Mutex
+	rng *mrand.Rand
+}
+
+func newSeededSampler(seed int64) *seededSampler {
+	return &seededSampler{rng: mrand.New(mrand.NewSource(seed))}
+}
+
+func (s *seededSampler) keep(rate float64) bool {
+	s.mu.Lock()
+	draw := s.rng.Int63n(sampleResolution)
+	s.mu.Unlock()
+	return keepDraw(rate, draw)
+}
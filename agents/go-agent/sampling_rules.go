@@ -0,0 +1,161 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"regexp"
+	"strings"
+)
+
+// ContentRule overrides the base rate of the lines it matches. A rule with a
+// pattern matches messages containing it; a rule with a field matches lines
+// whose structured field (as sent in LogEntry.Fields, e.g. service,
+// request_time or an exec parser's fields) equals value, or matches it as a
+// regular expression with regex: true:
+//
+//	content_rules:
+//	  - field: priority
+//	    value: high
+//	    rate: 1.0
+//	  - field: user_agent
+//	    value: "(?i)bot|crawler"
+//	    regex: true
+//	    rate: 0.01
+//	  - pattern: "OutOfMemory"
+//	    rate: 1.0
+//
+// Field rules take precedence: they are tried first, in order, and message
+// patterns only apply to lines no field rule matched. Within each kind the
+// first match wins. A line matching no rule uses its level's base rate.
+type ContentRule struct {
+	Pattern string  `yaml:"pattern"`
+	Field   string  `yaml:"field"`
+	Value   string  `yaml:"value"`
+	Regex   bool    `yaml:"regex"`
+	Rate    float64 `yaml:"rate"`
+
+	re       *regexp.Regexp // compiled Value when Regex is set
+	disabled bool           // invalid rule, never matches
+}
+
+// compileRules prepares field rules once per config load; invalid rules are
+// logged and disabled rather than rejecting the whole config
+func compileRules(rules []ContentRule) {
+	for i := range rules {
+		rule := &rules[i]
+		switch {
+		case rule.Field == "" && rule.Pattern == "":
+			log.Printf("⚠️  Ignoring content rule without pattern or field")
+			rule.disabled = true
+		case rule.Field != "" && rule.Pattern != "":
+			log.Printf("⚠️  Content rule on field %q also has pattern %q; the pattern is ignored", rule.Field, rule.Pattern)
+		}
+		if rule.Field != "" && rule.Regex {
+			re, err := regexp.Compile(rule.Value)
+			if err != nil {
+				log.Printf("⚠️  Ignoring content rule on field %q: invalid regex %q: %v", rule.Field, rule.Value, err)
+				rule.disabled = true
+				continue
+			}
+			rule.re = re
+		}
+	}
+}
+
+// key identifies the rule in config diffs: the quoted pattern, or field=value
+// (field~regex for regex rules)
+func (r ContentRule) key() string {
+	switch {
+	case r.Field == "":
+		return fmt.Sprintf("%q", r.Pattern)
+	case r.Regex:
+		return fmt.Sprintf("%s~%q", r.Field, r.Value)
+	}
+	return fmt.Sprintf("%s=%q", r.Field, r.Value)
+}
+
+func (r *ContentRule) matchesField(fields map[string]string) bool {
+	value, ok := fields[r.Field]
+	if !ok {
+		return false
+	}
+	if r.re != nil {
+		return r.re.MatchString(value)
+	}
+	return value == r.Value
+}
+
+// serviceDefaultRate is the service_rates key for a service's rate at levels it
+// doesn't list
+const serviceDefaultRate = "default"
+
+// sanitizeRates drops base_rates and service_rates entries whose rate is not
+// between 0 and 1 (a negative rate would drop everything, one above 1 is
+// meaningless), logging each, rather than rejecting the whole config
+func sanitizeRates(cfg *AgentConfig) {
+	dropInvalidRates("base_rates", cfg.Sampling.BaseRates)
+	for service, rates := range cfg.Sampling.ServiceRates {
+		dropInvalidRates("service_rates."+service, rates)
+		for key := range rates {
+			if _, ok := levelSeverity[key]; !ok && key != serviceDefaultRate {
+				log.Printf("⚠️  service_rates.%s.%s is not DEBUG, INFO, WARN, ERROR or %s; it only matches lines with exactly that level", service, key, serviceDefaultRate)
+			}
+		}
+		if len(rates) == 0 {
+			delete(cfg.Sampling.ServiceRates, service)
+		}
+	}
+}
+
+func dropInvalidRates(name string, rates map[string]float64) {
+	for key, rate := range rates {
+		if rate < 0 || rate > 1 || math.IsNaN(rate) {
+			log.Printf("⚠️  Ignoring %s.%s: rate %g is not between 0 and 1", name, key, rate)
+			delete(rates, key)
+		}
+	}
+}
+
+// sampleRate picks the sampling rate for a parsed line of service, most
+// specific first:
+//
+//  1. the first matching field rule, then the first matching message pattern
+//  2. service_rates[service][level]
+//  3. service_rates[service].default
+//  4. base_rates[level]
+//  5. 1.0, keeping the line
+//
+// so a busy service can be thinned without touching the others, e.g.
+//
+//	service_rates:
+//	  user-service:
+//	    INFO: 0.01
+//	    default: 0.5
+//	  nginx:
+//	    INFO: 1.0
+func sampleRate(cfg *AgentConfig, service, level, message string, fields map[string]string) float64 {
+	rules := cfg.Sampling.ContentRules
+	for i := range rules {
+		if rule := &rules[i]; !rule.disabled && rule.Field != "" && rule.matchesField(fields) {
+			return rule.Rate
+		}
+	}
+	for i := range rules {
+		if rule := &rules[i]; !rule.disabled && rule.Field == "" && strings.Contains(message, rule.Pattern) {
+			return rule.Rate
+		}
+	}
+	if rates, ok := cfg.Sampling.ServiceRates[service]; ok {
+		if rate, ok := rates[level]; ok {
+			return rate
+		}
+		if rate, ok := rates[serviceDefaultRate]; ok {
+			return rate
+		}
+	}
+	if rate, ok := cfg.Sampling.BaseRates[level]; ok {
+		return rate
+	}
+	return 1.0 // Default to 100% sampling
+}
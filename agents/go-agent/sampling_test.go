@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"testing"
+)
+
+func TestKeepDraw(t *testing.T) {
+	tests := []struct {
+		name string
+		rate float64
+		draw int64
+		want bool
+	}{
+		{name: "full rate keeps the highest draw", rate: 1.0, draw: sampleResolution - 1, want: true},
+		{name: "rate above one", rate: 1.5, draw: sampleResolution - 1, want: true},
+		{name: "zero rate drops the lowest draw", rate: 0, draw: 0, want: false},
+		{name: "negative rate", rate: -0.5, draw: 0, want: false},
+		{name: "half keeps draws below the midpoint", rate: 0.5, draw: sampleResolution/2 - 1, want: true},
+		{name: "half drops the midpoint", rate: 0.5, draw: sampleResolution / 2, want: false},
+		{name: "one percent", rate: 0.01, draw: 9_999, want: true},
+		{name: "one percent boundary", rate: 0.01, draw: 10_000, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := keepDraw(tt.rate, tt.draw); got != tt.want {
+				t.Errorf("keepDraw(%v, %d) = %v, want %v", tt.rate, tt.draw, got, tt.want)
+			}
+		})
+	}
+}
+
+// sampledLines runs lines 0..n-1 of one INFO stream through parseLog with
+// INFO sampled at rate and returns the indices it kept
+func sampledLines(s sampler, rate float64, n int) []int {
+	cfg := &AgentConfig{}
+	cfg.Sampling.BaseRates = map[string]float64{"INFO": rate}
+	a := &Agent{config: cfg, sampler: s, clock: realClock{}, audit: &dropAudit{}}
+
+	var kept []int
+	for i := 0; i < n; i++ {
+		line := fmt.Sprintf("[2024-01-15T10:30:00] [INFO] [checkout] request %d served", i)
+		if a.parseLog(line, "/var/log/app/checkout.log") != nil {
+			kept = append(kept, i)
+		}
+	}
+	return kept
+}
+
+func TestSeededSamplingKeepsAFixedSubset(t *testing.T) {
+	tests := []struct {
+		name string
+		seed int64
+		rate float64
+		want []int
+	}{
+		{name: "seed 42 at 50%", seed: 42, rate: 0.5, want: []int{0, 5, 8, 12, 13, 19}},
+		{name: "seed 7 at 50%", seed: 7, rate: 0.5, want: []int{0, 2, 5, 7, 8, 9, 11, 14, 16, 17}},
+		{name: "full rate keeps everything", seed: 42, rate: 1.0, want: []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18, 19}},
+		{name: "zero rate keeps nothing", seed: 42, rate: 0, want: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := sampledLines(newSeededSampler(tt.seed), tt.rate, 20)
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("kept %v, want %v", got, tt.want)
+			}
+			// Same seed, same input: same subset
+			if again := sampledLines(newSeededSampler(tt.seed), tt.rate, 20); !slices.Equal(again, got) {
+				t.Errorf("second run kept %v, first kept %v", again, got)
+			}
+		})
+	}
+}
+
+func TestSampledLinesAreCounted(t *testing.T) {
+	cfg := &AgentConfig{}
+	cfg.Sampling.BaseRates = map[string]float64{"INFO": 0.5}
+	a := &Agent{config: cfg, sampler: newSeededSampler(42), clock: realClock{}, audit: &dropAudit{}}
+	for i := 0; i < 20; i++ {
+		a.parseLog(fmt.Sprintf("[2024-01-15T10:30:00] [INFO] [checkout] request %d served", i), "/var/log/app/checkout.log")
+	}
+	if processed, sampled := a.logsProcessed.Load(), a.logsSampled.Load(); processed != 6 || sampled != 14 {
+		t.Errorf("processed %d, sampled %d; want 6 and 14 (the seed 42 subset above)", processed, sampled)
+	}
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+
+	configpb "stackmonitor.com/go-agent/configproto"
+	logpb "stackmonitor.com/go-agent/logproto"
+)
+
+const selfTestTimeout = 5 * time.Second
+
+// selfTestStep is one line of the self-test report
+type selfTestStep struct {
+	name     string
+	duration time.Duration
+	err      error
+	detail   string
+}
+
+// selfTestRetryConfig keeps retries short so a broken setup fails fast
+func selfTestRetryConfig() *RetryConfig {
+	config := DefaultRetryConfig()
+	config.MaxRetries = 2
+	config.BaseDelay = 500 * time.Millisecond
+	config.MaxDelay = 2 * time.Second
+	return config
+}
+
+// runSelfTest checks connectivity to the config and ingestion services end to end:
+// dial both, fetch config once, send one synthetic batch and wait for its ack.
+// It prints a pass/fail report and returns true when every step passed.
+func runSelfTest(agentID, configURL, ingestionURL string) bool {
+	fmt.Printf("StackMonitor agent self-test (agent %s)\n\n", agentID)
+
+	var steps []selfTestStep
+	run := func(name string, fn func() (string, error)) bool {
+		start := time.Now()
+		detail, err := fn()
+		steps = append(steps, selfTestStep{name: name, duration: time.Since(start), err: err, detail: detail})
+		return err == nil
+	}
+
+	var configConn, ingestionConn *grpc.ClientConn
+	defer func() {
+		if configConn != nil {
+			configConn.Close()
+		}
+		if ingestionConn != nil {
+			ingestionConn.Close()
+		}
+	}()
+
+	ok := run("dial config service "+configURL, func() (string, error) {
+		var err error
+		configConn, err = selfTestDial(configURL)
+		return "", err
+	})
+
+	if ok {
+		run("fetch config", func() (string, error) {
+			var resp *configpb.ConfigResponse
+			err := RetryWithBackoff(context.Background(), selfTestRetryConfig(), "fetch config", func() error {
+				ctx, cancel := context.WithTimeout(context.Background(), selfTestTimeout)
+				defer cancel()
+				var err error
+				resp, err = configpb.NewConfigServiceClient(configConn).GetConfig(ctx, &configpb.ConfigRequest{AgentId: agentID})
+				return err
+			})
+			if err != nil {
+				return "", err
+			}
+			return fmt.Sprintf("version %s, %d bytes", resp.Version, len(resp.ConfigPayload)), nil
+		})
+	}
+
+	ok = run("dial ingestion service "+ingestionURL, func() (string, error) {
+		var err error
+		ingestionConn, err = selfTestDial(ingestionURL)
+		return "", err
+	})
+
+	if ok {
+		run("send synthetic batch and wait for ack", func() (string, error) {
+			return selfTestSendBatch(logpb.NewLogIngestionClient(ingestionConn), agentID)
+		})
+	}
+
+	passed := true
+	fmt.Println()
+	for _, step := range steps {
+		status := "PASS"
+		if step.err != nil {
+			status = "FAIL"
+			passed = false
+		}
+		line := fmt.Sprintf("[%s] %-50s %8s", status, step.name, step.duration.Round(time.Millisecond))
+		if step.err != nil {
+			line += "  " + step.err.Error()
+		} else if step.detail != "" {
+			line += "  " + step.detail
+		}
+		fmt.Println(line)
+	}
+
+	fmt.Println()
+	if passed {
+		fmt.Println("✅ Self-test passed: agent can reach config and ingestion services")
+	} else {
+		fmt.Println("❌ Self-test failed")
+	}
+	return passed
+}
+
+// selfTestDial blocks until the connection is ready so unreachable hosts show up here
+func selfTestDial(target string) (*grpc.ClientConn, error) {
+	var conn *grpc.ClientConn
+	err := RetryWithBackoff(context.Background(), selfTestRetryConfig(), "dial "+target, func() error {
+		ctx, cancel := context.WithTimeout(context.Background(), selfTestTimeout)
+		defer cancel()
+		var err error
+		conn, err = grpc.DialContext(ctx, target,
+			grpc.WithTransportCredentials(insecure.NewCredentials()),
+			grpc.WithBlock())
+		return err
+	})
+	return conn, err
+}
+
+// selfTestSendBatch streams a single-entry batch and waits for the server's ack
+func selfTestSendBatch(client logpb.LogIngestionClient, agentID string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), selfTestTimeout)
+	defer cancel()
+
+	stream, err := client.StreamLogs(ctx)
+	if err != nil {
+		return "", fmt.Errorf("open stream: %w", err)
+	}
+
+	now := time.Now()
+	batchID := now.UnixNano()
+	batch := &logpb.LogBatch{
+		AgentId:     agentID,
+		BatchId:     batchID,
+		TimestampMs: now.UnixMilli(),
+		Logs: []*logpb.LogEntry{{
+			TimestampNs: now.UnixNano(),
+			Level:       "INFO",
+			Message:     "StackMonitor agent self-test",
+			Source:      "selftest",
+			Fields: map[string]string{
+				"service":  "selftest",
+				"trace_id": fmt.Sprintf("selftest-%d", batchID),
+			},
+			AgentId: agentID,
+		}},
+		Compression: logpb.CompressionType_NONE,
+		Metadata:    map[string]string{"selftest": "true"},
+	}
+	if err := stream.Send(batch); err != nil {
+		return "", fmt.Errorf("send: %w", err)
+	}
+
+	ack, err := stream.Recv()
+	if err != nil {
+		return "", fmt.Errorf("waiting for ack: %w", err)
+	}
+	stream.CloseSend()
+	if ack.BatchId != batchID {
+		return "", fmt.Errorf("ack for unexpected batch %d", ack.BatchId)
+	}
+	if ack.Status != logpb.AckStatus_SUCCESS {
+		return "", fmt.Errorf("ack status %s: %s", ack.Status, ack.Message)
+	}
+	return "ack: " + ack.Message, nil
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"log"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Parser names usable in services.sources.<path>.parser
+const (
+	parserApp    = "app"
+	parserTomcat = "tomcat"
+	parserNginx  = "nginx"
+	parserExec   = "exec" // external program, see exec_parser.go
+	parserJSON   = "json" // one exec-reply object per record, see records.go
+	parserRaw    = "raw"  // the whole line as the message, nothing parsed
+)
+
+// SourceSettings overrides parsing for one log file
+type SourceSettings struct {
+	// Service replaces whatever service the parser derived for every line of the file
+	Service string `yaml:"service"`
+	// Parser pins the file to one format (app, tomcat, nginx, exec, json or raw) instead of trying each in turn
+	Parser string `yaml:"parser"`
+	// Delimiter ends each record instead of \n, see records.go; ignored by the json parser
+	Delimiter string `yaml:"delimiter"`
+	// Command and Timeout configure the exec parser: the program and its
+	// arguments, and how long it may take per line (default 1s)
+	Command []string `yaml:"command"`
+	Timeout string   `yaml:"timeout"`
+	// Level is given to every line of a raw source (default INFO); with
+	// SkipSampling its lines bypass sampling and are all shipped
+	Level        string `yaml:"level"`
+	SkipSampling bool   `yaml:"skip_sampling"`
+}
+
+// ServiceMapping controls how the service field, the platform's main grouping
+// key, is populated:
+//
+//	services:
+//	  sources:
+//	    /logs/tomcat.log: {service: billing, parser: tomcat}
+//	  from_path:
+//	    pattern: '^/logs/([^/]+)/'
+//	  aliases:
+//	    Nginx: nginx
+//	    payment-svc: payment-service
+//	  lowercase: true
+type ServiceMapping struct {
+	Sources map[string]SourceSettings `yaml:"sources"`
+	// Service taken from the file path for lines that don't name one
+	FromPath PathService `yaml:"from_path"`
+	// Parsed service name -> canonical name; keys match case-insensitively
+	Aliases map[string]string `yaml:"aliases"`
+	// Lowercase every service name after aliasing
+	Lowercase bool `yaml:"lowercase"`
+}
+
+// PathService derives a service from the path of the file a line came from,
+// e.g. payment-service for /logs/payment-service/app.log with the pattern
+// '^/logs/([^/]+)/'. Service is a template over the pattern's groups ($1,
+// ${name}); it defaults to $1. Paths the pattern doesn't match keep the
+// parser's fallback.
+type PathService struct {
+	Pattern string `yaml:"pattern"`
+	Service string `yaml:"service"`
+
+	re *regexp.Regexp // compiled Pattern; nil when unset or invalid
+}
+
+// sanitize drops unknown parser names, which would otherwise reject every
+// line of the source, and compiles from_path
+func (m *ServiceMapping) sanitize() {
+	if m.FromPath.Pattern != "" {
+		re, err := regexp.Compile(m.FromPath.Pattern)
+		if err != nil {
+			log.Printf("⚠️  Ignoring invalid services.from_path pattern %q: %v", m.FromPath.Pattern, err)
+		} else {
+			m.FromPath.re = re
+		}
+	}
+	for source, settings := range m.Sources {
+		switch settings.Parser {
+		case "", parserApp, parserTomcat, parserNginx, parserJSON:
+			continue
+		case parserRaw:
+			level := strings.ToUpper(settings.Level)
+			if _, ok := levelSeverity[level]; !ok {
+				if level != "" {
+					log.Printf("⚠️  Invalid raw level %q for %s, using INFO", settings.Level, source)
+				}
+				level = "INFO"
+			}
+			settings.Level = level
+			m.Sources[source] = settings
+			continue
+		case parserExec:
+			if len(settings.Command) == 0 {
+				log.Printf("⚠️  Ignoring exec parser for %s: no command", source)
+				break
+			}
+			if settings.Timeout != "" {
+				if d, err := time.ParseDuration(settings.Timeout); err != nil || d <= 0 {
+					log.Printf("⚠️  Invalid exec parser timeout %q for %s, using %s", settings.Timeout, source, defaultExecTimeout)
+					settings.Timeout = ""
+					m.Sources[source] = settings
+				}
+			}
+			continue
+		default:
+			log.Printf("⚠️  Ignoring unknown parser %q for %s (want %s, %s, %s, %s, %s or %s)", settings.Parser, source, parserApp, parserTomcat, parserNginx, parserExec, parserJSON, parserRaw)
+		}
+		settings.Parser = ""
+		m.Sources[source] = settings
+	}
+}
+
+// canonicalService applies the mapping to the service parsed from a line of source.
+// A per-source service wins outright; otherwise aliases and lowercasing apply.
+func (m *ServiceMapping) canonicalService(source, parsed string) string {
+	if s := m.Sources[source].Service; s != "" {
+		return s
+	}
+	service := parsed
+	if alias, ok := m.Aliases[service]; ok {
+		service = alias
+	} else {
+		for from, to := range m.Aliases {
+			if strings.EqualFold(from, service) {
+				service = to
+				break
+			}
+		}
+	}
+	if m.Lowercase {
+		service = strings.ToLower(service)
+	}
+	return service
+}
+
+// defaultService is the service of a line of source that doesn't name one:
+// the one from_path extracts from the path, else fallback (the file name, or
+// the format for tomcat and nginx lines)
+func (m *ServiceMapping) defaultService(source, fallback string) string {
+	re := m.FromPath.re
+	if re == nil {
+		return fallback
+	}
+	match := re.FindStringSubmatchIndex(source)
+	if match == nil {
+		return fallback
+	}
+	template := m.FromPath.Service
+	if template == "" {
+		template = "$1"
+	}
+	if service := string(re.ExpandString(nil, template, source, match)); service != "" {
+		return service
+	}
+	return fallback
+}
+
+// matchFormat runs re against line unless the source is pinned to another parser
+func matchFormat(pinned, format string, re *regexp.Regexp, line string) []string {
+	if pinned != "" && pinned != format {
+		return nil
+	}
+	return re.FindStringSubmatch(line)
+}
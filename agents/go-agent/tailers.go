@@ -0,0 +1,68 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// Every tailed file holds a descriptor and an fsnotify watch for as long as
+// the agent runs. MAX_TAILERS bounds how many are tailed at once; files past
+// the limit wait, in the order they were found, for a tailer to finish.
+const (
+	defaultMaxTailers = 128
+	fdBackoffInitial  = time.Second
+	fdBackoffMax      = time.Minute
+)
+
+// tailerLimiter hands out tailing slots
+type tailerLimiter struct {
+	slots      chan struct{}
+	active     atomic.Int64
+	queued     atomic.Int64
+	fdBackoffs atomic.Uint64 // opens retried after running out of descriptors or watches
+}
+
+func newTailerLimiter(max int) *tailerLimiter {
+	return &tailerLimiter{slots: make(chan struct{}, max)}
+}
+
+// acquire blocks until a slot is free
+func (l *tailerLimiter) acquire() {
+	l.queued.Add(1)
+	l.slots <- struct{}{}
+	l.queued.Add(-1)
+	l.active.Add(1)
+}
+
+func (l *tailerLimiter) release() {
+	l.active.Add(-1)
+	<-l.slots
+}
+
+// fdExhausted reports whether err means the process or host ran out of file
+// descriptors (EMFILE, ENFILE) or inotify watches (ENOSPC from inotify_add_watch)
+func fdExhausted(err error) bool {
+	return errors.Is(err, syscall.EMFILE) || errors.Is(err, syscall.ENFILE) ||
+		errors.Is(err, syscall.ENOSPC) || strings.Contains(err.Error(), "too many open files")
+}
+
+// openWithBackoff runs open until it succeeds or fails for another reason
+// than exhausted descriptors, waiting longer after each exhausted attempt so
+// the agent neither crashes nor starves other processes on the host
+func (a *Agent) openWithBackoff(what, path string, open func() error) error {
+	backoff := fdBackoffInitial
+	for {
+		err := open()
+		if err == nil || !fdExhausted(err) {
+			return err
+		}
+		a.tailers.fdBackoffs.Add(1)
+		log.Printf("⚠️  %s %s: %v; retrying in %s", what, path, err, backoff)
+		<-a.clock.After(backoff)
+		backoff = min(backoff*2, fdBackoffMax)
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	logpb "stackmonitor.com/go-agent/logproto"
+)
+
+// When the ingestion service can't store batches it turns them away unread
+// with a RETRY ack carrying retry_after_ms. The agent then stops sending for
+// that long, without building or compressing batches; meanwhile logChan fills
+// up and the overflow policy decides what the tailers drop, as with
+// max_in_flight. The rejected batches are sent again first once the pause ends.
+// A RETRY without retry_after_ms (a failed durable insert, a payload that
+// failed to decompress) is resent the same way after defaultRetryBackoff.
+const defaultRetryBackoff = time.Second
+
+type throttle struct {
+	mu       sync.Mutex
+	until    time.Time
+	rejected []rejectedBatch
+
+	pauses atomic.Uint64 // RETRY acks
+}
+
+type rejectedBatch struct {
+	id   int64
+	logs []*logpb.LogEntry
+}
+
+// reject records a batch the server turned away and extends the pause; logs
+// is nil when the batch was no longer tracked (its ack timed out)
+func (t *throttle) reject(batchID int64, logs []*logpb.LogEntry, retryAfter time.Duration, now time.Time) {
+	t.pauses.Add(1)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if until := now.Add(retryAfter); until.After(t.until) {
+		t.until = until
+	}
+	if len(logs) > 0 {
+		t.rejected = append(t.rejected, rejectedBatch{id: batchID, logs: logs})
+	}
+}
+
+// take returns how long sending must still wait, or, once it may resume, the
+// rejected batches to send again
+func (t *throttle) take(now time.Time) (time.Duration, []rejectedBatch) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if now.Before(t.until) {
+		return t.until.Sub(now), nil
+	}
+	rejected := t.rejected
+	t.rejected = nil
+	return 0, rejected
+}
+
+func (t *throttle) paused(now time.Time) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return now.Before(t.until)
+}
+
+// waitOutThrottle blocks batchSender while the server asked for a pause, then
+// resends what it rejected. A resent batch gets a new ID and WAL record, so
+// the old record is acked only after it.
+func (a *Agent) waitOutThrottle() {
+	for {
+		wait, rejected := a.throttle.take(a.clock.Now())
+		if wait > 0 {
+			log.Printf("⏸️  Ingestion service asked to pause, sending again in %s", wait.Round(time.Millisecond))
+			<-a.clock.After(wait)
+			continue
+		}
+		for _, b := range rejected {
+			a.sendBatch(b.logs)
+			if a.wal != nil {
+				a.wal.ack(b.id)
+			}
+		}
+		return
+	}
+}
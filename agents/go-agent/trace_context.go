@@ -0,0 +1,41 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+)
+
+// traceparentRegex matches a W3C traceparent (version 00): the trace ID, the
+// ID of the span the line was logged under, and the trace flags
+var traceparentRegex = regexp.MustCompile(`\b00-([0-9a-f]{32})-([0-9a-f]{16})-[0-9a-f]{2}\b`)
+
+// parentSpanRegex finds the caller's span, which traceparent doesn't carry;
+// services that want their spans nested log it as a logfmt token
+var parentSpanRegex = regexp.MustCompile(`\bparent_span_id=([0-9a-f]{16})\b`)
+
+const (
+	zeroTraceID = "00000000000000000000000000000000"
+	zeroSpanID  = "0000000000000000"
+)
+
+// applyTraceContext sets trace_id, span_id and parent_span_id from a W3C
+// traceparent, taken from a traceparent field a parser extracted or else from
+// the raw line. Lines without one keep their placeholder trace_id, and the
+// api-server orders them by timestamp.
+func applyTraceContext(fields map[string]string, line string) {
+	m := traceparentRegex.FindStringSubmatch(strings.ToLower(fields["traceparent"]))
+	if m == nil {
+		m = traceparentRegex.FindStringSubmatch(line)
+	}
+	if m == nil || m[1] == zeroTraceID || m[2] == zeroSpanID {
+		return
+	}
+	fields["trace_id"] = m[1]
+	fields["span_id"] = m[2]
+	if fields["parent_span_id"] != "" {
+		return
+	}
+	if p := parentSpanRegex.FindStringSubmatch(line); p != nil && p[1] != zeroSpanID && p[1] != m[2] {
+		fields["parent_span_id"] = p[1]
+	}
+}
@@ -0,0 +1,364 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"google.golang.org/protobuf/proto"
+
+	logpb "stackmonitor.com/go-agent/logproto"
+)
+
+// The write-ahead log (WAL_ENABLED=true) keeps every batch on disk from just
+// before it is sent until the ingestion service acks it, so batches lost to an
+// agent crash, a failed send or a RETRY ack are sent again on the next start.
+//
+// It is a directory of append-only segment files, each a sequence of records:
+//
+//	type (1 byte) | seq (8) | payload length (4) | CRC-32 of the rest (4) | payload
+//
+// A batch record carries the entries as a LogBatch; an ack record, written
+// when the batch is acked, carries no payload and names the batch by seq.
+// Segments are deleted oldest first once all their batches are acked (acks
+// always land in the same or a later segment). When the WAL outgrows
+// WAL_MAX_MB the oldest segment is deleted anyway and its unacked batches are
+// counted as evicted.
+const (
+	walRecordBatch byte = 1
+	walRecordAck   byte = 2
+
+	walHeaderSize    = 17
+	walSegmentBytes  = 4 << 20
+	defaultWALMaxMB  = 256
+	walSegmentSuffix = ".wal"
+)
+
+// walBatch is an unacked batch found on startup
+type walBatch struct {
+	seq  uint64
+	logs []*logpb.LogEntry
+}
+
+type walSegment struct {
+	id      uint64
+	path    string
+	size    int64
+	pending int // batch records not yet acked
+}
+
+type wal struct {
+	dir          string
+	maxBytes     int64
+	segmentBytes int64 // a quarter of maxBytes at most, so eviction is gradual
+
+	mu         sync.Mutex
+	segments   []*walSegment // oldest first; the last one is appended to
+	active     *os.File
+	nextSeq    uint64
+	segmentOf  map[uint64]*walSegment // seq of an unacked batch -> its segment
+	seqOfBatch map[int64]uint64       // batch ID sent this run -> seq
+
+	evicted atomic.Uint64 // unacked batches deleted to stay under maxBytes
+	corrupt atomic.Uint64 // damaged records skipped on startup
+	errors  atomic.Uint64 // failed writes
+}
+
+// openWAL recovers the WAL in dir and returns the batches still unacked,
+// oldest first. A damaged record ends its segment: everything after it is
+// skipped, since its length can't be trusted.
+func openWAL(dir string, maxBytes int64) (*wal, []walBatch, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, nil, err
+	}
+	w := &wal{
+		dir:          dir,
+		maxBytes:     maxBytes,
+		segmentBytes: min(walSegmentBytes, maxBytes/4),
+		nextSeq:      1,
+		segmentOf:    make(map[uint64]*walSegment),
+		seqOfBatch:   make(map[int64]uint64),
+	}
+
+	names, err := filepath.Glob(filepath.Join(dir, "*"+walSegmentSuffix))
+	if err != nil {
+		return nil, nil, err
+	}
+	var nextID uint64 = 1
+	batches := make(map[uint64]walBatch)
+	for _, path := range names {
+		id, err := strconv.ParseUint(strings.TrimSuffix(filepath.Base(path), walSegmentSuffix), 10, 64)
+		if err != nil {
+			continue
+		}
+		seg := &walSegment{id: id, path: path}
+		if err := w.recoverSegment(seg, batches); err != nil {
+			return nil, nil, err
+		}
+		w.segments = append(w.segments, seg)
+		nextID = max(nextID, id+1)
+	}
+	sort.Slice(w.segments, func(i, j int) bool { return w.segments[i].id < w.segments[j].id })
+
+	unacked := make([]walBatch, 0, len(batches))
+	for _, b := range batches {
+		unacked = append(unacked, b)
+	}
+	sort.Slice(unacked, func(i, j int) bool { return unacked[i].seq < unacked[j].seq })
+
+	// Never append behind a possibly torn record: start a fresh segment
+	if err := w.startSegment(nextID); err != nil {
+		return nil, nil, err
+	}
+	w.mu.Lock()
+	w.releaseSegments()
+	w.enforceLimit()
+	w.mu.Unlock()
+	return w, unacked, nil
+}
+
+// recoverSegment reads one segment into batches, applying its acks
+func (w *wal) recoverSegment(seg *walSegment, batches map[uint64]walBatch) error {
+	f, err := os.Open(seg.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if info, err := f.Stat(); err == nil {
+		seg.size = info.Size()
+	}
+
+	r := bufio.NewReader(f)
+	var offset int64
+	for {
+		typ, seq, payload, err := readWALRecord(r)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			w.corrupt.Add(1)
+			log.Printf("⚠️  WAL segment %s damaged at offset %d (%v), skipping the rest of it", seg.path, offset, err)
+			return nil
+		}
+		offset += int64(walHeaderSize + len(payload))
+		w.nextSeq = max(w.nextSeq, seq+1)
+
+		switch typ {
+		case walRecordBatch:
+			var batch logpb.LogBatch
+			if err := proto.Unmarshal(payload, &batch); err != nil {
+				w.corrupt.Add(1)
+				continue
+			}
+			batches[seq] = walBatch{seq: seq, logs: batch.Logs}
+			w.segmentOf[seq] = seg
+			seg.pending++
+		case walRecordAck:
+			if owner, ok := w.segmentOf[seq]; ok {
+				delete(batches, seq)
+				delete(w.segmentOf, seq)
+				owner.pending--
+			}
+		}
+	}
+}
+
+func readWALRecord(r io.Reader) (byte, uint64, []byte, error) {
+	header := make([]byte, walHeaderSize)
+	if _, err := io.ReadFull(r, header); err != nil {
+		if err == io.EOF {
+			return 0, 0, nil, io.EOF
+		}
+		return 0, 0, nil, fmt.Errorf("truncated header: %w", err)
+	}
+	typ, seq := header[0], binary.BigEndian.Uint64(header[1:9])
+	length := binary.BigEndian.Uint32(header[9:13])
+	if (typ != walRecordBatch && typ != walRecordAck) || length > 1<<30 {
+		return 0, 0, nil, errors.New("invalid header")
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return 0, 0, nil, fmt.Errorf("truncated payload: %w", err)
+	}
+	if crc32.ChecksumIEEE(append(header[:13:13], payload...)) != binary.BigEndian.Uint32(header[13:17]) {
+		return 0, 0, nil, errors.New("checksum mismatch")
+	}
+	return typ, seq, payload, nil
+}
+
+func (w *wal) startSegment(id uint64) error {
+	path := filepath.Join(w.dir, fmt.Sprintf("%020d%s", id, walSegmentSuffix))
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o640)
+	if err != nil {
+		return err
+	}
+	if w.active != nil {
+		w.active.Close()
+	}
+	w.active = f
+	w.segments = append(w.segments, &walSegment{id: id, path: path})
+	return nil
+}
+
+// write appends one record to the active segment; the caller holds mu
+func (w *wal) write(typ byte, seq uint64, payload []byte, sync bool) error {
+	record := make([]byte, walHeaderSize, walHeaderSize+len(payload))
+	record[0] = typ
+	binary.BigEndian.PutUint64(record[1:9], seq)
+	binary.BigEndian.PutUint32(record[9:13], uint32(len(payload)))
+	record = append(record, payload...)
+	binary.BigEndian.PutUint32(record[13:17], crc32.ChecksumIEEE(append(record[:13:13], payload...)))
+
+	seg := w.segments[len(w.segments)-1]
+	n, err := w.active.Write(record)
+	seg.size += int64(n)
+	if err == nil && sync {
+		err = w.active.Sync()
+	}
+	if err != nil {
+		w.errors.Add(1)
+		// A partly written record would hide every record after it on recovery
+		if n > 0 {
+			w.startSegment(seg.id + 1)
+		}
+		return err
+	}
+	if seg.size >= w.segmentBytes {
+		if err := w.startSegment(seg.id + 1); err != nil {
+			w.errors.Add(1)
+			log.Printf("⚠️  WAL segment rotation failed: %v", err)
+		}
+	}
+	return nil
+}
+
+// append durably records a batch about to be sent
+func (w *wal) append(batchID int64, logs []*logpb.LogEntry) error {
+	payload, err := proto.Marshal(&logpb.LogBatch{Logs: logs})
+	if err != nil {
+		return err
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	seq := w.nextSeq
+	w.nextSeq++
+	seg := w.segments[len(w.segments)-1]
+	if err := w.write(walRecordBatch, seq, payload, true); err != nil {
+		return err
+	}
+	seg.pending++
+	w.segmentOf[seq] = seg
+	w.seqOfBatch[batchID] = seq
+	w.enforceLimit()
+	return nil
+}
+
+// ack marks the batch sent under batchID as delivered
+func (w *wal) ack(batchID int64) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	seq, ok := w.seqOfBatch[batchID]
+	if !ok {
+		return
+	}
+	delete(w.seqOfBatch, batchID)
+	w.ackSeq(seq)
+}
+
+// replayed marks a recovered batch done once its entries were sent again
+// (under a new seq, so they stay covered until that batch is acked)
+func (w *wal) replayed(seq uint64) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.ackSeq(seq)
+}
+
+// ackSeq writes the ack record and frees fully acked segments; the caller holds mu
+func (w *wal) ackSeq(seq uint64) {
+	seg, ok := w.segmentOf[seq]
+	if !ok {
+		return
+	}
+	// Not synced: a lost ack only means the batch is sent twice
+	if err := w.write(walRecordAck, seq, nil, false); err != nil {
+		log.Printf("⚠️  WAL ack write failed: %v", err)
+		return
+	}
+	delete(w.segmentOf, seq)
+	seg.pending--
+	w.releaseSegments()
+}
+
+// releaseSegments deletes acked segments from the oldest end, never the active one
+func (w *wal) releaseSegments() {
+	for len(w.segments) > 1 && w.segments[0].pending <= 0 {
+		w.removeOldest()
+	}
+}
+
+// enforceLimit deletes the oldest segments, acked or not, while over maxBytes
+func (w *wal) enforceLimit() {
+	for len(w.segments) > 1 && w.bytes() > w.maxBytes {
+		oldest := w.segments[0]
+		if oldest.pending > 0 {
+			w.evicted.Add(uint64(oldest.pending))
+			log.Printf("⚠️  WAL over %d MB: dropping %d unacked batch(es) in %s", w.maxBytes>>20, oldest.pending, oldest.path)
+		}
+		w.removeOldest()
+	}
+}
+
+func (w *wal) removeOldest() {
+	oldest := w.segments[0]
+	for seq, seg := range w.segmentOf {
+		if seg == oldest {
+			delete(w.segmentOf, seq)
+		}
+	}
+	for batchID, seq := range w.seqOfBatch {
+		if _, ok := w.segmentOf[seq]; !ok {
+			delete(w.seqOfBatch, batchID)
+		}
+	}
+	if err := os.Remove(oldest.path); err != nil && !os.IsNotExist(err) {
+		log.Printf("⚠️  Failed to delete WAL segment %s: %v", oldest.path, err)
+	}
+	w.segments = w.segments[1:]
+}
+
+// bytes is the WAL's size on disk; the caller holds mu
+func (w *wal) bytes() int64 {
+	var total int64
+	for _, seg := range w.segments {
+		total += seg.size
+	}
+	return total
+}
+
+// walStats is the wal section of /metrics, nil when the WAL is disabled
+func walStats(w *wal) map[string]interface{} {
+	if w == nil {
+		return nil
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return map[string]interface{}{
+		"bytes":           w.bytes(),
+		"segments":        len(w.segments),
+		"pending_batches": len(w.segmentOf),
+		"evicted":         w.evicted.Load(),
+		"corrupt_records": w.corrupt.Load(),
+		"write_errors":    w.errors.Load(),
+	}
+}
@@ -149,9 +149,12 @@ def generate_nginx_log():
     referer = random.choice(["https://example.com", "-", "https://stackmonitor.com"])
     user_agent = random.choice(USER_AGENTS)
     
-    # Nginx Combined Log Format:
-    # $remote_addr - $remote_user [$time_local] "$request" $status $body_bytes_sent "$http_referer" "$http_user_agent"
-    return f'{client_ip} - - [{timestamp}] "{method} {path} {protocol}" {status} {body_bytes} "{referer}" "{user_agent}"\n'
+    upstream_time = round(random.uniform(0.005, 1.5), 3)
+    request_time = round(upstream_time + random.uniform(0.001, 0.05), 3)
+
+    # Nginx Combined Log Format plus timing:
+    # $remote_addr - $remote_user [$time_local] "$request" $status $body_bytes_sent "$http_referer" "$http_user_agent" rt=$request_time urt="$upstream_response_time"
+    return f'{client_ip} - - [{timestamp}] "{method} {path} {protocol}" {status} {body_bytes} "{referer}" "{user_agent}" rt={request_time} urt="{upstream_time}"\n'
 
 def get_log_line(log_type):
     """Get a log line based on type"""
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// defaultOfflineAfter is three missed heartbeats at the agents' default 30s interval
+const defaultOfflineAfter = 90 * time.Second
+
+// GET /api/v1/agents/status?offline_after=90s
+// Every agent the ingestion-service has heard from, with its hostname, version
+// and last batch or heartbeat. Agents silent for longer than offline_after
+// (AGENT_OFFLINE_AFTER by default) are reported offline.
+func (api *APIServer) agentStatus(c *gin.Context) {
+	offlineAfter := api.offlineAfter
+	if offlineAfter <= 0 {
+		offlineAfter = defaultOfflineAfter
+	}
+	if v := c.Query("offline_after"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			abortWithError(c, invalidParam("offline_after", "offline_after must be a positive duration such as 90s or 5m"))
+			return
+		}
+		offlineAfter = d
+	}
+
+	rows, err := api.store.AgentStatuses(context.Background())
+	if err != nil {
+		abortWithError(c, queryError(err))
+		return
+	}
+
+	now := time.Now()
+	online := 0
+	agents := make([]map[string]interface{}, 0, len(rows))
+	for _, row := range rows {
+		since := now.Sub(row.LastSeen)
+		status := "offline"
+		if since <= offlineAfter {
+			status = "online"
+			online++
+		}
+		agents = append(agents, map[string]interface{}{
+			"agent_id":           row.AgentID,
+			"hostname":           row.Hostname,
+			"version":            row.Version,
+			"last_seen":          row.LastSeen.Format(time.RFC3339),
+			"seconds_since_seen": int64(since.Seconds()),
+			"status":             status,
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"agents":        agents,
+		"status":        resultStatus(len(agents)),
+		"total":         len(agents),
+		"online":        online,
+		"offline":       len(agents) - online,
+		"offline_after": offlineAfter.String(),
+	})
+}
+
+// DELETE /api/v1/agents/:id
+// Forgets a decommissioned agent so it stops showing as offline. An agent that
+// is still running reappears with its next heartbeat.
+func (api *APIServer) forgetAgent(c *gin.Context) {
+	id := c.Param("id")
+	if err := api.store.ForgetAgent(context.Background(), id); err != nil {
+		abortWithError(c, queryError(err))
+		return
+	}
+	log.Printf("🗑️ Forgot agent %s", id)
+	c.JSON(http.StatusOK, gin.H{"agent_id": id, "status": "forgotten"})
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// Per-minute log counts by service and level, maintained by materialized
+// views (see ingestion-service/init-db.sh):
+//
+//	<table>_counts_1m     SummingMergeTree(minute, service, level, count)
+//	<t>_counts_1m_mv      one view per logs table t (the main table and every
+//	                      routed table), all writing to the counts table
+//
+// When the counts table and every view exist, Stats, ErrorRate and
+// ErrorsByService read it instead of scanning raw logs, as long as the query
+// is on event time with buckets of whole minutes and not narrowed to one
+// agent (the counts have no agent_id). Everything else, and every
+// query when CH_AGGREGATES=false, uses the raw tables. Counts are not reduced
+// by DELETE /api/v1/logs.
+const (
+	countsSuffix     = "_counts_1m"
+	countsViewSuffix = "_counts_1m_mv"
+)
+
+// detectCounts looks for the counts table and its views and enables them when complete
+func (s *clickHouseStore) detectCounts(ctx context.Context) error {
+	counts := s.table + countsSuffix
+	names := []string{counts}
+	sources := tablesFor(s.routes, s.table, LogFilter{})
+	for _, table := range sources {
+		names = append(names, table+countsViewSuffix)
+	}
+
+	rows, err := s.db.Query(ctx, "SELECT name FROM system.tables WHERE database = ? AND name IN ?", s.database, names)
+	if err != nil {
+		return fmt.Errorf("look up aggregate tables: %w", err)
+	}
+	defer rows.Close()
+	found := make(map[string]bool)
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return fmt.Errorf("look up aggregate tables: %w", err)
+		}
+		found[name] = true
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("look up aggregate tables: %w", err)
+	}
+
+	if !found[counts] {
+		log.Printf("No %s.%s table, aggregate queries scan raw logs", s.database, counts)
+		return nil
+	}
+	var missing []string
+	for _, name := range names[1:] {
+		if !found[name] {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		// A table without a view would be missing from the counts
+		log.Printf("⚠️  %s.%s is incomplete (missing views: %s), aggregate queries scan raw logs", s.database, counts, strings.Join(missing, ", "))
+		return nil
+	}
+	s.counts = s.database + "." + counts
+	log.Printf("Using %s for stats and error-rate queries", s.counts)
+	return nil
+}
+
+// useCounts reports whether a query on this timeline and bucket size can be answered from the counts table
+func (s *clickHouseStore) useCounts(timeline Timeline, bucket time.Duration) bool {
+	return s.counts != "" && timeline.column() == "timestamp" && bucket%time.Minute == 0
+}
+
+func (s *clickHouseStore) statsFromCounts(ctx context.Context) (LogStats, error) {
+	var stats LogStats
+	err := s.db.QueryRow(ctx, `
+		SELECT
+			sum(count),
+			sumIf(count, level = 'ERROR'),
+			sumIf(count, level = 'WARN'),
+			sumIf(count, level = 'INFO')
+		FROM `+s.counts).Scan(&stats.Total, &stats.Errors, &stats.Warnings, &stats.Info)
+	return stats, err
+}
+
+// errorRateFromCounts mirrors ErrorRate; the window starts at the beginning of its first minute
+func (s *clickHouseStore) errorRateFromCounts(ctx context.Context, f LogFilter, w TimeWindow) ([]RatePoint, error) {
+	query := fmt.Sprintf(`
+		SELECT
+			toStartOfInterval(minute, INTERVAL %d SECOND) as time,
+			sum(count) as error_count
+		FROM %s
+		WHERE level = 'ERROR'
+	`, int64(w.Bucket.Seconds()), s.counts)
+	args := []interface{}{}
+
+	conditions, scopeArgs := scopeConditions(f)
+	for _, cond := range conditions {
+		query += " AND " + cond
+	}
+	args = append(args, scopeArgs...)
+
+	query += fmt.Sprintf(" AND minute >= toStartOfMinute(now() - INTERVAL %d SECOND)", int64((w.Span + w.Offset).Seconds()))
+	if w.Offset > 0 {
+		query += fmt.Sprintf(" AND minute < toStartOfMinute(now() - INTERVAL %d SECOND)", int64(w.Offset.Seconds()))
+	}
+	query += " GROUP BY time ORDER BY time"
+
+	rows, err := s.db.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var points []RatePoint
+	for rows.Next() {
+		var p RatePoint
+		if err := rows.Scan(&p.Time, &p.Count); err != nil {
+			log.Printf("Error scanning row: %v", err)
+			continue
+		}
+		points = append(points, p)
+	}
+	return points, nil
+}
+
+// levelCountsFromCounts mirrors LevelCounts
+func (s *clickHouseStore) levelCountsFromCounts(ctx context.Context, f LogFilter, w TimeWindow) ([]LevelCountRow, error) {
+	bucket := int64(w.Bucket.Seconds())
+	query := fmt.Sprintf(`
+		SELECT
+			toStartOfInterval(minute, INTERVAL %d SECOND) as time,
+			service,
+			level,
+			sum(count)
+		FROM %s
+		WHERE minute >= toStartOfInterval(now() - INTERVAL %d SECOND, INTERVAL %d SECOND)
+	`, bucket, s.counts, int64(w.Span.Seconds()), bucket)
+	conditions, args := scopeConditions(f)
+	for _, cond := range conditions {
+		query += " AND " + cond
+	}
+	query += " GROUP BY time, service, level ORDER BY time, service, level"
+	return s.queryLevelCounts(ctx, query, args)
+}
+
+func (s *clickHouseStore) errorsByServiceFromCounts(ctx context.Context, span time.Duration) ([]ServiceCount, error) {
+	rows, err := s.db.Query(ctx, fmt.Sprintf(
+		"SELECT service, sum(count) as cnt FROM %s WHERE level = 'ERROR' AND minute >= toStartOfMinute(now() - INTERVAL %d SECOND) GROUP BY service",
+		s.counts, int64(span.Seconds())))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var counts []ServiceCount
+	for rows.Next() {
+		var sc ServiceCount
+		if err := rows.Scan(&sc.Service, &sc.Count); err == nil {
+			counts = append(counts, sc)
+		}
+	}
+	return counts, nil
+}
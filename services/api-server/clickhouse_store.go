@@ -0,0 +1,607 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ClickHouse/clickhouse-go/v2/lib/driver"
+)
+
+const (
+	// metadata is the Map(String, String) of structured fields the agents collect.
+	// Routed tables are unioned with the same columns, so field-based aggregations work too.
+	logColumns       = "timestamp, level, service, message, trace_id, agent_id, ingested_at, metadata"
+	defaultDatabase  = "stackmonitor"
+	defaultLogsTable = "logs"
+	// agentsTable is written by the ingestion-service's agent registry
+	agentsTable = "agents"
+)
+
+// clickHouseStore is the production LogStore backed by the logs table
+// (stackmonitor.logs unless CH_DATABASE/CH_TABLE say otherwise) plus any
+// tables the ingestion-service routes entries to
+type clickHouseStore struct {
+	db       driver.Conn
+	database string
+	table    string
+	routes   []routeRule
+	counts   string // database.table of per-minute counts, empty to scan raw logs (see aggregates.go)
+}
+
+// newClickHouseStore expects database and table to be validated identifiers,
+// since they are interpolated into every query
+func newClickHouseStore(db driver.Conn, database, table string, routes []routeRule) *clickHouseStore {
+	return &clickHouseStore{db: db, database: database, table: table, routes: routes}
+}
+
+// from returns the FROM expression for a filter: the single table that can
+// hold its matches, or a UNION ALL over every candidate table
+func (s *clickHouseStore) from(f LogFilter) string {
+	tables := tablesFor(s.routes, s.table, f)
+	if len(tables) == 1 {
+		return s.database + "." + tables[0]
+	}
+
+	selects := make([]string, len(tables))
+	for i, table := range tables {
+		selects[i] = "SELECT " + logColumns + " FROM " + s.database + "." + table
+	}
+	return "(" + strings.Join(selects, " UNION ALL ") + ")"
+}
+
+// serviceCondition renders the filter's service constraint: an equality for
+// one service, IN for a service group
+func serviceCondition(f LogFilter) (string, interface{}, bool) {
+	services := f.services()
+	switch len(services) {
+	case 0:
+		return "", nil, false
+	case 1:
+		return "service = ?", services[0], true
+	}
+	return "service IN ?", services, true
+}
+
+// scopeConditions renders the filter's service and agent constraints, which
+// aggregate queries apply next to their own level and time conditions
+func scopeConditions(f LogFilter) ([]string, []interface{}) {
+	var conditions []string
+	var args []interface{}
+	if cond, arg, ok := serviceCondition(f); ok {
+		conditions = append(conditions, cond)
+		args = append(args, arg)
+	}
+	if f.AgentID != "" {
+		conditions = append(conditions, "agent_id = ?")
+		args = append(args, f.AgentID)
+	}
+	return conditions, args
+}
+
+// whereClause renders the filter as SQL conditions (always valid after WHERE)
+func (s *clickHouseStore) whereClause(f LogFilter) (string, []interface{}) {
+	conditions, args := scopeConditions(f)
+	conditions = append([]string{"1=1"}, conditions...)
+	if f.Level != "" {
+		conditions = append(conditions, "level = ?")
+		args = append(args, f.Level)
+	} else if len(f.Levels) > 0 {
+		conditions = append(conditions, "level IN ?")
+		args = append(args, f.Levels)
+	}
+	if f.TraceID != "" {
+		conditions = append(conditions, "trace_id = ?")
+		args = append(args, f.TraceID)
+	}
+	if !f.From.IsZero() {
+		conditions = append(conditions, f.Timeline.column()+" >= ?")
+		args = append(args, f.From)
+	}
+	if !f.To.IsZero() {
+		conditions = append(conditions, f.Timeline.column()+" < ?")
+		args = append(args, f.To)
+	}
+	return strings.Join(conditions, " AND "), args
+}
+
+func (s *clickHouseStore) QueryLogs(ctx context.Context, f LogFilter) ([]LogRecord, error) {
+	where, args := s.whereClause(f)
+	query := "SELECT " + logColumns + " FROM " + s.from(f) + " WHERE " + where + " ORDER BY " + f.Timeline.column() + " DESC LIMIT ?"
+	args = append(args, f.Limit)
+
+	rows, err := s.db.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return scanLogRows(rows), nil
+}
+
+func (s *clickHouseStore) Stats(ctx context.Context) (LogStats, error) {
+	if s.counts != "" {
+		return s.statsFromCounts(ctx)
+	}
+	var stats LogStats
+	err := s.db.QueryRow(ctx, `
+		SELECT
+			count(),
+			countIf(level = 'ERROR'),
+			countIf(level = 'WARN'),
+			countIf(level = 'INFO')
+		FROM `+s.from(LogFilter{})+`
+	`).Scan(&stats.Total, &stats.Errors, &stats.Warnings, &stats.Info)
+	return stats, err
+}
+
+func (s *clickHouseStore) ErrorRate(ctx context.Context, f LogFilter, w TimeWindow) ([]RatePoint, error) {
+	if f.AgentID == "" && s.useCounts(f.Timeline, w.Bucket) {
+		return s.errorRateFromCounts(ctx, f, w)
+	}
+	column := f.Timeline.column()
+	query := fmt.Sprintf(`
+		SELECT
+			toStartOfInterval(%s, INTERVAL %d SECOND) as time,
+			count(*) as error_count
+		FROM %s
+		WHERE level = 'ERROR'
+	`, column, int64(w.Bucket.Seconds()), s.from(LogFilter{Level: "ERROR", Service: f.Service, Services: f.Services}))
+	args := []interface{}{}
+
+	conditions, scopeArgs := scopeConditions(f)
+	for _, cond := range conditions {
+		query += " AND " + cond
+	}
+	args = append(args, scopeArgs...)
+
+	query += fmt.Sprintf(" AND %s >= now() - INTERVAL %d SECOND", column, int64((w.Span + w.Offset).Seconds()))
+	if w.Offset > 0 {
+		query += fmt.Sprintf(" AND %s < now() - INTERVAL %d SECOND", column, int64(w.Offset.Seconds()))
+	}
+	query += " GROUP BY time ORDER BY time"
+
+	rows, err := s.db.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var points []RatePoint
+	for rows.Next() {
+		var p RatePoint
+		if err := rows.Scan(&p.Time, &p.Count); err != nil {
+			log.Printf("Error scanning row: %v", err)
+			continue
+		}
+		points = append(points, p)
+	}
+	return points, nil
+}
+
+func (s *clickHouseStore) LevelCounts(ctx context.Context, f LogFilter, w TimeWindow) ([]LevelCountRow, error) {
+	if f.AgentID == "" && s.useCounts(f.Timeline, w.Bucket) {
+		return s.levelCountsFromCounts(ctx, f, w)
+	}
+	column := f.Timeline.column()
+	bucket := int64(w.Bucket.Seconds())
+	query := fmt.Sprintf(`
+		SELECT
+			toStartOfInterval(%s, INTERVAL %d SECOND) as time,
+			service,
+			level,
+			count()
+		FROM %s
+		WHERE %s >= toStartOfInterval(now() - INTERVAL %d SECOND, INTERVAL %d SECOND)
+	`, column, bucket, s.from(LogFilter{Service: f.Service, Services: f.Services}), column, int64(w.Span.Seconds()), bucket)
+	conditions, args := scopeConditions(f)
+	for _, cond := range conditions {
+		query += " AND " + cond
+	}
+	query += " GROUP BY time, service, level ORDER BY time, service, level"
+	return s.queryLevelCounts(ctx, query, args)
+}
+
+func (s *clickHouseStore) queryLevelCounts(ctx context.Context, query string, args []interface{}) ([]LevelCountRow, error) {
+	rows, err := s.db.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []LevelCountRow
+	for rows.Next() {
+		var row LevelCountRow
+		if err := rows.Scan(&row.Time, &row.Service, &row.Level, &row.Count); err != nil {
+			log.Printf("Error scanning row: %v", err)
+			continue
+		}
+		out = append(out, row)
+	}
+	return out, rows.Err()
+}
+
+func (s *clickHouseStore) StreamSince(ctx context.Context, timeline Timeline, since time.Time, limit int) ([]LogRecord, error) {
+	column := timeline.column()
+	rows, err := s.db.Query(ctx,
+		"SELECT "+logColumns+" FROM "+s.from(LogFilter{})+" WHERE "+column+" > ? ORDER BY "+column+" LIMIT ?",
+		since, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return scanLogRows(rows), nil
+}
+
+func (s *clickHouseStore) ErrorsByService(ctx context.Context, span time.Duration) ([]ServiceCount, error) {
+	if s.counts != "" {
+		return s.errorsByServiceFromCounts(ctx, span)
+	}
+	rows, err := s.db.Query(ctx, fmt.Sprintf(
+		"SELECT service, count(*) as cnt FROM %s WHERE level = 'ERROR' AND timestamp >= now() - INTERVAL %d SECOND GROUP BY service",
+		s.from(LogFilter{Level: "ERROR"}), int64(span.Seconds())))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var counts []ServiceCount
+	for rows.Next() {
+		var sc ServiceCount
+		if err := rows.Scan(&sc.Service, &sc.Count); err == nil {
+			counts = append(counts, sc)
+		}
+	}
+	return counts, nil
+}
+
+func (s *clickHouseStore) CountLogs(ctx context.Context, f LogFilter) (uint64, error) {
+	where, args := s.whereClause(f)
+	var count uint64
+	err := s.db.QueryRow(ctx, "SELECT count() FROM "+s.from(f)+" WHERE "+where, args...).Scan(&count)
+	return count, err
+}
+
+func (s *clickHouseStore) DeleteLogs(ctx context.Context, f LogFilter) error {
+	where, args := s.whereClause(f)
+	// ALTER TABLE ... DELETE runs as an asynchronous mutation, one per table
+	for _, table := range tablesFor(s.routes, s.table, f) {
+		if err := s.db.Exec(ctx, "ALTER TABLE "+s.database+"."+table+" DELETE WHERE "+where, args...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *clickHouseStore) ServiceHealth(ctx context.Context, span time.Duration) ([]ServiceHealthRow, error) {
+	rows, err := s.db.Query(ctx, fmt.Sprintf(`
+		SELECT
+			service,
+			countIf(level = 'ERROR'),
+			countIf(level = 'WARN'),
+			count(),
+			max(timestamp)
+		FROM %s
+		WHERE timestamp >= now() - INTERVAL %d SECOND
+		GROUP BY service
+		ORDER BY service
+	`, s.from(LogFilter{}), int64(span.Seconds())))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []ServiceHealthRow
+	for rows.Next() {
+		var row ServiceHealthRow
+		if err := rows.Scan(&row.Service, &row.Errors, &row.Warnings, &row.Total, &row.LastSeen); err != nil {
+			log.Printf("Error scanning row: %v", err)
+			continue
+		}
+		out = append(out, row)
+	}
+	return out, nil
+}
+
+func (s *clickHouseStore) Freshness(ctx context.Context, span time.Duration) ([]FreshnessRow, time.Time, error) {
+	// ClickHouse's now(), so the lag doesn't depend on this server's clock
+	var now time.Time
+	if err := s.db.QueryRow(ctx, "SELECT now64(3)").Scan(&now); err != nil {
+		return nil, time.Time{}, err
+	}
+	rows, err := s.db.Query(ctx, fmt.Sprintf(`
+		SELECT
+			service,
+			agent_id,
+			max(timestamp),
+			max(ingested_at),
+			count()
+		FROM %s
+		WHERE timestamp >= now() - INTERVAL %d SECOND
+		GROUP BY service, agent_id
+		ORDER BY service, agent_id
+	`, s.from(LogFilter{}), int64(span.Seconds())))
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	defer rows.Close()
+
+	var out []FreshnessRow
+	for rows.Next() {
+		var row FreshnessRow
+		if err := rows.Scan(&row.Service, &row.AgentID, &row.LastEvent, &row.LastIngested, &row.Logs); err != nil {
+			log.Printf("Error scanning row: %v", err)
+			continue
+		}
+		out = append(out, row)
+	}
+	return out, now, rows.Err()
+}
+
+func (s *clickHouseStore) ErrorFingerprints(ctx context.Context, f LogFilter, span time.Duration) ([]ErrorFingerprintRow, error) {
+	expr, args := fingerprintSQL("message")
+	query := fmt.Sprintf(`
+		SELECT
+			%s AS fingerprint,
+			argMin(message, timestamp),
+			count(),
+			min(timestamp),
+			max(timestamp),
+			arraySort(groupUniqArray(service))
+		FROM %s
+		WHERE level = 'ERROR' AND timestamp >= now() - INTERVAL %d SECOND
+	`, expr, s.from(LogFilter{Service: f.Service, Services: f.Services}), int64(span.Seconds()))
+
+	conditions, scopeArgs := scopeConditions(f)
+	for _, cond := range conditions {
+		query += " AND " + cond
+	}
+	args = append(args, scopeArgs...)
+	query += fmt.Sprintf(" GROUP BY fingerprint ORDER BY count() DESC, fingerprint LIMIT %d", f.Limit)
+
+	rows, err := s.db.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []ErrorFingerprintRow
+	for rows.Next() {
+		var row ErrorFingerprintRow
+		if err := rows.Scan(&row.Fingerprint, &row.Example, &row.Count, &row.FirstSeen, &row.LastSeen, &row.Services); err != nil {
+			log.Printf("Error scanning row: %v", err)
+			continue
+		}
+		out = append(out, row)
+	}
+	return out, nil
+}
+
+// searchConditions renders what a search adds to its filter's whereClause
+func searchConditions(q SearchQuery) ([]string, []interface{}) {
+	var conditions []string
+	var args []interface{}
+	if len(q.Levels) > 1 {
+		conditions = append(conditions, "level IN ?")
+		args = append(args, q.Levels)
+	}
+	if !q.AsOf.IsZero() {
+		conditions = append(conditions, "ingested_at <= ?")
+		args = append(args, q.AsOf)
+	}
+	for _, term := range q.Terms {
+		conditions = append(conditions, "positionCaseInsensitiveUTF8(message, ?) > 0")
+		args = append(args, term)
+	}
+	for _, p := range q.Fields {
+		switch p.Op {
+		case opEq:
+			conditions = append(conditions, "(mapContains(metadata, ?) AND metadata[?] = ?)")
+			args = append(args, p.Field, p.Field, p.Value)
+		case opNe:
+			conditions = append(conditions, "NOT (mapContains(metadata, ?) AND metadata[?] = ?)")
+			args = append(args, p.Field, p.Field, p.Value)
+		case opContains:
+			conditions = append(conditions, "positionCaseInsensitiveUTF8(metadata[?], ?) > 0")
+			args = append(args, p.Field, p.Value)
+		case opExists:
+			conditions = append(conditions, "mapContains(metadata, ?)")
+			args = append(args, p.Field)
+		}
+	}
+	return conditions, args
+}
+
+func (s *clickHouseStore) Search(ctx context.Context, q SearchQuery) (SearchResult, error) {
+	where, args := s.whereClause(q.Filter)
+	conditions, extra := searchConditions(q)
+	for _, cond := range conditions {
+		where += " AND " + cond
+	}
+	args = append(args, extra...)
+	from := s.from(q.Filter)
+
+	column := q.Filter.Timeline.column()
+	order := column + " DESC"
+	var orderArgs []interface{}
+	switch q.Sort {
+	case sortOldest:
+		order = column + " ASC"
+	case sortRelevance:
+		counts := make([]string, len(q.Terms))
+		for i, term := range q.Terms {
+			counts[i] = "countSubstringsCaseInsensitiveUTF8(message, ?)"
+			orderArgs = append(orderArgs, term)
+		}
+		order = "(" + strings.Join(counts, " + ") + ") DESC, " + column + " DESC"
+	}
+
+	query := "SELECT " + logColumns + " FROM " + from + " WHERE " + where + " ORDER BY " + order + " LIMIT ? OFFSET ?"
+	pageArgs := append(append(append([]interface{}{}, args...), orderArgs...), q.Filter.Limit, q.Offset)
+	rows, err := s.db.Query(ctx, query, pageArgs...)
+	if err != nil {
+		return SearchResult{}, err
+	}
+	result := SearchResult{Records: scanLogRows(rows), ByService: map[string]uint64{}, ByLevel: map[string]uint64{}}
+	rows.Close()
+
+	// Both facets come from one pass over the matches
+	rows, err = s.db.Query(ctx, "SELECT service, level, count() FROM "+from+" WHERE "+where+" GROUP BY service, level", args...)
+	if err != nil {
+		return SearchResult{}, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var service, level string
+		var count uint64
+		if err := rows.Scan(&service, &level, &count); err != nil {
+			log.Printf("Error scanning row: %v", err)
+			continue
+		}
+		result.ByService[service] += count
+		result.ByLevel[level] += count
+		result.Total += count
+	}
+	return result, nil
+}
+
+// LatencyPercentiles interpolates field into the SQL, so it must come from
+// latencyFields; quantile levels are parameters of the function, not values,
+// and are formatted in as numbers
+func (s *clickHouseStore) LatencyPercentiles(ctx context.Context, f LogFilter, field string, quantiles []float64, w TimeWindow) ([]LatencyPoint, error) {
+	levels := make([]string, len(quantiles))
+	for i, q := range quantiles {
+		levels[i] = strconv.FormatFloat(q, 'f', -1, 64)
+	}
+	column := f.Timeline.column()
+	query := fmt.Sprintf(`
+		SELECT
+			toStartOfInterval(%s, INTERVAL %d SECOND) as time,
+			quantiles(%s)(toFloat64OrZero(metadata['%s'])) as q,
+			count()
+		FROM %s
+		WHERE metadata['%s'] != '' AND %s >= now() - INTERVAL %d SECOND
+	`, column, int64(w.Bucket.Seconds()), strings.Join(levels, ", "), field, s.from(LogFilter{Service: f.Service, Services: f.Services}), field, column, int64(w.Span.Seconds()))
+	args := []interface{}{}
+
+	conditions, scopeArgs := scopeConditions(f)
+	for _, cond := range conditions {
+		query += " AND " + cond
+	}
+	args = append(args, scopeArgs...)
+	query += " GROUP BY time ORDER BY time"
+
+	rows, err := s.db.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var points []LatencyPoint
+	for rows.Next() {
+		var p LatencyPoint
+		if err := rows.Scan(&p.Time, &p.Quantiles, &p.Count); err != nil {
+			log.Printf("Error scanning row: %v", err)
+			continue
+		}
+		points = append(points, p)
+	}
+	return points, nil
+}
+
+func (s *clickHouseStore) AgentStatuses(ctx context.Context) ([]AgentStatusRow, error) {
+	// The table is a ReplacingMergeTree, but unmerged parts can still hold older rows
+	rows, err := s.db.Query(ctx, `
+		SELECT
+			agent_id,
+			argMax(hostname, last_seen),
+			argMax(version, last_seen),
+			max(last_seen)
+		FROM `+s.database+"."+agentsTable+`
+		GROUP BY agent_id
+		ORDER BY agent_id
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []AgentStatusRow
+	for rows.Next() {
+		var row AgentStatusRow
+		if err := rows.Scan(&row.AgentID, &row.Hostname, &row.Version, &row.LastSeen); err != nil {
+			log.Printf("Agent status scan error: %v", err)
+			continue
+		}
+		out = append(out, row)
+	}
+	return out, rows.Err()
+}
+
+func (s *clickHouseStore) ForgetAgent(ctx context.Context, agentID string) error {
+	return s.db.Exec(ctx, "ALTER TABLE "+s.database+"."+agentsTable+" DELETE WHERE agent_id = ?", agentID)
+}
+
+// scanLogRows reads rows selected with logColumns, skipping rows that fail to scan
+func scanLogRows(rows driver.Rows) []LogRecord {
+	var records []LogRecord
+	for rows.Next() {
+		var r LogRecord
+		if err := rows.Scan(&r.Timestamp, &r.Level, &r.Service, &r.Message, &r.TraceID, &r.AgentID, &r.IngestedAt, (*metadataFields)(&r.Fields)); err != nil {
+			log.Printf("Error scanning row: %v", err)
+			continue
+		}
+		records = append(records, r)
+	}
+	return records
+}
+
+// metadataFields scans the metadata column into LogRecord.Fields. It is read
+// as the driver's OrderedMap, key by key, rather than as a map[string]string,
+// which only fits a column typed exactly Map(String, String): a routed table
+// created by hand with e.g. Map(String, Nullable(String)) would fail every row
+// of a union. Keys and values are rendered as strings, NULL as "". An empty
+// map leaves Fields nil, which toMap returns as {}.
+type metadataFields map[string]string
+
+func (f *metadataFields) Put(key, value interface{}) {
+	if *f == nil {
+		*f = make(metadataFields)
+	}
+	(*f)[metadataString(key)] = metadataString(value)
+}
+
+func (f *metadataFields) Get(key interface{}) (interface{}, bool) {
+	value, ok := (*f)[metadataString(key)]
+	return value, ok
+}
+
+func (f *metadataFields) Keys() <-chan interface{} {
+	keys := make(chan interface{}, len(*f))
+	for key := range *f {
+		keys <- key
+	}
+	close(keys)
+	return keys
+}
+
+// metadataString renders one map key or value, dereferencing Nullable pointers
+func metadataString(v interface{}) string {
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Pointer || rv.Kind() == reflect.Interface {
+		if rv.IsNil() {
+			return ""
+		}
+		rv = rv.Elem()
+	}
+	if !rv.IsValid() {
+		return ""
+	}
+	if rv.Kind() == reflect.String {
+		return rv.String()
+	}
+	return fmt.Sprint(rv.Interface())
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const defaultInboxLimit = 100
+
+// GET /api/v1/errors/inbox?range=24h&service=X&limit=100
+// Distinct error types over the range: ERROR logs grouped by fingerprint, with
+// an example message, count, first/last seen and the services reporting it,
+// most frequent first. The grouping runs in ClickHouse.
+func (api *APIServer) errorInbox(c *gin.Context) {
+	rangeStr := c.Query("range")
+	if rangeStr == "" {
+		rangeStr = "24h"
+	}
+	limit := defaultInboxLimit
+	if v := c.Query("limit"); v != "" {
+		parsed, err := strconv.Atoi(v)
+		if err != nil || parsed <= 0 {
+			abortWithError(c, invalidParam("limit", "limit must be a positive integer"))
+			return
+		}
+		limit = parsed
+	}
+	limit, _ = api.clampLimit(c, limit)
+
+	filter := LogFilter{Service: c.Query("service"), AgentID: c.Query("agent_id"), Limit: limit}
+	members := api.expandService(&filter)
+	window := resolveRange(rangeStr)
+
+	rows, err := api.store.ErrorFingerprints(context.Background(), filter, window.Span)
+	if err != nil {
+		abortWithError(c, queryError(err))
+		return
+	}
+
+	errors := make([]map[string]interface{}, 0, len(rows))
+	for _, row := range rows {
+		errors = append(errors, map[string]interface{}{
+			"fingerprint": row.Fingerprint,
+			"example":     row.Example,
+			"count":       row.Count,
+			"first_seen":  row.FirstSeen.Format(time.RFC3339),
+			"last_seen":   row.LastSeen.Format(time.RFC3339),
+			"services":    row.Services,
+		})
+	}
+
+	result := gin.H{"range": rangeStr, "errors": errors, "count": len(errors), "status": resultStatus(len(errors))}
+	if members != nil {
+		result["services"] = members
+	}
+	c.JSON(http.StatusOK, result)
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GET /api/v1/metrics/error-rate/compare?range=1h&service=X
+// The error-rate series for the range plus the same aggregation over the
+// immediately preceding window, with a percent-change summary
+func (api *APIServer) errorRateCompare(c *gin.Context) {
+	rangeStr := c.Query("range")
+	if rangeStr == "" {
+		rangeStr = "1h"
+	}
+	timeline, err := parseTimeline(c.Query("timeline"))
+	if err != nil {
+		abortWithError(c, invalidParam("timeline", err.Error()))
+		return
+	}
+
+	filter := LogFilter{Service: c.Query("service"), AgentID: c.Query("agent_id"), Timeline: timeline}
+	members := api.expandService(&filter)
+	window := resolveRange(rangeStr)
+
+	current, err := api.store.ErrorRate(context.Background(), filter, window)
+	if err != nil {
+		abortWithError(c, queryError(err))
+		return
+	}
+	previous, err := api.store.ErrorRate(context.Background(), filter, window.previous())
+	if err != nil {
+		abortWithError(c, queryError(err))
+		return
+	}
+
+	currentTotal, previousTotal := sumPoints(current), sumPoints(previous)
+	result := gin.H{
+		"range":    rangeStr,
+		"timeline": timeline,
+		"count":    currentTotal,
+		"status":   resultStatus(int(currentTotal + previousTotal)),
+		"current":  gin.H{"total": currentTotal, "metrics": ratePointMaps(current)},
+		"previous": gin.H{"total": previousTotal, "metrics": ratePointMaps(previous)},
+		"change":   compareTotals(currentTotal, previousTotal),
+	}
+	if members != nil {
+		result["services"] = members
+	}
+	c.JSON(http.StatusOK, result)
+}
+
+// compareTotals summarizes current vs previous. percent_change is null when the
+// previous window had no errors, since any increase from zero is unbounded.
+func compareTotals(current, previous uint64) gin.H {
+	direction := "flat"
+	switch {
+	case current > previous:
+		direction = "up"
+	case current < previous:
+		direction = "down"
+	}
+
+	var percent interface{}
+	if previous > 0 {
+		percent = (float64(current) - float64(previous)) / float64(previous) * 100
+	}
+	return gin.H{
+		"absolute":       int64(current) - int64(previous),
+		"percent_change": percent,
+		"direction":      direction,
+	}
+}
+
+func sumPoints(points []RatePoint) uint64 {
+	var total uint64
+	for _, p := range points {
+		total += p.Count
+	}
+	return total
+}
+
+// ratePointMaps renders points in the same shape as /metrics/error-rate
+func ratePointMaps(points []RatePoint) []map[string]interface{} {
+	out := make([]map[string]interface{}, 0, len(points))
+	for _, p := range points {
+		out = append(out, map[string]interface{}{
+			"time":  p.Time.Format(time.RFC3339),
+			"count": p.Count,
+		})
+	}
+	return out
+}
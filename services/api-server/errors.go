@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"runtime/debug"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Every failed request is answered with the same envelope:
+//
+//	{"status": "error", "error": {"code": "invalid_request", "message": "...", "request_id": "...", "details": {...}}}
+//
+// Codes are stable for clients to switch on; messages are safe to show and
+// never carry store internals (SQL, hosts), which are only logged.
+const (
+	codeInvalidRequest     = "invalid_request"     // 400
+	codeUnauthorized       = "unauthorized"        // 401
+	codeForbidden          = "forbidden"           // 403
+	codeNotFound           = "not_found"           // 404, 405
+	codeConflict           = "conflict"            // 409
+	codeQueryFailed        = "query_failed"        // 500
+	codeInternal           = "internal"            // 500
+	codeOverloaded         = "overloaded"          // 503, load shedding
+	codeStorageUnavailable = "storage_unavailable" // 503, ClickHouse unreachable
+	codeQueryTimeout       = "query_timeout"       // 504
+)
+
+// requestIDHeader is echoed on every response, taken from the request when set
+const requestIDHeader = "X-Request-ID"
+
+// apiError is an error response. Err, the internal cause, is logged with the
+// request ID and never sent.
+type apiError struct {
+	Status  int
+	Code    string
+	Message string
+	Details gin.H
+	Err     error
+}
+
+func (e *apiError) Error() string {
+	if e.Err != nil {
+		return e.Message + ": " + e.Err.Error()
+	}
+	return e.Message
+}
+
+func invalidRequest(message string) *apiError {
+	return &apiError{Status: http.StatusBadRequest, Code: codeInvalidRequest, Message: message}
+}
+
+// invalidParam is a 400 naming the query parameter or body field at fault
+func invalidParam(param, message string) *apiError {
+	e := invalidRequest(message)
+	e.Details = gin.H{"param": param}
+	return e
+}
+
+// queryError maps a store error to what the client may see: timeouts and
+// connection failures get their own codes so callers know a retry can help
+func queryError(err error) *apiError {
+	var netErr net.Error
+	switch {
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout(),
+		strings.Contains(strings.ToLower(err.Error()), "timeout"):
+		return &apiError{Status: http.StatusGatewayTimeout, Code: codeQueryTimeout,
+			Message: "the query timed out; narrow the time range or filters", Err: err}
+	case errors.As(err, &netErr), errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF),
+		strings.Contains(err.Error(), "connection refused"):
+		return &apiError{Status: http.StatusServiceUnavailable, Code: codeStorageUnavailable,
+			Message: "log storage is unavailable, retry later", Err: err}
+	}
+	return &apiError{Status: http.StatusInternalServerError, Code: codeQueryFailed, Message: "the query failed", Err: err}
+}
+
+// abortWithError ends the request; errorMiddleware writes the envelope
+func abortWithError(c *gin.Context, e *apiError) {
+	c.Error(e)
+	c.Abort()
+}
+
+// respondError logs and writes the envelope. Middleware outside
+// errorMiddleware (gzip) calls it directly.
+func respondError(c *gin.Context, e *apiError) {
+	id := c.GetString("request_id")
+	if e.Err != nil {
+		log.Printf("❌ [%s] %s %s: %s (%s): %v", id, c.Request.Method, c.Request.URL.Path, e.Code, e.Message, e.Err)
+	}
+	body := gin.H{"code": e.Code, "message": e.Message, "request_id": id}
+	if e.Details != nil {
+		body["details"] = e.Details
+	}
+	c.AbortWithStatusJSON(e.Status, gin.H{"status": statusError, "error": body})
+}
+
+// errorMiddleware renders the last error a handler passed to abortWithError.
+// Errors that aren't apiErrors, and panics, are internal and answered generically.
+func errorMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		defer func() {
+			if recovered := recover(); recovered != nil {
+				respondError(c, &apiError{Status: http.StatusInternalServerError, Code: codeInternal,
+					Message: "internal server error", Err: fmt.Errorf("panic: %v\n%s", recovered, debug.Stack())})
+			}
+		}()
+		c.Next()
+		if len(c.Errors) == 0 || c.Writer.Written() {
+			return
+		}
+		err := c.Errors.Last().Err
+		var e *apiError
+		if !errors.As(err, &e) {
+			e = &apiError{Status: http.StatusInternalServerError, Code: codeInternal, Message: "internal server error", Err: err}
+		}
+		respondError(c, e)
+	}
+}
+
+// requestIDMiddleware tags the request with the caller's X-Request-ID, or a
+// new one, so a client's error report can be matched to the server log
+func requestIDMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader(requestIDHeader)
+		if id == "" || len(id) > 128 || strings.ContainsFunc(id, func(r rune) bool { return r < '!' || r > '~' }) {
+			raw := make([]byte, 8)
+			rand.Read(raw)
+			id = hex.EncodeToString(raw)
+		}
+		c.Set("request_id", id)
+		c.Header(requestIDHeader, id)
+		c.Next()
+	}
+}
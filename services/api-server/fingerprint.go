@@ -0,0 +1,52 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+)
+
+// fingerprintRule replaces every match of pattern with a placeholder token
+type fingerprintRule struct {
+	placeholder string
+	pattern     string // RE2, so ClickHouse and Go agree on matches
+	re          *regexp.Regexp
+}
+
+// fingerprintRules are the mcp-server's default normalize rules (fingerprint.go
+// there), so the error inbox groups errors the way the assistant does. Order
+// matters: UUIDs and IPs must be replaced before the generic hex and number
+// rules eat them.
+var fingerprintRules = []fingerprintRule{
+	{placeholder: "<uuid>", pattern: `[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}`},
+	{placeholder: "<ip>", pattern: `\b\d{1,3}(\.\d{1,3}){3}(:\d+)?\b`},
+	{placeholder: "<hex>", pattern: `\b(0x[0-9a-fA-F]+|[0-9a-fA-F]{8,})\b`},
+	{placeholder: "<num>", pattern: `\d+(\.\d+)?`},
+}
+
+func init() {
+	for i := range fingerprintRules {
+		fingerprintRules[i].re = regexp.MustCompile(fingerprintRules[i].pattern)
+	}
+}
+
+// fingerprint returns the normalized form of a message, e.g.
+// "refused to 10.0.0.5:5432" -> "refused to <ip>"
+func fingerprint(message string) string {
+	out := message
+	for _, rule := range fingerprintRules {
+		out = rule.re.ReplaceAllString(out, rule.placeholder)
+	}
+	return strings.Join(strings.Fields(out), " ")
+}
+
+// fingerprintSQL is fingerprint as a ClickHouse expression over column, with
+// the patterns passed as query args so they need no escaping
+func fingerprintSQL(column string) (string, []interface{}) {
+	expr := column
+	var args []interface{}
+	for _, rule := range fingerprintRules {
+		expr = "replaceRegexpAll(" + expr + ", ?, ?)"
+		args = append(args, rule.pattern, rule.placeholder)
+	}
+	return "trimBoth(replaceRegexpAll(" + expr + ", ?, ' '))", append(args, `\s+`)
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+var gzipWriterPool = sync.Pool{
+	New: func() interface{} { return gzip.NewWriter(io.Discard) },
+}
+
+// gzipResponseWriter compresses everything the handler writes
+type gzipResponseWriter struct {
+	gin.ResponseWriter
+	gz    *gzip.Writer
+	wrote bool
+}
+
+func (w *gzipResponseWriter) WriteHeader(code int) {
+	w.Header().Del("Content-Length") // the handler's length is for the uncompressed body
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *gzipResponseWriter) Write(data []byte) (int, error) {
+	w.Header().Del("Content-Length")
+	w.wrote = true
+	return w.gz.Write(data)
+}
+
+func (w *gzipResponseWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+// gzipMiddleware decompresses gzipped request bodies and compresses responses
+// for clients that send Accept-Encoding: gzip. Streaming endpoints (WebSocket
+// upgrades, SSE) are passed through untouched since they need unbuffered writes.
+func gzipMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if strings.EqualFold(c.GetHeader("Content-Encoding"), "gzip") && c.Request.Body != nil {
+			body, err := gzip.NewReader(c.Request.Body)
+			if err != nil {
+				respondError(c, invalidRequest("invalid gzip request body"))
+				return
+			}
+			defer body.Close()
+			c.Request.Body = body
+			c.Request.Header.Del("Content-Encoding")
+			c.Request.ContentLength = -1
+		}
+
+		if !wantsGzip(c.Request) {
+			c.Next()
+			return
+		}
+
+		gz := gzipWriterPool.Get().(*gzip.Writer)
+		gz.Reset(c.Writer)
+		gw := &gzipResponseWriter{ResponseWriter: c.Writer, gz: gz}
+		defer func() {
+			if gw.wrote {
+				gz.Close()
+			} else {
+				// Bodiless responses (204, 304, aborts) must not get a gzip footer
+				c.Writer.Header().Del("Content-Encoding")
+			}
+			gzipWriterPool.Put(gz)
+		}()
+
+		c.Header("Content-Encoding", "gzip")
+		c.Header("Vary", "Accept-Encoding")
+		c.Writer = gw
+		c.Next()
+	}
+}
+
+// wantsGzip reports whether the response to r may be gzip-compressed
+func wantsGzip(r *http.Request) bool {
+	if !strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
+		return false
+	}
+	if r.Header.Get("Upgrade") != "" || strings.Contains(r.Header.Get("Accept"), "text/event-stream") {
+		return false
+	}
+	return !strings.HasSuffix(r.URL.Path, "/stream")
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// defaultStaleAfter flags a source whose newest log is older than this
+	defaultStaleAfter = 5 * time.Minute
+	// defaultLagLookback is how far back sources are looked for; older ones don't appear
+	defaultLagLookback = 24 * time.Hour
+)
+
+// freshness is the newest log of a service or an agent
+type freshness struct {
+	lastEvent    time.Time
+	lastIngested time.Time
+	logs         uint64
+}
+
+func (f *freshness) add(row FreshnessRow) {
+	if row.LastEvent.After(f.lastEvent) {
+		f.lastEvent = row.LastEvent
+	}
+	if row.LastIngested.After(f.lastIngested) {
+		f.lastIngested = row.LastIngested
+	}
+	f.logs += row.Logs
+}
+
+// lag is how long ago the newest log was written; a log stamped ahead of
+// now (agent clock skew) counts as no lag
+func (f *freshness) lag(now time.Time) time.Duration {
+	return max(0, now.Sub(f.lastEvent))
+}
+
+func (f *freshness) report(now time.Time, staleAfter time.Duration) map[string]interface{} {
+	return map[string]interface{}{
+		"last_log":      f.lastEvent.Format(time.RFC3339),
+		"last_ingested": f.lastIngested.Format(time.RFC3339),
+		"lag_seconds":   int64(f.lag(now).Seconds()),
+		// How long the newest log took from being written to being stored
+		"ingest_delay_ms": max(0, f.lastIngested.Sub(f.lastEvent).Milliseconds()),
+		"logs":            f.logs,
+		"stale":           f.lag(now) > staleAfter,
+	}
+}
+
+// GET /api/v1/metrics/ingestion-lag?stale_after=5m&lookback=24h
+// End-to-end freshness from the data side: per service and per agent, how long
+// ago the newest log was written (lag_seconds, against ClickHouse's now()) and
+// how long it took to be stored (ingest_delay_ms). Sources lagging more than
+// stale_after are flagged stale; those silent for longer than lookback don't
+// appear. Agents also carry their last heartbeat, so a stale agent that still
+// heartbeats (quiet: running, nothing to ship) is told apart from one that
+// stopped (down), using the offline threshold of /agents/status.
+func (api *APIServer) ingestionLag(c *gin.Context) {
+	staleAfter := defaultStaleAfter
+	if v := c.Query("stale_after"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			abortWithError(c, invalidParam("stale_after", "stale_after must be a positive duration such as 5m"))
+			return
+		}
+		staleAfter = d
+	}
+	lookback := defaultLagLookback
+	if v := c.Query("lookback"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			abortWithError(c, invalidParam("lookback", "lookback must be a positive duration such as 24h"))
+			return
+		}
+		lookback = d
+	}
+	offlineAfter := api.offlineAfter
+	if offlineAfter <= 0 {
+		offlineAfter = defaultOfflineAfter
+	}
+
+	rows, now, err := api.store.Freshness(context.Background(), lookback)
+	if err != nil {
+		abortWithError(c, queryError(err))
+		return
+	}
+	byService := make(map[string]*freshness)
+	byAgent := make(map[string]*freshness)
+	for _, row := range rows {
+		if byService[row.Service] == nil {
+			byService[row.Service] = &freshness{}
+		}
+		byService[row.Service].add(row)
+		if row.AgentID == "" {
+			continue
+		}
+		if byAgent[row.AgentID] == nil {
+			byAgent[row.AgentID] = &freshness{}
+		}
+		byAgent[row.AgentID].add(row)
+	}
+
+	// Heartbeats are best effort: without them agents are reported from their logs alone
+	heartbeats := make(map[string]AgentStatusRow)
+	if statuses, err := api.store.AgentStatuses(context.Background()); err != nil {
+		log.Printf("Ingestion lag without agent heartbeats: %v", err)
+	} else {
+		for _, row := range statuses {
+			heartbeats[row.AgentID] = row
+		}
+	}
+
+	services := make([]map[string]interface{}, 0, len(byService))
+	staleServices := 0
+	for _, name := range stalestFirst(byService, now) {
+		entry := byService[name].report(now, staleAfter)
+		entry["service"] = name
+		if entry["stale"] == true {
+			staleServices++
+		}
+		services = append(services, entry)
+	}
+
+	// Agents that heartbeat without shipping anything in the lookback are listed too
+	for id := range heartbeats {
+		if byAgent[id] == nil {
+			byAgent[id] = &freshness{}
+		}
+	}
+	serverNow := time.Now()
+	agents := make([]map[string]interface{}, 0, len(byAgent))
+	staleAgents := 0
+	for _, id := range stalestFirst(byAgent, now) {
+		f := byAgent[id]
+		entry := map[string]interface{}{"agent_id": id, "logs": uint64(0), "stale": true}
+		if f.logs > 0 {
+			entry = f.report(now, staleAfter)
+			entry["agent_id"] = id
+		}
+		state := "fresh"
+		if entry["stale"] == true {
+			staleAgents++
+			state = "down"
+			if hb, ok := heartbeats[id]; ok && serverNow.Sub(hb.LastSeen) <= offlineAfter {
+				state = "quiet"
+			}
+		}
+		entry["state"] = state
+		if hb, ok := heartbeats[id]; ok {
+			entry["hostname"] = hb.Hostname
+			entry["last_heartbeat"] = hb.LastSeen.Format(time.RFC3339)
+			entry["seconds_since_heartbeat"] = int64(serverNow.Sub(hb.LastSeen).Seconds())
+		}
+		agents = append(agents, entry)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"services":       services,
+		"agents":         agents,
+		"count":          len(services),
+		"status":         resultStatus(len(services)),
+		"stale_services": staleServices,
+		"stale_agents":   staleAgents,
+		"stale_after":    staleAfter.String(),
+		"lookback":       lookback.String(),
+		"now":            now.Format(time.RFC3339),
+	})
+}
+
+// stalestFirst orders names by lag, largest first (no logs at all first), ties by name
+func stalestFirst(sources map[string]*freshness, now time.Time) []string {
+	names := make([]string, 0, len(sources))
+	for name := range sources {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		a, b := sources[names[i]], sources[names[j]]
+		if a.lastEvent.Equal(b.lastEvent) {
+			return names[i] < names[j]
+		}
+		return a.lastEvent.Before(b.lastEvent)
+	})
+	return names
+}
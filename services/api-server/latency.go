@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// latencyFields are the numeric timing fields the agents extract from nginx
+// access logs (seconds); only these may be charted
+var latencyFields = map[string]bool{
+	"request_time":           true,
+	"upstream_response_time": true,
+}
+
+const (
+	defaultLatencyPercentiles = "50,95"
+	maxLatencyPercentiles     = 10
+)
+
+// parsePercentiles parses e.g. "50,95,99.9" into sorted, distinct percentiles
+func parsePercentiles(raw string) ([]float64, error) {
+	var percentiles []float64
+	for _, part := range strings.Split(raw, ",") {
+		p, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+		if err != nil || p <= 0 || p >= 100 {
+			return nil, fmt.Errorf("invalid percentile %q (want a number between 0 and 100)", part)
+		}
+		if !slices.Contains(percentiles, p) {
+			percentiles = append(percentiles, p)
+		}
+	}
+	if len(percentiles) > maxLatencyPercentiles {
+		return nil, fmt.Errorf("at most %d percentiles", maxLatencyPercentiles)
+	}
+	slices.Sort(percentiles)
+	return percentiles, nil
+}
+
+// percentileKey names a percentile in the response, e.g. p99_ms or p99.9_ms
+func percentileKey(p float64) string {
+	return "p" + strconv.FormatFloat(p, 'f', -1, 64) + "_ms"
+}
+
+// GET /api/v1/metrics/latency?range=1h&field=request_time&service=nginx&percentiles=50,95,99
+// Latency percentiles per bucket from the nginx timing fields, in
+// milliseconds. Services that log no timing get an empty series.
+func (api *APIServer) latency(c *gin.Context) {
+	rangeStr := c.Query("range")
+	if rangeStr == "" {
+		rangeStr = "1h"
+	}
+	field := c.DefaultQuery("field", "request_time")
+	if !latencyFields[field] {
+		abortWithError(c, invalidParam("field", "field must be request_time or upstream_response_time"))
+		return
+	}
+	percentiles, err := parsePercentiles(c.DefaultQuery("percentiles", defaultLatencyPercentiles))
+	if err != nil {
+		abortWithError(c, invalidParam("percentiles", err.Error()))
+		return
+	}
+	timeline, err := parseTimeline(c.Query("timeline"))
+	if err != nil {
+		abortWithError(c, invalidParam("timeline", err.Error()))
+		return
+	}
+	quantiles := make([]float64, len(percentiles))
+	for i, p := range percentiles {
+		quantiles[i] = p / 100
+	}
+
+	filter := LogFilter{Service: c.Query("service"), AgentID: c.Query("agent_id"), Timeline: timeline}
+	members := api.expandService(&filter)
+
+	points, err := api.store.LatencyPercentiles(context.Background(), filter, field, quantiles, resolveRange(rangeStr))
+	if err != nil {
+		abortWithError(c, queryError(err))
+		return
+	}
+
+	var timed uint64
+	metrics := make([]map[string]interface{}, 0, len(points))
+	for _, p := range points {
+		timed += p.Count
+		metric := map[string]interface{}{
+			"time":  p.Time.Format(time.RFC3339),
+			"count": p.Count,
+		}
+		for i, v := range p.Quantiles {
+			if i < len(percentiles) {
+				metric[percentileKey(percentiles[i])] = v * 1000
+			}
+		}
+		metrics = append(metrics, metric)
+	}
+
+	result := gin.H{"range": rangeStr, "field": field, "percentiles": percentiles, "timeline": timeline, "metrics": metrics, "count": timed, "status": resultStatus(int(timed))}
+	if members != nil {
+		result["services"] = members
+	}
+	c.JSON(http.StatusOK, result)
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// defaultCountsInterval is how often /metrics/counts/stream pushes an update
+	defaultCountsInterval = 5 * time.Second
+	minCountsInterval     = time.Second
+)
+
+// countsPoint is one bucket of the live counts feed
+type countsPoint struct {
+	Time     string                       `json:"time"`
+	Count    uint64                       `json:"count"`
+	Levels   map[string]uint64            `json:"levels"`
+	Services map[string]map[string]uint64 `json:"services,omitempty"`
+}
+
+// countsPoints folds store rows into one point per bucket, oldest first;
+// byService also breaks each bucket down per service
+func countsPoints(rows []LevelCountRow, byService bool) []countsPoint {
+	byTime := make(map[time.Time]*countsPoint)
+	var times []time.Time
+	for _, row := range rows {
+		p, ok := byTime[row.Time]
+		if !ok {
+			p = &countsPoint{Time: row.Time.Format(time.RFC3339), Levels: make(map[string]uint64)}
+			if byService {
+				p.Services = make(map[string]map[string]uint64)
+			}
+			byTime[row.Time] = p
+			times = append(times, row.Time)
+		}
+		p.Count += row.Count
+		p.Levels[row.Level] += row.Count
+		if byService {
+			if p.Services[row.Service] == nil {
+				p.Services[row.Service] = make(map[string]uint64)
+			}
+			p.Services[row.Service][row.Level] += row.Count
+		}
+	}
+	sort.Slice(times, func(i, j int) bool { return times[i].Before(times[j]) })
+	points := make([]countsPoint, 0, len(times))
+	for _, t := range times {
+		points = append(points, *byTime[t])
+	}
+	return points
+}
+
+// WebSocket /api/v1/metrics/counts/stream?range=1h&service=&by_service=true&interval=5s&timeline=
+// A live chart feed: log counts per bucket by level (and with by_service per
+// service) instead of the raw logs of /logs/stream. Buckets follow the range
+// mapping of /metrics/error-rate (1m for 15m and 1h, 5m for 6h, ...). The first
+// message is a "snapshot" of the whole range; every interval after that an
+// "update" carries the previous and the current bucket, the only ones that
+// can still change, recounted with a query over just those two. Clients
+// replace their points by time. The feed stops when the client disconnects.
+func (api *APIServer) countsStream(c *gin.Context) {
+	rangeStr := c.Query("range")
+	if rangeStr == "" {
+		rangeStr = "1h"
+	}
+	window := resolveRange(rangeStr)
+	timeline, err := parseTimeline(c.Query("timeline"))
+	if err != nil {
+		abortWithError(c, invalidParam("timeline", err.Error()))
+		return
+	}
+	interval := defaultCountsInterval
+	if v := c.Query("interval"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < minCountsInterval {
+			abortWithError(c, invalidParam("interval", "interval must be a duration of at least 1s such as 5s"))
+			return
+		}
+		interval = d
+	}
+	byService := false
+	if v := c.Query("by_service"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			abortWithError(c, invalidParam("by_service", "by_service must be true or false"))
+			return
+		}
+		byService = b
+	}
+	filter := LogFilter{Service: c.Query("service"), AgentID: c.Query("agent_id"), Timeline: timeline}
+	api.expandService(&filter)
+
+	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		log.Printf("WebSocket upgrade failed: %v", err)
+		return
+	}
+	defer conn.Close()
+
+	// The client sends nothing; reading surfaces its close (or a dead
+	// connection) and cancels the feed and any query in flight
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		defer cancel()
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+	log.Printf("Counts stream connected (range: %s, bucket: %s, interval: %s)", rangeStr, window.Bucket, interval)
+	defer log.Printf("Counts stream disconnected")
+
+	send := func(kind string, w TimeWindow) bool {
+		rows, err := api.store.LevelCounts(ctx, filter, w)
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Printf("Query error: %v", err)
+			}
+			return ctx.Err() == nil
+		}
+		data, _ := json.Marshal(gin.H{
+			"type":     kind,
+			"bucket":   window.Bucket.String(),
+			"timeline": timeline,
+			"points":   countsPoints(rows, byService),
+		})
+		if err := conn.WriteMessage(websocket.TextMessage, data); err != nil {
+			log.Printf("WebSocket write error: %v", err)
+			return false
+		}
+		return true
+	}
+
+	if !send("snapshot", window) {
+		return
+	}
+	// From the start of the previous bucket: late logs still land there
+	recent := TimeWindow{Span: window.Bucket, Bucket: window.Bucket}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if !send("update", recent) {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultMaxInFlight = 32
+	shedRetryAfter     = 2 * time.Second
+)
+
+// loadShedder caps how many heavy queries run against ClickHouse at once.
+// Requests over the cap are rejected with 503 + Retry-After instead of queuing,
+// so a burst of dashboard polling can't pile up on the shared database.
+type loadShedder struct {
+	slots chan struct{}
+	shed  atomic.Uint64
+}
+
+func newLoadShedder(maxInFlight int) *loadShedder {
+	if maxInFlight <= 0 {
+		maxInFlight = defaultMaxInFlight
+	}
+	return &loadShedder{slots: make(chan struct{}, maxInFlight)}
+}
+
+// middleware guards a handler with the in-flight limit (a nil shedder lets everything through)
+func (l *loadShedder) middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if l == nil {
+			c.Next()
+			return
+		}
+		select {
+		case l.slots <- struct{}{}:
+		default:
+			l.shed.Add(1)
+			c.Header("Retry-After", strconv.Itoa(int(shedRetryAfter.Seconds())))
+			abortWithError(c, &apiError{Status: http.StatusServiceUnavailable, Code: codeOverloaded, Message: "server busy, retry later"})
+			return
+		}
+		defer func() { <-l.slots }()
+		c.Next()
+	}
+}
+
+func (l *loadShedder) inFlight() int {
+	if l == nil {
+		return 0
+	}
+	return len(l.slots)
+}
+
+func (l *loadShedder) capacity() int {
+	if l == nil {
+		return 0
+	}
+	return cap(l.slots)
+}
+
+func (l *loadShedder) shedTotal() uint64 {
+	if l == nil {
+		return 0
+	}
+	return l.shed.Load()
+}
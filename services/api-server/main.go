@@ -2,36 +2,99 @@ package main
 
 import (
 	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/ClickHouse/clickhouse-go/v2"
-	"github.com/ClickHouse/clickhouse-go/v2/lib/driver"
 	"github.com/gin-gonic/gin"
 	"github.com/gorilla/websocket"
 )
 
-var upgrader = websocket.Upgrader{
-	CheckOrigin: func(r *http.Request) bool { return true },
-}
+// upgrader's CheckOrigin is set by setupRouter from the CORS policy
+var upgrader = websocket.Upgrader{}
 
 type APIServer struct {
-	db driver.Conn
+	store   LogStore
+	apiKey  string       // Required for destructive endpoints; empty disables them
+	shedder *loadShedder // Caps concurrent heavy queries; nil disables the limit
+	gzip    bool         // Compress responses / accept gzipped bodies
+	maxRows int          // Largest limit a /logs query may ask for; 0 means defaultMaxRows
+	cors    *corsPolicy  // Browser origins allowed to call the API (and open the WebSocket)
+	groups  serviceGroups // SERVICE_GROUPS: service=<group> queries all its members
+	registry serviceRegistry // SERVICE_REGISTRY_FILE: team, owner, criticality and runbook per service
+	offlineAfter time.Duration // Heartbeat staleness after which an agent is offline; 0 means defaultOfflineAfter
+	quiet   *quietDetector // Alerts on services that stopped logging; nil when disabled
+}
+
+// defaultMaxRows caps /logs results, which are buffered in memory before encoding
+const defaultMaxRows = 10000
+
+// clampLimit bounds a requested row limit to the server maximum. When it has to
+// clamp, it sets X-Limit-Clamped to the applied limit and reports true.
+func (api *APIServer) clampLimit(c *gin.Context, limit int) (int, bool) {
+	maxRows := api.maxRows
+	if maxRows <= 0 {
+		maxRows = defaultMaxRows
+	}
+	if limit >= 0 && limit <= maxRows {
+		return limit, false
+	}
+	c.Header("X-Limit-Clamped", strconv.Itoa(maxRows))
+	return maxRows, true
+}
+
+// requireAPIKey guards an endpoint with the X-API-Key header.
+// When no API_KEY is configured the endpoint is refused outright rather than left open.
+func (api *APIServer) requireAPIKey() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if api.apiKey == "" {
+			abortWithError(c, &apiError{Status: http.StatusForbidden, Code: codeForbidden, Message: "endpoint disabled: set API_KEY to enable"})
+			return
+		}
+		key := c.GetHeader("X-API-Key")
+		if key == "" {
+			key = strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+		}
+		if subtle.ConstantTimeCompare([]byte(key), []byte(api.apiKey)) != 1 {
+			abortWithError(c, &apiError{Status: http.StatusUnauthorized, Code: codeUnauthorized, Message: "invalid or missing API key"})
+			return
+		}
+		c.Next()
+	}
 }
 
 func setupRouter(api *APIServer) *gin.Engine {
 	r := gin.Default()
+	r.Use(requestIDMiddleware())
+
+	// The live stream is a WebSocket, which browsers open cross-origin without
+	// CORS checks: accept non-browser clients, the same host and allowed origins
+	upgrader.CheckOrigin = func(r *http.Request) bool {
+		origin := r.Header.Get("Origin")
+		if origin == "" || api.cors.allowed(origin) {
+			return true
+		}
+		u, err := url.Parse(origin)
+		return err == nil && strings.EqualFold(u.Host, r.Host)
+	}
 
-	// CORS middleware
+	// CORS middleware: only allowed origins get the Access-Control headers
 	r.Use(func(c *gin.Context) {
-		c.Header("Access-Control-Allow-Origin", "*")
-		c.Header("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
-		c.Header("Access-Control-Allow-Headers", "Content-Type")
+		if api.cors.apply(c.Writer.Header(), c.GetHeader("Origin")) {
+			c.Header("Access-Control-Allow-Methods", "GET, POST, DELETE, OPTIONS")
+			c.Header("Access-Control-Allow-Headers", "Content-Type, X-API-Key, Authorization, X-Request-ID")
+			c.Header("Access-Control-Expose-Headers", "X-Limit-Clamped, X-Request-ID")
+		}
 		if c.Request.Method == "OPTIONS" {
 			c.AbortWithStatus(204)
 			return
@@ -39,10 +102,38 @@ func setupRouter(api *APIServer) *gin.Engine {
 		c.Next()
 	})
 
+	if api.gzip {
+		r.Use(gzipMiddleware())
+	}
+	// Inside gzip, so error bodies are compressed like any other; see errors.go
+	r.Use(errorMiddleware())
+	r.NoRoute(func(c *gin.Context) {
+		abortWithError(c, &apiError{Status: http.StatusNotFound, Code: codeNotFound, Message: "no such endpoint"})
+	})
+
+	// Health and metrics stay outside the load shedder so probes work under load
+	r.GET("/health", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"status": "healthy"})
+	})
+	r.GET("/metrics", func(c *gin.Context) {
+		metrics := gin.H{
+			"in_flight":     api.shedder.inFlight(),
+			"max_in_flight": api.shedder.capacity(),
+			"shed_total":    api.shedder.shedTotal(),
+		}
+		if api.quiet != nil {
+			metrics["quiet_alerts_sent"] = api.quiet.alertsSent.Load()
+			metrics["quiet_alerts_failed"] = api.quiet.alertsFailed.Load()
+		}
+		c.JSON(http.StatusOK, metrics)
+	})
+
+	heavy := api.shedder.middleware()
+
 	apiGroup := r.Group("/api/v1")
 	{
 		// GET /api/v1/logs
-		apiGroup.GET("/logs", func(c *gin.Context) {
+		apiGroup.GET("/logs", heavy, func(c *gin.Context) {
 			service := c.Query("service")
 			level := c.Query("level")
 			limitStr := c.Query("limit")
@@ -52,184 +143,217 @@ func setupRouter(api *APIServer) *gin.Engine {
 					limit = l
 				}
 			}
+			limit, clamped := api.clampLimit(c, limit)
 
-			query := "SELECT timestamp, level, service, message, trace_id, agent_id FROM stackmonitor.logs WHERE 1=1"
-			args := []interface{}{}
-
-			if service != "" {
-				query += " AND service = ?"
-				args = append(args, service)
-			}
-			if level != "" {
-				query += " AND level = ?"
-				args = append(args, level)
+			timeline, err := parseTimeline(c.Query("timeline"))
+			if err != nil {
+				abortWithError(c, invalidParam("timeline", err.Error()))
+				return
 			}
 
-			query += " ORDER BY timestamp DESC LIMIT ?"
-			args = append(args, limit)
-
-			rows, err := api.db.Query(context.Background(), query, args...)
+			levels, err := minLevels(c)
 			if err != nil {
-				log.Printf("Query error: %v", err)
-				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				abortWithError(c, invalidParam("min_level", err.Error()))
 				return
 			}
-			defer rows.Close()
-
-			var logs []map[string]interface{}
-			for rows.Next() {
-				var timestamp time.Time
-				var logLevel, service, message, traceID, agentID string
 
-				if err := rows.Scan(&timestamp, &logLevel, &service, &message, &traceID, &agentID); err != nil {
-					log.Printf("Error scanning row: %v", err)
-					continue
-				}
+			filter := LogFilter{
+				Service:  service,
+				Level:    level,
+				Levels:   levels,
+				AgentID:  c.Query("agent_id"),
+				Timeline: timeline,
+				Limit:    limit,
+			}
+			members := api.expandService(&filter)
 
-				logs = append(logs, map[string]interface{}{
-					"timestamp": timestamp.Format(time.RFC3339),
-					"level":     logLevel,
-					"service":   service,
-					"message":   message,
-					"trace_id":  traceID,
-					"agent_id":  agentID,
-				})
+			records, err := api.store.QueryLogs(context.Background(), filter)
+			if err != nil {
+				abortWithError(c, queryError(err))
+				return
 			}
 
 			// Ensure logs is never null
-			if logs == nil {
-				logs = []map[string]interface{}{}
+			logs := make([]map[string]interface{}, 0, len(records))
+			for _, record := range records {
+				logs = append(logs, record.toMap())
 			}
 
-			result := gin.H{"logs": logs, "count": len(logs)}
+			result := gin.H{"logs": logs, "count": len(logs), "status": resultStatus(len(logs)), "timeline": timeline}
+			if members != nil {
+				result["services"] = members
+			}
+			levelLabel := level
+			if level == "" && levels != nil {
+				result["levels"] = levels
+				levelLabel = levels[0] + " and above"
+			}
+			if clamped {
+				result["warning"] = fmt.Sprintf("limit clamped to %d; page through larger results with POST /api/v1/search and its next_cursor", limit)
+			}
 
 			// Check if request wants HTML (from browser)
 			if c.GetHeader("Accept") == "text/html" || c.Query("format") == "html" {
 				c.Header("Content-Type", "text/html; charset=utf-8")
-				renderLogsHTML(c, logs, level, service, limit)
+				renderLogsHTML(c, logs, levelLabel, service, limit)
 				return
 			}
 
 			c.JSON(http.StatusOK, result)
 		})
 
-		// GET /api/v1/logs/stats
-		apiGroup.GET("/logs/stats", func(c *gin.Context) {
-			// Get log statistics
-			var totalCount, errorCount, warnCount, infoCount uint64
-			
-			// Total count
-			err := api.db.QueryRow(context.Background(), "SELECT count() FROM stackmonitor.logs").Scan(&totalCount)
+		// DELETE /api/v1/logs?service=X&level=Y&from=T1&to=T2&dry_run=true
+		// Deletes are mutations in ClickHouse: expensive and irreversible, so callers
+		// must always state dry_run explicitly and see the match count first: the
+		// dry run returns a confirm token that dry_run=false must send back, and
+		// the delete is refused when the filters or the count changed since.
+		apiGroup.DELETE("/logs", api.requireAPIKey(), func(c *gin.Context) {
+			dryRunStr := c.Query("dry_run")
+			if dryRunStr == "" {
+				abortWithError(c, invalidParam("dry_run", "dry_run is required: call with dry_run=true to preview, then dry_run=false to delete"))
+				return
+			}
+			dryRun, err := strconv.ParseBool(dryRunStr)
 			if err != nil {
-				log.Printf("Error getting total count: %v", err)
+				abortWithError(c, invalidParam("dry_run", "dry_run must be true or false"))
+				return
 			}
-			
-			// Error count
-			err = api.db.QueryRow(context.Background(), "SELECT count() FROM stackmonitor.logs WHERE level = 'ERROR'").Scan(&errorCount)
+
+			filter, err := parseDeleteFilter(c)
 			if err != nil {
-				log.Printf("Error getting error count: %v", err)
+				abortWithError(c, invalidRequest(err.Error()))
+				return
 			}
-			
-			// Warn count
-			err = api.db.QueryRow(context.Background(), "SELECT count() FROM stackmonitor.logs WHERE level = 'WARN'").Scan(&warnCount)
+			members := api.expandService(&filter)
+
+			matched, err := api.store.CountLogs(context.Background(), filter)
 			if err != nil {
-				log.Printf("Error getting warn count: %v", err)
+				abortWithError(c, queryError(err))
+				return
+			}
+
+			filters := gin.H{
+				"service":  c.Query("service"),
+				"level":    c.Query("level"),
+				"agent_id": c.Query("agent_id"),
+				"from":     c.Query("from"),
+				"to":       c.Query("to"),
+			}
+			if members != nil {
+				filters["services"] = members
+			}
+
+			confirm := deleteConfirmToken(c, matched)
+			if dryRun {
+				c.JSON(http.StatusOK, gin.H{"dry_run": true, "matched": matched, "confirm": confirm, "filters": filters})
+				return
+			}
+			switch c.Query("confirm") {
+			case "":
+				abortWithError(c, invalidParam("confirm", "confirm is required: pass the token returned by the dry_run=true call"))
+				return
+			case confirm:
+			default:
+				abortWithError(c, &apiError{Status: http.StatusConflict, Code: codeConflict,
+					Message: "the logs matched changed since the dry run; review the new count and confirm again",
+					Details: gin.H{"param": "confirm", "matched": matched, "confirm": confirm}})
+				return
 			}
-			
-			// Info count
-			err = api.db.QueryRow(context.Background(), "SELECT count() FROM stackmonitor.logs WHERE level = 'INFO'").Scan(&infoCount)
+
+			if err := api.store.DeleteLogs(context.Background(), filter); err != nil {
+				abortWithError(c, queryError(err))
+				return
+			}
+			log.Printf("🗑️ Delete submitted for %d logs (filters: %v)", matched, filters)
+
+			// ClickHouse applies the delete asynchronously as a mutation
+			c.JSON(http.StatusAccepted, gin.H{"dry_run": false, "deleted": matched, "status": "submitted", "filters": filters})
+		})
+
+		apiGroup.GET("/logs/tail", heavy, api.tail)
+
+		apiGroup.GET("/services", api.listServices)
+		apiGroup.GET("/services/groups", api.listServiceGroups)
+
+		apiGroup.GET("/agents/status", api.agentStatus)
+		apiGroup.DELETE("/agents/:id", api.requireAPIKey(), api.forgetAgent)
+
+		apiGroup.GET("/alerts/quiet-services", api.quietServices)
+
+		// GET /api/v1/logs/stats
+		apiGroup.GET("/logs/stats", heavy, func(c *gin.Context) {
+			stats, err := api.store.Stats(context.Background())
 			if err != nil {
-				log.Printf("Error getting info count: %v", err)
+				abortWithError(c, queryError(err))
+				return
 			}
-			
+
 			c.JSON(http.StatusOK, gin.H{
-				"total": totalCount,
-				"errors": errorCount,
-				"warnings": warnCount,
-				"info": infoCount,
+				"status": resultStatus(int(stats.Total)),
+				"total": stats.Total,
+				"errors": stats.Errors,
+				"warnings": stats.Warnings,
+				"info": stats.Info,
 			})
 		})
 
 		// GET /api/v1/metrics/error-rate
-		apiGroup.GET("/metrics/error-rate", func(c *gin.Context) {
+		apiGroup.GET("/metrics/error-rate", heavy, func(c *gin.Context) {
 			service := c.Query("service")
 			rangeStr := c.Query("range")
 			if rangeStr == "" {
 				rangeStr = "1h"
 			}
 
-			var interval string
-			var timeRange string
-			switch rangeStr {
-			case "15m":
-				interval = "1 minute"
-				timeRange = "15 MINUTE"
-			case "1h":
-				interval = "1 minute"
-				timeRange = "1 HOUR"
-			case "6h":
-				interval = "5 minute"
-				timeRange = "6 HOUR"
-			case "24h":
-				interval = "15 minute"
-				timeRange = "24 HOUR"
-			case "all":
-				interval = "1 hour"
-				timeRange = "30 DAY"
-			default:
-				interval = "1 minute"
-				timeRange = "1 HOUR"
-			}
-
-			query := `
-				SELECT 
-					toStartOfInterval(timestamp, INTERVAL ` + interval + `) as time,
-					count(*) as error_count
-				FROM stackmonitor.logs
-				WHERE level = 'ERROR'
-			`
-			args := []interface{}{}
-
-			if service != "" {
-				query += " AND service = ?"
-				args = append(args, service)
+			timeline, err := parseTimeline(c.Query("timeline"))
+			if err != nil {
+				abortWithError(c, invalidParam("timeline", err.Error()))
+				return
 			}
 
-			query += " AND timestamp >= now() - INTERVAL " + timeRange + " GROUP BY time ORDER BY time"
+			filter := LogFilter{Service: service, AgentID: c.Query("agent_id"), Timeline: timeline}
+			members := api.expandService(&filter)
 
-			rows, err := api.db.Query(context.Background(), query, args...)
+			points, err := api.store.ErrorRate(context.Background(), filter, resolveRange(rangeStr))
 			if err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				abortWithError(c, queryError(err))
 				return
 			}
-			defer rows.Close()
 
 			var metrics []map[string]interface{}
-			for rows.Next() {
-				var timeVal time.Time
-				var count uint64
-
-				if err := rows.Scan(&timeVal, &count); err != nil {
-					log.Printf("Error scanning row: %v", err)
-					continue
-				}
-
+			for _, p := range points {
 				metrics = append(metrics, map[string]interface{}{
-					"time":  timeVal.Format(time.RFC3339),
-					"count": count,
+					"time":  p.Time.Format(time.RFC3339),
+					"count": p.Count,
 				})
 			}
 
-			c.JSON(http.StatusOK, gin.H{"metrics": metrics})
+			// count is the total errors in the range, so zero means no errors rather than no data
+			total := sumPoints(points)
+			result := gin.H{"metrics": metrics, "count": total, "status": resultStatus(int(total)), "timeline": timeline}
+			if members != nil {
+				result["services"] = members
+			}
+			c.JSON(http.StatusOK, result)
 		})
 
+		apiGroup.GET("/metrics/error-rate/compare", heavy, api.errorRateCompare)
+		apiGroup.GET("/metrics/service-health", heavy, api.serviceHealth)
+		apiGroup.GET("/metrics/ingestion-lag", heavy, api.ingestionLag)
+		// WebSocket: live per-bucket counts by level, see live_counts.go
+		apiGroup.GET("/metrics/counts/stream", api.countsStream)
+		apiGroup.GET("/metrics/latency", heavy, api.latency)
+		apiGroup.GET("/errors/inbox", heavy, api.errorInbox)
+		apiGroup.GET("/traces/:trace_id", heavy, api.trace)
+		apiGroup.POST("/search", heavy, api.search)
+
 		// POST /api/v1/query (Natural Language Query)
-		apiGroup.POST("/query", func(c *gin.Context) {
+		apiGroup.POST("/query", heavy, func(c *gin.Context) {
 			var req struct {
 				Query string `json:"query"`
 			}
-			if err := c.BindJSON(&req); err != nil {
-				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+			if err := c.ShouldBindJSON(&req); err != nil {
+				abortWithError(c, invalidRequest("request body must be {\"query\": \"...\"}"))
 				return
 			}
 
@@ -239,31 +363,33 @@ func setupRouter(api *APIServer) *gin.Engine {
 
 			if contains(query, "error", "errors") {
 				// Get recent errors
-				rows, err := api.db.Query(context.Background(),
-					"SELECT service, count(*) as cnt FROM stackmonitor.logs WHERE level = 'ERROR' AND timestamp >= now() - INTERVAL 1 HOUR GROUP BY service",
-				)
-				if err == nil {
-					defer rows.Close()
-					var errorCounts []map[string]interface{}
-					for rows.Next() {
-						var service string
-						var count uint64
-						if err := rows.Scan(&service, &count); err == nil {
-							errorCounts = append(errorCounts, map[string]interface{}{
-								"service": service,
-								"count":   count,
-							})
-						}
-					}
-					results["errors_by_service"] = errorCounts
+				counts, err := api.store.ErrorsByService(context.Background(), time.Hour)
+				if err != nil {
+					abortWithError(c, queryError(err))
+					return
+				}
+				var errorCounts []map[string]interface{}
+				for _, sc := range counts {
+					errorCounts = append(errorCounts, map[string]interface{}{
+						"service": sc.Service,
+						"count":   sc.Count,
+					})
 				}
+				results["errors_by_service"] = errorCounts
 			}
 
 			c.JSON(http.StatusOK, gin.H{"query": query, "results": results})
 		})
 
 		// WebSocket for live log stream
+		// ?timeline=ingest tails by ingestion time so late or backfilled logs are not skipped
 		apiGroup.GET("/logs/stream", func(c *gin.Context) {
+			timeline, err := parseTimeline(c.Query("timeline"))
+			if err != nil {
+				abortWithError(c, invalidParam("timeline", err.Error()))
+				return
+			}
+
 			conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
 			if err != nil {
 				log.Printf("WebSocket upgrade failed: %v", err)
@@ -274,40 +400,24 @@ func setupRouter(api *APIServer) *gin.Engine {
 			ticker := time.NewTicker(1 * time.Second)
 			defer ticker.Stop()
 			lastTimestamp := time.Now()
+			log.Printf("Live stream connected (timeline: %s)", timeline)
 
 			for {
 				select {
 				case <-ticker.C:
-					query := "SELECT timestamp, level, service, message, trace_id, agent_id FROM stackmonitor.logs WHERE timestamp > ? ORDER BY timestamp LIMIT 100"
-					rows, err := api.db.Query(context.Background(), query, lastTimestamp)
+					records, err := api.store.StreamSince(context.Background(), timeline, lastTimestamp, 100)
 					if err != nil {
 						log.Printf("Query error: %v", err)
 						continue
 					}
 
 					var logs []map[string]interface{}
-					for rows.Next() {
-						var timestamp time.Time
-						var logLevel, service, message, traceID, agentID string
-
-						if err := rows.Scan(&timestamp, &logLevel, &service, &message, &traceID, &agentID); err != nil {
-							continue
+					for _, record := range records {
+						if t := timeline.of(record); t.After(lastTimestamp) {
+							lastTimestamp = t
 						}
-
-						if timestamp.After(lastTimestamp) {
-							lastTimestamp = timestamp
-						}
-
-						logs = append(logs, map[string]interface{}{
-							"timestamp": timestamp.Format(time.RFC3339),
-							"level":     logLevel,
-							"service":   service,
-							"message":   message,
-							"trace_id":  traceID,
-							"agent_id":  agentID,
-						})
+						logs = append(logs, record.toMap())
 					}
-					rows.Close()
 
 					if len(logs) > 0 {
 						data, _ := json.Marshal(logs)
@@ -337,6 +447,55 @@ func contains(s string, subs ...string) bool {
 	return false
 }
 
+// minLevels reads min_level: that level and every more severe one, nil when unset.
+// Unlike level it is a floor, e.g. min_level=WARN hides DEBUG and INFO noise.
+func minLevels(c *gin.Context) ([]string, error) {
+	if v := c.Query("min_level"); v != "" {
+		return levelsFrom(v)
+	}
+	return nil, nil
+}
+
+// parseDeleteFilter reads the delete query params into a LogFilter.
+// At least one filter is required so a bare call can never wipe the whole table.
+func parseDeleteFilter(c *gin.Context) (LogFilter, error) {
+	filter := LogFilter{
+		Service: c.Query("service"),
+		Level:   c.Query("level"),
+		AgentID: c.Query("agent_id"),
+	}
+	if from := c.Query("from"); from != "" {
+		t, err := time.Parse(time.RFC3339, from)
+		if err != nil {
+			return filter, fmt.Errorf("invalid from (want RFC3339): %v", err)
+		}
+		filter.From = t
+	}
+	if to := c.Query("to"); to != "" {
+		t, err := time.Parse(time.RFC3339, to)
+		if err != nil {
+			return filter, fmt.Errorf("invalid to (want RFC3339): %v", err)
+		}
+		filter.To = t
+	}
+
+	if filter.isEmpty() {
+		return filter, fmt.Errorf("at least one of service, level, agent_id, from, to is required")
+	}
+	return filter, nil
+}
+
+// deleteConfirmToken hashes the delete filters with the count they match, so
+// a confirm token only fits the delete its dry run previewed
+func deleteConfirmToken(c *gin.Context, matched uint64) string {
+	h := sha256.New()
+	for _, param := range []string{"service", "level", "agent_id", "from", "to"} {
+		fmt.Fprintf(h, "%s=%s\n", param, c.Query(param))
+	}
+	fmt.Fprintf(h, "matched=%d", matched)
+	return hex.EncodeToString(h.Sum(nil))[:16]
+}
+
 // Render logs as HTML for browser viewing
 func renderLogsHTML(c *gin.Context, logs []map[string]interface{}, level, service string, limit int) {
 	html := `<!DOCTYPE html>
@@ -496,14 +655,28 @@ func main() {
 		clickhouseAddr = "clickhouse:9000"
 	}
 
-	// ClickHouse connection - dev mode (no authentication)
-	conn, err := clickhouse.Open(&clickhouse.Options{
-		Addr: []string{clickhouseAddr},
-		Auth: clickhouse.Auth{
-			Database: "stackmonitor",
-			// No username/password for dev mode
-		},
-	})
+	// CH_DATABASE/CH_TABLE select a non-default dataset (e.g. staging next to prod).
+	// They are interpolated into SQL, so only plain identifiers are accepted.
+	chDatabase := os.Getenv("CH_DATABASE")
+	if chDatabase == "" {
+		chDatabase = defaultDatabase
+	}
+	chTable := os.Getenv("CH_TABLE")
+	if chTable == "" {
+		chTable = defaultLogsTable
+	}
+	for _, name := range []string{chDatabase, chTable} {
+		if !identifierRegex.MatchString(name) {
+			log.Fatalf("Invalid ClickHouse identifier %q in CH_DATABASE/CH_TABLE", name)
+		}
+	}
+
+	// ClickHouse connection: dev mode (no auth) unless CH_USER/CH_PASSWORD/CH_TLS are set
+	chOptions, err := clickHouseOptions(clickhouseAddr, chDatabase)
+	if err != nil {
+		log.Fatalf("Invalid ClickHouse connection settings: %v", err)
+	}
+	conn, err := clickhouse.Open(chOptions)
 	if err != nil {
 		log.Fatalf("Failed to connect to ClickHouse: %v", err)
 	}
@@ -513,7 +686,109 @@ func main() {
 		log.Fatalf("Failed to ping ClickHouse: %v", err)
 	}
 
-	api := &APIServer{db: conn}
+	routes, err := parseRouteRules(os.Getenv("ROUTE_RULES"))
+	if err != nil {
+		log.Fatalf("Invalid ROUTE_RULES: %v", err)
+	}
+
+	maxInFlight := defaultMaxInFlight
+	if v := os.Getenv("MAX_INFLIGHT_QUERIES"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			log.Fatalf("Invalid MAX_INFLIGHT_QUERIES: %q", v)
+		}
+		maxInFlight = n
+	}
+
+	maxRows := defaultMaxRows
+	if v := os.Getenv("MAX_QUERY_ROWS"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			log.Fatalf("Invalid MAX_QUERY_ROWS: %q", v)
+		}
+		maxRows = n
+	}
+
+	offlineAfter := defaultOfflineAfter
+	if v := os.Getenv("AGENT_OFFLINE_AFTER"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			log.Fatalf("Invalid AGENT_OFFLINE_AFTER: %q", v)
+		}
+		offlineAfter = d
+	}
+
+	groups, err := parseServiceGroups(os.Getenv("SERVICE_GROUPS"))
+	if err != nil {
+		log.Fatalf("Invalid SERVICE_GROUPS: %v", err)
+	}
+
+	registry, err := loadServiceRegistry(os.Getenv("SERVICE_REGISTRY_FILE"))
+	if err != nil {
+		log.Fatalf("Invalid SERVICE_REGISTRY_FILE: %v", err)
+	}
+
+	cors, err := corsPolicyFromEnv()
+	if err != nil {
+		log.Fatalf("Invalid CORS settings: %v", err)
+	}
+
+	// QUIET_SERVICE_AFTER=0 disables the quiet service detector
+	quietAfter := defaultQuietAfter
+	if v := os.Getenv("QUIET_SERVICE_AFTER"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			log.Fatalf("Invalid QUIET_SERVICE_AFTER: %q", v)
+		}
+		quietAfter = d
+	}
+	quietLookback := defaultQuietLookback
+	if v := os.Getenv("QUIET_SERVICE_LOOKBACK"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			log.Fatalf("Invalid QUIET_SERVICE_LOOKBACK: %q", v)
+		}
+		quietLookback = d
+	}
+	if quietAfter > 0 && quietLookback <= quietAfter {
+		log.Fatalf("QUIET_SERVICE_LOOKBACK (%s) must be longer than QUIET_SERVICE_AFTER (%s)", quietLookback, quietAfter)
+	}
+
+	store := newClickHouseStore(conn, chDatabase, chTable, routes)
+	// CH_AGGREGATES=false keeps every query on the raw log tables
+	if os.Getenv("CH_AGGREGATES") != "false" {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		if err := store.detectCounts(ctx); err != nil {
+			log.Printf("⚠️  %v, aggregate queries scan raw logs", err)
+		}
+		cancel()
+	}
+
+	api := &APIServer{
+		store:   store,
+		apiKey:  os.Getenv("API_KEY"),
+		shedder: newLoadShedder(maxInFlight),
+		gzip:    os.Getenv("GZIP_ENABLED") != "false", // on by default
+		maxRows: maxRows,
+		offlineAfter: offlineAfter,
+		cors:    cors,
+		groups:  groups,
+		registry: registry,
+	}
+	if quietAfter > 0 {
+		api.quiet = newQuietDetector(api.store, quietAfter, quietLookback, os.Getenv("ALERT_WEBHOOK_URL"))
+		go api.quiet.run()
+		log.Printf("Alerting on services silent for %s (active within %s)", quietAfter, quietLookback)
+	}
 	r := setupRouter(api)
-	r.Run(":5000")
+
+	// LISTEN_ADDR can pin the bind address, e.g. 127.0.0.1:5000
+	listenAddr := os.Getenv("LISTEN_ADDR")
+	if listenAddr == "" {
+		listenAddr = ":5000"
+	}
+	log.Printf("API server listening on %s", listenAddr)
+	if err := r.Run(listenAddr); err != nil {
+		log.Fatalf("Failed to start API server: %v", err)
+	}
 }
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestMain(m *testing.M) {
+	gin.SetMode(gin.TestMode)
+	os.Exit(m.Run())
+}
+
+const testAPIKey = "test-key"
+
+// testStore holds a few logs of the last minutes across two services
+func testStore() *memoryStore {
+	now := time.Now()
+	return newMemoryStore(
+		LogRecord{Timestamp: now.Add(-5 * time.Minute), Level: "INFO", Service: "api-gateway", Message: "request served", AgentID: "agent-1"},
+		LogRecord{Timestamp: now.Add(-4 * time.Minute), Level: "WARN", Service: "api-gateway", Message: "slow upstream", AgentID: "agent-1"},
+		LogRecord{Timestamp: now.Add(-3 * time.Minute), Level: "ERROR", Service: "payment-service", Message: "card declined", AgentID: "agent-2"},
+		LogRecord{Timestamp: now.Add(-2 * time.Minute), Level: "ERROR", Service: "payment-service", Message: "gateway timeout", AgentID: "agent-2"},
+		LogRecord{Timestamp: now.Add(-1 * time.Minute), Level: "INFO", Service: "payment-service", Message: "payment captured", AgentID: "agent-2"},
+	)
+}
+
+// serve runs one request through the full router and decodes the JSON body
+func serve(t *testing.T, api *APIServer, method, target string, header http.Header) (*httptest.ResponseRecorder, map[string]interface{}) {
+	t.Helper()
+	req := httptest.NewRequest(method, target, nil)
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	w := httptest.NewRecorder()
+	setupRouter(api).ServeHTTP(w, req)
+	var body map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatalf("%s %s: decode body %q: %v", method, target, w.Body.String(), err)
+	}
+	return w, body
+}
+
+// errorCode is the envelope's error.code, "" when the body isn't an error
+func errorCode(body map[string]interface{}) string {
+	e, _ := body["error"].(map[string]interface{})
+	code, _ := e["code"].(string)
+	return code
+}
+
+func TestGetLogs(t *testing.T) {
+	tests := []struct {
+		name      string
+		query     string
+		wantCode  int
+		wantCount int
+		wantError string
+		clamped   string
+	}{
+		{name: "all", query: "", wantCode: http.StatusOK, wantCount: 5},
+		{name: "by service", query: "service=api-gateway", wantCode: http.StatusOK, wantCount: 2},
+		{name: "by level", query: "level=ERROR", wantCode: http.StatusOK, wantCount: 2},
+		{name: "min level", query: "min_level=WARN", wantCode: http.StatusOK, wantCount: 3},
+		{name: "by agent", query: "agent_id=agent-1", wantCode: http.StatusOK, wantCount: 2},
+		{name: "limit", query: "limit=2", wantCode: http.StatusOK, wantCount: 2},
+		{name: "nothing matched", query: "service=unknown", wantCode: http.StatusOK, wantCount: 0},
+		{name: "clamped", query: "limit=50", wantCode: http.StatusOK, wantCount: 5, clamped: "3"},
+		{name: "bad timeline", query: "timeline=wall", wantCode: http.StatusBadRequest, wantError: codeInvalidRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := &APIServer{store: testStore(), maxRows: 3}
+			if tt.clamped == "" {
+				api.maxRows = 0
+			}
+			w, body := serve(t, api, http.MethodGet, "/api/v1/logs?"+tt.query, nil)
+			if w.Code != tt.wantCode {
+				t.Fatalf("status = %d, want %d (body %s)", w.Code, tt.wantCode, w.Body.String())
+			}
+			if tt.wantError != "" {
+				if got := errorCode(body); got != tt.wantError {
+					t.Errorf("error code = %q, want %q", got, tt.wantError)
+				}
+				return
+			}
+			wantCount := tt.wantCount
+			if tt.clamped != "" {
+				wantCount = 3
+			}
+			if got := int(body["count"].(float64)); got != wantCount {
+				t.Errorf("count = %d, want %d", got, wantCount)
+			}
+			wantStatus := statusOK
+			if wantCount == 0 {
+				wantStatus = statusEmpty
+			}
+			if body["status"] != wantStatus {
+				t.Errorf("status = %v, want %s", body["status"], wantStatus)
+			}
+			if got := w.Header().Get("X-Limit-Clamped"); got != tt.clamped {
+				t.Errorf("X-Limit-Clamped = %q, want %q", got, tt.clamped)
+			}
+		})
+	}
+}
+
+func TestGetLogStats(t *testing.T) {
+	_, body := serve(t, &APIServer{store: testStore()}, http.MethodGet, "/api/v1/logs/stats", nil)
+	want := map[string]float64{"total": 5, "errors": 2, "warnings": 1, "info": 2}
+	for field, n := range want {
+		if body[field] != n {
+			t.Errorf("%s = %v, want %v", field, body[field], n)
+		}
+	}
+}
+
+// failingStore fails the queries the error envelope tests go through
+type failingStore struct {
+	*memoryStore
+	err error
+}
+
+func (s failingStore) Stats(ctx context.Context) (LogStats, error) { return LogStats{}, s.err }
+
+func (s failingStore) ErrorsByService(ctx context.Context, span time.Duration) ([]ServiceCount, error) {
+	return nil, s.err
+}
+
+func TestStoreErrorsUseEnvelope(t *testing.T) {
+	tests := []struct {
+		name     string
+		method   string
+		target   string
+		body     string
+		err      error
+		wantCode int
+		wantErr  string
+	}{
+		{name: "stats failed", method: http.MethodGet, target: "/api/v1/logs/stats", err: errors.New("code: 60, table missing"),
+			wantCode: http.StatusInternalServerError, wantErr: codeQueryFailed},
+		{name: "stats timed out", method: http.MethodGet, target: "/api/v1/logs/stats", err: context.DeadlineExceeded,
+			wantCode: http.StatusGatewayTimeout, wantErr: codeQueryTimeout},
+		{name: "query failed", method: http.MethodPost, target: "/api/v1/query", body: `{"query": "show errors"}`, err: errors.New("code: 60, table missing"),
+			wantCode: http.StatusInternalServerError, wantErr: codeQueryFailed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := &APIServer{store: failingStore{memoryStore: testStore(), err: tt.err}}
+			req := httptest.NewRequest(tt.method, tt.target, strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set(requestIDHeader, "req-123")
+			w := httptest.NewRecorder()
+			setupRouter(api).ServeHTTP(w, req)
+
+			if w.Code != tt.wantCode {
+				t.Fatalf("status = %d, want %d (body %s)", w.Code, tt.wantCode, w.Body.String())
+			}
+			var body map[string]interface{}
+			if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+				t.Fatal(err)
+			}
+			if got := errorCode(body); got != tt.wantErr {
+				t.Errorf("error code = %q, want %q", got, tt.wantErr)
+			}
+			if got := body["error"].(map[string]interface{})["request_id"]; got != "req-123" {
+				t.Errorf("request_id = %v, want req-123", got)
+			}
+		})
+	}
+}
+
+func TestDeleteLogs(t *testing.T) {
+	auth := http.Header{"X-Api-Key": {testAPIKey}}
+
+	t.Run("guards", func(t *testing.T) {
+		tests := []struct {
+			name     string
+			apiKey   string
+			header   http.Header
+			query    string
+			wantCode int
+			wantErr  string
+		}{
+			{name: "disabled without API_KEY", query: "service=api-gateway&dry_run=true", wantCode: http.StatusForbidden, wantErr: codeForbidden},
+			{name: "missing key", apiKey: testAPIKey, query: "service=api-gateway&dry_run=true", wantCode: http.StatusUnauthorized, wantErr: codeUnauthorized},
+			{name: "dry_run required", apiKey: testAPIKey, header: auth, query: "service=api-gateway", wantCode: http.StatusBadRequest, wantErr: codeInvalidRequest},
+			{name: "filter required", apiKey: testAPIKey, header: auth, query: "dry_run=true", wantCode: http.StatusBadRequest, wantErr: codeInvalidRequest},
+			{name: "confirm required", apiKey: testAPIKey, header: auth, query: "service=api-gateway&dry_run=false", wantCode: http.StatusBadRequest, wantErr: codeInvalidRequest},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				store := testStore()
+				w, body := serve(t, &APIServer{store: store, apiKey: tt.apiKey}, http.MethodDelete, "/api/v1/logs?"+tt.query, tt.header)
+				if w.Code != tt.wantCode {
+					t.Fatalf("status = %d, want %d (body %s)", w.Code, tt.wantCode, w.Body.String())
+				}
+				if got := errorCode(body); got != tt.wantErr {
+					t.Errorf("error code = %q, want %q", got, tt.wantErr)
+				}
+				if n, _ := store.CountLogs(context.Background(), LogFilter{}); n != 5 {
+					t.Errorf("%d logs left, want all 5", n)
+				}
+			})
+		}
+	})
+
+	t.Run("dry run then confirmed delete", func(t *testing.T) {
+		store := testStore()
+		api := &APIServer{store: store, apiKey: testAPIKey}
+
+		w, preview := serve(t, api, http.MethodDelete, "/api/v1/logs?service=payment-service&dry_run=true", auth)
+		if w.Code != http.StatusOK || preview["matched"] != float64(3) {
+			t.Fatalf("dry run = %d %v, want 200 with 3 matched", w.Code, preview)
+		}
+		confirm, _ := preview["confirm"].(string)
+		if confirm == "" {
+			t.Fatalf("dry run returned no confirm token: %v", preview)
+		}
+
+		// A token is only good for the filters it previewed
+		w, body := serve(t, api, http.MethodDelete, "/api/v1/logs?service=api-gateway&dry_run=false&confirm="+confirm, auth)
+		if w.Code != http.StatusConflict || errorCode(body) != codeConflict {
+			t.Fatalf("delete with another filter's token = %d %v, want 409 conflict", w.Code, body)
+		}
+
+		w, body = serve(t, api, http.MethodDelete, "/api/v1/logs?service=payment-service&dry_run=false&confirm="+confirm, auth)
+		if w.Code != http.StatusAccepted || body["deleted"] != float64(3) {
+			t.Fatalf("confirmed delete = %d %v, want 202 with 3 deleted", w.Code, body)
+		}
+		if n, _ := store.CountLogs(context.Background(), LogFilter{}); n != 2 {
+			t.Errorf("%d logs left, want 2", n)
+		}
+	})
+
+	t.Run("count changed since the dry run", func(t *testing.T) {
+		store := testStore()
+		api := &APIServer{store: store, apiKey: testAPIKey}
+
+		_, preview := serve(t, api, http.MethodDelete, "/api/v1/logs?service=payment-service&dry_run=true", auth)
+		store.Add(LogRecord{Timestamp: time.Now(), Level: "ERROR", Service: "payment-service", Message: "late arrival"})
+
+		w, body := serve(t, api, http.MethodDelete, "/api/v1/logs?service=payment-service&dry_run=false&confirm="+preview["confirm"].(string), auth)
+		if w.Code != http.StatusConflict {
+			t.Fatalf("status = %d, want 409 (body %s)", w.Code, w.Body.String())
+		}
+		details := body["error"].(map[string]interface{})["details"].(map[string]interface{})
+		if details["matched"] != float64(4) {
+			t.Errorf("details.matched = %v, want the new count 4", details["matched"])
+		}
+		if n, _ := store.CountLogs(context.Background(), LogFilter{}); n != 6 {
+			t.Errorf("%d logs left, want all 6", n)
+		}
+	})
+}
+
+func TestUnknownRoute(t *testing.T) {
+	w, body := serve(t, &APIServer{store: testStore()}, http.MethodGet, "/api/v1/nope", nil)
+	if w.Code != http.StatusNotFound || errorCode(body) != codeNotFound {
+		t.Errorf("got %d %v, want 404 not_found", w.Code, body)
+	}
+}
@@ -0,0 +1,365 @@
+package main
+
+import (
+	"context"
+	"math"
+	"slices"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// memoryStore is an in-memory LogStore for handler tests and local experiments.
+// It implements the same semantics as the ClickHouse store over a plain slice.
+type memoryStore struct {
+	mu     sync.RWMutex
+	logs   []LogRecord
+	agents map[string]AgentStatusRow
+	now    func() time.Time
+}
+
+func newMemoryStore(records ...LogRecord) *memoryStore {
+	s := &memoryStore{agents: make(map[string]AgentStatusRow), now: time.Now}
+	s.Add(records...)
+	return s
+}
+
+// Add appends records to the store
+func (s *memoryStore) Add(records ...LogRecord) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.logs = append(s.logs, records...)
+}
+
+// AddAgent records (or replaces) an agent's latest heartbeat
+func (s *memoryStore) AddAgent(row AgentStatusRow) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.agents[row.AgentID] = row
+}
+
+// filtered returns matching records sorted newest first on the filter's timeline
+func (s *memoryStore) filtered(f LogFilter) []LogRecord {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var out []LogRecord
+	for _, r := range s.logs {
+		if f.matches(r) {
+			out = append(out, r)
+		}
+	}
+	sort.SliceStable(out, func(i, j int) bool { return f.Timeline.of(out[i]).After(f.Timeline.of(out[j])) })
+	return out
+}
+
+func (s *memoryStore) QueryLogs(ctx context.Context, f LogFilter) ([]LogRecord, error) {
+	out := s.filtered(f)
+	if f.Limit >= 0 && len(out) > f.Limit {
+		out = out[:f.Limit]
+	}
+	return out, nil
+}
+
+func (s *memoryStore) Stats(ctx context.Context) (LogStats, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var stats LogStats
+	for _, r := range s.logs {
+		stats.Total++
+		switch r.Level {
+		case "ERROR":
+			stats.Errors++
+		case "WARN":
+			stats.Warnings++
+		case "INFO":
+			stats.Info++
+		}
+	}
+	return stats, nil
+}
+
+func (s *memoryStore) ErrorRate(ctx context.Context, f LogFilter, w TimeWindow) ([]RatePoint, error) {
+	end := s.now().Add(-w.Offset)
+	filter := LogFilter{Service: f.Service, Services: f.Services, AgentID: f.AgentID, Level: "ERROR", From: end.Add(-w.Span), Timeline: f.Timeline}
+	if w.Offset > 0 {
+		filter.To = end
+	}
+	records := s.filtered(filter)
+
+	buckets := make(map[time.Time]uint64)
+	for _, r := range records {
+		buckets[f.Timeline.of(r).Truncate(w.Bucket)]++
+	}
+
+	points := make([]RatePoint, 0, len(buckets))
+	for t, count := range buckets {
+		points = append(points, RatePoint{Time: t, Count: count})
+	}
+	sort.Slice(points, func(i, j int) bool { return points[i].Time.Before(points[j].Time) })
+	return points, nil
+}
+
+func (s *memoryStore) LevelCounts(ctx context.Context, f LogFilter, w TimeWindow) ([]LevelCountRow, error) {
+	from := s.now().Add(-w.Span).Truncate(w.Bucket)
+	type key struct {
+		time           time.Time
+		service, level string
+	}
+	counts := make(map[key]uint64)
+	for _, r := range s.filtered(LogFilter{Service: f.Service, Services: f.Services, AgentID: f.AgentID, From: from, Timeline: f.Timeline}) {
+		counts[key{f.Timeline.of(r).Truncate(w.Bucket), r.Service, r.Level}]++
+	}
+
+	out := make([]LevelCountRow, 0, len(counts))
+	for k, count := range counts {
+		out = append(out, LevelCountRow{Time: k.time, Service: k.service, Level: k.level, Count: count})
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if !out[i].Time.Equal(out[j].Time) {
+			return out[i].Time.Before(out[j].Time)
+		}
+		if out[i].Service != out[j].Service {
+			return out[i].Service < out[j].Service
+		}
+		return out[i].Level < out[j].Level
+	})
+	return out, nil
+}
+
+func (s *memoryStore) StreamSince(ctx context.Context, timeline Timeline, since time.Time, limit int) ([]LogRecord, error) {
+	records := s.filtered(LogFilter{Timeline: timeline})
+
+	var out []LogRecord
+	for i := len(records) - 1; i >= 0 && len(out) < limit; i-- {
+		if timeline.of(records[i]).After(since) {
+			out = append(out, records[i])
+		}
+	}
+	return out, nil
+}
+
+func (s *memoryStore) ErrorsByService(ctx context.Context, span time.Duration) ([]ServiceCount, error) {
+	counts := make(map[string]uint64)
+	for _, r := range s.filtered(LogFilter{Level: "ERROR", From: s.now().Add(-span)}) {
+		counts[r.Service]++
+	}
+
+	out := make([]ServiceCount, 0, len(counts))
+	for service, count := range counts {
+		out = append(out, ServiceCount{Service: service, Count: count})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Service < out[j].Service })
+	return out, nil
+}
+
+func (s *memoryStore) CountLogs(ctx context.Context, f LogFilter) (uint64, error) {
+	return uint64(len(s.filtered(f))), nil
+}
+
+func (s *memoryStore) DeleteLogs(ctx context.Context, f LogFilter) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	kept := s.logs[:0]
+	for _, r := range s.logs {
+		if !f.matches(r) {
+			kept = append(kept, r)
+		}
+	}
+	s.logs = kept
+	return nil
+}
+
+func (s *memoryStore) ServiceHealth(ctx context.Context, span time.Duration) ([]ServiceHealthRow, error) {
+	byService := make(map[string]*ServiceHealthRow)
+	for _, r := range s.filtered(LogFilter{From: s.now().Add(-span)}) {
+		row, ok := byService[r.Service]
+		if !ok {
+			row = &ServiceHealthRow{Service: r.Service}
+			byService[r.Service] = row
+		}
+		row.Total++
+		switch r.Level {
+		case "ERROR":
+			row.Errors++
+		case "WARN":
+			row.Warnings++
+		}
+		if r.Timestamp.After(row.LastSeen) {
+			row.LastSeen = r.Timestamp
+		}
+	}
+
+	out := make([]ServiceHealthRow, 0, len(byService))
+	for _, row := range byService {
+		out = append(out, *row)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Service < out[j].Service })
+	return out, nil
+}
+
+func (s *memoryStore) Freshness(ctx context.Context, span time.Duration) ([]FreshnessRow, time.Time, error) {
+	now := s.now()
+	type key struct{ service, agent string }
+	byKey := make(map[key]*FreshnessRow)
+	for _, r := range s.filtered(LogFilter{From: now.Add(-span)}) {
+		k := key{r.Service, r.AgentID}
+		row, ok := byKey[k]
+		if !ok {
+			row = &FreshnessRow{Service: r.Service, AgentID: r.AgentID}
+			byKey[k] = row
+		}
+		row.Logs++
+		if r.Timestamp.After(row.LastEvent) {
+			row.LastEvent = r.Timestamp
+		}
+		if r.IngestedAt.After(row.LastIngested) {
+			row.LastIngested = r.IngestedAt
+		}
+	}
+
+	out := make([]FreshnessRow, 0, len(byKey))
+	for _, row := range byKey {
+		out = append(out, *row)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Service != out[j].Service {
+			return out[i].Service < out[j].Service
+		}
+		return out[i].AgentID < out[j].AgentID
+	})
+	return out, now, nil
+}
+
+func (s *memoryStore) Search(ctx context.Context, q SearchQuery) (SearchResult, error) {
+	result := SearchResult{ByService: map[string]uint64{}, ByLevel: map[string]uint64{}}
+	var matched []LogRecord
+	for _, r := range s.filtered(q.Filter) {
+		if !q.matches(r) {
+			continue
+		}
+		matched = append(matched, r)
+		result.ByService[r.Service]++
+		result.ByLevel[r.Level]++
+		result.Total++
+	}
+
+	// filtered is newest first already
+	switch q.Sort {
+	case sortOldest:
+		slices.Reverse(matched)
+	case sortRelevance:
+		sort.SliceStable(matched, func(i, j int) bool { return q.relevance(matched[i]) > q.relevance(matched[j]) })
+	}
+	if q.Offset >= len(matched) {
+		return result, nil
+	}
+	matched = matched[q.Offset:]
+	if q.Filter.Limit >= 0 && len(matched) > q.Filter.Limit {
+		matched = matched[:q.Filter.Limit]
+	}
+	result.Records = matched
+	return result, nil
+}
+
+func (s *memoryStore) ErrorFingerprints(ctx context.Context, f LogFilter, span time.Duration) ([]ErrorFingerprintRow, error) {
+	byFingerprint := make(map[string]*ErrorFingerprintRow)
+	services := make(map[string]map[string]bool)
+	for _, r := range s.filtered(LogFilter{Service: f.Service, Services: f.Services, AgentID: f.AgentID, Level: "ERROR", From: s.now().Add(-span)}) {
+		fp := fingerprint(r.Message)
+		row, ok := byFingerprint[fp]
+		if !ok {
+			row = &ErrorFingerprintRow{Fingerprint: fp, FirstSeen: r.Timestamp, LastSeen: r.Timestamp}
+			byFingerprint[fp] = row
+			services[fp] = make(map[string]bool)
+		}
+		row.Count++
+		// filtered is newest first, so the last match is the earliest
+		if !r.Timestamp.After(row.FirstSeen) {
+			row.FirstSeen = r.Timestamp
+			row.Example = r.Message
+		}
+		if r.Timestamp.After(row.LastSeen) {
+			row.LastSeen = r.Timestamp
+		}
+		services[fp][r.Service] = true
+	}
+
+	out := make([]ErrorFingerprintRow, 0, len(byFingerprint))
+	for fp, row := range byFingerprint {
+		for service := range services[fp] {
+			row.Services = append(row.Services, service)
+		}
+		sort.Strings(row.Services)
+		out = append(out, *row)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Count != out[j].Count {
+			return out[i].Count > out[j].Count
+		}
+		return out[i].Fingerprint < out[j].Fingerprint
+	})
+	if f.Limit > 0 && len(out) > f.Limit {
+		out = out[:f.Limit]
+	}
+	return out, nil
+}
+
+func (s *memoryStore) LatencyPercentiles(ctx context.Context, f LogFilter, field string, quantiles []float64, w TimeWindow) ([]LatencyPoint, error) {
+	buckets := make(map[time.Time][]float64)
+	for _, r := range s.filtered(LogFilter{Service: f.Service, Services: f.Services, AgentID: f.AgentID, From: s.now().Add(-w.Span), Timeline: f.Timeline}) {
+		raw, ok := r.Fields[field]
+		if !ok || raw == "" {
+			continue
+		}
+		v, _ := strconv.ParseFloat(raw, 64)
+		t := f.Timeline.of(r).Truncate(w.Bucket)
+		buckets[t] = append(buckets[t], v)
+	}
+
+	points := make([]LatencyPoint, 0, len(buckets))
+	for t, values := range buckets {
+		sort.Float64s(values)
+		p := LatencyPoint{Time: t, Count: uint64(len(values))}
+		for _, q := range quantiles {
+			p.Quantiles = append(p.Quantiles, percentile(values, q))
+		}
+		points = append(points, p)
+	}
+	sort.Slice(points, func(i, j int) bool { return points[i].Time.Before(points[j].Time) })
+	return points, nil
+}
+
+// percentile returns the nearest-rank percentile of sorted values
+func (s *memoryStore) AgentStatuses(ctx context.Context) ([]AgentStatusRow, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	out := make([]AgentStatusRow, 0, len(s.agents))
+	for _, row := range s.agents {
+		out = append(out, row)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].AgentID < out[j].AgentID })
+	return out, nil
+}
+
+func (s *memoryStore) ForgetAgent(ctx context.Context, agentID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.agents, agentID)
+	return nil
+}
+
+func percentile(sorted []float64, q float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := int(math.Ceil(q*float64(len(sorted)))) - 1
+	if rank < 0 {
+		rank = 0
+	}
+	return sorted[rank]
+}
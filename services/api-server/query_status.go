@@ -0,0 +1,17 @@
+package main
+
+// Read endpoints report a status alongside their data, so callers can tell
+// an empty result from a failed query without inspecting the payload
+const (
+	statusOK    = "ok"    // the query matched data
+	statusEmpty = "empty" // the query ran and matched nothing
+	statusError = "error" // the query failed; see "error" (errors.go)
+)
+
+// resultStatus is ok or empty depending on how much a query matched
+func resultStatus(count int) string {
+	if count == 0 {
+		return statusEmpty
+	}
+	return statusOK
+}
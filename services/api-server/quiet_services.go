@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultQuietAfter    = 10 * time.Minute
+	defaultQuietLookback = 24 * time.Hour
+	quietCheckInterval   = time.Minute
+	alertWebhookTimeout  = 10 * time.Second
+)
+
+// quietService is a service that logged within the lookback but not within
+// the quiet interval, which usually means it crashed or lost its agent
+type quietService struct {
+	Service  string    `json:"service"`
+	LastSeen time.Time `json:"last_seen"`
+}
+
+// quietDetector watches the per-service last-seen times behind service-health
+// and alerts once when a recently active service goes silent, and once more
+// when it logs again. Alerts are logged and, with ALERT_WEBHOOK_URL, POSTed
+// as Slack-compatible JSON.
+type quietDetector struct {
+	store    LogStore
+	after    time.Duration // silence that makes a service quiet (QUIET_SERVICE_AFTER)
+	lookback time.Duration // how recently a service must have logged to be watched (QUIET_SERVICE_LOOKBACK)
+	webhook  string        // empty only logs alerts
+	client   *http.Client
+
+	mu    sync.Mutex
+	quiet map[string]time.Time // quiet service -> last seen
+
+	alertsSent   atomic.Uint64
+	alertsFailed atomic.Uint64
+}
+
+func newQuietDetector(store LogStore, after, lookback time.Duration, webhook string) *quietDetector {
+	return &quietDetector{
+		store:    store,
+		after:    after,
+		lookback: lookback,
+		webhook:  webhook,
+		client:   &http.Client{Timeout: alertWebhookTimeout},
+		quiet:    make(map[string]time.Time),
+	}
+}
+
+// run checks every quietCheckInterval; call it in its own goroutine
+func (d *quietDetector) run() {
+	ticker := time.NewTicker(quietCheckInterval)
+	defer ticker.Stop()
+	for {
+		if err := d.check(context.Background(), time.Now()); err != nil {
+			log.Printf("⚠️  Quiet service check failed: %v", err)
+		}
+		<-ticker.C
+	}
+}
+
+// check compares each service's last log with now and alerts on transitions
+func (d *quietDetector) check(ctx context.Context, now time.Time) error {
+	rows, err := d.store.ServiceHealth(ctx, d.lookback)
+	if err != nil {
+		return err
+	}
+
+	var wentQuiet, recovered []quietService
+	d.mu.Lock()
+	watched := make(map[string]bool, len(rows))
+	for _, row := range rows {
+		watched[row.Service] = true
+		_, wasQuiet := d.quiet[row.Service]
+		isQuiet := now.Sub(row.LastSeen) > d.after
+		switch {
+		case isQuiet && !wasQuiet:
+			d.quiet[row.Service] = row.LastSeen
+			wentQuiet = append(wentQuiet, quietService{row.Service, row.LastSeen})
+		case !isQuiet && wasQuiet:
+			delete(d.quiet, row.Service)
+			recovered = append(recovered, quietService{row.Service, row.LastSeen})
+		}
+	}
+	// Silent for longer than the lookback: no longer "recently active", stop tracking
+	for service := range d.quiet {
+		if !watched[service] {
+			delete(d.quiet, service)
+		}
+	}
+	d.mu.Unlock()
+
+	for _, s := range wentQuiet {
+		silent := now.Sub(s.LastSeen).Round(time.Second)
+		d.alert("quiet_service", s, fmt.Sprintf("🔇 %s has not logged for %s (last log %s)", s.Service, silent, s.LastSeen.UTC().Format(time.RFC3339)))
+	}
+	for _, s := range recovered {
+		d.alert("quiet_service_resolved", s, fmt.Sprintf("🔊 %s is logging again", s.Service))
+	}
+	return nil
+}
+
+// alert logs text and, when a webhook is configured, posts it with the details
+func (d *quietDetector) alert(kind string, s quietService, text string) {
+	log.Print(text)
+	if d.webhook == "" {
+		return
+	}
+	body, _ := json.Marshal(map[string]interface{}{
+		"text":      text,
+		"alert":     kind,
+		"service":   s.Service,
+		"last_seen": s.LastSeen.UTC().Format(time.RFC3339),
+	})
+	resp, err := d.client.Post(d.webhook, "application/json", bytes.NewReader(body))
+	if err == nil {
+		resp.Body.Close()
+		if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+			err = fmt.Errorf("webhook returned %s", resp.Status)
+		}
+	}
+	if err != nil {
+		log.Printf("⚠️  Failed to send %s alert for %s: %v", kind, s.Service, err)
+		d.alertsFailed.Add(1)
+		return
+	}
+	d.alertsSent.Add(1)
+}
+
+// current lists the services that are quiet right now, longest silent first
+func (d *quietDetector) current() []quietService {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	out := make([]quietService, 0, len(d.quiet))
+	for service, lastSeen := range d.quiet {
+		out = append(out, quietService{service, lastSeen})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].LastSeen.Before(out[j].LastSeen) })
+	return out
+}
+
+// GET /api/v1/alerts/quiet-services
+// Services the detector currently considers quiet, as of its last check
+func (api *APIServer) quietServices(c *gin.Context) {
+	if api.quiet == nil {
+		c.JSON(http.StatusOK, gin.H{"enabled": false, "services": []quietService{}, "count": 0, "status": statusEmpty})
+		return
+	}
+	services := api.quiet.current()
+	c.JSON(http.StatusOK, gin.H{
+		"enabled":  true,
+		"after":    api.quiet.after.String(),
+		"lookback": api.quiet.lookback.String(),
+		"services": services,
+		"count":    len(services),
+		"status":   resultStatus(len(services)),
+	})
+}
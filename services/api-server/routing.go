@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+var identifierRegex = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// routeRule mirrors the ingestion-service ROUTE_RULES: entries whose field
+// equals value were written to table instead of the default logs table.
+type routeRule struct {
+	field string
+	value string
+	table string
+}
+
+// parseRouteRules parses ROUTE_RULES, e.g. "level=ERROR:logs_errors,service=nginx:logs_access".
+// It must be given the same value as the ingestion-service so reads find every row.
+func parseRouteRules(raw string) ([]routeRule, error) {
+	var rules []routeRule
+	for _, spec := range strings.Split(raw, ",") {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+		match, table, ok := strings.Cut(spec, ":")
+		if !ok {
+			return nil, fmt.Errorf("route %q: want field=value:table", spec)
+		}
+		field, value, ok := strings.Cut(match, "=")
+		if !ok || field == "" {
+			return nil, fmt.Errorf("route %q: want field=value:table", spec)
+		}
+		if !identifierRegex.MatchString(table) {
+			return nil, fmt.Errorf("route %q: invalid table name %q", spec, table)
+		}
+		rules = append(rules, routeRule{field: field, value: value, table: table})
+	}
+	return rules, nil
+}
+
+// pinnedValue returns the value a filter fixes for a routable field, if any
+func (f LogFilter) pinnedValue(field string) (string, bool) {
+	switch field {
+	case "level":
+		return f.Level, f.Level != ""
+	case "service":
+		if services := f.services(); len(services) == 1 {
+			return services[0], true
+		}
+	}
+	return "", false
+}
+
+// tablesFor returns every table that may hold logs matching the filter.
+// Rules are walked in ingestion order: a rule whose field the filter pins to a
+// different value can't hold matches, and one pinned to the same value catches
+// every match, so later rules and the default table can be skipped.
+func tablesFor(routes []routeRule, defaultTable string, f LogFilter) []string {
+	var tables []string
+	seen := make(map[string]bool)
+	add := func(table string) {
+		if !seen[table] {
+			seen[table] = true
+			tables = append(tables, table)
+		}
+	}
+
+	for _, rule := range routes {
+		if rule.field == "service" && len(f.Services) > 0 && !slices.Contains(f.Services, rule.value) {
+			continue // no member of the service group is routed here
+		}
+		if rule.field == "level" && f.Level == "" && len(f.Levels) > 0 && !slices.Contains(f.Levels, rule.value) {
+			continue // below min_level
+		}
+		value, pinned := f.pinnedValue(rule.field)
+		if pinned && value != rule.value {
+			continue
+		}
+		add(rule.table)
+		if pinned {
+			return tables
+		}
+	}
+	add(defaultTable)
+	return tables
+}
@@ -0,0 +1,291 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const defaultSearchLimit = 100
+
+// Sort orders for POST /api/v1/search
+const (
+	sortNewest    = "newest" // default
+	sortOldest    = "oldest"
+	sortRelevance = "relevance" // most text term occurrences first, then newest; needs text
+)
+
+// Field predicate operators, applied to the metadata map
+const (
+	opEq       = "eq"
+	opNe       = "ne"       // also matches logs without the field
+	opContains = "contains" // case-insensitive substring
+	opExists   = "exists"
+)
+
+// FieldPredicate is one condition on a structured field
+type FieldPredicate struct {
+	Field string `json:"field"`
+	Op    string `json:"op"` // default eq
+	Value string `json:"value"`
+}
+
+// SearchQuery is a structured search: the filter's services, time range and
+// timeline, plus several levels, text terms and field predicates, all ANDed
+type SearchQuery struct {
+	Filter LogFilter // Filter.Level is set when exactly one level is searched
+	Levels []string
+	Terms  []string // each must appear in the message, case-insensitive
+	Fields []FieldPredicate
+	Sort   string
+	Offset int
+	// AsOf pins a search to the logs ingested by its first page, so logs
+	// arriving while a client pages through don't shift later pages
+	AsOf time.Time
+}
+
+// SearchResult is one page of matches plus facets over every match
+type SearchResult struct {
+	Records   []LogRecord
+	Total     uint64
+	ByService map[string]uint64
+	ByLevel   map[string]uint64
+}
+
+// matches applies everything but the filter, which the stores handle
+func (q SearchQuery) matches(r LogRecord) bool {
+	if len(q.Levels) > 1 && !slices.Contains(q.Levels, r.Level) {
+		return false
+	}
+	if !q.AsOf.IsZero() && r.IngestedAt.After(q.AsOf) {
+		return false
+	}
+	if len(q.Terms) > 0 && q.relevance(r) == 0 {
+		return false
+	}
+	for _, p := range q.Fields {
+		value, ok := r.Fields[p.Field]
+		switch p.Op {
+		case opEq:
+			if !ok || value != p.Value {
+				return false
+			}
+		case opNe:
+			if ok && value == p.Value {
+				return false
+			}
+		case opContains:
+			if !strings.Contains(strings.ToLower(value), strings.ToLower(p.Value)) {
+				return false
+			}
+		case opExists:
+			if !ok {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// relevance counts occurrences of the terms in the message, 0 unless every term appears
+func (q SearchQuery) relevance(r LogRecord) int {
+	message := strings.ToLower(r.Message)
+	total := 0
+	for _, term := range q.Terms {
+		n := strings.Count(message, strings.ToLower(term))
+		if n == 0 {
+			return 0
+		}
+		total += n
+	}
+	return total
+}
+
+// searchCursor is where the next page starts; clients pass it back opaque
+type searchCursor struct {
+	Offset int   `json:"o"`
+	AsOf   int64 `json:"t"` // unix nanoseconds
+}
+
+func encodeCursor(offset int, asOf time.Time) string {
+	raw, _ := json.Marshal(searchCursor{Offset: offset, AsOf: asOf.UnixNano()})
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+func decodeCursor(cursor string) (searchCursor, error) {
+	var sc searchCursor
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err == nil {
+		err = json.Unmarshal(raw, &sc)
+	}
+	if err != nil || sc.Offset < 0 || sc.AsOf <= 0 {
+		return sc, fmt.Errorf("invalid cursor")
+	}
+	return sc, nil
+}
+
+// searchRequest is the body of POST /api/v1/search
+type searchRequest struct {
+	Services []string         `json:"services"` // names or service groups
+	Levels   []string         `json:"levels"`
+	AgentID  string           `json:"agent_id"`
+	From     string           `json:"from"`  // RFC3339, inclusive
+	To       string           `json:"to"`    // RFC3339, exclusive
+	Range    string           `json:"range"` // 15m, 1h, 6h, 24h or all; ignored when from is set
+	Timeline string           `json:"timeline"`
+	Text     string           `json:"text"` // whitespace-separated terms, all required
+	Fields   []FieldPredicate `json:"fields"`
+	Sort     string           `json:"sort"`
+	Limit    int              `json:"limit"`
+	Cursor   string           `json:"cursor"` // next_cursor of the previous page
+}
+
+// toQuery validates the request and turns it into a SearchQuery; now anchors range
+func (api *APIServer) toQuery(req searchRequest, now time.Time) (SearchQuery, error) {
+	var q SearchQuery
+	timeline, err := parseTimeline(req.Timeline)
+	if err != nil {
+		return q, err
+	}
+	q.Filter.Timeline = timeline
+
+	for _, name := range req.Services {
+		if members, ok := api.groups[name]; ok {
+			q.Filter.Services = append(q.Filter.Services, members...)
+		} else if name != "" {
+			q.Filter.Services = append(q.Filter.Services, name)
+		}
+	}
+	for _, level := range req.Levels {
+		if level = strings.ToUpper(strings.TrimSpace(level)); level != "" && !slices.Contains(q.Levels, level) {
+			q.Levels = append(q.Levels, level)
+		}
+	}
+	if len(q.Levels) == 1 {
+		q.Filter.Level = q.Levels[0]
+	}
+	q.Filter.AgentID = req.AgentID
+
+	if req.From != "" {
+		if q.Filter.From, err = time.Parse(time.RFC3339, req.From); err != nil {
+			return q, fmt.Errorf("invalid from (want RFC3339): %v", err)
+		}
+	} else if req.Range != "" {
+		q.Filter.From = now.Add(-resolveRange(req.Range).Span)
+	}
+	if req.To != "" {
+		if q.Filter.To, err = time.Parse(time.RFC3339, req.To); err != nil {
+			return q, fmt.Errorf("invalid to (want RFC3339): %v", err)
+		}
+	}
+
+	q.Terms = strings.Fields(req.Text)
+	for i, p := range req.Fields {
+		if p.Field == "" {
+			return q, fmt.Errorf("fields[%d]: field is required", i)
+		}
+		if p.Op == "" {
+			p.Op = opEq
+		}
+		switch p.Op {
+		case opEq, opNe, opContains, opExists:
+		default:
+			return q, fmt.Errorf("fields[%d]: unknown op %q (want %s, %s, %s or %s)", i, p.Op, opEq, opNe, opContains, opExists)
+		}
+		q.Fields = append(q.Fields, p)
+	}
+
+	switch req.Sort {
+	case "":
+		q.Sort = sortNewest
+	case sortNewest, sortOldest:
+		q.Sort = req.Sort
+	case sortRelevance:
+		if len(q.Terms) == 0 {
+			return q, fmt.Errorf("sort %s needs text", sortRelevance)
+		}
+		q.Sort = req.Sort
+	default:
+		return q, fmt.Errorf("unknown sort %q (want %s, %s or %s)", req.Sort, sortNewest, sortOldest, sortRelevance)
+	}
+
+	q.AsOf = now
+	if req.Cursor != "" {
+		cursor, err := decodeCursor(req.Cursor)
+		if err != nil {
+			return q, err
+		}
+		q.Offset, q.AsOf = cursor.Offset, time.Unix(0, cursor.AsOf)
+	}
+	return q, nil
+}
+
+// POST /api/v1/search
+// One structured query instead of a URL per capability: services (or groups),
+// levels, time range, text terms and field predicates are combined into a
+// single WHERE clause. Returns a page of logs, a cursor for the next one, and
+// facets (counts by service and level) over every match.
+func (api *APIServer) search(c *gin.Context) {
+	var req searchRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		abortWithError(c, invalidRequest("request body must be a JSON search query"))
+		return
+	}
+	if req.Limit < 0 {
+		abortWithError(c, invalidParam("limit", "limit must not be negative"))
+		return
+	}
+	limit := req.Limit
+	if limit == 0 {
+		limit = defaultSearchLimit
+	}
+	limit, _ = api.clampLimit(c, limit)
+
+	q, err := api.toQuery(req, time.Now())
+	if err != nil {
+		abortWithError(c, invalidRequest(err.Error()))
+		return
+	}
+	// One extra row tells whether there is a next page
+	q.Filter.Limit = limit + 1
+
+	result, err := api.store.Search(context.Background(), q)
+	if err != nil {
+		abortWithError(c, queryError(err))
+		return
+	}
+
+	records := result.Records
+	nextCursor := ""
+	if len(records) > limit {
+		records = records[:limit]
+		nextCursor = encodeCursor(q.Offset+limit, q.AsOf)
+	}
+	logs := make([]map[string]interface{}, 0, len(records))
+	for _, record := range records {
+		logs = append(logs, record.toMap())
+	}
+
+	response := gin.H{
+		"logs":   logs,
+		"count":  len(logs),
+		"total":  result.Total,
+		"facets": gin.H{"service": result.ByService, "level": result.ByLevel},
+		"sort":   q.Sort,
+		"status": resultStatus(len(logs)),
+	}
+	if nextCursor != "" {
+		response["next_cursor"] = nextCursor
+	}
+	if q.Filter.Services != nil {
+		response["services"] = q.Filter.Services
+	}
+	c.JSON(http.StatusOK, response)
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// serviceGroups maps a logical group name to its member services, so that
+// service=frontend queries nginx and app together
+type serviceGroups map[string][]string
+
+// parseServiceGroups parses SERVICE_GROUPS, e.g. "frontend=nginx|app,payments=payment-service|billing".
+// A group shadows any real service of the same name.
+func parseServiceGroups(raw string) (serviceGroups, error) {
+	groups := make(serviceGroups)
+	for _, spec := range strings.Split(raw, ",") {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+		name, list, ok := strings.Cut(spec, "=")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" {
+			return nil, fmt.Errorf("group %q: want name=service|service", spec)
+		}
+		var members []string
+		for _, member := range strings.Split(list, "|") {
+			if member = strings.TrimSpace(member); member != "" {
+				members = append(members, member)
+			}
+		}
+		if len(members) == 0 {
+			return nil, fmt.Errorf("group %q has no services", name)
+		}
+		if _, dup := groups[name]; dup {
+			return nil, fmt.Errorf("group %q defined twice", name)
+		}
+		groups[name] = members
+	}
+	return groups, nil
+}
+
+// expandService resolves a group named in the filter's Service into its
+// members and returns them (nil when Service is not a group), so handlers
+// can echo the expanded set back to the caller
+func (api *APIServer) expandService(f *LogFilter) []string {
+	members, ok := api.groups[f.Service]
+	if !ok {
+		return nil
+	}
+	f.Services = members
+	return members
+}
+
+// GET /api/v1/services/groups
+// The configured service groups, for clients (UI, MCP server) offering them as filters
+func (api *APIServer) listServiceGroups(c *gin.Context) {
+	groups := api.groups
+	if groups == nil {
+		groups = serviceGroups{}
+	}
+	c.JSON(http.StatusOK, gin.H{"groups": groups, "count": len(groups)})
+}
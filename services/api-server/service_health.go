@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Error-ratio thresholds for the service health classification
+const (
+	degradedErrorRatio = 0.05 // >= 5% errors
+	downErrorRatio     = 0.50 // >= 50% errors
+)
+
+// classifyHealth maps a service's error ratio to healthy/degraded/down
+func classifyHealth(errors, total uint64) (string, float64) {
+	if total == 0 {
+		return "healthy", 0
+	}
+	ratio := float64(errors) / float64(total)
+	switch {
+	case ratio >= downErrorRatio:
+		return "down", ratio
+	case ratio >= degradedErrorRatio:
+		return "degraded", ratio
+	default:
+		return "healthy", ratio
+	}
+}
+
+// GET /api/v1/metrics/service-health?range=1h
+// Per-service error/warn/total counts and last-seen time from a single grouped query
+func (api *APIServer) serviceHealth(c *gin.Context) {
+	rangeStr := c.Query("range")
+	if rangeStr == "" {
+		rangeStr = "1h"
+	}
+	window := resolveRange(rangeStr)
+
+	rows, err := api.store.ServiceHealth(context.Background(), window.Span)
+	if err != nil {
+		abortWithError(c, queryError(err))
+		return
+	}
+
+	services := make([]map[string]interface{}, 0, len(rows))
+	for _, row := range rows {
+		status, ratio := classifyHealth(row.Errors, row.Total)
+		services = append(services, map[string]interface{}{
+			"service":     row.Service,
+			"errors":      row.Errors,
+			"warnings":    row.Warnings,
+			"total":       row.Total,
+			"error_ratio": ratio,
+			"last_seen":   row.LastSeen.Format(time.RFC3339),
+			"status":      status,
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{"range": rangeStr, "services": services, "count": len(services), "status": resultStatus(len(services))})
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// serviceInfo is the organizational metadata of one service
+type serviceInfo struct {
+	Team        string `json:"team,omitempty"`
+	Owner       string `json:"owner,omitempty"`
+	Criticality string `json:"criticality"` // critical, high, medium (default) or low
+	Runbook     string `json:"runbook,omitempty"`
+}
+
+// serviceRegistry maps a service name to its metadata, so analysis and alerts
+// can weigh a service by how much it matters to the team
+type serviceRegistry map[string]serviceInfo
+
+// defaultCriticality is given to registered services that don't set one
+const defaultCriticality = "medium"
+
+var criticalities = map[string]bool{"critical": true, "high": true, "medium": true, "low": true}
+
+// loadServiceRegistry reads SERVICE_REGISTRY_FILE, a JSON object of service
+// name -> {team, owner, criticality, runbook}; an empty path is an empty registry
+func loadServiceRegistry(path string) (serviceRegistry, error) {
+	registry := make(serviceRegistry)
+	if path == "" {
+		return registry, nil
+	}
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(raw, &registry); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	for name, info := range registry {
+		if strings.TrimSpace(name) == "" {
+			return nil, fmt.Errorf("%s: empty service name", path)
+		}
+		info.Criticality = strings.ToLower(strings.TrimSpace(info.Criticality))
+		if info.Criticality == "" {
+			info.Criticality = defaultCriticality
+		}
+		if !criticalities[info.Criticality] {
+			return nil, fmt.Errorf("service %q: unknown criticality %q (want critical, high, medium or low)", name, info.Criticality)
+		}
+		registry[name] = info
+	}
+	return registry, nil
+}
+
+// GET /api/v1/services?criticality=critical
+// The service registry, for clients (UI, MCP server) prioritizing by service
+func (api *APIServer) listServices(c *gin.Context) {
+	services := api.registry
+	if want := strings.ToLower(c.Query("criticality")); want != "" {
+		if !criticalities[want] {
+			abortWithError(c, invalidRequest("criticality must be critical, high, medium or low"))
+			return
+		}
+		services = make(serviceRegistry)
+		for name, info := range api.registry {
+			if info.Criticality == want {
+				services[name] = info
+			}
+		}
+	}
+	if services == nil {
+		services = serviceRegistry{}
+	}
+	c.JSON(http.StatusOK, gin.H{"services": services, "count": len(services)})
+}
@@ -0,0 +1,304 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// LogStore is the storage backend behind the API handlers.
+// Handlers only talk to this interface so they can run against ClickHouse
+// in production and against the in-memory store in tests.
+type LogStore interface {
+	// QueryLogs returns logs matching the filter, newest first
+	QueryLogs(ctx context.Context, filter LogFilter) ([]LogRecord, error)
+	// Stats returns total and per-level counts across all logs
+	Stats(ctx context.Context) (LogStats, error)
+	// ErrorRate returns ERROR counts bucketed over the given window.
+	// Only the filter's service and timeline are used.
+	ErrorRate(ctx context.Context, filter LogFilter, window TimeWindow) ([]RatePoint, error)
+	// LevelCounts returns log counts per bucket, service and level over the
+	// window, from the start of its first bucket. Only the filter's service,
+	// agent and timeline are used.
+	LevelCounts(ctx context.Context, filter LogFilter, window TimeWindow) ([]LevelCountRow, error)
+	// StreamSince returns logs newer than since on the given timeline, oldest first (live tail cursor)
+	StreamSince(ctx context.Context, timeline Timeline, since time.Time, limit int) ([]LogRecord, error)
+	// ErrorsByService returns ERROR counts per service over the last span
+	ErrorsByService(ctx context.Context, span time.Duration) ([]ServiceCount, error)
+	// CountLogs returns how many logs match the filter (limit is ignored)
+	CountLogs(ctx context.Context, filter LogFilter) (uint64, error)
+	// DeleteLogs removes all logs matching the filter
+	DeleteLogs(ctx context.Context, filter LogFilter) error
+	// ServiceHealth returns per-service counts and last-seen time over the last span
+	ServiceHealth(ctx context.Context, span time.Duration) ([]ServiceHealthRow, error)
+	// Freshness returns the newest log of every service and agent pair over
+	// the last span, and the store's current time to measure lag against
+	Freshness(ctx context.Context, span time.Duration) ([]FreshnessRow, time.Time, error)
+	// LatencyPercentiles returns the quantiles (0-1) of a numeric metadata field (seconds)
+	// per bucket. Only the filter's service and timeline are used.
+	LatencyPercentiles(ctx context.Context, filter LogFilter, field string, quantiles []float64, window TimeWindow) ([]LatencyPoint, error)
+	// AgentStatuses returns the latest heartbeat of every known agent
+	AgentStatuses(ctx context.Context) ([]AgentStatusRow, error)
+	// ForgetAgent drops an agent from the registry until it reports again
+	ForgetAgent(ctx context.Context, agentID string) error
+	// ErrorFingerprints groups ERROR logs over the last span by message
+	// fingerprint, most frequent first. Only the filter's service and limit are used.
+	ErrorFingerprints(ctx context.Context, filter LogFilter, span time.Duration) ([]ErrorFingerprintRow, error)
+	// Search returns one page of a structured search (filter.Limit rows from
+	// query.Offset, in query.Sort order) and facets over all its matches
+	Search(ctx context.Context, query SearchQuery) (SearchResult, error)
+}
+
+// Timeline selects which timestamp a query filters, orders and buckets on
+type Timeline string
+
+const (
+	// EventTime is when the log line was written (the timestamp column)
+	EventTime Timeline = "event"
+	// IngestTime is when the ingestion-service stored it (the ingested_at column).
+	// Backfilled or late-arriving logs show up here even with old event timestamps.
+	IngestTime Timeline = "ingest"
+)
+
+// parseTimeline reads the timeline query param; empty means event time
+func parseTimeline(s string) (Timeline, error) {
+	switch Timeline(s) {
+	case "", EventTime:
+		return EventTime, nil
+	case IngestTime:
+		return IngestTime, nil
+	}
+	return "", fmt.Errorf("invalid timeline %q (want %s or %s)", s, EventTime, IngestTime)
+}
+
+// column returns the ClickHouse column backing the timeline
+func (t Timeline) column() string {
+	if t == IngestTime {
+		return "ingested_at"
+	}
+	return "timestamp"
+}
+
+// of returns the record's time on this timeline
+func (t Timeline) of(r LogRecord) time.Time {
+	if t == IngestTime {
+		return r.IngestedAt
+	}
+	return r.Timestamp
+}
+
+// LogFilter narrows a log query. Zero values mean "no filter".
+type LogFilter struct {
+	Service string
+	// Services, when set, replaces Service with "any of these" (an expanded service group)
+	Services []string
+	Level    string
+	// Levels, when set, accepts any of these instead (min_level); Level wins if both are set
+	Levels   []string
+	AgentID  string
+	From     time.Time // inclusive
+	To       time.Time // exclusive
+	Timeline Timeline  // which timestamp From/To and ordering apply to; empty means event time
+	TraceID  string
+	Limit    int
+}
+
+// logLevels are the levels agents ship, least severe first
+var logLevels = []string{"DEBUG", "INFO", "WARN", "ERROR"}
+
+// levelsFrom returns min and every more severe level, for min_level
+func levelsFrom(min string) ([]string, error) {
+	i := slices.Index(logLevels, strings.ToUpper(min))
+	if i < 0 {
+		return nil, fmt.Errorf("min_level must be one of %s", strings.Join(logLevels, ", "))
+	}
+	return logLevels[i:], nil
+}
+
+// services returns the service names the filter accepts; nil means any
+func (f LogFilter) services() []string {
+	if len(f.Services) > 0 {
+		return f.Services
+	}
+	if f.Service != "" {
+		return []string{f.Service}
+	}
+	return nil
+}
+
+// isEmpty reports whether the filter would match every log
+func (f LogFilter) isEmpty() bool {
+	return f.services() == nil && f.Level == "" && len(f.Levels) == 0 && f.AgentID == "" && f.TraceID == "" && f.From.IsZero() && f.To.IsZero()
+}
+
+// matches applies the filter to a single record (used by the in-memory store)
+func (f LogFilter) matches(r LogRecord) bool {
+	if services := f.services(); services != nil && !slices.Contains(services, r.Service) {
+		return false
+	}
+	if f.Level != "" && r.Level != f.Level {
+		return false
+	}
+	if f.Level == "" && len(f.Levels) > 0 && !slices.Contains(f.Levels, r.Level) {
+		return false
+	}
+	if f.AgentID != "" && r.AgentID != f.AgentID {
+		return false
+	}
+	if f.TraceID != "" && r.TraceID != f.TraceID {
+		return false
+	}
+	t := f.Timeline.of(r)
+	if !f.From.IsZero() && t.Before(f.From) {
+		return false
+	}
+	if !f.To.IsZero() && !t.Before(f.To) {
+		return false
+	}
+	return true
+}
+
+// LogRecord is a single stored log line
+type LogRecord struct {
+	Timestamp  time.Time
+	Level      string
+	Service    string
+	Message    string
+	TraceID    string
+	AgentID    string
+	IngestedAt time.Time
+	Fields     map[string]string // structured fields from the metadata column
+}
+
+// toMap renders the record in the JSON shape the API returns
+func (r LogRecord) toMap() map[string]interface{} {
+	fields := r.Fields
+	if fields == nil {
+		fields = map[string]string{}
+	}
+	return map[string]interface{}{
+		"timestamp":   r.Timestamp.Format(time.RFC3339),
+		"level":       r.Level,
+		"service":     r.Service,
+		"message":     r.Message,
+		"trace_id":    r.TraceID,
+		"agent_id":    r.AgentID,
+		"ingested_at": r.IngestedAt.Format(time.RFC3339),
+		"occurrences": r.occurrences(),
+		"fields":      fields,
+	}
+}
+
+// occurrences is how many logs the row stands for: the ingestion service's
+// DEDUP_MODE=count (and collapse) stores the count of a deduplicated burst in
+// the occurrences field, and every other row is one log
+func (r LogRecord) occurrences() uint64 {
+	if n, err := strconv.ParseUint(r.Fields["occurrences"], 10, 64); err == nil && n > 0 {
+		return n
+	}
+	return 1
+}
+
+// LogStats holds the aggregate counts served by /logs/stats
+type LogStats struct {
+	Total    uint64
+	Errors   uint64
+	Warnings uint64
+	Info     uint64
+}
+
+// RatePoint is one bucket of a time series
+type RatePoint struct {
+	Time  time.Time
+	Count uint64
+}
+
+// LevelCountRow is the count of one level of one service in one bucket, behind /metrics/counts/stream
+type LevelCountRow struct {
+	Time    time.Time
+	Service string
+	Level   string
+	Count   uint64
+}
+
+// LatencyPoint is one bucket of a latency percentile series, in seconds
+type LatencyPoint struct {
+	Time      time.Time
+	Quantiles []float64 // in the order requested
+	Count     uint64
+}
+
+// ServiceCount is a per-service count
+type ServiceCount struct {
+	Service string
+	Count   uint64
+}
+
+// ServiceHealthRow is the per-service aggregate behind /metrics/service-health
+type ServiceHealthRow struct {
+	Service  string
+	Errors   uint64
+	Warnings uint64
+	Total    uint64
+	LastSeen time.Time
+}
+
+// ErrorFingerprintRow is one distinct error type behind /errors/inbox
+type ErrorFingerprintRow struct {
+	Fingerprint string
+	Example     string // the earliest message with this fingerprint
+	Count       uint64
+	FirstSeen   time.Time
+	LastSeen    time.Time
+	Services    []string
+}
+
+// FreshnessRow is the newest log one agent shipped for one service, behind /metrics/ingestion-lag
+type FreshnessRow struct {
+	Service      string
+	AgentID      string
+	LastEvent    time.Time // max(timestamp): when the newest log was written
+	LastIngested time.Time // max(ingested_at): when the newest log was stored
+	Logs         uint64
+}
+
+// AgentStatusRow is one agent's latest heartbeat, as recorded by the ingestion-service
+type AgentStatusRow struct {
+	AgentID  string
+	Hostname string
+	Version  string
+	LastSeen time.Time
+}
+
+// TimeWindow describes how far back a metrics query looks and how it buckets.
+// The window covers [now-Offset-Span, now-Offset); Offset is zero for "the last Span".
+type TimeWindow struct {
+	Span   time.Duration
+	Bucket time.Duration
+	Offset time.Duration
+}
+
+// previous returns the window of the same length immediately before w
+func (w TimeWindow) previous() TimeWindow {
+	w.Offset += w.Span
+	return w
+}
+
+// resolveRange maps the range query param (15m, 1h, 6h, 24h, all) to a window
+func resolveRange(rangeStr string) TimeWindow {
+	switch rangeStr {
+	case "15m":
+		return TimeWindow{Span: 15 * time.Minute, Bucket: time.Minute}
+	case "6h":
+		return TimeWindow{Span: 6 * time.Hour, Bucket: 5 * time.Minute}
+	case "24h":
+		return TimeWindow{Span: 24 * time.Hour, Bucket: 15 * time.Minute}
+	case "all":
+		return TimeWindow{Span: 30 * 24 * time.Hour, Bucket: time.Hour}
+	default: // 1h
+		return TimeWindow{Span: time.Hour, Bucket: time.Minute}
+	}
+}
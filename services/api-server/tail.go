@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultTailN      = 50
+	defaultTailWindow = time.Hour
+)
+
+// GET /api/v1/logs/tail?n=50&service=X&level=ERROR&window=1h (or min_level=WARN for WARN and ERROR)
+// The newest n logs within the window, newest first. No pagination and never
+// cached: every call reads the store, so it is the "latest logs right now"
+// contract for scripts and the MCP server.
+func (api *APIServer) tail(c *gin.Context) {
+	n := defaultTailN
+	if v := c.Query("n"); v != "" {
+		parsed, err := strconv.Atoi(v)
+		if err != nil || parsed <= 0 {
+			abortWithError(c, invalidParam("n", "n must be a positive integer"))
+			return
+		}
+		n = parsed
+	}
+	n, clamped := api.clampLimit(c, n)
+
+	window := defaultTailWindow
+	if v := c.Query("window"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			abortWithError(c, invalidParam("window", "window must be a positive duration such as 15m or 6h"))
+			return
+		}
+		window = d
+	}
+
+	timeline, err := parseTimeline(c.Query("timeline"))
+	if err != nil {
+		abortWithError(c, invalidParam("timeline", err.Error()))
+		return
+	}
+
+	levels, err := minLevels(c)
+	if err != nil {
+		abortWithError(c, invalidParam("min_level", err.Error()))
+		return
+	}
+
+	filter := LogFilter{
+		Service:  c.Query("service"),
+		Level:    c.Query("level"),
+		Levels:   levels,
+		AgentID:  c.Query("agent_id"),
+		From:     time.Now().Add(-window),
+		Timeline: timeline,
+		Limit:    n,
+	}
+	members := api.expandService(&filter)
+
+	records, err := api.store.QueryLogs(context.Background(), filter)
+	if err != nil {
+		abortWithError(c, queryError(err))
+		return
+	}
+
+	logs := make([]map[string]interface{}, 0, len(records))
+	for _, record := range records {
+		logs = append(logs, record.toMap())
+	}
+
+	c.Header("Cache-Control", "no-store")
+	result := gin.H{"logs": logs, "count": len(logs), "status": resultStatus(len(logs)), "n": n, "window": window.String(), "timeline": timeline}
+	if members != nil {
+		result["services"] = members
+	}
+	if filter.Level == "" && levels != nil {
+		result["levels"] = levels
+	}
+	if clamped {
+		result["warning"] = "n clamped to the server maximum; page through larger results with POST /api/v1/search and its next_cursor"
+	}
+	c.JSON(http.StatusOK, result)
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const defaultTraceLimit = 1000
+
+// Timeline orderings reported by GET /traces/:trace_id
+const (
+	traceOrderSpans     = "spans"     // call order reconstructed from span_id/parent_span_id
+	traceOrderTimestamp = "timestamp" // no span fields: plain event time
+)
+
+// traceSpan is one node of a trace's inferred call tree: the logs that share a
+// span_id (the agent extracts it from W3C traceparent), nested under the span
+// named by their parent_span_id
+type traceSpan struct {
+	id       string
+	parentID string
+	service  string
+	start    time.Time // earliest log
+	end      time.Time // latest log
+	logs     []LogRecord
+	children []*traceSpan
+}
+
+func (s *traceSpan) toMap() map[string]interface{} {
+	children := make([]map[string]interface{}, len(s.children))
+	for i, child := range s.children {
+		children[i] = child.toMap()
+	}
+	m := map[string]interface{}{
+		"span_id":     s.id,
+		"service":     s.service,
+		"start":       s.start.Format(time.RFC3339Nano),
+		"end":         s.end.Format(time.RFC3339Nano),
+		"duration_ms": float64(s.end.Sub(s.start).Microseconds()) / 1000,
+		"log_count":   len(s.logs),
+		"children":    children,
+	}
+	if s.parentID != "" {
+		m["parent_span_id"] = s.parentID
+	}
+	return m
+}
+
+// traceEntry is a log in timeline order and the depth of its span in the call
+// tree (0 for root spans and logs without a span)
+type traceEntry struct {
+	record LogRecord
+	depth  int
+}
+
+// buildTraceTree groups a trace's logs into spans and links each span to its
+// parent. Spans whose parent isn't among the logs (sampled out, or logged by a
+// service without an agent) become roots, as do spans that would close a cycle.
+// Records must be sorted by timestamp; logs without a span_id are returned apart.
+func buildTraceTree(records []LogRecord) (roots []*traceSpan, unspanned []LogRecord) {
+	spans := make(map[string]*traceSpan)
+	var order []*traceSpan
+	for _, r := range records {
+		id := r.Fields["span_id"]
+		if id == "" {
+			unspanned = append(unspanned, r)
+			continue
+		}
+		s, ok := spans[id]
+		if !ok {
+			s = &traceSpan{id: id, parentID: r.Fields["parent_span_id"], service: r.Service, start: r.Timestamp}
+			spans[id] = s
+			order = append(order, s)
+		}
+		if s.parentID == "" {
+			s.parentID = r.Fields["parent_span_id"]
+		}
+		s.end = r.Timestamp
+		s.logs = append(s.logs, r)
+	}
+
+	// Spans are visited by start time, so children lists come out sorted
+	linked := make(map[string]string) // span -> parent it was attached to
+	for _, s := range order {
+		parent, ok := spans[s.parentID]
+		if !ok || parent == s || closesCycle(linked, parent.id, s.id) {
+			roots = append(roots, s)
+			continue
+		}
+		linked[s.id] = parent.id
+		parent.children = append(parent.children, s)
+	}
+	return roots, unspanned
+}
+
+// closesCycle reports whether span is already an ancestor of parent
+func closesCycle(linked map[string]string, parent, span string) bool {
+	for id, ok := parent, true; ok; id, ok = linked[id] {
+		if id == span {
+			return true
+		}
+	}
+	return false
+}
+
+// traceTimeline orders a trace's logs by call sequence: a span's own logs and
+// its children interleave by time, and a child's whole subtree comes right
+// after the parent logs at or before its start. Sub-millisecond clock skew
+// between services can't put a callee's logs ahead of the call that made it.
+func traceTimeline(roots []*traceSpan, unspanned []LogRecord) []traceEntry {
+	var out []traceEntry
+	var walk func(logs []LogRecord, logDepth int, children []*traceSpan, childDepth int)
+	walk = func(logs []LogRecord, logDepth int, children []*traceSpan, childDepth int) {
+		i := 0
+		for _, child := range children {
+			for ; i < len(logs) && !logs[i].Timestamp.After(child.start); i++ {
+				out = append(out, traceEntry{logs[i], logDepth})
+			}
+			walk(child.logs, childDepth, child.children, childDepth+1)
+		}
+		for ; i < len(logs); i++ {
+			out = append(out, traceEntry{logs[i], logDepth})
+		}
+	}
+	walk(unspanned, 0, roots, 0)
+	return out
+}
+
+// GET /api/v1/traces/:trace_id?limit=1000
+// Every log of one trace across services. When the logs carry span_id and
+// parent_span_id, the timeline follows the inferred call tree (returned as
+// "spans"); otherwise it is sorted by timestamp.
+func (api *APIServer) trace(c *gin.Context) {
+	limit := defaultTraceLimit
+	if v := c.Query("limit"); v != "" {
+		parsed, err := strconv.Atoi(v)
+		if err != nil || parsed <= 0 {
+			abortWithError(c, invalidParam("limit", "limit must be a positive integer"))
+			return
+		}
+		limit = parsed
+	}
+	limit, _ = api.clampLimit(c, limit)
+
+	traceID := c.Param("trace_id")
+	records, err := api.store.QueryLogs(context.Background(), LogFilter{TraceID: traceID, Limit: limit})
+	if err != nil {
+		abortWithError(c, queryError(err))
+		return
+	}
+	sort.SliceStable(records, func(i, j int) bool { return records[i].Timestamp.Before(records[j].Timestamp) })
+
+	roots, unspanned := buildTraceTree(records)
+	ordering := traceOrderSpans
+	if len(roots) == 0 {
+		ordering = traceOrderTimestamp
+	}
+
+	timeline := traceTimeline(roots, unspanned)
+	logs := make([]map[string]interface{}, len(timeline))
+	seen := make(map[string]bool)
+	services := []string{}
+	for i, e := range timeline {
+		m := e.record.toMap()
+		m["timestamp"] = e.record.Timestamp.Format(time.RFC3339Nano)
+		m["depth"] = e.depth
+		logs[i] = m
+		if !seen[e.record.Service] {
+			seen[e.record.Service] = true
+			services = append(services, e.record.Service)
+		}
+	}
+	sort.Strings(services)
+	spans := make([]map[string]interface{}, len(roots))
+	for i, root := range roots {
+		spans[i] = root.toMap()
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"trace_id": traceID,
+		"ordering": ordering,
+		"logs":     logs,
+		"spans":    spans,
+		"services": services,
+		"count":    len(logs),
+		"status":   resultStatus(len(logs)),
+	})
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// The audit log records when each config version started being served, one
+// JSON object per line, appended (and synced) on every version change. It
+// lives outside the container (AUDIT_LOG, on a volume in docker-compose) so
+// the trail survives restarts; a restart serving the same version as the last
+// entry adds nothing. Set AUDIT_LOG empty to disable it.
+const (
+	defaultAuditLog   = "/var/lib/config-service/audit.jsonl"
+	defaultAuditLimit = 100
+)
+
+// auditEntry is one line of the audit log
+type auditEntry struct {
+	Time            time.Time `json:"time"`
+	Version         string    `json:"version"`
+	PreviousVersion string    `json:"previous_version,omitempty"`
+	SHA256          string    `json:"sha256"`
+	SizeBytes       int       `json:"size_bytes"`
+}
+
+type auditLog struct {
+	path        string
+	mu          sync.Mutex
+	lastVersion string // version of the newest entry
+	errors      uint64 // failed appends, reported on /metrics
+}
+
+// openAuditLog creates path's directory and picks up the newest entry of an
+// existing log, so a restart doesn't record the same version again
+func openAuditLog(path string) (*auditLog, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, err
+	}
+	a := &auditLog{path: path}
+	entries, _, err := a.read()
+	if err != nil {
+		return nil, err
+	}
+	if len(entries) > 0 {
+		a.lastVersion = entries[len(entries)-1].Version
+	}
+	if err := a.terminate(); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// terminate ends a line cut short by a crash, so the next entry isn't glued to it
+func (a *auditLog) terminate() error {
+	f, err := os.OpenFile(a.path, os.O_CREATE|os.O_RDWR, 0o644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil || info.Size() == 0 {
+		return err
+	}
+	last := make([]byte, 1)
+	if _, err := f.ReadAt(last, info.Size()-1); err != nil {
+		return err
+	}
+	if last[0] != '\n' {
+		_, err = f.WriteAt([]byte{'\n'}, info.Size())
+	}
+	return err
+}
+
+// record appends entry unless its version is already the newest one
+func (a *auditLog) record(entry auditEntry) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if entry.Version == a.lastVersion {
+		return nil
+	}
+	if entry.PreviousVersion == "" {
+		// First load after a restart: the predecessor is whatever was served before it
+		entry.PreviousVersion = a.lastVersion
+	}
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(a.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		a.errors++
+		return err
+	}
+	defer f.Close()
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		a.errors++
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		a.errors++
+		return err
+	}
+	a.lastVersion = entry.Version
+	return nil
+}
+
+// read returns every entry, oldest first, and how many damaged lines (e.g. one
+// cut short by a crash) were skipped
+func (a *auditLog) read() ([]auditEntry, int, error) {
+	f, err := os.Open(a.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, 0, nil
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	defer f.Close()
+
+	var entries []auditEntry
+	skipped := 0
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var entry auditEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil || entry.Version == "" {
+			skipped++
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	return entries, skipped, scanner.Err()
+}
+
+func (a *auditLog) failedWrites() uint64 {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.errors
+}
+
+// GET /api/v1/config/audit?limit=N
+// The newest N (default 100) audit entries, newest first: when each config
+// version went live and which version it replaced.
+func (s *configServer) auditHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if s.audit == nil {
+		http.Error(w, "audit log disabled (AUDIT_LOG is empty)", http.StatusNotFound)
+		return
+	}
+	limit := defaultAuditLimit
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			http.Error(w, "limit must be a positive integer", http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+
+	s.audit.mu.Lock()
+	entries, skipped, err := s.audit.read()
+	s.audit.mu.Unlock()
+	if err != nil {
+		http.Error(w, "read audit log: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	total := len(entries)
+	if len(entries) > limit {
+		entries = entries[len(entries)-limit:]
+	}
+	newestFirst := make([]auditEntry, len(entries))
+	for i, entry := range entries {
+		newestFirst[len(entries)-1-i] = entry
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{
+		"entries":         newestFirst,
+		"count":           len(newestFirst),
+		"total":           total,
+		"skipped_damaged": skipped,
+	}); err != nil {
+		log.Printf("Failed to write audit response: %v", err)
+	}
+}
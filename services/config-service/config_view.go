@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Agents not heard from for this long are dropped from GET /api/v1/config
+const agentForgetAfter = 24 * time.Hour
+
+// agentSeen is the last GetConfig call of one agent
+type agentSeen struct {
+	version  string // the config version the agent reported holding
+	lastSeen time.Time
+	peer     string
+}
+
+// agentTracker remembers which agents poll for config and what they hold
+type agentTracker struct {
+	mu     sync.Mutex
+	agents map[string]agentSeen
+}
+
+func newAgentTracker() *agentTracker {
+	return &agentTracker{agents: make(map[string]agentSeen)}
+}
+
+func (t *agentTracker) record(agentID, version, peer string, now time.Time) {
+	if agentID == "" {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.agents[agentID] = agentSeen{version: version, lastSeen: now, peer: peer}
+	for id, seen := range t.agents {
+		if now.Sub(seen.lastSeen) > agentForgetAfter {
+			delete(t.agents, id)
+		}
+	}
+}
+
+// list returns the agents sorted by ID, flagging those holding current
+func (t *agentTracker) list(current string) []map[string]interface{} {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	ids := make([]string, 0, len(t.agents))
+	for id := range t.agents {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	out := make([]map[string]interface{}, 0, len(ids))
+	for _, id := range ids {
+		seen := t.agents[id]
+		out = append(out, map[string]interface{}{
+			"agent_id":       id,
+			"config_version": seen.version, // empty before the agent's first config
+			"up_to_date":     seen.version == current,
+			"last_seen":      seen.lastSeen.UTC().Format(time.RFC3339),
+			"peer":           seen.peer,
+		})
+	}
+	return out
+}
+
+// GET /api/v1/config
+// Read-only view of what is being served: the version, when it was loaded,
+// the config parsed to JSON, and the agents polling for it with the version
+// each reported. An agent's version lags by one poll, since it reports what
+// it held when it asked.
+func (s *configServer) configHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	s.mu.RLock()
+	payload, version, loadedAt := s.configPayload, s.configVersion, s.loadedAt
+	s.mu.RUnlock()
+
+	response := map[string]interface{}{
+		"config_version": version,
+		"agents":         s.agents.list(version),
+	}
+	if !loadedAt.IsZero() {
+		response["loaded_at"] = loadedAt.UTC().Format(time.RFC3339)
+	}
+	// Agents parse the file themselves; one they can't parse is still served
+	var parsed interface{}
+	if err := yaml.Unmarshal(payload, &parsed); err != nil {
+		response["parse_error"] = err.Error()
+		response["raw"] = string(payload)
+	} else {
+		response["config"] = parsed
+	}
+
+	body, err := json.Marshal(response)
+	if err != nil {
+		// e.g. a mapping with non-string keys, which JSON can't express
+		http.Error(w, "config can't be shown as JSON: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(append(body, '\n'))
+}
@@ -4,20 +4,29 @@ import (
 	"context"
 	"crypto/sha256"
 	"encoding/hex"
+	"encoding/json"
 	"log"
 	"net"
+	"net/http"
 	"os"
 	"sync"
 	"time"
 
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	"google.golang.org/grpc/peer"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 
 	pb "stackmonitor.com/config-service/proto/configproto"
 )
 
 const (
-	port       = ":8080"
-	configFile = "/config/config.yaml"
+	defaultListenAddr = ":8080" // override with LISTEN_ADDR
+	configFile        = "/config/config.yaml"
+	// Longest a long-polling GetConfig is held; override with CONFIG_MAX_WAIT
+	defaultMaxWait = 60 * time.Second
+	// /metrics and /api/v1/config are served here; override with HTTP_ADDR, or set it empty to disable
+	defaultHTTPAddr = ":8084"
 )
 
 type configServer struct {
@@ -25,12 +34,52 @@ type configServer struct {
 	configPayload []byte
 	configVersion string
 	mu            sync.RWMutex
+	health        *health.Server // reports SERVING only while the config file is readable
+	changed       chan struct{}  // closed and replaced whenever the version changes
+	maxWait       time.Duration  // cap on a client's wait_seconds
+	grpcStats     *rpcStats      // per-method RPC counts and durations, see grpc_interceptors.go
+	agents        *agentTracker  // agents polling GetConfig, see config_view.go
+	loadedAt      time.Time      // when configVersion was loaded
+	audit         *auditLog      // nil when AUDIT_LOG is empty, see audit.go
+	startTime     time.Time
+}
+
+// metricsHandler serves the RPC stats and the config version being handed out
+func (s *configServer) metricsHandler(w http.ResponseWriter, r *http.Request) {
+	s.mu.RLock()
+	version := s.configVersion
+	s.mu.RUnlock()
+	metrics := map[string]interface{}{
+		"config_version": version,
+		"uptime_seconds": time.Since(s.startTime).Seconds(),
+		"grpc":           s.grpcStats.snapshot(),
+	}
+	if s.audit != nil {
+		metrics["audit_write_errors"] = s.audit.failedWrites()
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(metrics)
+}
+
+// setHealth reports the config file's state through the gRPC health service
+func (s *configServer) setHealth(status healthpb.HealthCheckResponse_ServingStatus) {
+	if s.health == nil {
+		return
+	}
+	s.health.SetServingStatus("", status)
+	s.health.SetServingStatus(pb.ConfigService_ServiceDesc.ServiceName, status)
 }
 
 func (s *configServer) loadConfig() {
 	payload, err := os.ReadFile(configFile)
 	if err != nil {
 		log.Printf("Failed to read config file: %v", err)
+		s.setHealth(healthpb.HealthCheckResponse_NOT_SERVING)
+		return
+	}
+	if len(payload) == 0 {
+		log.Printf("Config file %s is empty", configFile)
+		s.setHealth(healthpb.HealthCheckResponse_NOT_SERVING)
 		return
 	}
 
@@ -41,7 +90,24 @@ func (s *configServer) loadConfig() {
 	oldVersion := s.configVersion
 	s.configPayload = payload
 	s.configVersion = version
+	loadedAt := s.loadedAt
+	if version != oldVersion {
+		s.loadedAt = time.Now()
+		loadedAt = s.loadedAt
+		// Wake long-polling clients
+		close(s.changed)
+		s.changed = make(chan struct{})
+	}
 	s.mu.Unlock()
+	s.setHealth(healthpb.HealthCheckResponse_SERVING)
+
+	if s.audit != nil && version != oldVersion {
+		entry := auditEntry{Time: loadedAt.UTC(), Version: version, PreviousVersion: oldVersion,
+			SHA256: hex.EncodeToString(hash[:]), SizeBytes: len(payload)}
+		if err := s.audit.record(entry); err != nil {
+			log.Printf("⚠️ Failed to audit config version %s: %v", version, err)
+		}
+	}
 	
 	// Only log if version actually changed
 	if oldVersion != "" && oldVersion != version {
@@ -52,6 +118,16 @@ func (s *configServer) loadConfig() {
 }
 
 func (s *configServer) GetConfig(ctx context.Context, req *pb.ConfigRequest) (*pb.ConfigResponse, error) {
+	client := ""
+	if p, ok := peer.FromContext(ctx); ok {
+		client = p.Addr.String()
+	}
+	s.agents.record(req.AgentId, req.CurrentConfigVersion, client, time.Now())
+
+	if req.WaitSeconds > 0 {
+		s.waitForChange(ctx, req.CurrentConfigVersion, time.Duration(req.WaitSeconds)*time.Second)
+	}
+
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
@@ -67,8 +143,61 @@ func (s *configServer) GetConfig(ctx context.Context, req *pb.ConfigRequest) (*p
 	}, nil
 }
 
+// waitForChange blocks while version is still current, until the config
+// changes, the wait (capped at maxWait) elapses or the client goes away
+func (s *configServer) waitForChange(ctx context.Context, version string, wait time.Duration) {
+	s.mu.RLock()
+	current, changed := s.configVersion, s.changed
+	s.mu.RUnlock()
+	if version != current {
+		return
+	}
+
+	if wait > s.maxWait {
+		wait = s.maxWait
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-changed:
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+}
+
 func main() {
-	s := &configServer{}
+	maxWait := defaultMaxWait
+	if v := os.Getenv("CONFIG_MAX_WAIT"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			log.Fatalf("Invalid CONFIG_MAX_WAIT: %q", v)
+		}
+		maxWait = d
+	}
+
+	// GRPC_INTERCEPTORS lists the interceptors wrapping every RPC; set it empty for none
+	interceptorsEnv, ok := os.LookupEnv("GRPC_INTERCEPTORS")
+	if !ok {
+		interceptorsEnv = defaultGRPCInterceptors
+	}
+	interceptors, err := parseInterceptors(interceptorsEnv)
+	if err != nil {
+		log.Fatalf("Invalid GRPC_INTERCEPTORS: %v", err)
+	}
+
+	s := &configServer{health: health.NewServer(), changed: make(chan struct{}), maxWait: maxWait,
+		grpcStats: newRPCStats(), agents: newAgentTracker(), startTime: time.Now()}
+
+	auditPath, ok := os.LookupEnv("AUDIT_LOG")
+	if !ok {
+		auditPath = defaultAuditLog
+	}
+	if auditPath != "" {
+		if s.audit, err = openAuditLog(auditPath); err != nil {
+			log.Fatalf("Invalid AUDIT_LOG: %v", err)
+		}
+	}
+	s.setHealth(healthpb.HealthCheckResponse_NOT_SERVING)
 	s.loadConfig()
 
 	// Watch config file for changes (polling every 10s)
@@ -79,13 +208,35 @@ func main() {
 		}
 	}()
 
-	lis, err := net.Listen("tcp", port)
+	listenAddr := os.Getenv("LISTEN_ADDR")
+	if listenAddr == "" {
+		listenAddr = defaultListenAddr
+	}
+	lis, err := net.Listen("tcp", listenAddr)
 	if err != nil {
 		log.Fatalf("failed to listen: %v", err)
 	}
 
-	grpcServer := grpc.NewServer()
+	httpAddr, ok := os.LookupEnv("HTTP_ADDR")
+	if !ok {
+		httpAddr = defaultHTTPAddr
+	}
+	if httpAddr != "" {
+		mux := http.NewServeMux()
+		mux.HandleFunc("/metrics", s.metricsHandler)
+		mux.HandleFunc("/api/v1/config", s.configHandler)
+		mux.HandleFunc("/api/v1/config/audit", s.auditHandler)
+		go func() {
+			log.Printf("HTTP listening at %s", httpAddr)
+			if err := http.ListenAndServe(httpAddr, mux); err != nil {
+				log.Printf("⚠️ HTTP server stopped: %v", err)
+			}
+		}()
+	}
+
+	grpcServer := grpc.NewServer(interceptorOptions(interceptors, s.grpcStats)...)
 	pb.RegisterConfigServiceServer(grpcServer, s)
+	healthpb.RegisterHealthServer(grpcServer, s.health)
 
 	log.Printf("Config server listening at %v", lis.Addr())
 	if err := grpcServer.Serve(lis); err != nil {
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	pb "stackmonitor.com/ingestion-service/proto/logproto"
+)
+
+// ACK_MODE controls when StreamLogs acknowledges a batch.
+//
+//   - receive (default): ack as soon as the entries are queued for insert. Lowest
+//     latency, but an ack only means "received": entries still in the queue are
+//     lost if the service crashes before the next ClickHouse insert.
+//   - durable: ack only after every entry of the batch has been inserted into
+//     ClickHouse, or ack RETRY if the insert failed. Each stream waits for its
+//     batch to be written (up to batchTimeout when traffic is light), so
+//     throughput per agent drops, but an ack means the logs are persisted.
+const (
+	ackOnReceive = "receive"
+	ackDurable   = "durable"
+)
+
+// durableAckTimeout bounds how long a stream waits for its batch to be inserted
+const durableAckTimeout = 30 * time.Second
+
+// parseAckMode validates the ACK_MODE setting (empty means receive)
+func parseAckMode(raw string) (string, error) {
+	switch raw {
+	case "", ackOnReceive:
+		return ackOnReceive, nil
+	case ackDurable:
+		return ackDurable, nil
+	}
+	return "", fmt.Errorf("unknown ack mode %q (want %s or %s)", raw, ackOnReceive, ackDurable)
+}
+
+// queuedEntry is a log entry on its way to ClickHouse
+type queuedEntry struct {
+	entry *pb.LogEntry
+	ack   *batchAck // nil unless durable acks are enabled
+	// The agent's LogBatch.TimestampMs, for end-to-end latency; 0 for entries made here
+	batchTimestampMs int64
+}
+
+// batchAck tracks the entries of one received batch, which may be spread
+// across several ClickHouse inserts, until all of them are written
+type batchAck struct {
+	pending sync.WaitGroup
+	mu      sync.Mutex
+	err     error
+	stored  []*pb.LogEntry // inserted or dead-lettered, so not to be written again
+}
+
+func newBatchAck(entries int) *batchAck {
+	b := &batchAck{}
+	b.pending.Add(entries)
+	return b
+}
+
+// done marks entry as stored (err == nil) or failed
+func (b *batchAck) done(entry *pb.LogEntry, err error) {
+	b.mu.Lock()
+	if err == nil {
+		b.stored = append(b.stored, entry)
+	} else if b.err == nil {
+		b.err = err
+	}
+	b.mu.Unlock()
+	b.pending.Done()
+}
+
+// storedEntries returns the entries stored so far
+func (b *batchAck) storedEntries() []*pb.LogEntry {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return slices.Clone(b.stored)
+}
+
+// wait blocks until every entry is done and returns the first insert error
+func (b *batchAck) wait(timeout time.Duration) error {
+	finished := make(chan struct{})
+	go func() {
+		b.pending.Wait()
+		close(finished)
+	}()
+
+	select {
+	case <-finished:
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		return b.err
+	case <-time.After(timeout):
+		return fmt.Errorf("timed out after %v waiting for insert", timeout)
+	}
+}
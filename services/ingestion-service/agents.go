@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/ClickHouse/clickhouse-go/v2/lib/driver"
+
+	pb "stackmonitor.com/ingestion-service/proto/logproto"
+)
+
+const (
+	// agentsTable holds one row per heartbeat flush; the api-server reads the latest per agent
+	agentsTable        = "agents"
+	agentFlushInterval = 15 * time.Second
+)
+
+// Batch metadata keys the Go agent sets. A batch with no logs and
+// metadata heartbeat=true only reports liveness and is not acked.
+const (
+	metaHeartbeat    = "heartbeat"
+	metaHostname     = "hostname"
+	metaAgentVersion = "agent_version"
+)
+
+// agentInfo is what the registry knows about one agent
+type agentInfo struct {
+	hostname string
+	version  string
+	lastSeen time.Time
+}
+
+// agentRegistry records when each agent was last heard from. Every batch and
+// heartbeat updates it in memory; dirty entries are flushed to ClickHouse
+// periodically so liveness tracking adds no per-batch writes.
+type agentRegistry struct {
+	mu    sync.Mutex
+	seen  map[string]agentInfo
+	dirty map[string]bool
+}
+
+func newAgentRegistry() *agentRegistry {
+	return &agentRegistry{seen: make(map[string]agentInfo), dirty: make(map[string]bool)}
+}
+
+// record notes a batch from an agent received at seenAt; missing metadata keeps the previous values
+func (r *agentRegistry) record(batch *pb.LogBatch, seenAt time.Time) {
+	if batch.AgentId == "" {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	info := r.seen[batch.AgentId]
+	if h := batch.Metadata[metaHostname]; h != "" {
+		info.hostname = h
+	}
+	if v := batch.Metadata[metaAgentVersion]; v != "" {
+		info.version = v
+	}
+	info.lastSeen = seenAt
+	r.seen[batch.AgentId] = info
+	r.dirty[batch.AgentId] = true
+}
+
+// takeDirty returns the agents updated since the last call
+func (r *agentRegistry) takeDirty() map[string]agentInfo {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	out := make(map[string]agentInfo, len(r.dirty))
+	for id := range r.dirty {
+		out[id] = r.seen[id]
+	}
+	r.dirty = make(map[string]bool)
+	return out
+}
+
+// markDirty re-queues agents whose flush failed
+func (r *agentRegistry) markDirty(ids map[string]agentInfo) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for id := range ids {
+		r.dirty[id] = true
+	}
+}
+
+// flushAgents writes registry updates to ClickHouse; call it in its own goroutine
+func (s *ingestionServer) flushAgents() {
+	ticker := time.NewTicker(agentFlushInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		updates := s.agents.takeDirty()
+		if len(updates) == 0 {
+			continue
+		}
+		if err := insertAgents(s.db, updates); err != nil {
+			log.Printf("⚠️  Failed to record agent heartbeats: %v", err)
+			s.agents.markDirty(updates)
+		}
+	}
+}
+
+func insertAgents(db driver.Conn, updates map[string]agentInfo) error {
+	ctx := context.Background()
+	batch, err := db.PrepareBatch(ctx, fmt.Sprintf("INSERT INTO %s.%s", database, agentsTable))
+	if err != nil {
+		return err
+	}
+	defer batch.Abort()
+	for id, info := range updates {
+		if err := batch.Append(id, info.hostname, info.version, info.lastSeen); err != nil {
+			return err
+		}
+	}
+	return batch.Send()
+}
+
+// ensureAgentsTable creates the heartbeat table. ReplacingMergeTree keeps only
+// the newest row per agent once parts merge; readers still aggregate with argMax.
+func (s *ingestionServer) ensureAgentsTable(ctx context.Context) error {
+	query := fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s.%s (
+		agent_id String,
+		hostname String,
+		version String,
+		last_seen DateTime64(3)
+	) ENGINE = ReplacingMergeTree(last_seen) ORDER BY agent_id`, database, agentsTable)
+	if err := s.db.Exec(ctx, query); err != nil {
+		return fmt.Errorf("create table %s: %w", agentsTable, err)
+	}
+	return nil
+}
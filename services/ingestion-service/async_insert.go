@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/ClickHouse/clickhouse-go/v2"
+)
+
+// CH_ASYNC_INSERT turns on ClickHouse's async_insert, which buffers inserts
+// server-side and flushes them in bulk. That cuts per-insert overhead under
+// heavy load, at some cost to insert latency and durability.
+//
+//   - off (default): synchronous inserts, written when batch.Send returns
+//   - wait: async_insert with wait_for_async_insert=1; Send returns once the
+//     server-side buffer has been flushed, so acks keep their meaning
+//   - nowait: wait_for_async_insert=0; Send returns as soon as ClickHouse has
+//     buffered the rows, before they reach disk. A ClickHouse crash can lose
+//     rows that were already acked, so this is rejected with ACK_MODE=durable.
+const (
+	asyncInsertOff    = "off"
+	asyncInsertWait   = "wait"
+	asyncInsertNoWait = "nowait"
+)
+
+// parseAsyncInsert validates CH_ASYNC_INSERT (empty means off)
+func parseAsyncInsert(raw string) (string, error) {
+	switch raw {
+	case "", asyncInsertOff:
+		return asyncInsertOff, nil
+	case asyncInsertWait, asyncInsertNoWait:
+		return raw, nil
+	}
+	return "", fmt.Errorf("unknown async insert mode %q (want %s, %s or %s)", raw, asyncInsertOff, asyncInsertWait, asyncInsertNoWait)
+}
+
+// asyncInsertSettings returns the ClickHouse session settings for a mode
+func asyncInsertSettings(mode string) clickhouse.Settings {
+	switch mode {
+	case asyncInsertWait:
+		return clickhouse.Settings{"async_insert": 1, "wait_for_async_insert": 1}
+	case asyncInsertNoWait:
+		return clickhouse.Settings{"async_insert": 1, "wait_for_async_insert": 0}
+	}
+	return nil
+}
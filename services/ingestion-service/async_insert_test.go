@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ClickHouse/clickhouse-go/v2"
+
+	pb "stackmonitor.com/ingestion-service/proto/logproto"
+)
+
+const asyncBenchTable = "logs_async_bench"
+
+// BenchmarkAsyncInsert inserts into a copy of the logs table with each
+// CH_ASYNC_INSERT mode, from 8 concurrent writers as the insert workers do.
+// Small batches are where async_insert should help. ns/op is per log. It needs
+// a ClickHouse with the stackmonitor schema (docker compose up clickhouse):
+//
+//	CLICKHOUSE_ADDR=localhost:9000 go test -run xxx -bench AsyncInsert
+func BenchmarkAsyncInsert(b *testing.B) {
+	addr := os.Getenv("CLICKHOUSE_ADDR")
+	if addr == "" {
+		b.Skip("CLICKHOUSE_ADDR not set")
+	}
+	for _, mode := range []string{asyncInsertOff, asyncInsertWait, asyncInsertNoWait} {
+		for _, size := range []int{10, 100} {
+			b.Run(fmt.Sprintf("%s/batch=%d", mode, size), func(b *testing.B) {
+				s := asyncBenchServer(b, addr, mode)
+				logs := make([]*pb.LogEntry, size)
+				for i := range logs {
+					logs[i] = &pb.LogEntry{TimestampNs: time.Now().UnixNano(), Level: "INFO", Message: "request served in 12ms",
+						AgentId: "agent-1", Fields: map[string]string{"service": "api-gateway", "trace_id": fmt.Sprintf("trace-%d", i)}}
+				}
+
+				var inserted atomic.Int64
+				b.SetParallelism(8)
+				b.ResetTimer()
+				start := time.Now()
+				b.RunParallel(func(iter *testing.PB) {
+					// Each iteration is one log; a writer sends a batch per size logs
+					for n := 0; iter.Next(); n++ {
+						if n%size != size-1 {
+							continue
+						}
+						if err := s.writeRows(context.Background(), asyncBenchTable, logs); err != nil {
+							b.Error(err)
+							return
+						}
+						inserted.Add(int64(size))
+					}
+				})
+				b.ReportMetric(float64(inserted.Load())/time.Since(start).Seconds(), "logs/s")
+			})
+		}
+	}
+}
+
+// asyncBenchServer connects with mode's settings and recreates the bench table
+// as an empty copy of the logs table
+func asyncBenchServer(b *testing.B, addr, mode string) *ingestionServer {
+	opts, err := clickHouseOptions(addr, database)
+	if err != nil {
+		b.Fatal(err)
+	}
+	opts.Settings = asyncInsertSettings(mode)
+	opts.MaxOpenConns, opts.MaxIdleConns = insertConnLimits(8)
+	db, err := clickhouse.Open(opts)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Cleanup(func() { db.Close() })
+
+	ctx := context.Background()
+	if err := db.Exec(ctx, fmt.Sprintf("DROP TABLE IF EXISTS %s.%s", database, asyncBenchTable)); err != nil {
+		b.Fatal(err)
+	}
+	if err := db.Exec(ctx, fmt.Sprintf("CREATE TABLE %s.%s AS %s.%s", database, asyncBenchTable, database, logsTable)); err != nil {
+		b.Fatal(err)
+	}
+	b.Cleanup(func() { db.Exec(ctx, fmt.Sprintf("DROP TABLE IF EXISTS %s.%s", database, asyncBenchTable)) })
+
+	s := &ingestionServer{db: db, schemas: newInsertSchemas(nil), clock: realClock{}}
+	if _, err := s.schemas.get(ctx, s, asyncBenchTable); err != nil {
+		b.Fatal(err)
+	}
+	return s
+}
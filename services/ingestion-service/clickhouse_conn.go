@@ -0,0 +1,52 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+
+	"github.com/ClickHouse/clickhouse-go/v2"
+)
+
+// clickHouseOptions builds the insert connection from the environment; main
+// adds the async_insert settings and pool size. With none of CH_USER,
+// CH_PASSWORD or CH_TLS set this is the dev-mode connection (default user, no
+// password, plaintext).
+//
+//   - CH_USER / CH_PASSWORD: credentials; the user needs INSERT, CREATE TABLE
+//     (agents, dead-letter and replay tables) and SELECT on the log tables and
+//     system.columns
+//   - CH_TLS=true: connect over TLS (ClickHouse's secure native port is usually 9440)
+//   - CH_TLS_CA: PEM file of CAs to trust instead of the system pool
+//   - CH_TLS_SKIP_VERIFY=true: don't verify the server certificate (testing only)
+func clickHouseOptions(addr, database string) (*clickhouse.Options, error) {
+	opts := &clickhouse.Options{
+		Addr: []string{addr},
+		Auth: clickhouse.Auth{
+			Database: database,
+			Username: os.Getenv("CH_USER"),
+			Password: os.Getenv("CH_PASSWORD"),
+		},
+	}
+
+	if os.Getenv("CH_TLS") != "true" {
+		return opts, nil
+	}
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: os.Getenv("CH_TLS_SKIP_VERIFY") == "true",
+	}
+	if caFile := os.Getenv("CH_TLS_CA"); caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("read CH_TLS_CA: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in CH_TLS_CA %s", caFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	opts.TLS = tlsConfig
+	return opts, nil
+}
@@ -0,0 +1,26 @@
+package main
+
+import "time"
+
+// Clock is where the ingestion server reads the time. The dedup window, ack
+// timestamps and insert bookkeeping go through it instead of the time
+// package, so time-dependent behavior can run against a controlled clock.
+type Clock interface {
+	Now() time.Time
+	Since(t time.Time) time.Duration
+	AfterFunc(d time.Duration, f func()) Timer
+}
+
+// Timer is the part of *time.Timer that callers use
+type Timer interface {
+	Stop() bool
+}
+
+// realClock is the wall clock, the default everywhere
+type realClock struct{}
+
+func (realClock) Now() time.Time                  { return time.Now() }
+func (realClock) Since(t time.Time) time.Duration { return time.Since(t) }
+func (realClock) AfterFunc(d time.Duration, f func()) Timer {
+	return time.AfterFunc(d, f)
+}
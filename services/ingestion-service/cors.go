@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// corsPolicy decides which browser origins may read the health and metrics
+// endpoints; the gRPC port is not affected. It is built from the environment:
+//
+//   - CORS_ORIGINS: comma-separated allow-list; only a matching request
+//     Origin is echoed back in Access-Control-Allow-Origin.
+//   - CORS_DEV_MODE=true: with no CORS_ORIGINS, allow any origin (*).
+//
+// With neither set no CORS headers are sent.
+type corsPolicy struct {
+	allowAll bool
+	origins  map[string]bool
+}
+
+func corsPolicyFromEnv() (*corsPolicy, error) {
+	p := &corsPolicy{origins: make(map[string]bool)}
+	for _, origin := range strings.Split(os.Getenv("CORS_ORIGINS"), ",") {
+		origin = strings.TrimRight(strings.TrimSpace(origin), "/")
+		if origin == "" {
+			continue
+		}
+		if origin == "*" {
+			return nil, fmt.Errorf("CORS_ORIGINS=* is not allowed; list the origins or set CORS_DEV_MODE=true")
+		}
+		u, err := url.Parse(origin)
+		if err != nil || u.Scheme == "" || u.Host == "" || u.Path != "" {
+			return nil, fmt.Errorf("invalid origin %q in CORS_ORIGINS (want scheme://host[:port])", origin)
+		}
+		p.origins[strings.ToLower(origin)] = true
+	}
+
+	devMode := os.Getenv("CORS_DEV_MODE") == "true"
+	switch {
+	case len(p.origins) > 0:
+		log.Printf("CORS allowed for %d origin(s)", len(p.origins))
+	case devMode:
+		p.allowAll = true
+		log.Println("⚠️  CORS_DEV_MODE: allowing requests from any origin")
+	default:
+		log.Println("CORS disabled: set CORS_ORIGINS to allow browser access from other origins")
+	}
+	return p, nil
+}
+
+// allowed reports whether a browser on origin may read responses
+func (p *corsPolicy) allowed(origin string) bool {
+	return p != nil && (p.allowAll || p.origins[strings.ToLower(origin)])
+}
+
+// apply sets Access-Control-Allow-Origin when the request's origin is allowed
+// and reports whether it did
+func (p *corsPolicy) apply(h http.Header, origin string) bool {
+	if p != nil && p.allowAll {
+		h.Set("Access-Control-Allow-Origin", "*")
+		return true
+	}
+	h.Add("Vary", "Origin")
+	if origin == "" || !p.allowed(origin) {
+		return false
+	}
+	h.Set("Access-Control-Allow-Origin", origin)
+	return true
+}
+
+// corsHandler applies the policy to the health and metrics endpoints, which are read-only
+func corsHandler(p *corsPolicy, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if p.apply(w.Header(), r.Header.Get("Origin")) {
+			w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+			w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+		}
+		if r.Method == http.MethodOptions {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"hash/maphash"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// dedupCache holds the dedup keys seen within the last dedupWindow. Keys are
+// spread over dedupShards shards by hash, each with its own lock, so streams
+// rarely contend. Instead of a timer per key, each shard keeps a ring of
+// dedupBucket-wide time buckets listing the keys that expire in them, and one
+// sweeper (sweepDedup) expires a whole bucket at a time. A key expires at the
+// end of the bucket its window ends in, so at most dedupBucket late.
+const (
+	dedupShards = 64
+	dedupBucket = time.Second
+)
+
+type dedupCache struct {
+	seed   maphash.Seed
+	window time.Duration
+	shards [dedupShards]dedupShard
+	swept  int64 // last bucket swept; only the sweeper touches it
+}
+
+type dedupShard struct {
+	mu      sync.Mutex
+	entries map[string]*dedupEntry
+	ring    [][]*dedupEntry // bucket % len(ring) -> entries expiring in it
+}
+
+// dedupEntry is one stored key; it expires exactly once, even when the key
+// was deleted (or deleted and stored again) before its bucket is swept
+type dedupEntry struct {
+	key     string
+	state   *dedupState
+	expires int64 // bucket index
+}
+
+func newDedupCache(window time.Duration, now time.Time) *dedupCache {
+	c := &dedupCache{seed: maphash.MakeSeed(), window: window, swept: bucketOf(now)}
+	for i := range c.shards {
+		c.shards[i].entries = make(map[string]*dedupEntry)
+		c.shards[i].ring = make([][]*dedupEntry, int(window/dedupBucket)+2)
+	}
+	return c
+}
+
+func bucketOf(t time.Time) int64 {
+	return t.UnixNano() / int64(dedupBucket)
+}
+
+func (c *dedupCache) shard(key string) *dedupShard {
+	return &c.shards[maphash.String(c.seed, key)%dedupShards]
+}
+
+// loadOrStore returns the state stored for key and true, or stores state and
+// returns false
+func (c *dedupCache) loadOrStore(key string, state *dedupState, now time.Time) (*dedupState, bool) {
+	sh := c.shard(key)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	if existing, ok := sh.entries[key]; ok {
+		return existing.state, true
+	}
+	entry := &dedupEntry{key: key, state: state, expires: bucketOf(now.Add(c.window)) + 1}
+	sh.entries[key] = entry
+	slot := entry.expires % int64(len(sh.ring))
+	sh.ring[slot] = append(sh.ring[slot], entry)
+	return nil, false
+}
+
+// delete forgets key early; its state still expires with its bucket
+func (c *dedupCache) delete(key string) {
+	sh := c.shard(key)
+	sh.mu.Lock()
+	delete(sh.entries, key)
+	sh.mu.Unlock()
+}
+
+// sweep removes every entry whose bucket has ended by now and returns their
+// states, oldest bucket first
+func (c *dedupCache) sweep(now time.Time) []*dedupState {
+	var expired []*dedupState
+	current := bucketOf(now)
+	for ; c.swept < current; c.swept++ {
+		bucket := c.swept + 1
+		for i := range c.shards {
+			sh := &c.shards[i]
+			sh.mu.Lock()
+			slot := bucket % int64(len(sh.ring))
+			kept := sh.ring[slot][:0]
+			for _, entry := range sh.ring[slot] {
+				if entry.expires > bucket {
+					kept = append(kept, entry) // only when the sweeper fell a full ring behind
+					continue
+				}
+				if sh.entries[entry.key] == entry {
+					delete(sh.entries, entry.key)
+				}
+				expired = append(expired, entry.state)
+			}
+			clear(sh.ring[slot][len(kept):])
+			sh.ring[slot] = kept
+			sh.mu.Unlock()
+		}
+	}
+	return expired
+}
+
+// drain removes every entry, expired or not, and returns their states; for
+// shutdown, once nothing stores keys any more
+func (c *dedupCache) drain() []*dedupState {
+	var states []*dedupState
+	for i := range c.shards {
+		sh := &c.shards[i]
+		sh.mu.Lock()
+		for slot, entries := range sh.ring {
+			for _, entry := range entries {
+				states = append(states, entry.state)
+			}
+			sh.ring[slot] = nil
+		}
+		clear(sh.entries)
+		sh.mu.Unlock()
+	}
+	return states
+}
+
+// dedupCacheStats tracks the size of dedupCache. Every key lives until its
+// bucket is swept, so the cache holds one entry per distinct key seen in the
+// last dedupWindow; high-cardinality keys grow it with no other bound.
+type dedupCacheStats struct {
+	entries   atomic.Int64
+	highWater atomic.Int64
+	evictions atomic.Uint64
+
+	mu        sync.Mutex
+	rateStart time.Time // start of the minute being counted
+	rateCount uint64    // evictions since rateStart
+	lastRate  float64   // evictions per second over the previous minute
+}
+
+// added records a new key and raises the high-water mark if needed
+func (d *dedupCacheStats) added() {
+	n := d.entries.Add(1)
+	for {
+		high := d.highWater.Load()
+		if n <= high || d.highWater.CompareAndSwap(high, n) {
+			return
+		}
+	}
+}
+
+// evicted records a key expiring at now
+func (d *dedupCacheStats) evicted(now time.Time) {
+	d.entries.Add(-1)
+	d.evictions.Add(1)
+	d.mu.Lock()
+	d.rotate(now)
+	d.rateCount++
+	d.mu.Unlock()
+}
+
+// evictionRate is evictions per second over the last full minute
+func (d *dedupCacheStats) evictionRate(now time.Time) float64 {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.rotate(now)
+	return d.lastRate
+}
+
+// rotate closes the counted minute once it is over; callers hold mu
+func (d *dedupCacheStats) rotate(now time.Time) {
+	elapsed := now.Sub(d.rateStart)
+	if elapsed < time.Minute {
+		return
+	}
+	d.lastRate = 0
+	if elapsed < 2*time.Minute { // otherwise the previous minute had no evictions
+		d.lastRate = float64(d.rateCount) / time.Minute.Seconds()
+	}
+	d.rateStart = now
+	d.rateCount = 0
+}
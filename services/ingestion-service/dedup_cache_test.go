@@ -0,0 +1,95 @@
+package main
+
+import (
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// timerDedup is the cache dedupCache replaced: one sync.Map and a timer per
+// stored key, kept here as the benchmark baseline
+type timerDedup struct {
+	entries sync.Map
+	window  time.Duration
+}
+
+func (c *timerDedup) loadOrStore(key string, state *dedupState) (*dedupState, bool) {
+	if existing, loaded := c.entries.LoadOrStore(key, state); loaded {
+		return existing.(*dedupState), true
+	}
+	time.AfterFunc(c.window, func() { c.entries.Delete(key) })
+	return nil, false
+}
+
+// dedupKeys are distinct keys shaped like dedupKey's output
+func dedupKeys(n int) []string {
+	keys := make([]string, n)
+	for i := range keys {
+		keys[i] = "payment-service\x00ERROR\x00card declined for order " + strconv.Itoa(i)
+	}
+	return keys
+}
+
+// BenchmarkDedupCache stores keys from parallel streams, as isDuplicate does
+// per entry. "unique" is one new key per log (a 50k logs/sec stream of
+// distinct lines keeps ~3M keys in a 60s window); "repeated" cycles through
+// 1000 keys, so nearly every lookup is a duplicate.
+func BenchmarkDedupCache(b *testing.B) {
+	for _, bc := range []struct {
+		name string
+		keys int
+	}{
+		{name: "unique", keys: 1 << 20},
+		{name: "repeated", keys: 1000},
+	} {
+		keys := dedupKeys(bc.keys)
+		b.Run(bc.name+"/sharded", func(b *testing.B) {
+			cache := newDedupCache(dedupWindow, time.Now())
+			var next atomic.Uint64
+			b.ReportAllocs()
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				now := time.Now()
+				for pb.Next() {
+					key := keys[next.Add(1)%uint64(len(keys))]
+					if existing, loaded := cache.loadOrStore(key, &dedupState{}, now); loaded {
+						existing.record(nil)
+					}
+				}
+			})
+		})
+		b.Run(bc.name+"/sync_map_timers", func(b *testing.B) {
+			cache := &timerDedup{window: dedupWindow}
+			var next atomic.Uint64
+			b.ReportAllocs()
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					key := keys[next.Add(1)%uint64(len(keys))]
+					if existing, loaded := cache.loadOrStore(key, &dedupState{}); loaded {
+						existing.record(nil)
+					}
+				}
+			})
+		})
+	}
+}
+
+// BenchmarkDedupSweep is the sweeper's cost per expired key, for a window of
+// keys that arrived over the whole window
+func BenchmarkDedupSweep(b *testing.B) {
+	keys := dedupKeys(b.N)
+	start := time.Unix(1_700_000_000, 0)
+	cache := newDedupCache(dedupWindow, start)
+	for i, key := range keys {
+		cache.loadOrStore(key, &dedupState{}, start.Add(time.Duration(i)*dedupWindow/time.Duration(len(keys))))
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	expired := 0
+	for now := start; expired < len(keys); now = now.Add(dedupBucket) {
+		expired += len(cache.sweep(now))
+	}
+}
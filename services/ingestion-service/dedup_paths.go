@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	pb "stackmonitor.com/ingestion-service/proto/logproto"
+)
+
+// ingestPath is how an entry entered the pipeline. The dedup window exists to
+// thin out live bursts; applied to historical data it drops rows that were
+// legitimately repeated, so each path can bypass it (DEDUP_BYPASS_PATHS).
+type ingestPath string
+
+const (
+	pathStream   ingestPath = "stream"   // live lines on the agents' gRPC stream
+	pathBackfill ingestPath = "backfill" // rotated files an agent read at startup (field backfill=true)
+	pathReplay   ingestPath = "replay"   // rows copied by POST /admin/replay
+)
+
+// fieldBackfill marks entries the agent read from rotated files, see the agent's backfill.go
+const fieldBackfill = "backfill"
+
+const defaultDedupBypassPaths = "backfill,replay"
+
+// parseDedupBypassPaths parses DEDUP_BYPASS_PATHS, e.g. "backfill,replay"
+func parseDedupBypassPaths(raw string) (map[ingestPath]bool, error) {
+	bypass := make(map[ingestPath]bool)
+	for _, name := range strings.Split(raw, ",") {
+		path := ingestPath(strings.ToLower(strings.TrimSpace(name)))
+		switch path {
+		case "":
+			continue
+		case pathStream, pathBackfill, pathReplay:
+			bypass[path] = true
+		default:
+			return nil, fmt.Errorf("unknown path %q (want %s, %s or %s)", name, pathStream, pathBackfill, pathReplay)
+		}
+	}
+	return bypass, nil
+}
+
+// entryPath is the path of one entry; on the stream, agents mark backfilled ones
+func entryPath(entry *pb.LogEntry, path ingestPath) ingestPath {
+	if path == pathStream && entry.Fields[fieldBackfill] == "true" {
+		return pathBackfill
+	}
+	return path
+}
+
+// dedupFilter is the dedup step shared by every path: it drops duplicates
+// among entries that arrived on path, unless the path bypasses dedup, and
+// returns the entries to insert now, how many were dropped and, in count
+// mode, how many first occurrences are held until their window closes. Held
+// entries are inserted by flushCounted, outside any durable ack, which is why
+// DEDUP_MODE=count is refused with ACK_MODE=durable.
+func (s *ingestionServer) dedupFilter(entries []*pb.LogEntry, path ingestPath) (fresh []*pb.LogEntry, duplicates, held int) {
+	fresh = make([]*pb.LogEntry, 0, len(entries))
+	for _, entry := range entries {
+		if s.dedupBypass[entryPath(entry, path)] {
+			s.logsDedupBypassed.Add(1)
+		} else if s.isDuplicate(entry) {
+			duplicates++
+			s.logsDuplicate.Add(1)
+			continue
+		} else if s.dedupMode == dedupCount && !s.dedupExempt[entry.Level] {
+			held++
+			continue // held by isDuplicate and inserted when its window closes, see flushCounted
+		}
+		fresh = append(fresh, entry)
+	}
+	return fresh, duplicates, held
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"os"
+	"os/signal"
+)
+
+// SIGUSR1 makes every batchWriter insert what it holds right away instead of waiting
+// for batchTimeout or a full batch, e.g. to see a test line in ClickHouse now:
+//
+//	docker compose kill -s SIGUSR1 ingestion-service
+//
+// FLUSH_ON_SIGUSR1=false ignores the signal.
+
+// notifyFlush returns a channel receiving flush requests, or nil (never fires)
+// when disabled or unsupported on this platform. It buffers one signal, so
+// signals arriving during a flush coalesce into a single follow-up flush.
+func notifyFlush(enabled bool) <-chan os.Signal {
+	if !enabled || flushSignal == nil {
+		return nil
+	}
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, flushSignal)
+	return ch
+}
+
+// insertAll inserts buffer plus whatever is already queued in logChan and
+// returns how many entries that was. It runs on a batchWriter goroutine, so it
+// never overlaps that worker's ticker-driven insert; the other workers drain
+// logChan alongside it.
+func (s *ingestionServer) insertAll(buffer []queuedEntry) int {
+drain:
+	for n := len(s.logChan); n > 0; n-- {
+		select {
+		case entry := <-s.logChan:
+			buffer = append(buffer, entry)
+		default:
+			break drain
+		}
+	}
+
+	total := len(buffer)
+	for len(buffer) > 0 {
+		n := min(len(buffer), batchSize)
+		s.insertBatch(buffer[:n])
+		buffer = buffer[n:]
+	}
+	return total
+}
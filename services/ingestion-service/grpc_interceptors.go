@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// Interceptors GRPC_INTERCEPTORS can enable, applied in the order listed
+const (
+	interceptorMetrics      = "metrics" // per-method calls, codes and durations on /metrics
+	interceptorLogging      = "logging" // one log line per finished RPC
+	defaultGRPCInterceptors = "metrics,logging"
+)
+
+// healthMethodPrefix marks health probes (SERVING while ClickHouse answers),
+// which are only logged when they fail
+const healthMethodPrefix = "/grpc.health.v1.Health/"
+
+// parseInterceptors parses GRPC_INTERCEPTORS, e.g. "metrics,logging"; "" enables none
+func parseInterceptors(raw string) ([]string, error) {
+	var names []string
+	for _, name := range strings.Split(raw, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		switch name {
+		case "":
+			continue
+		case interceptorMetrics, interceptorLogging:
+			names = append(names, name)
+		default:
+			return nil, fmt.Errorf("unknown interceptor %q (want %s or %s)", name, interceptorMetrics, interceptorLogging)
+		}
+	}
+	return names, nil
+}
+
+// methodStats accumulates the finished RPCs of one method. StreamLogs, one
+// stream per agent connection, counts once when the agent disconnects, with
+// the connection's lifetime as the duration.
+type methodStats struct {
+	calls      uint64
+	errors     uint64
+	codes      map[string]uint64
+	totalNanos int64
+	maxNanos   int64
+}
+
+// rpcStats is filled by the metrics interceptor
+type rpcStats struct {
+	mu      sync.Mutex
+	methods map[string]*methodStats
+}
+
+func newRPCStats() *rpcStats {
+	return &rpcStats{methods: make(map[string]*methodStats)}
+}
+
+func (s *rpcStats) record(method string, code codes.Code, d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	m, ok := s.methods[method]
+	if !ok {
+		m = &methodStats{codes: make(map[string]uint64)}
+		s.methods[method] = m
+	}
+	m.calls++
+	if code != codes.OK {
+		m.errors++
+	}
+	m.codes[code.String()]++
+	m.totalNanos += int64(d)
+	m.maxNanos = max(m.maxNanos, int64(d))
+}
+
+// snapshot renders the stats for /metrics, keyed by full method name
+func (s *rpcStats) snapshot() map[string]interface{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make(map[string]interface{}, len(s.methods))
+	for method, m := range s.methods {
+		codeCounts := make(map[string]uint64, len(m.codes))
+		for code, n := range m.codes {
+			codeCounts[code] = n
+		}
+		out[method] = map[string]interface{}{
+			"calls":           m.calls,
+			"errors":          m.errors,
+			"codes":           codeCounts,
+			"avg_duration_ms": float64(m.totalNanos) / float64(m.calls) / 1e6,
+			"max_duration_ms": float64(m.maxNanos) / 1e6,
+		}
+	}
+	return out
+}
+
+// rpcObserver is what an interceptor does once an RPC has finished
+type rpcObserver func(ctx context.Context, method string, err error, d time.Duration)
+
+func metricsObserver(stats *rpcStats) rpcObserver {
+	return func(_ context.Context, method string, err error, d time.Duration) {
+		stats.record(method, status.Code(err), d)
+	}
+}
+
+func loggingObserver(ctx context.Context, method string, err error, d time.Duration) {
+	code := status.Code(err)
+	if code == codes.OK && strings.HasPrefix(method, healthMethodPrefix) {
+		return
+	}
+	client := "unknown"
+	if p, ok := peer.FromContext(ctx); ok {
+		client = p.Addr.String()
+	}
+	if err != nil {
+		log.Printf("grpc method=%s code=%s duration=%s peer=%s error=%q", method, code, d.Round(time.Microsecond), client, status.Convert(err).Message())
+		return
+	}
+	log.Printf("grpc method=%s code=%s duration=%s peer=%s", method, code, d.Round(time.Microsecond), client)
+}
+
+// interceptorOptions returns the server options installing the named
+// interceptors around every unary and stream RPC
+func interceptorOptions(names []string, stats *rpcStats) []grpc.ServerOption {
+	var unary []grpc.UnaryServerInterceptor
+	var stream []grpc.StreamServerInterceptor
+	for _, name := range names {
+		observe := rpcObserver(loggingObserver)
+		if name == interceptorMetrics {
+			observe = metricsObserver(stats)
+		}
+		unary = append(unary, func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+			start := time.Now()
+			resp, err := handler(ctx, req)
+			observe(ctx, info.FullMethod, err, time.Since(start))
+			return resp, err
+		})
+		stream = append(stream, func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+			start := time.Now()
+			err := handler(srv, ss)
+			observe(ss.Context(), info.FullMethod, err, time.Since(start))
+			return err
+		})
+	}
+	if len(names) == 0 {
+		return nil
+	}
+	return []grpc.ServerOption{grpc.ChainUnaryInterceptor(unary...), grpc.ChainStreamInterceptor(stream...)}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+
+	pb "stackmonitor.com/ingestion-service/proto/logproto"
+)
+
+const (
+	healthCheckInterval = 10 * time.Second
+	healthCheckTimeout  = 5 * time.Second
+)
+
+// watchClickHouse keeps the standard gRPC health service in step with
+// ClickHouse: SERVING while it answers pings, NOT_SERVING otherwise. Both the
+// overall status ("") and the LogIngestion service are reported.
+func (s *ingestionServer) watchClickHouse(hs *health.Server) {
+	last := healthpb.HealthCheckResponse_UNKNOWN
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), healthCheckTimeout)
+		err := s.db.Ping(ctx)
+		cancel()
+
+		status := healthpb.HealthCheckResponse_SERVING
+		if err != nil {
+			status = healthpb.HealthCheckResponse_NOT_SERVING
+		}
+		if status != last {
+			if err != nil {
+				log.Printf("⚠️  gRPC health NOT_SERVING: ClickHouse ping failed: %v", err)
+			} else {
+				log.Println("gRPC health SERVING")
+			}
+			last = status
+		}
+		hs.SetServingStatus("", status)
+		hs.SetServingStatus(pb.LogIngestion_ServiceDesc.ServiceName, status)
+
+		time.Sleep(healthCheckInterval)
+	}
+}
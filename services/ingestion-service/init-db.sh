@@ -1,9 +1,15 @@
 #!/bin/bash
 set -e
 
+# Same credentials / TLS settings as the services (CH_USER, CH_PASSWORD, CH_TLS)
+CH_ARGS=(--host clickhouse)
+[ -n "${CH_USER}" ] && CH_ARGS+=(--user "${CH_USER}")
+[ -n "${CH_PASSWORD}" ] && CH_ARGS+=(--password "${CH_PASSWORD}")
+[ "${CH_TLS}" = "true" ] && CH_ARGS+=(--secure)
+
 echo "Waiting for ClickHouse to be ready..."
 for i in {1..30}; do
-    if clickhouse-client --host clickhouse --query "SELECT 1" 2>/dev/null; then
+    if clickhouse-client "${CH_ARGS[@]}" --query "SELECT 1" 2>/dev/null; then
         echo "ClickHouse is ready!"
         break
     fi
@@ -11,14 +17,18 @@ for i in {1..30}; do
     sleep 1
 done
 
+# Must match CH_DATABASE / CH_TABLE of the ingestion-service and api-server
+DB="${CH_DATABASE:-stackmonitor}"
+TABLE="${CH_TABLE:-logs}"
+
 # Create database
 echo "Creating database..."
-clickhouse-client --host clickhouse --query "CREATE DATABASE IF NOT EXISTS stackmonitor"
+clickhouse-client "${CH_ARGS[@]}" --query "CREATE DATABASE IF NOT EXISTS ${DB}"
 
 # Create table
 echo "Creating table..."
-clickhouse-client --host clickhouse --query "
-CREATE TABLE IF NOT EXISTS stackmonitor.logs (
+clickhouse-client "${CH_ARGS[@]}" --query "
+CREATE TABLE IF NOT EXISTS ${DB}.${TABLE} (
     timestamp DateTime64(3),
     level String,
     service String,
@@ -26,13 +36,58 @@ CREATE TABLE IF NOT EXISTS stackmonitor.logs (
     trace_id String,
     agent_id String,
     metadata Map(String, String),
+    ingested_at DateTime64(3) DEFAULT timestamp,
     INDEX message_idx message TYPE tokenbf_v1(10240, 3, 0) GRANULARITY 1
 ) ENGINE = MergeTree()
 ORDER BY (timestamp, service)
 TTL timestamp + INTERVAL 7 DAY
 "
 
+# Per-minute counts by service and level for the api-server's stats and
+# error-rate queries. Every table logs are routed to (ROUTE_RULES) needs its
+# own <table>_counts_1m_mv into the same counts table, otherwise the
+# api-server keeps scanning raw logs.
+COUNTS_EXISTS=$(clickhouse-client "${CH_ARGS[@]}" --query "EXISTS TABLE ${DB}.${TABLE}_counts_1m")
+echo "Creating aggregate views..."
+clickhouse-client "${CH_ARGS[@]}" --query "
+CREATE TABLE IF NOT EXISTS ${DB}.${TABLE}_counts_1m (
+    minute DateTime,
+    service LowCardinality(String),
+    level LowCardinality(String),
+    count UInt64
+) ENGINE = SummingMergeTree()
+ORDER BY (minute, service, level)
+TTL minute + INTERVAL 7 DAY
+"
+clickhouse-client "${CH_ARGS[@]}" --query "
+CREATE MATERIALIZED VIEW IF NOT EXISTS ${DB}.${TABLE}_counts_1m_mv TO ${DB}.${TABLE}_counts_1m AS
+SELECT toStartOfMinute(timestamp) AS minute, service, level, count() AS count
+FROM ${DB}.${TABLE}
+GROUP BY minute, service, level
+"
+if [ "${COUNTS_EXISTS}" = "0" ]; then
+    # The view only sees new inserts; count the rows already stored once
+    echo "Backfilling aggregate counts..."
+    clickhouse-client "${CH_ARGS[@]}" --query "
+    INSERT INTO ${DB}.${TABLE}_counts_1m
+    SELECT toStartOfMinute(timestamp) AS minute, service, level, count() AS count
+    FROM ${DB}.${TABLE}
+    GROUP BY minute, service, level
+    "
+fi
+
+# Agent registry: latest heartbeat per agent, read by /api/v1/agents/status
+clickhouse-client "${CH_ARGS[@]}" --query "
+CREATE TABLE IF NOT EXISTS ${DB}.agents (
+    agent_id String,
+    hostname String,
+    version String,
+    last_seen DateTime64(3)
+) ENGINE = ReplacingMergeTree(last_seen)
+ORDER BY agent_id
+"
+
 echo "ClickHouse database and table initialized successfully!"
 echo "Verifying table exists..."
-clickhouse-client --host clickhouse --query "SELECT count() FROM stackmonitor.logs"
+clickhouse-client "${CH_ARGS[@]}" --query "SELECT count() FROM ${DB}.${TABLE}"
 
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// The insert breaker stops the service from taking batches it can't store.
+// After INSERT_BREAKER_FAILURES consecutive failed ClickHouse inserts (default
+// 3, 0 disables) it opens for INSERT_BREAKER_COOLDOWN (default 30s): StreamLogs
+// then answers every batch with RETRY and retry_after_ms, before decompressing
+// or deduplicating it, and agents pause sending instead of pushing doomed
+// batches. Once the cooldown is over batches are accepted again; the next
+// insert closes the breaker when it succeeds and reopens it when it fails.
+const (
+	defaultBreakerFailures = 3
+	defaultBreakerCooldown = 30 * time.Second
+)
+
+type insertBreaker struct {
+	threshold int
+	cooldown  time.Duration
+
+	mu        sync.Mutex
+	failures  int // consecutive failed inserts
+	openUntil time.Time
+
+	trips     atomic.Uint64 // times the breaker opened
+	throttled atomic.Uint64 // batches answered RETRY while open
+}
+
+func newInsertBreaker(threshold int, cooldown time.Duration) *insertBreaker {
+	return &insertBreaker{threshold: threshold, cooldown: cooldown}
+}
+
+// record feeds one insert result to the breaker
+func (b *insertBreaker) record(err error, now time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err == nil {
+		if b.failures >= b.threshold {
+			log.Printf("✅ ClickHouse inserts recovered, accepting batches again")
+		}
+		b.failures = 0
+		b.openUntil = time.Time{}
+		return
+	}
+	b.failures++
+	if b.failures >= b.threshold {
+		if !now.Before(b.openUntil) {
+			b.trips.Add(1)
+			log.Printf("⚠️  %d consecutive inserts failed, rejecting batches for %s", b.failures, b.cooldown)
+		}
+		b.openUntil = now.Add(b.cooldown)
+	}
+}
+
+// retryAfter is how long agents should hold off, 0 while batches are accepted
+func (b *insertBreaker) retryAfter(now time.Time) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if now.Before(b.openUntil) {
+		return b.openUntil.Sub(now)
+	}
+	return 0
+}
+
+// state is closed, open, or half-open while the first insert after a cooldown is pending
+func (b *insertBreaker) state(now time.Time) string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch {
+	case now.Before(b.openUntil):
+		return "open"
+	case b.failures >= b.threshold:
+		return "half-open"
+	}
+	return "closed"
+}
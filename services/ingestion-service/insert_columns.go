@@ -0,0 +1,197 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	pb "stackmonitor.com/ingestion-service/proto/logproto"
+)
+
+// columnValue produces one column's value for an entry
+type columnValue func(entry *pb.LogEntry, ingestedAt time.Time) interface{}
+
+// builtinColumn is a column the service knows how to fill, with the
+// ClickHouse types it can be written to
+type builtinColumn struct {
+	types []string // accepted types; LowCardinality(...) wrappers are stripped first
+	value columnValue
+}
+
+var builtinColumns = map[string]builtinColumn{
+	"timestamp": {[]string{"DateTime64", "DateTime"}, func(e *pb.LogEntry, _ time.Time) interface{} {
+		return time.Unix(0, e.TimestampNs)
+	}},
+	"level": {[]string{"String"}, func(e *pb.LogEntry, _ time.Time) interface{} { return e.Level }},
+	"service": {[]string{"String"}, func(e *pb.LogEntry, _ time.Time) interface{} {
+		if service := e.Fields["service"]; service != "" {
+			return service
+		}
+		return "unknown"
+	}},
+	"message":  {[]string{"String"}, func(e *pb.LogEntry, _ time.Time) interface{} { return e.Message }},
+	"trace_id": {[]string{"String"}, func(e *pb.LogEntry, _ time.Time) interface{} { return e.Fields["trace_id"] }},
+	"agent_id": {[]string{"String"}, func(e *pb.LogEntry, _ time.Time) interface{} { return e.AgentId }},
+	"source":   {[]string{"String"}, func(e *pb.LogEntry, _ time.Time) interface{} { return e.Source }},
+	// All structured fields; the agents' fields double as the metadata map
+	"metadata": {[]string{"Map(String, String)"}, func(e *pb.LogEntry, _ time.Time) interface{} { return e.Fields }},
+	// Server-side ingestion time, so backfilled logs with old event timestamps
+	// can still be found by ingestion-time queries and the live tail
+	"ingested_at": {[]string{"DateTime64", "DateTime"}, func(_ *pb.LogEntry, ingestedAt time.Time) interface{} {
+		return ingestedAt
+	}},
+}
+
+// tableInsert is the column list and value sources used to insert into one table
+type tableInsert struct {
+	columns []string
+	values  []columnValue
+}
+
+// insertSchemas maps each target table to how rows are written to it. Tables
+// are introspected from system.columns instead of assuming a fixed tuple, so
+// adding a column such as tenant_id needs no code change:
+//
+//   - known columns (builtinColumns) get their usual value
+//   - String columns listed in INSERT_FIELD_MAP (e.g. "tenant_id=tenant")
+//     take that entry field; other String columns without a default take
+//     the field of the same name
+//   - remaining columns with a default, nullable columns and
+//     MATERIALIZED/ALIAS columns are left to ClickHouse
+//
+// Anything else (a required column of another type) fails at startup.
+type insertSchemas struct {
+	fieldMap map[string]string // column -> entry field
+
+	mu      sync.RWMutex
+	byTable map[string]*tableInsert
+}
+
+func newInsertSchemas(fieldMap map[string]string) *insertSchemas {
+	return &insertSchemas{fieldMap: fieldMap, byTable: make(map[string]*tableInsert)}
+}
+
+// parseInsertFieldMap parses INSERT_FIELD_MAP, e.g. "tenant_id=tenant,region=aws_region"
+func parseInsertFieldMap(raw string) (map[string]string, error) {
+	fieldMap := make(map[string]string)
+	for _, spec := range strings.Split(raw, ",") {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+		column, field, ok := strings.Cut(spec, "=")
+		if !ok || column == "" || field == "" {
+			return nil, fmt.Errorf("mapping %q: want column=field", spec)
+		}
+		if !identifierRegex.MatchString(column) {
+			return nil, fmt.Errorf("mapping %q: invalid column name %q", spec, column)
+		}
+		if _, ok := builtinColumns[column]; ok {
+			return nil, fmt.Errorf("mapping %q: %s is a built-in column", spec, column)
+		}
+		fieldMap[column] = field
+	}
+	return fieldMap, nil
+}
+
+// load introspects table and stores its insert plan, failing with a clear
+// error when a required column can't be filled
+func (m *insertSchemas) load(ctx context.Context, s *ingestionServer, table string) (*tableInsert, error) {
+	rows, err := s.db.Query(ctx, `
+		SELECT name, type, default_kind
+		FROM system.columns
+		WHERE database = ? AND table = ?
+		ORDER BY position`, database, table)
+	if err != nil {
+		return nil, fmt.Errorf("read columns of %s: %w", table, err)
+	}
+	defer rows.Close()
+
+	plan := &tableInsert{}
+	var skipped []string
+	for rows.Next() {
+		var name, typ, defaultKind string
+		if err := rows.Scan(&name, &typ, &defaultKind); err != nil {
+			return nil, fmt.Errorf("read columns of %s: %w", table, err)
+		}
+		if defaultKind == "MATERIALIZED" || defaultKind == "ALIAS" || defaultKind == "EPHEMERAL" {
+			continue // computed by ClickHouse, can't be inserted
+		}
+		optional := defaultKind != "" || strings.HasPrefix(typ, "Nullable(")
+		base := typ
+		if strings.HasPrefix(typ, "LowCardinality(") {
+			base = strings.TrimSuffix(strings.TrimPrefix(typ, "LowCardinality("), ")")
+		}
+
+		if builtin, ok := builtinColumns[name]; ok {
+			if !typeAccepted(base, builtin.types) {
+				if optional {
+					skipped = append(skipped, name)
+					continue
+				}
+				return nil, fmt.Errorf("column %s.%s has type %s, want one of %v", table, name, typ, builtin.types)
+			}
+			plan.columns = append(plan.columns, name)
+			plan.values = append(plan.values, builtin.value)
+			continue
+		}
+
+		mapped, isMapped := m.fieldMap[name]
+		if base == "String" && (isMapped || !optional) {
+			field := name
+			if isMapped {
+				field = mapped
+			}
+			plan.columns = append(plan.columns, name)
+			plan.values = append(plan.values, func(e *pb.LogEntry, _ time.Time) interface{} { return e.Fields[field] })
+			continue
+		}
+		if optional {
+			skipped = append(skipped, name)
+			continue
+		}
+		return nil, fmt.Errorf("column %s.%s (%s) has no default and no value source; give it a DEFAULT or make it a String filled from an entry field", table, name, typ)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("read columns of %s: %w", table, err)
+	}
+	if len(plan.columns) == 0 {
+		return nil, fmt.Errorf("table %s.%s not found or has no insertable columns", database, table)
+	}
+
+	log.Printf("Insert columns for %s: %s", table, strings.Join(plan.columns, ", "))
+	if len(skipped) > 0 {
+		log.Printf("Leaving %s columns to their defaults: %s", table, strings.Join(skipped, ", "))
+	}
+
+	m.mu.Lock()
+	m.byTable[table] = plan
+	m.mu.Unlock()
+	return plan, nil
+}
+
+// get returns the insert plan for table, introspecting it on first use
+// (tables created after startup, such as replay targets)
+func (m *insertSchemas) get(ctx context.Context, s *ingestionServer, table string) (*tableInsert, error) {
+	m.mu.RLock()
+	plan, ok := m.byTable[table]
+	m.mu.RUnlock()
+	if ok {
+		return plan, nil
+	}
+	return m.load(ctx, s, table)
+}
+
+// typeAccepted matches a column type against accepted type names; DateTime64(3)
+// and DateTime('UTC') match DateTime64 and DateTime respectively
+func typeAccepted(typ string, accepted []string) bool {
+	for _, want := range accepted {
+		if typ == want || strings.HasPrefix(typ, want+"(") {
+			return true
+		}
+	}
+	return false
+}
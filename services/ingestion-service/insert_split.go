@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/bits"
+
+	"github.com/ClickHouse/clickhouse-go/v2"
+
+	pb "stackmonitor.com/ingestion-service/proto/logproto"
+)
+
+// When a batch fails because of its rows (a value the driver can't convert or
+// ClickHouse refuses, such as a bad type or a violated constraint) rather than
+// because ClickHouse is unreachable or overloaded, insertInto retries it in
+// halves: halves that insert are kept and failing ones are split again, at
+// most INSERT_SPLIT_DEPTH times (default: down to single rows of a BATCH_SIZE
+// batch). Rows still failing at the bottom go to DEAD_LETTER_TABLE with the
+// error, so one bad entry no longer sends its whole batch back to the agent.
+// INSERT_SPLIT_DEPTH=0 fails the batch as a whole; other errors always do. An
+// error that isn't about the rows during the split only fails the entries not
+// yet stored: the halves already inserted or dead-lettered are kept.
+const defaultDeadLetterTable = "dead_letters"
+
+// errAppend wraps a row the driver couldn't encode for its column
+var errAppend = errors.New("append")
+
+// rowErrorCodes are the ClickHouse exceptions caused by the data sent,
+// which splitting the batch can isolate
+var rowErrorCodes = map[int32]bool{
+	6:   true, // CANNOT_PARSE_TEXT
+	26:  true, // CANNOT_PARSE_QUOTED_STRING
+	27:  true, // CANNOT_PARSE_INPUT_ASSERTION_FAILED
+	38:  true, // CANNOT_PARSE_DATE
+	41:  true, // CANNOT_PARSE_DATETIME
+	53:  true, // TYPE_MISMATCH
+	69:  true, // ARGUMENT_OUT_OF_BOUND
+	70:  true, // CANNOT_CONVERT_TYPE
+	72:  true, // CANNOT_PARSE_NUMBER
+	117: true, // INCORRECT_DATA
+	131: true, // TOO_LARGE_STRING_SIZE
+	321: true, // VALUE_IS_OUT_OF_RANGE_OF_DATA_TYPE
+	349: true, // CANNOT_INSERT_NULL_IN_ORDINARY_COLUMN
+	469: true, // VIOLATED_CONSTRAINT
+}
+
+// isRowError reports whether err was caused by the rows of the batch
+func isRowError(err error) bool {
+	if errors.Is(err, errAppend) {
+		return true
+	}
+	var ex *clickhouse.Exception
+	return errors.As(err, &ex) && rowErrorCodes[ex.Code]
+}
+
+// defaultSplitDepth is how many halvings reach single rows of a batchSize batch
+func defaultSplitDepth(batchSize int) int {
+	return bits.Len(uint(batchSize - 1))
+}
+
+// deadLetterEntry is a rejected entry as stored, with the LogEntry field names
+type deadLetterEntry struct {
+	TimestampNs int64             `json:"timestamp_ns"`
+	Level       string            `json:"level"`
+	Message     string            `json:"message"`
+	Source      string            `json:"source,omitempty"`
+	Fields      map[string]string `json:"fields,omitempty"`
+	AgentID     string            `json:"agent_id,omitempty"`
+}
+
+// rejectedRow is an entry ClickHouse refused, with the error of its last attempt
+type rejectedRow struct {
+	entry *pb.LogEntry
+	err   error
+}
+
+// insertSplit inserts the halves of logs, which failed as a whole with cause,
+// splitting failing halves further while depth lasts. It returns the entries
+// inserted and those still rejected; an error that isn't about the rows stops
+// it and is returned.
+func (s *ingestionServer) insertSplit(ctx context.Context, table string, logs []*pb.LogEntry, depth int, cause error) (inserted []*pb.LogEntry, rejected []rejectedRow, err error) {
+	if len(logs) == 1 || depth == 0 {
+		for _, entry := range logs {
+			rejected = append(rejected, rejectedRow{entry: entry, err: cause})
+		}
+		return nil, rejected, nil
+	}
+	mid := len(logs) / 2
+	for _, part := range [][]*pb.LogEntry{logs[:mid], logs[mid:]} {
+		err := s.writeRows(ctx, table, part)
+		if err == nil {
+			inserted = append(inserted, part...)
+			continue
+		}
+		if !isRowError(err) {
+			return inserted, rejected, err
+		}
+		partInserted, partRejected, err := s.insertSplit(ctx, table, part, depth-1, err)
+		inserted = append(inserted, partInserted...)
+		rejected = append(rejected, partRejected...)
+		if err != nil {
+			return inserted, rejected, err
+		}
+	}
+	return inserted, rejected, nil
+}
+
+// unsettled returns the entries of logs that a split cut short left neither
+// inserted nor rejected
+func unsettled(logs, inserted []*pb.LogEntry, rejected []rejectedRow) []*pb.LogEntry {
+	settled := make(map[*pb.LogEntry]bool, len(inserted)+len(rejected))
+	for _, entry := range inserted {
+		settled[entry] = true
+	}
+	for _, row := range rejected {
+		settled[row.entry] = true
+	}
+	var rest []*pb.LogEntry
+	for _, entry := range logs {
+		if !settled[entry] {
+			rest = append(rest, entry)
+		}
+	}
+	return rest
+}
+
+// deadLetter stores rows rejected by table in the dead-letter table. They are
+// dropped, with a log line, when it is disabled or the write fails: they
+// would be rejected again if the agent resent them.
+func (s *ingestionServer) deadLetter(table string, rows []rejectedRow) {
+	if len(rows) == 0 {
+		return
+	}
+	s.logsRejected.Add(uint64(len(rows)))
+	if s.deadLetterTable == "" {
+		log.Printf("⚠️  Dropped %d logs rejected by %s: %v", len(rows), table, rows[0].err)
+		return
+	}
+	if err := s.writeDeadLetters(context.Background(), table, rows); err != nil {
+		s.deadLetterErrors.Add(1)
+		log.Printf("❌ Failed to dead-letter %d logs rejected by %s, dropping them: %v", len(rows), table, err)
+		return
+	}
+	log.Printf("⚠️  Dead-lettered %d logs rejected by %s into %s: %v", len(rows), table, s.deadLetterTable, rows[0].err)
+}
+
+func (s *ingestionServer) writeDeadLetters(ctx context.Context, table string, rows []rejectedRow) error {
+	batch, err := s.db.PrepareBatch(ctx, fmt.Sprintf("INSERT INTO %s.%s", database, s.deadLetterTable))
+	if err != nil {
+		return err
+	}
+	defer batch.Abort()
+	deadAt := s.clock.Now()
+	for _, row := range rows {
+		entry, err := json.Marshal(deadLetterEntry{
+			TimestampNs: row.entry.TimestampNs,
+			Level:       row.entry.Level,
+			Message:     row.entry.Message,
+			Source:      row.entry.Source,
+			Fields:      row.entry.Fields,
+			AgentID:     row.entry.AgentId,
+		})
+		if err != nil {
+			return fmt.Errorf("encode entry: %w", err)
+		}
+		if err := batch.Append(deadAt, table, row.err.Error(), string(entry)); err != nil {
+			return err
+		}
+	}
+	return batch.Send()
+}
+
+// ensureDeadLetterTable creates the dead-letter table: each rejected entry as
+// JSON, with the table it was meant for and why it was refused
+func (s *ingestionServer) ensureDeadLetterTable(ctx context.Context) error {
+	query := fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s.%s (
+		dead_at DateTime64(3),
+		target_table String,
+		error String,
+		entry String
+	) ENGINE = MergeTree ORDER BY dead_at
+	TTL toDateTime(dead_at) + INTERVAL 30 DAY`, database, s.deadLetterTable)
+	if err := s.db.Exec(ctx, query); err != nil {
+		return fmt.Errorf("create table %s: %w", s.deadLetterTable, err)
+	}
+	return nil
+}
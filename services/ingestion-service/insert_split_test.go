@@ -0,0 +1,130 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/ClickHouse/clickhouse-go/v2"
+	"google.golang.org/protobuf/proto"
+
+	pb "stackmonitor.com/ingestion-service/proto/logproto"
+)
+
+var (
+	errTypeMismatch = &clickhouse.Exception{Code: 53, Message: "type mismatch"}
+	errConnReset    = errors.New("read: connection reset by peer")
+)
+
+// splitLogs returns n distinct entries
+func splitLogs(n int) []*pb.LogEntry {
+	logs := make([]*pb.LogEntry, n)
+	for i := range logs {
+		logs[i] = &pb.LogEntry{TimestampNs: int64(i), Level: "INFO", Message: fmt.Sprintf("entry %d", i), AgentId: "agent-1"}
+	}
+	return logs
+}
+
+func TestInsertIntoSplitCutShort(t *testing.T) {
+	defer log.SetOutput(log.Writer())
+	log.SetOutput(io.Discard)
+
+	tests := []struct {
+		name         string
+		depth        int
+		errs         []error // successive Sends: the whole batch, then each half in order
+		wantErr      bool
+		wantFailed   []int // indices of the entries left for the agent to resend
+		wantInserted uint64
+		wantRejected uint64
+	}{
+		{name: "not a row error", depth: 2, errs: []error{errConnReset},
+			wantErr: true, wantFailed: []int{0, 1, 2, 3}},
+		{name: "connection lost on the second half", depth: 2, errs: []error{errTypeMismatch, nil, errConnReset},
+			wantErr: true, wantFailed: []int{2, 3}, wantInserted: 2},
+		{name: "rejected half kept when the connection is lost", depth: 1, errs: []error{errTypeMismatch, errTypeMismatch, errConnReset},
+			wantErr: true, wantFailed: []int{2, 3}, wantRejected: 2},
+		{name: "connection lost deeper in the split", depth: 2, errs: []error{errTypeMismatch, nil, errTypeMismatch, nil, errConnReset},
+			wantErr: true, wantFailed: []int{3}, wantInserted: 3},
+		{name: "split completes", depth: 2, errs: []error{errTypeMismatch, nil, errTypeMismatch, nil, errTypeMismatch},
+			wantErr: false, wantInserted: 3, wantRejected: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := &fakeConn{errs: tt.errs}
+			s := benchServer(db)
+			s.splitDepth = tt.depth
+			logs := splitLogs(4)
+
+			failed, err := s.insertInto(logsTable, logs)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error: %v", err, tt.wantErr)
+			}
+			var want []*pb.LogEntry
+			for _, i := range tt.wantFailed {
+				want = append(want, logs[i])
+			}
+			if !slices.Equal(failed, want) {
+				t.Errorf("failed = %v, want %v", failed, want)
+			}
+			if got := s.logsInserted.Load(); got != tt.wantInserted || db.rows.Load() != tt.wantInserted {
+				t.Errorf("logs_inserted %d, rows written %d, want %d", got, db.rows.Load(), tt.wantInserted)
+			}
+			if got := s.logsRejected.Load(); got != tt.wantRejected {
+				t.Errorf("logs_rejected = %d, want %d", got, tt.wantRejected)
+			}
+		})
+	}
+}
+
+func TestResendSkipsStoredEntries(t *testing.T) {
+	defer log.SetOutput(log.Writer())
+	log.SetOutput(io.Discard)
+
+	db := &fakeConn{errs: []error{errTypeMismatch, nil, errConnReset}}
+	s := benchServer(db)
+	s.splitDepth = 2
+	s.resend = newStoredEntries()
+	logs := splitLogs(4)
+
+	ack := newBatchAck(len(logs))
+	queued := make([]queuedEntry, len(logs))
+	for i, entry := range logs {
+		queued[i] = queuedEntry{entry: entry, ack: ack}
+	}
+	s.insertBatch(queued)
+	if err := ack.wait(time.Second); err == nil {
+		t.Fatal("ack succeeded, want the insert error")
+	}
+	stored := ack.storedEntries()
+	if !slices.Equal(stored, logs[:2]) {
+		t.Fatalf("stored %v, want the first half", stored)
+	}
+
+	// The agent resends copies of the whole batch: only the second half is left
+	now := time.Now()
+	s.resend.remember("agent-1", stored, now)
+	resent := make([]*pb.LogEntry, len(logs))
+	for i, entry := range logs {
+		resent[i] = proto.Clone(entry).(*pb.LogEntry)
+	}
+	rest, skipped := s.resend.skip("agent-1", resent, now)
+	if skipped != 2 || !slices.Equal(rest, resent[2:]) {
+		t.Errorf("skip kept %v (skipped %d), want the second half", rest, skipped)
+	}
+	// Each stored entry is skipped once; other agents are unaffected
+	if rest, skipped := s.resend.skip("agent-1", resent, now); skipped != 0 || len(rest) != len(resent) {
+		t.Errorf("second resend skipped %d, want 0", skipped)
+	}
+	s.resend.remember("agent-1", stored, now)
+	if _, skipped := s.resend.skip("agent-2", resent, now); skipped != 0 {
+		t.Errorf("agent-2 skipped %d, want 0", skipped)
+	}
+	if _, skipped := s.resend.skip("agent-1", resent, now.Add(storedEntryTTL+time.Second)); skipped != 0 {
+		t.Errorf("skipped %d after the TTL, want 0", skipped)
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+// INSERT_WORKERS batchWriter goroutines (default 4) consume logChan, each into
+// its own buffer, so a slow ClickHouse insert holds up only its worker while
+// the others keep draining logChan. Each worker inserts at batchSize or after
+// batchTimeout like a single writer did, so at low volume there can be up to
+// one insert per worker per batchTimeout; INSERT_WORKERS=1 is the old single
+// writer. The ClickHouse pool is sized so every worker can hold a connection
+// next to replay jobs and health checks, see insertConnLimits.
+const (
+	defaultInsertWorkers = 4
+	// Connections kept for replay jobs, schema introspection and health checks
+	reservedInsertConns = 5
+)
+
+// insertConnLimits returns the ClickHouse MaxOpenConns and MaxIdleConns for
+// workers, never below the driver's defaults (10 and 5)
+func insertConnLimits(workers int) (maxOpen, maxIdle int) {
+	return max(10, workers+reservedInsertConns), max(5, workers)
+}
+
+// startInsertWorkers starts the batchWriter pool and, with FLUSH_ON_SIGUSR1,
+// forwards each SIGUSR1 to every worker. The returned stop makes the workers
+// insert their buffers and everything left in logChan, and waits for them.
+func (s *ingestionServer) startInsertWorkers(n int) (stop func()) {
+	done := make(chan struct{})
+	var workers sync.WaitGroup
+	flushes := make([]chan struct{}, n)
+	for i := range flushes {
+		// One pending request per worker, so signals during a flush coalesce
+		flushes[i] = make(chan struct{}, 1)
+		workers.Add(1)
+		go func(flush <-chan struct{}) {
+			defer workers.Done()
+			s.batchWriter(flush, done)
+		}(flushes[i])
+	}
+	stop = func() {
+		close(done)
+		workers.Wait()
+	}
+	forceFlush := notifyFlush(s.flushOnSignal)
+	if forceFlush == nil {
+		return stop
+	}
+	go func() {
+		for range forceFlush {
+			s.forcedFlushes.Add(1)
+			for _, flush := range flushes {
+				select {
+				case flush <- struct{}{}:
+				default:
+				}
+			}
+		}
+	}()
+	return stop
+}
+
+// Batch writer for ClickHouse, one per insert worker; returns once done is
+// closed and logChan is drained
+func (s *ingestionServer) batchWriter(forceFlush, done <-chan struct{}) {
+	ticker := time.NewTicker(batchTimeout)
+	defer ticker.Stop()
+	buffer := make([]queuedEntry, 0, batchSize)
+
+	for {
+		select {
+		case entry := <-s.logChan:
+			buffer = append(buffer, entry)
+			if len(buffer) >= batchSize {
+				s.insertBatch(buffer)
+				buffer = make([]queuedEntry, 0, batchSize)
+			}
+		case <-ticker.C:
+			if len(buffer) > 0 {
+				s.insertBatch(buffer)
+				buffer = make([]queuedEntry, 0, batchSize)
+			}
+		case <-forceFlush:
+			log.Printf("🚿 SIGUSR1: forcing insert of %d buffered logs", s.insertAll(buffer))
+			buffer = make([]queuedEntry, 0, batchSize)
+		case <-done:
+			if n := s.insertAll(buffer); n > 0 {
+				log.Printf("Shutdown: inserted the last %d queued logs", n)
+			}
+			return
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ClickHouse/clickhouse-go/v2/lib/driver"
+
+	pb "stackmonitor.com/ingestion-service/proto/logproto"
+)
+
+// fakeConn stands in for ClickHouse: every batch Send takes latency, like an
+// insert round trip, and the rows are only counted
+type fakeConn struct {
+	driver.Conn
+	latency time.Duration
+	rows    atomic.Uint64
+	sends   atomic.Uint64
+
+	mu   sync.Mutex
+	errs []error // returned by successive Sends, then nil
+}
+
+func (c *fakeConn) PrepareBatch(ctx context.Context, query string, opts ...driver.PrepareBatchOption) (driver.Batch, error) {
+	return &fakeBatch{conn: c}, nil
+}
+
+type fakeBatch struct {
+	driver.Batch
+	conn *fakeConn
+	rows uint64
+}
+
+func (b *fakeBatch) Append(v ...any) error { b.rows++; return nil }
+func (b *fakeBatch) Abort() error          { return nil }
+func (b *fakeBatch) Send() error {
+	time.Sleep(b.conn.latency)
+	b.conn.mu.Lock()
+	var err error
+	if len(b.conn.errs) > 0 {
+		err, b.conn.errs = b.conn.errs[0], b.conn.errs[1:]
+	}
+	b.conn.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	b.conn.rows.Add(b.rows)
+	b.conn.sends.Add(1)
+	return nil
+}
+
+// benchServer is an ingestionServer inserting into db, with the logs table
+// planned as the default schema so nothing is introspected
+func benchServer(db driver.Conn) *ingestionServer {
+	plan := &tableInsert{}
+	for _, column := range []string{"timestamp", "level", "service", "message", "trace_id", "agent_id", "metadata", "ingested_at"} {
+		plan.columns = append(plan.columns, column)
+		plan.values = append(plan.values, builtinColumns[column].value)
+	}
+	schemas := newInsertSchemas(nil)
+	schemas.byTable[logsTable] = plan
+	return &ingestionServer{
+		db:         db,
+		logChan:    make(chan queuedEntry, 10000),
+		schemas:    schemas,
+		e2eLatency: newLatencyWindow(1000),
+		clock:      realClock{},
+	}
+}
+
+// BenchmarkInsertWorkers pushes logs through logChan into a ClickHouse stand-in
+// whose inserts take 5ms, with 1 to 8 insert workers. ns/op is per log.
+func BenchmarkInsertWorkers(b *testing.B) {
+	defer log.SetOutput(log.Writer())
+	log.SetOutput(io.Discard)
+	entry := &pb.LogEntry{TimestampNs: time.Now().UnixNano(), Level: "INFO", Message: "request served in 12ms",
+		AgentId: "agent-1", Fields: map[string]string{"service": "api-gateway", "trace_id": "trace-1"}}
+
+	for _, workers := range []int{1, 2, 4, 8} {
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			db := &fakeConn{latency: 5 * time.Millisecond}
+			s := benchServer(db)
+			flushes := make([]chan struct{}, workers)
+			for i := range flushes {
+				flushes[i] = make(chan struct{}, 1)
+				go s.batchWriter(flushes[i], nil)
+			}
+
+			b.ResetTimer()
+			start := time.Now()
+			for i := 0; i < b.N; i++ {
+				s.logChan <- queuedEntry{entry: entry}
+			}
+			// The last partial batches would wait for batchTimeout: flush them
+			for db.rows.Load() < uint64(b.N) {
+				for _, flush := range flushes {
+					select {
+					case flush <- struct{}{}:
+					default:
+					}
+				}
+				time.Sleep(time.Millisecond)
+			}
+			b.ReportMetric(float64(b.N)/time.Since(start).Seconds(), "logs/s")
+			b.ReportMetric(float64(b.N)/float64(db.sends.Load()), "logs/insert")
+		})
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/keepalive"
+)
+
+// gRPC keepalive for agent streams, matching the agents' settings:
+//
+//   - GRPC_KEEPALIVE_TIME: ping a connection idle for this long (default 30s,
+//     0 keeps gRPC's 2h default)
+//   - GRPC_KEEPALIVE_TIMEOUT: close it when the ping isn't answered within
+//     this (default 10s), so streams of vanished agents are released
+//   - GRPC_KEEPALIVE_MIN_TIME: the most frequent client pings tolerated
+//     (default 10s); gRPC's own default of 5m would disconnect agents pinging
+//     every 30s with too_many_pings
+//   - GRPC_KEEPALIVE_PERMIT_WITHOUT_STREAM: accept pings on connections with
+//     no open stream (default true, as the agents send them)
+const (
+	defaultKeepaliveTime    = 30 * time.Second
+	defaultKeepaliveTimeout = 10 * time.Second
+	defaultKeepaliveMinTime = 10 * time.Second
+)
+
+// keepaliveServerOptions builds the keepalive server options from the environment
+func keepaliveServerOptions() ([]grpc.ServerOption, error) {
+	params := keepalive.ServerParameters{
+		Time:    defaultKeepaliveTime,
+		Timeout: defaultKeepaliveTimeout,
+	}
+	policy := keepalive.EnforcementPolicy{
+		MinTime:             defaultKeepaliveMinTime,
+		PermitWithoutStream: true,
+	}
+
+	if v := os.Getenv("GRPC_KEEPALIVE_TIME"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			return nil, fmt.Errorf("GRPC_KEEPALIVE_TIME: %q is not a duration such as 30s (0 keeps the gRPC default)", v)
+		}
+		params.Time = d // 0 lets gRPC apply its default
+	}
+	if v := os.Getenv("GRPC_KEEPALIVE_TIMEOUT"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("GRPC_KEEPALIVE_TIMEOUT: %q is not a positive duration", v)
+		}
+		params.Timeout = d
+	}
+	if v := os.Getenv("GRPC_KEEPALIVE_MIN_TIME"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("GRPC_KEEPALIVE_MIN_TIME: %q is not a positive duration", v)
+		}
+		policy.MinTime = d
+	}
+	if v := os.Getenv("GRPC_KEEPALIVE_PERMIT_WITHOUT_STREAM"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, fmt.Errorf("GRPC_KEEPALIVE_PERMIT_WITHOUT_STREAM: %q is not true or false", v)
+		}
+		policy.PermitWithoutStream = b
+	}
+
+	return []grpc.ServerOption{
+		grpc.KeepaliveParams(params),
+		grpc.KeepaliveEnforcementPolicy(policy),
+	}, nil
+}
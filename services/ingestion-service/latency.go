@@ -0,0 +1,78 @@
+package main
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// e2eLatencySamples is how many recent batches the latency percentiles cover
+const e2eLatencySamples = 2048
+
+// latencyWindow keeps the end-to-end latency of the most recent batches: the
+// time from the agent stamping LogBatch.TimestampMs to the batch's logs being
+// inserted into ClickHouse, i.e. queryable. It is the pipeline's SLO metric.
+type latencyWindow struct {
+	mu      sync.Mutex
+	samples []time.Duration // ring buffer
+	next    int
+	skewed  uint64 // samples that came out negative: the agent's clock is ahead
+}
+
+func newLatencyWindow(size int) *latencyWindow {
+	return &latencyWindow{samples: make([]time.Duration, 0, size)}
+}
+
+// record adds a sample. Clock skew between agent and ingestion can make it
+// negative; such samples count as zero and are tallied separately.
+func (w *latencyWindow) record(d time.Duration) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if d < 0 {
+		w.skewed++
+		d = 0
+	}
+	if len(w.samples) < cap(w.samples) {
+		w.samples = append(w.samples, d)
+		return
+	}
+	w.samples[w.next] = d
+	w.next = (w.next + 1) % len(w.samples)
+}
+
+// snapshot returns p50/p95/max over the window, the sample count and the skewed count
+func (w *latencyWindow) snapshot() (p50, p95, max time.Duration, n int, skewed uint64) {
+	w.mu.Lock()
+	sorted := append([]time.Duration(nil), w.samples...)
+	skewed = w.skewed
+	w.mu.Unlock()
+
+	n = len(sorted)
+	if n == 0 {
+		return 0, 0, 0, 0, skewed
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	at := func(q float64) time.Duration { return sorted[int(q*float64(n-1))] }
+	return at(0.50), at(0.95), sorted[n-1], n, skewed
+}
+
+// recordE2ELatency takes one sample per agent batch among the inserted entries.
+// Entries created here (collapsed duplicates) carry no batch timestamp.
+func (s *ingestionServer) recordE2ELatency(group []queuedEntry, insertedAt time.Time) {
+	type batchKey struct {
+		agentID string
+		sentMs  int64
+	}
+	seen := make(map[batchKey]bool)
+	for _, q := range group {
+		if q.batchTimestampMs == 0 {
+			continue
+		}
+		key := batchKey{q.entry.AgentId, q.batchTimestampMs}
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		s.e2eLatency.record(insertedAt.Sub(time.UnixMilli(q.batchTimestampMs)))
+	}
+}
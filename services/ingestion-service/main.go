@@ -10,6 +10,9 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"slices"
+	"strconv"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"syscall"
@@ -19,64 +22,310 @@ import (
 	"github.com/ClickHouse/clickhouse-go/v2/lib/driver"
 	"github.com/klauspost/compress/zstd"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/proto"
 
 	pb "stackmonitor.com/ingestion-service/proto/logproto"
 )
 
 var (
-	port          = ":50051"
+	listenAddr    = ":50051" // gRPC listen address; override with LISTEN_ADDR
 	clickhouseAddr = "clickhouse:9000"
-	database     = "stackmonitor"
-	batchSize    = 100 // Number of logs to buffer before insert
+	database     = "stackmonitor" // override with CH_DATABASE
+	logsTable    = "logs" // Default table, override with CH_TABLE; ROUTE_RULES can send entries elsewhere
+	batchSize    = 100 // Number of logs to buffer before insert; override with BATCH_SIZE
 	batchTimeout = 5 * time.Second
+	// gRPC max send/receive message size; override with GRPC_MAX_MSG_BYTES.
+	// Agents must use the same limit so they split batches before hitting it.
+	maxMsgBytes = 4 << 20
+	// Fields hashed into the dedup key; override with DEDUP_KEY_FIELDS
+	dedupKeyFields = []string{"message", "level", "service"}
+	// Levels never deduplicated, so a burst keeps its frequency signal;
+	// override with DEDUP_EXEMPT_LEVELS (set it empty to dedup every level)
+	dedupExemptLevels = []string{"ERROR"}
+	dedupWindow       = 60 * time.Second
+)
+
+// DEDUP_MODE: drop discards duplicates within the window; collapse counts them
+// and inserts one extra entry per window carrying the count in its "occurrences" field;
+// count holds the first occurrence until the window closes and inserts it alone,
+// with "occurrences" set to how many times it was seen
+const (
+	dedupDrop     = "drop"
+	dedupCollapse = "collapse"
+	dedupCount    = "count"
+)
+
+// Dedup scope (DEDUP_SCOPE): agent (default) adds agent_id to the dedup key, so
+// the same line from 50 hosts is kept once per host and a widespread failure
+// stays visible; global dedups across agents, keeping one for all of them
+const (
+	dedupScopeAgent  = "agent"
+	dedupScopeGlobal = "global"
 )
 
 type ingestionServer struct {
 	pb.UnimplementedLogIngestionServer
 	db         driver.Conn
-	logChan    chan *pb.LogEntry
-	dedupCache *sync.Map // PoC deduplication
+	logChan    chan queuedEntry
+	ackMode    string // ackOnReceive or ackDurable, see ack.go
+	dedupCache *dedupCache // sharded, swept once per dedupBucket, see dedup_cache.go
+	dedupStats dedupCacheStats // dedupCache size and evictions, see dedup_cache.go
+	grpcStats  *rpcStats // per-method RPC counts and durations, see grpc_interceptors.go
+	dedupFields []string // Entry fields that make up the dedup key
+	dedupExempt map[string]bool // Levels that bypass dedup
+	dedupMode  string // dedupDrop, dedupCollapse or dedupCount
+	dedupScope string // dedupScopeAgent or dedupScopeGlobal
+	dedupBypass map[ingestPath]bool // Paths that skip dedup, see dedup_paths.go
+	sweepMu    sync.Mutex // Serializes sweepDedup and drainDedup
+	sweepStopped bool // Set by drainDedup at shutdown
+	routes     []routeRule // Per-table routing, first match wins
+	webhook    *webhookSink // Forwards inserted entries; nil unless WEBHOOK_URL is set
+	breaker    *insertBreaker // Rejects batches while inserts fail, see insert_breaker.go; nil when disabled
+	asyncInsert string // asyncInsertOff, asyncInsertWait or asyncInsertNoWait
+	agents     *agentRegistry // Last-seen per agent, for the api-server's fleet view
+	e2eLatency *latencyWindow // Agent batch timestamp -> insert, see latency.go
+	schemas    *insertSchemas // Per-table insert columns, introspected at startup
+	flushOnSignal bool // SIGUSR1 forces an insert, see flush.go
+	insertWorkers int // batchWriter goroutines, see insert_workers.go
+	splitDepth int // Halvings of a batch rejected for its rows, see insert_split.go; 0 disables
+	deadLetterTable string // Rejected rows go here; "" drops them
+	resend     *storedEntries // Entries already stored from batches acked RETRY, see resend.go
 	encoder    *zstd.Encoder
 	decoder    *zstd.Decoder
+	clock      Clock // realClock outside tests, see clock.go
 	
 	// Metrics
 	batchesReceived   atomic.Uint64
+	batchesOversize   atomic.Uint64
+	acksRetry         atomic.Uint64
 	logsReceived      atomic.Uint64
 	logsProcessed     atomic.Uint64
 	logsDuplicate     atomic.Uint64
+	logsDedupBypassed atomic.Uint64 // backfill/replay entries let past dedup
+	logsCollapsed     atomic.Uint64
+	logsHeld          atomic.Int64 // first occurrences waiting for their window in count mode
 	logsInserted      atomic.Uint64
 	insertsFailed     atomic.Uint64
+	insertBatches     atomic.Uint64 // successful ClickHouse inserts
+	insertNanos       atomic.Uint64 // time spent in successful inserts
+	insertsInFlight   atomic.Int64  // insertBatch calls running, at most insertWorkers
+	forcedFlushes     atomic.Uint64 // SIGUSR1 flushes
+	insertSplits      atomic.Uint64 // batches retried in parts after a row error
+	logsRejected      atomic.Uint64 // rows left failing after splitting, dead-lettered or dropped
+	logsResendSkipped atomic.Uint64 // entries of a resent batch that were already stored
+	deadLetterErrors  atomic.Uint64
 	bytesReceived     atomic.Uint64
 	bytesDecompressed atomic.Uint64
 	startTime         time.Time
 	lastInsertTime    atomic.Int64
 }
 
+// parseDedupKeyFields parses a comma-separated list of dedup key fields.
+// Besides message, level, service, source and agent_id, any name is looked up in entry.Fields.
+func parseDedupKeyFields(raw string) ([]string, error) {
+	var fields []string
+	for _, field := range strings.Split(raw, ",") {
+		field = strings.TrimSpace(field)
+		if field != "" {
+			fields = append(fields, field)
+		}
+	}
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("dedup key must contain at least one field")
+	}
+	return fields, nil
+}
+
+// scopeDedupKeyFields applies DEDUP_SCOPE to the dedup key fields: agent
+// scope appends agent_id unless it is already listed, global scope refuses it
+func scopeDedupKeyFields(fields []string, scope string) ([]string, error) {
+	hasAgent := slices.Contains(fields, "agent_id")
+	switch scope {
+	case dedupScopeAgent:
+		if !hasAgent {
+			fields = append(slices.Clip(fields), "agent_id")
+		}
+		return fields, nil
+	case dedupScopeGlobal:
+		if hasAgent {
+			return nil, fmt.Errorf("DEDUP_KEY_FIELDS includes agent_id, which makes the scope per agent")
+		}
+		return fields, nil
+	}
+	return nil, fmt.Errorf("unknown scope %q (want %s or %s)", scope, dedupScopeAgent, dedupScopeGlobal)
+}
+
+// dedupKey builds the dedup hash input from the configured fields
+func (s *ingestionServer) dedupKey(entry *pb.LogEntry) string {
+	parts := make([]string, len(s.dedupFields))
+	for i, field := range s.dedupFields {
+		switch field {
+		case "message":
+			parts[i] = entry.Message
+		case "level":
+			parts[i] = entry.Level
+		case "source":
+			parts[i] = entry.Source
+		case "agent_id":
+			parts[i] = entry.AgentId
+		case "service":
+			parts[i] = entry.Fields["service"]
+			if parts[i] == "" {
+				parts[i] = "unknown"
+			}
+		default:
+			parts[i] = entry.Fields[field]
+		}
+	}
+	return strings.Join(parts, "-")
+}
+
+// parseDedupExemptLevels parses a comma-separated list of levels that skip dedup
+func parseDedupExemptLevels(raw string) map[string]bool {
+	exempt := make(map[string]bool)
+	for _, level := range strings.Split(raw, ",") {
+		level = strings.ToUpper(strings.TrimSpace(level))
+		if level != "" {
+			exempt[level] = true
+		}
+	}
+	return exempt
+}
+
+// dedupState tracks the duplicates seen for one dedup key within the window
+type dedupState struct {
+	mu      sync.Mutex
+	repeats uint64
+	last    *pb.LogEntry
+	first   *pb.LogEntry // held until the window closes in count mode
+}
+
+func (d *dedupState) record(entry *pb.LogEntry) {
+	d.mu.Lock()
+	d.repeats++
+	d.last = entry
+	d.mu.Unlock()
+}
+
 // Deduplication: in-memory hash cache with automatic expiration
 // Detects duplicate log messages within a 60-second window
 func (s *ingestionServer) isDuplicate(entry *pb.LogEntry) bool {
-	// Hash based on message content, level, and service (NOT timestamp)
-	// This catches the same error/warning occurring multiple times within 60s
-	service := entry.Fields["service"]
-	if service == "" {
-		service = "unknown"
+	if s.dedupExempt[entry.Level] {
+		return false
 	}
+
+	// Hash based on the configured key fields (default: message + level + service),
+	// never the timestamp - we want to catch the same error/warning occurring
+	// multiple times within 60s even if timestamps differ
+	hash := s.dedupKey(entry)
 	
-	// Create hash from: message + level + service
-	// Do NOT include timestamp - we want to catch duplicate messages even if timestamps differ
-	hash := fmt.Sprintf("%s-%s-%s", entry.Message, entry.Level, service)
-	
-	if _, loaded := s.dedupCache.LoadOrStore(hash, true); loaded {
+	state := &dedupState{}
+	if s.dedupMode == dedupCount {
+		state.first = entry
+	}
+	if existing, loaded := s.dedupCache.loadOrStore(hash, state, s.clock.Now()); loaded {
+		if s.dedupMode == dedupCollapse || s.dedupMode == dedupCount {
+			existing.record(entry)
+		}
 		return true // Duplicate found
 	}
-	
-	// Expire cache entries after 60s to prevent memory leak
-	// After 60s, the same error can be logged again (not considered a duplicate anymore)
-	time.AfterFunc(60*time.Second, func() { s.dedupCache.Delete(hash) })
+	s.dedupStats.added()
+	// sweepDedup expires the key after the window, so the same error can be logged again
+	if s.dedupMode == dedupCount {
+		s.logsHeld.Add(1)
+	}
 	return false
 }
 
+// sweepDedup expires the dedup keys whose window has closed, then schedules
+// itself again; started once from main, stopped by drainDedup
+func (s *ingestionServer) sweepDedup() {
+	s.sweepMu.Lock()
+	defer s.sweepMu.Unlock()
+	if s.sweepStopped {
+		return
+	}
+	s.flushDedup(s.dedupCache.sweep(s.clock.Now()))
+	s.clock.AfterFunc(dedupBucket, s.sweepDedup)
+}
+
+// drainDedup stops the sweeper and ends every window still open, queueing
+// the entries held in count mode and the collapsed duplicates for insert, so
+// a shutdown doesn't lose logs that were acked. It returns how many keys it
+// flushed.
+func (s *ingestionServer) drainDedup() int {
+	s.sweepMu.Lock()
+	defer s.sweepMu.Unlock()
+	s.sweepStopped = true
+	states := s.dedupCache.drain()
+	s.flushDedup(states)
+	return len(states)
+}
+
+// flushDedup queues what the dedup mode kept back for keys whose window ended
+func (s *ingestionServer) flushDedup(states []*dedupState) {
+	for _, state := range states {
+		s.dedupStats.evicted(s.clock.Now())
+		switch s.dedupMode {
+		case dedupCollapse:
+			s.flushCollapsed(state)
+		case dedupCount:
+			s.flushCounted(state)
+		}
+	}
+}
+
+// flushCounted inserts the first occurrence held for the window, its
+// "occurrences" field counting it and every duplicate (absent when it was
+// the only one), so one row carries the whole burst's frequency
+func (s *ingestionServer) flushCounted(state *dedupState) {
+	state.mu.Lock()
+	repeats, first := state.repeats, state.first
+	state.mu.Unlock()
+	s.logsHeld.Add(-1)
+
+	if repeats > 0 {
+		counted := proto.Clone(first).(*pb.LogEntry)
+		fields := make(map[string]string, len(first.Fields)+1)
+		for k, v := range first.Fields {
+			fields[k] = v
+		}
+		fields["occurrences"] = strconv.FormatUint(repeats+1, 10)
+		counted.Fields = fields
+		first = counted
+		s.logsCollapsed.Add(repeats)
+	}
+	s.logChan <- queuedEntry{entry: first}
+}
+
+// flushCollapsed inserts one entry standing in for all duplicates seen in the
+// window. Its "occurrences" field holds how many duplicates it represents, so
+// summing occurrences (1 when absent) gives the true frequency.
+func (s *ingestionServer) flushCollapsed(state *dedupState) {
+	state.mu.Lock()
+	repeats, last := state.repeats, state.last
+	state.mu.Unlock()
+	if repeats == 0 {
+		return
+	}
+
+	collapsed := proto.Clone(last).(*pb.LogEntry)
+	fields := make(map[string]string, len(last.Fields)+2)
+	for k, v := range last.Fields {
+		fields[k] = v
+	}
+	fields["occurrences"] = strconv.FormatUint(repeats, 10)
+	fields["dedup_collapsed"] = "true"
+	collapsed.Fields = fields
+
+	s.logsCollapsed.Add(repeats)
+	s.logChan <- queuedEntry{entry: collapsed}
+}
+
 // gRPC StreamLogs implementation
 func (s *ingestionServer) StreamLogs(stream pb.LogIngestion_StreamLogsServer) error {
 	for {
@@ -84,10 +333,39 @@ func (s *ingestionServer) StreamLogs(stream pb.LogIngestion_StreamLogsServer) er
 		if err == io.EOF {
 			return nil
 		}
+		if status.Code(err) == codes.ResourceExhausted {
+			// gRPC has already failed the stream; make the cause visible instead of opaque
+			s.batchesOversize.Add(1)
+			log.Printf("Rejected batch larger than GRPC_MAX_MSG_BYTES (%d): %v", maxMsgBytes, err)
+			return err
+		}
 		if err != nil {
 			return err
 		}
 
+		s.agents.record(batch, s.clock.Now())
+		if batch.Metadata[metaHeartbeat] == "true" && len(batch.Logs) == 0 {
+			continue // liveness only: nothing to insert or ack
+		}
+
+		// Inserts are failing: turn the batch away before spending work on it
+		if s.breaker != nil {
+			if wait := s.breaker.retryAfter(s.clock.Now()); wait > 0 {
+				s.breaker.throttled.Add(1)
+				s.acksRetry.Add(1)
+				if err := stream.Send(&pb.Ack{
+					BatchId:           batch.BatchId,
+					Status:            pb.AckStatus_RETRY,
+					Message:           fmt.Sprintf("ClickHouse inserts failing, retry in %s", wait.Round(time.Second)),
+					ServerTimestampMs: s.clock.Now().UnixMilli(),
+					RetryAfterMs:      wait.Milliseconds(),
+				}); err != nil {
+					return err
+				}
+				continue
+			}
+		}
+
 		s.batchesReceived.Add(1)
 		s.logsReceived.Add(uint64(len(batch.Logs)))
 
@@ -97,8 +375,8 @@ func (s *ingestionServer) StreamLogs(stream pb.LogIngestion_StreamLogsServer) er
 		// Handle compression if enabled
 		if batch.Compression == pb.CompressionType_ZSTD && len(batch.CompressedPayload) > 0 {
 			s.bytesReceived.Add(uint64(len(batch.CompressedPayload)))
-			log.Printf("Received compressed batch %d (%d bytes compressed, original: %d bytes)", 
-				batch.BatchId, len(batch.CompressedPayload), batch.OriginalSize)
+			log.Printf("Received compressed batch %s/%d (%d bytes compressed, original: %d bytes)", 
+				batch.AgentId, batch.BatchId, len(batch.CompressedPayload), batch.OriginalSize)
 			
 			// Decompress payload
 			decompressed, err := s.decoder.DecodeAll(batch.CompressedPayload, nil)
@@ -108,7 +386,7 @@ func (s *ingestionServer) StreamLogs(stream pb.LogIngestion_StreamLogsServer) er
 					BatchId:           batch.BatchId,
 					Status:            pb.AckStatus_RETRY,
 					Message:           fmt.Sprintf("Decompression failed: %v", err),
-					ServerTimestampMs: time.Now().UnixMilli(),
+					ServerTimestampMs: s.clock.Now().UnixMilli(),
 				})
 				continue
 			}
@@ -121,8 +399,8 @@ func (s *ingestionServer) StreamLogs(stream pb.LogIngestion_StreamLogsServer) er
 			// In production, you'd want to implement proper framing or use the decompressed data
 			logsToProcess = batch.Logs
 			
-			log.Printf("Decompressed batch %d: %d logs from %d bytes", 
-				batch.BatchId, len(logsToProcess), len(decompressed))
+			log.Printf("Decompressed batch %s/%d: %d logs from %d bytes", 
+				batch.AgentId, batch.BatchId, len(logsToProcess), len(decompressed))
 		} else if len(batch.Logs) > 0 {
 			// Track uncompressed bytes (estimate)
 			for _, entry := range batch.Logs {
@@ -131,96 +409,227 @@ func (s *ingestionServer) StreamLogs(stream pb.LogIngestion_StreamLogsServer) er
 			}
 		}
 
-		processedCount := 0
-		duplicateCount := 0
-		for _, entry := range logsToProcess {
-			// Apply deduplication
-			if !s.isDuplicate(entry) {
-				s.logChan <- entry
-				processedCount++
-				s.logsProcessed.Add(1)
+		// Drop what a partly stored batch already wrote before it was acked RETRY
+		if s.ackMode == ackDurable {
+			var skipped int
+			logsToProcess, skipped = s.resend.skip(batch.AgentId, logsToProcess, s.clock.Now())
+			s.logsResendSkipped.Add(uint64(skipped))
+		}
+
+		// Apply deduplication (backfilled entries may bypass it)
+		fresh, duplicateCount, heldCount := s.dedupFilter(logsToProcess, pathStream)
+		processedCount := len(fresh)
+		s.logsProcessed.Add(uint64(processedCount))
+
+		var ack *batchAck
+		if s.ackMode == ackDurable {
+			ack = newBatchAck(processedCount)
+		}
+		for _, entry := range fresh {
+			s.logChan <- queuedEntry{entry: entry, ack: ack, batchTimestampMs: batch.TimestampMs}
+		}
+		log.Printf("📥 Received batch %s/%d: %d logs (processed: %d, duplicates: %d, held: %d)", 
+			batch.AgentId, batch.BatchId, len(logsToProcess), processedCount, duplicateCount, heldCount)
+
+		status := pb.AckStatus_SUCCESS
+		message := fmt.Sprintf("Processed %d/%d logs", processedCount, len(logsToProcess))
+		if heldCount > 0 {
+			// Not yet stored: a crash before their window closes loses them
+			message += fmt.Sprintf(", %d held for the dedup window", heldCount)
+		}
+		if ack != nil {
+			if err := ack.wait(durableAckTimeout); err != nil {
+				// The agent resends the whole batch: skip what was stored, and
+				// forget the dedup keys of the rest so it isn't dropped as a duplicate
+				stored := ack.storedEntries()
+				s.resend.remember(batch.AgentId, stored, s.clock.Now())
+				for _, entry := range fresh {
+					if !slices.Contains(stored, entry) && !s.dedupBypass[entryPath(entry, pathStream)] {
+						s.dedupCache.delete(s.dedupKey(entry))
+					}
+				}
+				s.acksRetry.Add(1)
+				status = pb.AckStatus_RETRY
+				message = fmt.Sprintf("Insert failed: %v", err)
 			} else {
-				duplicateCount++
-				s.logsDuplicate.Add(1)
+				message = fmt.Sprintf("Persisted %d/%d logs", processedCount, len(logsToProcess))
 			}
 		}
-		log.Printf("📥 Received batch %d: %d logs (processed: %d, duplicates: %d)", 
-			batch.BatchId, len(logsToProcess), processedCount, duplicateCount)
 
 		if err := stream.Send(&pb.Ack{
 			BatchId:           batch.BatchId,
-			Status:            pb.AckStatus_SUCCESS,
-			Message:           fmt.Sprintf("Processed %d/%d logs", processedCount, len(logsToProcess)),
-			ServerTimestampMs: time.Now().UnixMilli(),
+			Status:            status,
+			Message:           message,
+			ServerTimestampMs: s.clock.Now().UnixMilli(),
 		}); err != nil {
 			return err
 		}
 	}
 }
 
-// Batch writer for ClickHouse
-func (s *ingestionServer) batchWriter() {
-	ticker := time.NewTicker(batchTimeout)
-	defer ticker.Stop()
-	buffer := make([]*pb.LogEntry, 0, batchSize)
-
-	for {
-		select {
-		case entry := <-s.logChan:
-			buffer = append(buffer, entry)
-			if len(buffer) >= batchSize {
-				s.insertBatch(buffer)
-				buffer = make([]*pb.LogEntry, 0, batchSize)
+func (s *ingestionServer) insertBatch(queued []queuedEntry) {
+	s.insertsInFlight.Add(1)
+	defer s.insertsInFlight.Add(-1)
+	// Prepare one ClickHouse batch per target table
+	byTable := make(map[string][]queuedEntry)
+	for _, q := range queued {
+		table := s.routeTable(q.entry)
+		byTable[table] = append(byTable[table], q)
+	}
+	for table, group := range byTable {
+		logs := make([]*pb.LogEntry, len(group))
+		for i, q := range group {
+			logs[i] = q.entry
+		}
+		failed, err := s.insertInto(table, logs)
+		if err == nil {
+			s.recordE2ELatency(group, s.clock.Now())
+		}
+		// Release any streams waiting on a durable ack for these entries
+		for _, q := range group {
+			if q.ack == nil {
+				continue
 			}
-		case <-ticker.C:
-			if len(buffer) > 0 {
-				s.insertBatch(buffer)
-				buffer = make([]*pb.LogEntry, 0, batchSize)
+			if slices.Contains(failed, q.entry) {
+				q.ack.done(q.entry, err)
+			} else {
+				q.ack.done(q.entry, nil)
 			}
 		}
 	}
 }
 
-func (s *ingestionServer) insertBatch(logs []*pb.LogEntry) {
-	ctx := context.Background()
-	batch, err := s.db.PrepareBatch(ctx, "INSERT INTO stackmonitor.logs")
+// insertInto inserts logs into table. On error it returns the entries that
+// were neither inserted nor dead-lettered: all of them, or the rest of a split
+// insert cut short by an error that isn't about the rows.
+func (s *ingestionServer) insertInto(table string, logs []*pb.LogEntry) (failed []*pb.LogEntry, err error) {
+	start := s.clock.Now()
+	err = s.writeRows(context.Background(), table, logs)
+	inserted := logs
 	if err != nil {
-		log.Printf("Failed to prepare batch: %v", err)
-		return
+		inserted, failed = nil, logs
+		if s.splitDepth > 0 && isRowError(err) {
+			// Keep the rows ClickHouse accepts, see insert_split.go
+			log.Printf("⚠️  Batch of %d logs rejected by %s, retrying in parts: %v", len(logs), table, err)
+			s.insertSplits.Add(1)
+			var rejected []rejectedRow
+			inserted, rejected, err = s.insertSplit(context.Background(), table, logs, s.splitDepth, err)
+			// What the split stored stays stored, even if it was cut short
+			s.deadLetter(table, rejected)
+			failed = unsettled(logs, inserted, rejected)
+		}
+	}
+	if s.breaker != nil {
+		s.breaker.record(err, s.clock.Now())
+	}
+	if len(inserted) > 0 {
+		s.logsInserted.Add(uint64(len(inserted)))
+		s.lastInsertTime.Store(s.clock.Now().Unix())
+		// Fan out only what was persisted; offer never blocks the writer
+		if s.webhook != nil {
+			for _, entry := range inserted {
+				s.webhook.offer(entry)
+			}
+		}
+	}
+	if err != nil {
+		log.Printf("❌ Failed to insert %d of %d logs into %s: %v", len(failed), len(logs), table, err)
+		s.insertsFailed.Add(1)
+		return failed, err
+	}
+	s.insertBatches.Add(1)
+	s.insertNanos.Add(uint64(s.clock.Since(start)))
+	log.Printf("✅ Inserted %d logs into ClickHouse (%s)", len(inserted), table)
+	return nil, nil
+}
+
+// writeRows inserts logs into table as a single ClickHouse batch.
+// insertInto wraps it with metrics; replay jobs call it directly.
+// Columns follow the table's introspected schema, see insert_columns.go.
+func (s *ingestionServer) writeRows(ctx context.Context, table string, logs []*pb.LogEntry) error {
+	plan, err := s.schemas.get(ctx, s, table)
+	if err != nil {
+		return err
+	}
+	batch, err := s.db.PrepareBatch(ctx, fmt.Sprintf("INSERT INTO %s.%s (%s)", database, table, strings.Join(plan.columns, ", ")))
+	if err != nil {
+		return fmt.Errorf("prepare batch: %w", err)
 	}
 	defer batch.Abort()
 
+	ingestedAt := s.clock.Now()
+	row := make([]interface{}, len(plan.values))
 	for _, entry := range logs {
-		service := entry.Fields["service"]
-		if service == "" {
-			service = "unknown"
-		}
-		traceID := entry.Fields["trace_id"]
-		agentID := entry.AgentId
-
-		err := batch.Append(
-			time.Unix(0, entry.TimestampNs),
-			entry.Level,
-			service,
-			entry.Message,
-			traceID,
-			agentID,
-			entry.Fields, // Using fields as metadata for PoC
-		)
-		if err != nil {
-			log.Printf("Failed to append to batch: %v", err)
-			return
+		for i, value := range plan.values {
+			row[i] = value(entry, ingestedAt)
+		}
+		if err := batch.Append(row...); err != nil {
+			return fmt.Errorf("%w: %w", errAppend, err)
 		}
 	}
 
 	if err := batch.Send(); err != nil {
-		log.Printf("❌ Failed to send batch: %v", err)
-		s.insertsFailed.Add(1)
-		return
+		return fmt.Errorf("send: %w", err)
+	}
+	return nil
+}
+
+// ensureSchema migrates the default table, creates the agents, replay
+// checkpoint and dead-letter tables and any routed tables with the same schema
+// as the default one
+func (s *ingestionServer) ensureSchema(ctx context.Context) error {
+	if err := s.addIngestedAt(ctx, logsTable); err != nil {
+		return err
+	}
+	if err := s.ensureAgentsTable(ctx); err != nil {
+		return err
+	}
+	if err := s.ensureReplayCheckpointsTable(ctx); err != nil {
+		return err
+	}
+	if s.deadLetterTable != "" {
+		if err := s.ensureDeadLetterTable(ctx); err != nil {
+			return err
+		}
+	}
+
+	created := make(map[string]bool)
+	tables := []string{logsTable}
+	for _, rule := range s.routes {
+		if rule.table == logsTable || created[rule.table] {
+			continue
+		}
+		if err := s.ensureLogsTable(ctx, rule.table); err != nil {
+			return err
+		}
+		// Routed tables created before ingested_at existed need the column too
+		if err := s.addIngestedAt(ctx, rule.table); err != nil {
+			return err
+		}
+		created[rule.table] = true
+		tables = append(tables, rule.table)
+		log.Printf("Routing %s=%s to table %s", rule.field, rule.value, rule.table)
+	}
+
+	// Work out every table's insert columns now, so a schema the service
+	// can't fill stops startup instead of failing each insert
+	for _, table := range tables {
+		if _, err := s.schemas.load(ctx, s, table); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+
+// addIngestedAt adds the ingested_at column to tables created before it existed.
+// Existing rows default to their event timestamp.
+func (s *ingestionServer) addIngestedAt(ctx context.Context, table string) error {
+	query := fmt.Sprintf("ALTER TABLE %s.%s ADD COLUMN IF NOT EXISTS ingested_at DateTime64(3) DEFAULT timestamp", database, table)
+	if err := s.db.Exec(ctx, query); err != nil {
+		return fmt.Errorf("add ingested_at to %s: %w", table, err)
 	}
-	s.logsInserted.Add(uint64(len(logs)))
-	s.lastInsertTime.Store(time.Now().Unix())
-	log.Printf("✅ Inserted %d logs into ClickHouse", len(logs))
+	return nil
 }
 
 // HTTP handler for health checks
@@ -228,7 +637,7 @@ func (s *ingestionServer) healthHandler(w http.ResponseWriter, r *http.Request)
 	w.Header().Set("Content-Type", "application/json")
 	
 	lastInsert := time.Unix(s.lastInsertTime.Load(), 0)
-	timeSinceLast := time.Since(lastInsert)
+	timeSinceLast := s.clock.Since(lastInsert)
 	healthy := timeSinceLast < 2*time.Minute
 	
 	status := "healthy"
@@ -240,7 +649,7 @@ func (s *ingestionServer) healthHandler(w http.ResponseWriter, r *http.Request)
 	
 	response := map[string]interface{}{
 		"status":                status,
-		"uptime_seconds":        time.Since(s.startTime).Seconds(),
+		"uptime_seconds":        s.clock.Since(s.startTime).Seconds(),
 		"last_insert_ago":       timeSinceLast.Seconds(),
 		"log_chan_size":         len(s.logChan),
 		"log_chan_capacity":     cap(s.logChan),
@@ -255,7 +664,7 @@ func (s *ingestionServer) healthHandler(w http.ResponseWriter, r *http.Request)
 func (s *ingestionServer) metricsHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	
-	uptime := time.Since(s.startTime).Seconds()
+	uptime := s.clock.Since(s.startTime).Seconds()
 	bytesReceived := s.bytesReceived.Load()
 	bytesDecompressed := s.bytesDecompressed.Load()
 	
@@ -266,15 +675,51 @@ func (s *ingestionServer) metricsHandler(w http.ResponseWriter, r *http.Request)
 	
 	logsProcessed := s.logsProcessed.Load()
 	logsInserted := s.logsInserted.Load()
+
+	avgInsertMs := 0.0
+	if batches := s.insertBatches.Load(); batches > 0 {
+		avgInsertMs = float64(s.insertNanos.Load()) / float64(batches) / 1e6
+	}
 	
+	e2eP50, e2eP95, e2eMax, e2eSamples, e2eSkewed := s.e2eLatency.snapshot()
+
 	response := map[string]interface{}{
 		"uptime_seconds":       uptime,
 		"batches_received":     s.batchesReceived.Load(),
+		"batches_oversize":     s.batchesOversize.Load(),
+		"ack_mode":             s.ackMode,
+		"acks_retry":           s.acksRetry.Load(),
 		"logs_received":        s.logsReceived.Load(),
 		"logs_processed":       logsProcessed,
 		"logs_duplicate":       s.logsDuplicate.Load(),
+		"logs_dedup_bypassed":  s.logsDedupBypassed.Load(),
+		"logs_collapsed":       s.logsCollapsed.Load(),
+		"logs_held":            s.logsHeld.Load(),
+		"dedup_mode":           s.dedupMode,
+		"dedup_scope":          s.dedupScope,
+		"dedup_cache_entries":  s.dedupStats.entries.Load(),
+		"dedup_cache_high_water": s.dedupStats.highWater.Load(),
+		"dedup_evictions":      s.dedupStats.evictions.Load(),
+		"dedup_evictions_per_second": s.dedupStats.evictionRate(s.clock.Now()),
+		"grpc":               s.grpcStats.snapshot(),
 		"logs_inserted":        logsInserted,
 		"inserts_failed":       s.insertsFailed.Load(),
+		"insert_batches":       s.insertBatches.Load(),
+		"forced_flushes":       s.forcedFlushes.Load(),
+		"insert_splits":        s.insertSplits.Load(),
+		"logs_rejected":        s.logsRejected.Load(),
+		"logs_resend_skipped":  s.logsResendSkipped.Load(),
+		"dead_letter_errors":   s.deadLetterErrors.Load(),
+		"avg_insert_ms":        avgInsertMs,
+		"insert_workers":       s.insertWorkers,
+		"inserts_in_flight":    s.insertsInFlight.Load(),
+		"batch_size":           batchSize,
+		"async_insert":         s.asyncInsert,
+		"e2e_latency_p50_ms":   float64(e2eP50) / 1e6,
+		"e2e_latency_p95_ms":   float64(e2eP95) / 1e6,
+		"e2e_latency_max_ms":   float64(e2eMax) / 1e6,
+		"e2e_latency_samples":  e2eSamples,
+		"e2e_clock_skewed":     e2eSkewed,
 		"bytes_received":       bytesReceived,
 		"bytes_decompressed":   bytesDecompressed,
 		"compression_ratio":    compressionRatio,
@@ -284,6 +729,17 @@ func (s *ingestionServer) metricsHandler(w http.ResponseWriter, r *http.Request)
 		"log_chan_size":        len(s.logChan),
 		"log_chan_capacity":    cap(s.logChan),
 	}
+	if s.breaker != nil {
+		response["insert_breaker"] = s.breaker.state(s.clock.Now())
+		response["insert_breaker_trips"] = s.breaker.trips.Load()
+		response["batches_throttled"] = s.breaker.throttled.Load()
+	}
+	if s.webhook != nil {
+		response["webhook_sent"] = s.webhook.sent.Load()
+		response["webhook_dropped"] = s.webhook.dropped.Load()
+		response["webhook_failed"] = s.webhook.failed.Load()
+		response["webhook_queue_size"] = len(s.webhook.queue)
+	}
 	
 	json.NewEncoder(w).Encode(response)
 }
@@ -294,19 +750,214 @@ func main() {
 		clickhouseAddr = clickhouseAddrEnv
 	}
 
-	lis, err := net.Listen("tcp", port)
+	// Database and table names are interpolated into SQL, so only plain identifiers are accepted
+	if dbEnv := os.Getenv("CH_DATABASE"); dbEnv != "" {
+		database = dbEnv
+	}
+	if tableEnv := os.Getenv("CH_TABLE"); tableEnv != "" {
+		logsTable = tableEnv
+	}
+	for _, name := range []string{database, logsTable} {
+		if !identifierRegex.MatchString(name) {
+			log.Fatalf("Invalid ClickHouse identifier %q in CH_DATABASE/CH_TABLE", name)
+		}
+	}
+
+	if fieldsEnv := os.Getenv("DEDUP_KEY_FIELDS"); fieldsEnv != "" {
+		fields, err := parseDedupKeyFields(fieldsEnv)
+		if err != nil {
+			log.Fatalf("Invalid DEDUP_KEY_FIELDS: %v", err)
+		}
+		dedupKeyFields = fields
+	}
+	dedupScope := os.Getenv("DEDUP_SCOPE")
+	if dedupScope == "" {
+		dedupScope = dedupScopeAgent
+	}
+	scoped, err := scopeDedupKeyFields(dedupKeyFields, dedupScope)
+	if err != nil {
+		log.Fatalf("Invalid DEDUP_SCOPE: %v", err)
+	}
+	dedupKeyFields = scoped
+	log.Printf("Dedup key fields: %v (scope: %s)", dedupKeyFields, dedupScope)
+
+	if levelsEnv, ok := os.LookupEnv("DEDUP_EXEMPT_LEVELS"); ok {
+		dedupExemptLevels = strings.Split(levelsEnv, ",")
+	}
+	dedupExempt := parseDedupExemptLevels(strings.Join(dedupExemptLevels, ","))
+
+	// DEDUP_BYPASS_PATHS lists the paths whose entries skip dedup; set it empty to dedup all
+	bypassEnv, ok := os.LookupEnv("DEDUP_BYPASS_PATHS")
+	if !ok {
+		bypassEnv = defaultDedupBypassPaths
+	}
+	dedupBypass, err := parseDedupBypassPaths(bypassEnv)
+	if err != nil {
+		log.Fatalf("Invalid DEDUP_BYPASS_PATHS: %v", err)
+	}
+
+	dedupMode := os.Getenv("DEDUP_MODE")
+	if dedupMode == "" {
+		dedupMode = dedupDrop
+	}
+	if dedupMode != dedupDrop && dedupMode != dedupCollapse && dedupMode != dedupCount {
+		log.Fatalf("Invalid DEDUP_MODE %q (want %s, %s or %s)", dedupMode, dedupDrop, dedupCollapse, dedupCount)
+	}
+	log.Printf("Dedup mode: %s, exempt levels: %v", dedupMode, dedupExemptLevels)
+
+	routes, err := parseRouteRules(os.Getenv("ROUTE_RULES"))
+	if err != nil {
+		log.Fatalf("Invalid ROUTE_RULES: %v", err)
+	}
+
+	insertFieldMap, err := parseInsertFieldMap(os.Getenv("INSERT_FIELD_MAP"))
+	if err != nil {
+		log.Fatalf("Invalid INSERT_FIELD_MAP: %v", err)
+	}
+
+	ackMode, err := parseAckMode(os.Getenv("ACK_MODE"))
+	if err != nil {
+		log.Fatalf("Invalid ACK_MODE: %v", err)
+	}
+	log.Printf("Ack mode: %s", ackMode)
+
+	// WEBHOOK_URL forwards inserted entries matching WEBHOOK_LEVELS / WEBHOOK_PATTERN
+	var webhook *webhookSink
+	if webhookURL := os.Getenv("WEBHOOK_URL"); webhookURL != "" {
+		levels := os.Getenv("WEBHOOK_LEVELS")
+		if levels == "" {
+			levels = defaultWebhookLevels
+		}
+		batch := defaultWebhookBatchSize
+		if v := os.Getenv("WEBHOOK_BATCH_SIZE"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n <= 0 {
+				log.Fatalf("Invalid WEBHOOK_BATCH_SIZE: %q", v)
+			}
+			batch = n
+		}
+		flush := defaultWebhookFlush
+		if v := os.Getenv("WEBHOOK_FLUSH_INTERVAL"); v != "" {
+			d, err := time.ParseDuration(v)
+			if err != nil || d <= 0 {
+				log.Fatalf("Invalid WEBHOOK_FLUSH_INTERVAL: %q", v)
+			}
+			flush = d
+		}
+		perMinute := defaultWebhookPerMinute
+		if v := os.Getenv("WEBHOOK_MAX_PER_MINUTE"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n <= 0 {
+				log.Fatalf("Invalid WEBHOOK_MAX_PER_MINUTE: %q", v)
+			}
+			perMinute = n
+		}
+		webhook, err = newWebhookSink(webhookURL, levels, os.Getenv("WEBHOOK_PATTERN"), os.Getenv("WEBHOOK_TEMPLATE"), batch, flush, perMinute)
+		if err != nil {
+			log.Fatalf("Invalid webhook config: %v", err)
+		}
+		log.Printf("Forwarding %s logs to webhook (batch %d, max %d posts/min)", levels, batch, perMinute)
+	}
+
+	if addr := os.Getenv("LISTEN_ADDR"); addr != "" {
+		listenAddr = addr
+	}
+	lis, err := net.Listen("tcp", listenAddr)
 	if err != nil {
 		log.Fatalf("failed to listen: %v", err)
 	}
 
-	// ClickHouse connection - dev mode (no authentication)
-	conn, err := clickhouse.Open(&clickhouse.Options{
-		Addr: []string{clickhouseAddr},
-		Auth: clickhouse.Auth{
-			Database: database,
-			// No username/password for dev mode
-		},
-	})
+	// ClickHouse connection: dev mode (no auth) unless CH_USER/CH_PASSWORD/CH_TLS are set
+	chOptions, err := clickHouseOptions(clickhouseAddr, database)
+	if err != nil {
+		log.Fatalf("Invalid ClickHouse connection settings: %v", err)
+	}
+	if sizeEnv := os.Getenv("BATCH_SIZE"); sizeEnv != "" {
+		size, err := strconv.Atoi(sizeEnv)
+		if err != nil || size <= 0 {
+			log.Fatalf("Invalid BATCH_SIZE: %q", sizeEnv)
+		}
+		batchSize = size
+	}
+
+	asyncInsert, err := parseAsyncInsert(os.Getenv("CH_ASYNC_INSERT"))
+	if err != nil {
+		log.Fatalf("Invalid CH_ASYNC_INSERT: %v", err)
+	}
+	if dedupMode == dedupCount && ackMode == ackDurable {
+		log.Fatalf("DEDUP_MODE=%s inserts entries up to a dedup window after they arrive; it cannot be combined with ACK_MODE=%s", dedupCount, ackDurable)
+	}
+	if asyncInsert == asyncInsertNoWait && ackMode == ackDurable {
+		log.Fatalf("CH_ASYNC_INSERT=%s acks inserts before they are written; it cannot be combined with ACK_MODE=%s", asyncInsertNoWait, ackDurable)
+	}
+	chOptions.Settings = asyncInsertSettings(asyncInsert)
+
+	flushOnSignal := true
+	if v := os.Getenv("FLUSH_ON_SIGUSR1"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			log.Fatalf("Invalid FLUSH_ON_SIGUSR1: %q (want true or false)", v)
+		}
+		flushOnSignal = b
+	}
+	log.Printf("Async insert: %s, batch size: %d", asyncInsert, batchSize)
+
+	insertWorkers := defaultInsertWorkers
+	if v := os.Getenv("INSERT_WORKERS"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			log.Fatalf("Invalid INSERT_WORKERS: %q", v)
+		}
+		insertWorkers = n
+	}
+	chOptions.MaxOpenConns, chOptions.MaxIdleConns = insertConnLimits(insertWorkers)
+
+	// Set after BATCH_SIZE, which the default depth follows
+	splitDepth := defaultSplitDepth(batchSize)
+	if v := os.Getenv("INSERT_SPLIT_DEPTH"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			log.Fatalf("Invalid INSERT_SPLIT_DEPTH: %q", v)
+		}
+		splitDepth = n
+	}
+	// DEAD_LETTER_TABLE set empty drops rejected rows instead
+	deadLetterTable, ok := os.LookupEnv("DEAD_LETTER_TABLE")
+	if !ok {
+		deadLetterTable = defaultDeadLetterTable
+	}
+	if deadLetterTable != "" && !identifierRegex.MatchString(deadLetterTable) {
+		log.Fatalf("Invalid DEAD_LETTER_TABLE: %q", deadLetterTable)
+	}
+	if splitDepth > 0 && deadLetterTable != "" {
+		log.Printf("Rejected batches split up to %d times, failing rows to %s", splitDepth, deadLetterTable)
+	} else if splitDepth > 0 {
+		log.Printf("Rejected batches split up to %d times, failing rows dropped", splitDepth)
+	}
+	log.Printf("Insert workers: %d (ClickHouse pool: %d connections)", insertWorkers, chOptions.MaxOpenConns)
+
+	breakerFailures := defaultBreakerFailures
+	if v := os.Getenv("INSERT_BREAKER_FAILURES"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			log.Fatalf("Invalid INSERT_BREAKER_FAILURES: %q", v)
+		}
+		breakerFailures = n
+	}
+	breakerCooldown := defaultBreakerCooldown
+	if v := os.Getenv("INSERT_BREAKER_COOLDOWN"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			log.Fatalf("Invalid INSERT_BREAKER_COOLDOWN: %q", v)
+		}
+		breakerCooldown = d
+	}
+	var breaker *insertBreaker
+	if breakerFailures > 0 {
+		breaker = newInsertBreaker(breakerFailures, breakerCooldown)
+	}
+
+	conn, err := clickhouse.Open(chOptions)
 	if err != nil {
 		log.Fatalf("Failed to connect to ClickHouse: %v", err)
 	}
@@ -319,34 +970,106 @@ func main() {
 	encoder, _ := zstd.NewWriter(nil)
 	decoder, _ := zstd.NewReader(nil)
 
-	s := grpc.NewServer()
+	if sizeEnv := os.Getenv("GRPC_MAX_MSG_BYTES"); sizeEnv != "" {
+		size, err := strconv.Atoi(sizeEnv)
+		if err != nil || size <= 0 {
+			log.Fatalf("Invalid GRPC_MAX_MSG_BYTES: %q", sizeEnv)
+		}
+		maxMsgBytes = size
+	}
+
+	keepaliveOpts, err := keepaliveServerOptions()
+	if err != nil {
+		log.Fatalf("Invalid keepalive settings: %v", err)
+	}
+	// GRPC_INTERCEPTORS lists the interceptors wrapping every RPC; set it empty for none
+	interceptorsEnv, ok := os.LookupEnv("GRPC_INTERCEPTORS")
+	if !ok {
+		interceptorsEnv = defaultGRPCInterceptors
+	}
+	interceptors, err := parseInterceptors(interceptorsEnv)
+	if err != nil {
+		log.Fatalf("Invalid GRPC_INTERCEPTORS: %v", err)
+	}
+	grpcStats := newRPCStats()
+	serverOpts := append([]grpc.ServerOption{
+		grpc.MaxRecvMsgSize(maxMsgBytes),
+		grpc.MaxSendMsgSize(maxMsgBytes),
+	}, keepaliveOpts...)
+	s := grpc.NewServer(append(serverOpts, interceptorOptions(interceptors, grpcStats)...)...)
 	server := &ingestionServer{
 		db:         conn,
-		logChan:    make(chan *pb.LogEntry, 1000),
-		dedupCache: &sync.Map{},
+		logChan:    make(chan queuedEntry, 1000),
+		ackMode:    ackMode,
+		dedupCache: newDedupCache(dedupWindow, time.Now()),
+		dedupFields: dedupKeyFields,
+		dedupExempt: dedupExempt,
+		dedupBypass: dedupBypass,
+		dedupMode:  dedupMode,
+		dedupScope: dedupScope,
+		grpcStats:  grpcStats,
+		routes:     routes,
+		webhook:    webhook,
+		breaker:    breaker,
+		asyncInsert: asyncInsert,
+		agents:     newAgentRegistry(),
+		e2eLatency: newLatencyWindow(e2eLatencySamples),
+		schemas:    newInsertSchemas(insertFieldMap),
+		flushOnSignal: flushOnSignal,
+		insertWorkers: insertWorkers,
+		splitDepth: splitDepth,
+		deadLetterTable: deadLetterTable,
+		resend:     newStoredEntries(),
 		encoder:    encoder,
 		decoder:    decoder,
+		clock:      realClock{},
 		startTime:  time.Now(),
 	}
 
+	if err := server.ensureSchema(context.Background()); err != nil {
+		log.Fatalf("Failed to prepare ClickHouse schema: %v", err)
+	}
+
 	pb.RegisterLogIngestionServer(s, server)
-	go server.batchWriter()
+	// Standard grpc.health.v1.Health, driven by ClickHouse reachability
+	healthServer := health.NewServer()
+	healthpb.RegisterHealthServer(s, healthServer)
+	go server.watchClickHouse(healthServer)
+	stopInsertWorkers := server.startInsertWorkers(insertWorkers)
+	server.clock.AfterFunc(dedupBucket, server.sweepDedup)
+	go server.flushAgents()
+	if webhook != nil {
+		go webhook.run()
+	}
 
 	// Start HTTP server for health and metrics
 	http.HandleFunc("/health", server.healthHandler)
 	http.HandleFunc("/metrics", server.metricsHandler)
+	// Admin: replay stored logs into other tables (needs API_KEY), see replay.go
+	http.Handle("/admin/replay", &replayManager{s: server, apiKey: os.Getenv("API_KEY")})
 	
 	httpPort := os.Getenv("HTTP_PORT")
 	if httpPort == "" {
 		httpPort = "8082"
 	}
+	// HTTP_LISTEN_ADDR takes precedence over HTTP_PORT when the bind address matters
+	httpAddr := os.Getenv("HTTP_LISTEN_ADDR")
+	if httpAddr == "" {
+		httpAddr = ":" + httpPort
+	}
 	
+	cors, err := corsPolicyFromEnv()
+	if err != nil {
+		log.Fatalf("Invalid CORS settings: %v", err)
+	}
+
 	httpServer := &http.Server{
-		Addr: ":" + httpPort,
+		Addr:    httpAddr,
+		Handler: corsHandler(cors, http.DefaultServeMux),
 	}
 	
 	go func() {
-		log.Printf("Starting HTTP server on port %s", httpPort)
+		log.Printf("Starting HTTP server on %s", httpAddr)
 		if err := httpServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
 			log.Printf("HTTP server error: %v", err)
 		}
@@ -374,8 +1097,16 @@ func main() {
 		log.Printf("HTTP server shutdown error: %v", err)
 	}
 	
-	// Gracefully stop gRPC server
+	// Gracefully stop gRPC server, telling health watchers first
+	healthServer.Shutdown()
 	s.GracefulStop()
+
+	// No more batches: insert what the dedup windows hold, then everything
+	// queued, before the connection goes
+	if held := server.drainDedup(); held > 0 {
+		log.Printf("Flushed %d dedup keys still in their window", held)
+	}
+	stopInsertWorkers()
 	
 	// Close ClickHouse connection
 	if conn != nil {
@@ -0,0 +1,460 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	pb "stackmonitor.com/ingestion-service/proto/logproto"
+)
+
+const (
+	// replayCheckpointsTable records each job's progress so it can resume
+	replayCheckpointsTable = "replay_checkpoints"
+	defaultReplayChunk     = time.Minute
+	defaultReplayRate      = 5000 // rows per second
+)
+
+// Replay job states
+const (
+	replayRunning   = "running"
+	replayCompleted = "completed"
+	replayCancelled = "cancelled"
+	replayFailed    = "failed"
+)
+
+// replayRequest is the body of POST /admin/replay. A job copies stored logs
+// from SourceTable in [From, To) into TargetTable, or into the tables the
+// current ROUTE_RULES pick when Route is set, applying SetFields on the way.
+// Re-posting the same JobID resumes from its last checkpoint.
+type replayRequest struct {
+	JobID       string            `json:"job_id"`
+	SourceTable string            `json:"source_table"`
+	TargetTable string            `json:"target_table"`
+	Route       bool              `json:"route"`
+	From        time.Time         `json:"from"`
+	To          time.Time         `json:"to"`
+	SetFields   map[string]string `json:"set_fields"`
+	// Rows read per query: a time slice of this length (default 1m)
+	Chunk string `json:"chunk"`
+	// Upper bound on rows written per second (default 5000)
+	RowsPerSecond int `json:"rows_per_second"`
+}
+
+// validate fills defaults and rejects requests that would be interpolated
+// unsafely into SQL or could never finish
+func (r *replayRequest) validate() (time.Duration, error) {
+	if r.JobID == "" {
+		return 0, errors.New("job_id is required")
+	}
+	if r.SourceTable == "" {
+		r.SourceTable = logsTable
+	}
+	if !identifierRegex.MatchString(r.SourceTable) {
+		return 0, fmt.Errorf("invalid source_table %q", r.SourceTable)
+	}
+	if r.Route == (r.TargetTable != "") {
+		return 0, errors.New("set exactly one of target_table or route")
+	}
+	if r.TargetTable != "" {
+		if !identifierRegex.MatchString(r.TargetTable) {
+			return 0, fmt.Errorf("invalid target_table %q", r.TargetTable)
+		}
+		if r.TargetTable == r.SourceTable {
+			return 0, errors.New("target_table must differ from source_table")
+		}
+	}
+	if r.From.IsZero() || r.To.IsZero() || !r.From.Before(r.To) {
+		return 0, errors.New("from and to are required RFC 3339 times with from before to")
+	}
+	if r.RowsPerSecond < 0 {
+		return 0, errors.New("rows_per_second must be positive")
+	}
+	if r.RowsPerSecond == 0 {
+		r.RowsPerSecond = defaultReplayRate
+	}
+	chunk := defaultReplayChunk
+	if r.Chunk != "" {
+		d, err := time.ParseDuration(r.Chunk)
+		if err != nil || d <= 0 {
+			return 0, fmt.Errorf("invalid chunk %q (want a duration such as 1m)", r.Chunk)
+		}
+		chunk = d
+	}
+	return chunk, nil
+}
+
+// replayJob is one running or finished replay
+type replayJob struct {
+	req     replayRequest
+	chunk   time.Duration
+	started time.Time
+	cancel  context.CancelFunc
+
+	mu     sync.Mutex
+	state  string
+	cursor time.Time // everything before it has been written
+	err    string
+
+	rowsRead    atomic.Uint64
+	rowsWritten atomic.Uint64
+	rowsSkipped atomic.Uint64 // routed back to the source table, or dropped by dedup
+}
+
+func (j *replayJob) setState(state string, err error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.state = state
+	if err != nil {
+		j.err = err.Error()
+	}
+}
+
+func (j *replayJob) status() map[string]interface{} {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	progress := float64(j.cursor.Sub(j.req.From)) / float64(j.req.To.Sub(j.req.From))
+	return map[string]interface{}{
+		"job_id":       j.req.JobID,
+		"state":        j.state,
+		"source_table": j.req.SourceTable,
+		"target_table": j.req.TargetTable,
+		"route":        j.req.Route,
+		"from":         j.req.From.Format(time.RFC3339),
+		"to":           j.req.To.Format(time.RFC3339),
+		"cursor":       j.cursor.Format(time.RFC3339Nano),
+		"progress":     progress,
+		"rows_read":    j.rowsRead.Load(),
+		"rows_written": j.rowsWritten.Load(),
+		"rows_skipped": j.rowsSkipped.Load(),
+		"started_at":   j.started.Format(time.RFC3339),
+		"error":        j.err,
+	}
+}
+
+// replayManager runs at most one replay job at a time behind the admin endpoints
+type replayManager struct {
+	s      *ingestionServer
+	apiKey string // API_KEY; empty disables the endpoints
+
+	mu  sync.Mutex
+	job *replayJob // current or most recent job
+}
+
+// ServeHTTP handles /admin/replay: POST starts or resumes a job, GET reports
+// on the current one and DELETE cancels it (its checkpoint is kept)
+func (m *replayManager) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if m.apiKey == "" {
+		writeJSON(w, http.StatusForbidden, map[string]interface{}{"error": "endpoint disabled: set API_KEY to enable"})
+		return
+	}
+	key := r.Header.Get("X-API-Key")
+	if key == "" {
+		key = strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	}
+	if subtle.ConstantTimeCompare([]byte(key), []byte(m.apiKey)) != 1 {
+		writeJSON(w, http.StatusUnauthorized, map[string]interface{}{"error": "invalid or missing API key"})
+		return
+	}
+
+	m.mu.Lock()
+	job := m.job
+	m.mu.Unlock()
+
+	switch r.Method {
+	case http.MethodGet:
+		if job == nil {
+			writeJSON(w, http.StatusNotFound, map[string]interface{}{"error": "no replay job has run"})
+			return
+		}
+		writeJSON(w, http.StatusOK, job.status())
+	case http.MethodDelete:
+		if job == nil {
+			writeJSON(w, http.StatusNotFound, map[string]interface{}{"error": "no replay job has run"})
+			return
+		}
+		job.cancel()
+		writeJSON(w, http.StatusAccepted, map[string]interface{}{"job_id": job.req.JobID, "status": "cancelling"})
+	case http.MethodPost:
+		var req replayRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]interface{}{"error": "invalid JSON body: " + err.Error()})
+			return
+		}
+		job, code, err := m.start(req)
+		if err != nil {
+			writeJSON(w, code, map[string]interface{}{"error": err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusAccepted, job.status())
+	default:
+		w.Header().Set("Allow", "GET, POST, DELETE")
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]interface{}{"error": "method not allowed"})
+	}
+}
+
+func writeJSON(w http.ResponseWriter, code int, body map[string]interface{}) {
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(body)
+}
+
+// start validates the request, resumes from any checkpoint and launches the job
+func (m *replayManager) start(req replayRequest) (*replayJob, int, error) {
+	chunk, err := req.validate()
+	if err != nil {
+		return nil, http.StatusBadRequest, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.job != nil {
+		m.job.mu.Lock()
+		running := m.job.state == replayRunning
+		m.job.mu.Unlock()
+		if running {
+			return nil, http.StatusConflict, fmt.Errorf("replay job %q is still running", m.job.req.JobID)
+		}
+	}
+
+	ctx := context.Background()
+	cursor, done, err := m.s.loadReplayCheckpoint(ctx, req.JobID)
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+	if done {
+		return nil, http.StatusConflict, fmt.Errorf("replay job %q already completed; use a new job_id", req.JobID)
+	}
+	if cursor.Before(req.From) || !cursor.Before(req.To) {
+		cursor = req.From
+	} else {
+		log.Printf("⏯️  Resuming replay %s from %s", req.JobID, cursor.Format(time.RFC3339Nano))
+	}
+
+	if req.TargetTable != "" && req.TargetTable != logsTable {
+		if err := m.s.ensureLogsTable(ctx, req.TargetTable); err != nil {
+			return nil, http.StatusInternalServerError, err
+		}
+	}
+
+	jobCtx, cancel := context.WithCancel(context.Background())
+	job := &replayJob{req: req, chunk: chunk, started: time.Now(), cancel: cancel, state: replayRunning, cursor: cursor}
+	m.job = job
+	go m.s.runReplay(jobCtx, job)
+	return job, 0, nil
+}
+
+// runReplay copies the job's range one time slice at a time. After each slice
+// is written the checkpoint advances, so a restart repeats at most one slice
+// (delivery is at-least-once). Writes are paced to RowsPerSecond.
+func (s *ingestionServer) runReplay(ctx context.Context, job *replayJob) {
+	req := job.req
+	log.Printf("🔁 Replay %s: %s -> %s, %s to %s", req.JobID, req.SourceTable, replayTarget(req),
+		job.cursor.Format(time.RFC3339), req.To.Format(time.RFC3339))
+
+	cursor := job.cursor
+	for cursor.Before(req.To) {
+		if ctx.Err() != nil {
+			job.setState(replayCancelled, nil)
+			log.Printf("⏹️  Replay %s cancelled at %s", req.JobID, cursor.Format(time.RFC3339Nano))
+			return
+		}
+
+		end := cursor.Add(job.chunk)
+		if end.After(req.To) {
+			end = req.To
+		}
+		start := time.Now()
+		written, err := s.replaySlice(ctx, job, cursor, end)
+		if err == nil {
+			err = s.saveReplayCheckpoint(ctx, req, end, false)
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				job.setState(replayCancelled, nil)
+				return
+			}
+			job.setState(replayFailed, err)
+			log.Printf("❌ Replay %s failed at %s: %v", req.JobID, cursor.Format(time.RFC3339Nano), err)
+			return
+		}
+
+		cursor = end
+		job.mu.Lock()
+		job.cursor = cursor
+		job.mu.Unlock()
+
+		// Rate limit: a slice of n rows takes at least n/RowsPerSecond seconds
+		budget := time.Duration(float64(written) / float64(req.RowsPerSecond) * float64(time.Second))
+		if wait := budget - time.Since(start); wait > 0 {
+			select {
+			case <-time.After(wait):
+			case <-ctx.Done():
+			}
+		}
+	}
+
+	if err := s.saveReplayCheckpoint(context.Background(), req, req.To, true); err != nil {
+		log.Printf("⚠️  Replay %s finished but its checkpoint was not saved: %v", req.JobID, err)
+	}
+	job.setState(replayCompleted, nil)
+	log.Printf("✅ Replay %s completed: %d rows read, %d written, %d skipped",
+		req.JobID, job.rowsRead.Load(), job.rowsWritten.Load(), job.rowsSkipped.Load())
+}
+
+func replayTarget(req replayRequest) string {
+	if req.Route {
+		return "ROUTE_RULES"
+	}
+	return req.TargetTable
+}
+
+// replaySlice reads [from, to) of the source table, transforms it and writes
+// it out, returning the number of rows written
+func (s *ingestionServer) replaySlice(ctx context.Context, job *replayJob, from, to time.Time) (int, error) {
+	req := job.req
+	rows, err := s.db.Query(ctx, fmt.Sprintf(`
+		SELECT timestamp, level, service, message, trace_id, agent_id, metadata
+		FROM %s.%s
+		WHERE timestamp >= ? AND timestamp < ?
+		ORDER BY timestamp`, database, req.SourceTable), from, to)
+	if err != nil {
+		return 0, fmt.Errorf("read %s: %w", req.SourceTable, err)
+	}
+	defer rows.Close()
+
+	byTable := make(map[string][]*pb.LogEntry)
+	for rows.Next() {
+		var (
+			ts                               time.Time
+			level, service, message, traceID string
+			agentID                          string
+			metadata                         map[string]string
+		)
+		if err := rows.Scan(&ts, &level, &service, &message, &traceID, &agentID, &metadata); err != nil {
+			return 0, fmt.Errorf("scan %s: %w", req.SourceTable, err)
+		}
+		job.rowsRead.Add(1)
+
+		entry := replayEntry(ts, level, service, message, traceID, agentID, metadata, req.SetFields)
+		table := req.TargetTable
+		if req.Route {
+			table = s.routeTable(entry)
+			if table == req.SourceTable {
+				// Nothing routes it elsewhere; copying it would duplicate it in place
+				job.rowsSkipped.Add(1)
+				continue
+			}
+		}
+		byTable[table] = append(byTable[table], entry)
+	}
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("read %s: %w", req.SourceTable, err)
+	}
+
+	written := 0
+	for table, logs := range byTable {
+		// Historical rows bypass dedup unless DEDUP_BYPASS_PATHS leaves replay out
+		logs, duplicates, _ := s.dedupFilter(logs, pathReplay)
+		job.rowsSkipped.Add(uint64(duplicates))
+		if req.Route && table != logsTable {
+			if err := s.ensureLogsTable(ctx, table); err != nil {
+				return written, err
+			}
+		}
+		if err := s.writeRows(ctx, table, logs); err != nil {
+			return written, fmt.Errorf("write %s: %w", table, err)
+		}
+		written += len(logs)
+		job.rowsWritten.Add(uint64(len(logs)))
+	}
+	return written, nil
+}
+
+// replayEntry rebuilds an entry from a stored row. writeRows takes service and
+// trace_id from the fields, as the agents send them; setFields are applied last.
+func replayEntry(ts time.Time, level, service, message, traceID, agentID string, metadata, setFields map[string]string) *pb.LogEntry {
+	fields := make(map[string]string, len(metadata)+len(setFields)+2)
+	for k, v := range metadata {
+		fields[k] = v
+	}
+	fields["service"] = service
+	fields["trace_id"] = traceID
+	for k, v := range setFields {
+		fields[k] = v
+	}
+	return &pb.LogEntry{
+		TimestampNs: ts.UnixNano(),
+		Level:       level,
+		Message:     message,
+		Fields:      fields,
+		AgentId:     agentID,
+	}
+}
+
+// ensureLogsTable creates a table with the default logs schema if it is missing
+func (s *ingestionServer) ensureLogsTable(ctx context.Context, table string) error {
+	query := fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s.%s AS %s.%s", database, table, database, logsTable)
+	if err := s.db.Exec(ctx, query); err != nil {
+		return fmt.Errorf("create table %s: %w", table, err)
+	}
+	return nil
+}
+
+func (s *ingestionServer) ensureReplayCheckpointsTable(ctx context.Context) error {
+	query := fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s.%s (
+		job_id String,
+		source_table String,
+		target String,
+		cursor DateTime64(9),
+		done UInt8,
+		updated_at DateTime64(3)
+	) ENGINE = ReplacingMergeTree(updated_at) ORDER BY job_id`, database, replayCheckpointsTable)
+	if err := s.db.Exec(ctx, query); err != nil {
+		return fmt.Errorf("create table %s: %w", replayCheckpointsTable, err)
+	}
+	return nil
+}
+
+// loadReplayCheckpoint returns a job's last cursor; a zero time means it never ran
+func (s *ingestionServer) loadReplayCheckpoint(ctx context.Context, jobID string) (time.Time, bool, error) {
+	rows, err := s.db.Query(ctx, fmt.Sprintf(`
+		SELECT argMax(cursor, updated_at), argMax(done, updated_at)
+		FROM %s.%s
+		WHERE job_id = ?
+		GROUP BY job_id`, database, replayCheckpointsTable), jobID)
+	if err != nil {
+		return time.Time{}, false, fmt.Errorf("load replay checkpoint: %w", err)
+	}
+	defer rows.Close()
+
+	var cursor time.Time
+	var done uint8
+	if rows.Next() {
+		if err := rows.Scan(&cursor, &done); err != nil {
+			return time.Time{}, false, fmt.Errorf("load replay checkpoint: %w", err)
+		}
+	}
+	return cursor, done == 1, rows.Err()
+}
+
+func (s *ingestionServer) saveReplayCheckpoint(ctx context.Context, req replayRequest, cursor time.Time, done bool) error {
+	var doneFlag uint8
+	if done {
+		doneFlag = 1
+	}
+	err := s.db.Exec(ctx, fmt.Sprintf("INSERT INTO %s.%s VALUES (?, ?, ?, ?, ?, ?)", database, replayCheckpointsTable),
+		req.JobID, req.SourceTable, replayTarget(req), cursor, doneFlag, time.Now())
+	if err != nil {
+		return fmt.Errorf("save replay checkpoint: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+
+	pb "stackmonitor.com/ingestion-service/proto/logproto"
+)
+
+// With ACK_MODE=durable a batch whose insert fails is acked RETRY and the
+// agent resends all of it, under a new batch ID. Part of it may already be
+// stored: a split insert (insert_split.go) cut short by a lost connection
+// keeps the halves it wrote and dead-lettered. Those entries are remembered
+// per agent and dropped from its next batches, so the resend only writes what
+// is missing. An identical entry (same timestamp, fields and message) sent
+// again within storedEntryTTL is dropped too.
+const storedEntryTTL = 10 * time.Minute
+
+// storedEntries remembers, per agent, entries stored from batches acked RETRY
+type storedEntries struct {
+	mu      sync.Mutex
+	byAgent map[string]map[string]time.Time // agent -> entry fingerprint -> expiry
+}
+
+func newStoredEntries() *storedEntries {
+	return &storedEntries{byAgent: make(map[string]map[string]time.Time)}
+}
+
+// entryFingerprint identifies an entry by its whole content
+func entryFingerprint(entry *pb.LogEntry) string {
+	b, _ := proto.MarshalOptions{Deterministic: true}.Marshal(entry)
+	return string(b)
+}
+
+// remember records entries of agent as stored
+func (r *storedEntries) remember(agent string, entries []*pb.LogEntry, now time.Time) {
+	if len(entries) == 0 {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.expire(now)
+	stored := r.byAgent[agent]
+	if stored == nil {
+		stored = make(map[string]time.Time)
+		r.byAgent[agent] = stored
+	}
+	for _, entry := range entries {
+		stored[entryFingerprint(entry)] = now.Add(storedEntryTTL)
+	}
+}
+
+// skip returns entries without the ones of agent already stored, which are
+// forgotten: a later copy is a new entry
+func (r *storedEntries) skip(agent string, entries []*pb.LogEntry, now time.Time) (rest []*pb.LogEntry, skipped int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.expire(now)
+	stored := r.byAgent[agent]
+	if len(stored) == 0 {
+		return entries, 0
+	}
+	rest = make([]*pb.LogEntry, 0, len(entries))
+	for _, entry := range entries {
+		fingerprint := entryFingerprint(entry)
+		if _, ok := stored[fingerprint]; ok {
+			delete(stored, fingerprint)
+			skipped++
+			continue
+		}
+		rest = append(rest, entry)
+	}
+	if len(stored) == 0 {
+		delete(r.byAgent, agent)
+	}
+	return rest, skipped
+}
+
+// expire drops entries whose resend never came; r.mu must be held
+func (r *storedEntries) expire(now time.Time) {
+	for agent, stored := range r.byAgent {
+		for fingerprint, expiry := range stored {
+			if now.After(expiry) {
+				delete(stored, fingerprint)
+			}
+		}
+		if len(stored) == 0 {
+			delete(r.byAgent, agent)
+		}
+	}
+}
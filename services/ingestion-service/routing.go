@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	pb "stackmonitor.com/ingestion-service/proto/logproto"
+)
+
+var identifierRegex = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// routeRule sends entries whose field equals value to a dedicated table.
+// High-volume access logs and low-volume error logs can then get their own
+// retention and sort keys instead of sharing stackmonitor.logs.
+type routeRule struct {
+	field string
+	value string
+	table string
+}
+
+// parseRouteRules parses ROUTE_RULES, e.g. "level=ERROR:logs_errors,service=nginx:logs_access".
+// Rules are evaluated in order and the first match wins.
+func parseRouteRules(raw string) ([]routeRule, error) {
+	var rules []routeRule
+	for _, spec := range strings.Split(raw, ",") {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+		match, table, ok := strings.Cut(spec, ":")
+		if !ok {
+			return nil, fmt.Errorf("route %q: want field=value:table", spec)
+		}
+		field, value, ok := strings.Cut(match, "=")
+		if !ok || field == "" {
+			return nil, fmt.Errorf("route %q: want field=value:table", spec)
+		}
+		if !identifierRegex.MatchString(table) {
+			return nil, fmt.Errorf("route %q: invalid table name %q", spec, table)
+		}
+		rules = append(rules, routeRule{field: field, value: value, table: table})
+	}
+	return rules, nil
+}
+
+// entryField returns the value of a routable field on an entry
+func entryField(entry *pb.LogEntry, field string) string {
+	switch field {
+	case "level":
+		return entry.Level
+	case "source":
+		return entry.Source
+	case "agent_id":
+		return entry.AgentId
+	default:
+		return entry.Fields[field]
+	}
+}
+
+// routeTable picks the target table for an entry
+func (s *ingestionServer) routeTable(entry *pb.LogEntry) string {
+	for _, rule := range s.routes {
+		if entryField(entry, rule.field) == rule.value {
+			return rule.table
+		}
+	}
+	return logsTable
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"io"
+	"log"
+	"testing"
+	"time"
+
+	pb "stackmonitor.com/ingestion-service/proto/logproto"
+)
+
+func TestShutdownFlushesHeldEntries(t *testing.T) {
+	defer log.SetOutput(log.Writer())
+	log.SetOutput(io.Discard)
+
+	tests := []struct {
+		name string
+		mode string
+		want uint64 // rows inserted after the duplicate has been dropped
+	}{
+		// Both firsts were held, one with occurrences=2
+		{name: "count", mode: dedupCount, want: 2},
+		// Both firsts were inserted on arrival; the duplicate adds a collapsed row
+		{name: "collapse", mode: dedupCollapse, want: 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := &fakeConn{}
+			s := benchServer(db)
+			s.dedupMode = tt.mode
+			s.dedupFields = []string{"message", "level", "service"}
+			s.dedupCache = newDedupCache(dedupWindow, time.Now())
+			stop := s.startInsertWorkers(2)
+
+			entries := []*pb.LogEntry{
+				{Level: "WARN", Message: "slow query 1200ms"},
+				{Level: "WARN", Message: "slow query 1200ms"},
+				{Level: "INFO", Message: "user login ok"},
+			}
+			fresh, _, _ := s.dedupFilter(entries, pathStream)
+			for _, entry := range fresh {
+				s.logChan <- queuedEntry{entry: entry}
+			}
+
+			// Well within the window and batchTimeout: only the shutdown stores them
+			if held := s.drainDedup(); held != 2 {
+				t.Errorf("drainDedup flushed %d keys, want 2", held)
+			}
+			stop()
+			if got := db.rows.Load(); got != tt.want {
+				t.Errorf("%d rows inserted, want %d", got, tt.want)
+			}
+			if held := s.logsHeld.Load(); held != 0 {
+				t.Errorf("logs_held = %d after shutdown, want 0", held)
+			}
+		})
+	}
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync/atomic"
+	"text/template"
+	"time"
+
+	pb "stackmonitor.com/ingestion-service/proto/logproto"
+)
+
+const (
+	defaultWebhookLevels    = "ERROR"
+	defaultWebhookBatchSize = 20
+	defaultWebhookFlush     = 5 * time.Second
+	defaultWebhookPerMinute = 30
+	webhookQueueSize        = 1000
+	webhookTimeout          = 10 * time.Second
+)
+
+// webhookEntry is the view of a log entry exposed to webhook templates
+type webhookEntry struct {
+	Timestamp time.Time         `json:"timestamp"`
+	Level     string            `json:"level"`
+	Service   string            `json:"service"`
+	Message   string            `json:"message"`
+	TraceID   string            `json:"trace_id,omitempty"`
+	AgentID   string            `json:"agent_id,omitempty"`
+	Fields    map[string]string `json:"fields,omitempty"`
+}
+
+// webhookPayload is the data passed to WEBHOOK_TEMPLATE
+type webhookPayload struct {
+	Count   int
+	Entries []webhookEntry
+}
+
+// webhookFuncs are available in WEBHOOK_TEMPLATE; json encodes any value,
+// so strings can be embedded safely: {"text": {{json .Count}}}
+var webhookFuncs = template.FuncMap{
+	"json": func(v interface{}) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+}
+
+// webhookSink forwards inserted entries matching a level/pattern filter to an
+// external URL. Entries are queued without blocking the insert path; when the
+// queue is full they are dropped and counted. Posts are batched and spaced to
+// stay under perMinute requests per minute.
+type webhookSink struct {
+	url       string
+	levels    map[string]bool
+	pattern   *regexp.Regexp // nil matches every message
+	tmpl      *template.Template
+	batchSize int
+	flush     time.Duration
+	interval  time.Duration // minimum gap between posts
+	client    *http.Client
+	queue     chan *pb.LogEntry
+
+	sent    atomic.Uint64 // entries delivered
+	dropped atomic.Uint64 // entries lost to a full queue
+	failed  atomic.Uint64 // posts that errored or got a non-2xx response
+}
+
+func newWebhookSink(url, levels, pattern, tmpl string, batchSize int, flush time.Duration, perMinute int) (*webhookSink, error) {
+	w := &webhookSink{
+		url:       url,
+		levels:    make(map[string]bool),
+		batchSize: batchSize,
+		flush:     flush,
+		interval:  time.Minute / time.Duration(perMinute),
+		client:    &http.Client{Timeout: webhookTimeout},
+		queue:     make(chan *pb.LogEntry, webhookQueueSize),
+	}
+	for _, level := range strings.Split(levels, ",") {
+		if level = strings.ToUpper(strings.TrimSpace(level)); level != "" {
+			w.levels[level] = true
+		}
+	}
+	if len(w.levels) == 0 {
+		return nil, fmt.Errorf("no webhook levels configured")
+	}
+	if pattern != "" {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern: %w", err)
+		}
+		w.pattern = re
+	}
+	if tmpl != "" {
+		t, err := template.New("webhook").Funcs(webhookFuncs).Parse(tmpl)
+		if err != nil {
+			return nil, fmt.Errorf("invalid template: %w", err)
+		}
+		w.tmpl = t
+	}
+	return w, nil
+}
+
+// matches reports whether an entry should be forwarded
+func (w *webhookSink) matches(entry *pb.LogEntry) bool {
+	if !w.levels[strings.ToUpper(entry.Level)] {
+		return false
+	}
+	return w.pattern == nil || w.pattern.MatchString(entry.Message)
+}
+
+// offer queues a matching entry without ever blocking the caller
+func (w *webhookSink) offer(entry *pb.LogEntry) {
+	if !w.matches(entry) {
+		return
+	}
+	select {
+	case w.queue <- entry:
+	default:
+		w.dropped.Add(1)
+	}
+}
+
+// run batches queued entries and posts them; call it in its own goroutine
+func (w *webhookSink) run() {
+	ticker := time.NewTicker(w.flush)
+	defer ticker.Stop()
+	var buffer []*pb.LogEntry
+	var lastPost time.Time
+
+	post := func() {
+		if wait := w.interval - time.Since(lastPost); wait > 0 {
+			time.Sleep(wait)
+		}
+		lastPost = time.Now()
+		if err := w.post(buffer); err != nil {
+			w.failed.Add(1)
+			log.Printf("⚠️  Webhook post of %d entries failed: %v", len(buffer), err)
+		} else {
+			w.sent.Add(uint64(len(buffer)))
+		}
+		buffer = nil
+	}
+
+	for {
+		select {
+		case entry := <-w.queue:
+			buffer = append(buffer, entry)
+			if len(buffer) >= w.batchSize {
+				post()
+			}
+		case <-ticker.C:
+			if len(buffer) > 0 {
+				post()
+			}
+		}
+	}
+}
+
+// post renders the payload for a batch and sends it
+func (w *webhookSink) post(entries []*pb.LogEntry) error {
+	body, err := w.render(entries)
+	if err != nil {
+		return err
+	}
+	resp, err := w.client.Post(w.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
+
+// render builds the request body. Without a template it sends a Slack-compatible
+// "text" summary plus the raw entries.
+func (w *webhookSink) render(entries []*pb.LogEntry) ([]byte, error) {
+	payload := webhookPayload{Count: len(entries)}
+	for _, entry := range entries {
+		service := entry.Fields["service"]
+		if service == "" {
+			service = "unknown"
+		}
+		payload.Entries = append(payload.Entries, webhookEntry{
+			Timestamp: time.Unix(0, entry.TimestampNs).UTC(),
+			Level:     entry.Level,
+			Service:   service,
+			Message:   entry.Message,
+			TraceID:   entry.Fields["trace_id"],
+			AgentID:   entry.AgentId,
+			Fields:    entry.Fields,
+		})
+	}
+
+	if w.tmpl != nil {
+		var buf bytes.Buffer
+		if err := w.tmpl.Execute(&buf, payload); err != nil {
+			return nil, fmt.Errorf("render template: %w", err)
+		}
+		return buf.Bytes(), nil
+	}
+
+	var text strings.Builder
+	fmt.Fprintf(&text, "StackMonitor: %d new log(s)", payload.Count)
+	for _, e := range payload.Entries {
+		fmt.Fprintf(&text, "\n• [%s] %s: %s", e.Level, e.Service, e.Message)
+	}
+	return json.Marshal(map[string]interface{}{
+		"text":    text.String(),
+		"entries": payload.Entries,
+	})
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Analysis depths, chosen with "depth" on POST /mcp/query
+const (
+	depthQuick    = "quick"
+	depthStandard = "standard"
+	depthDeep     = "deep"
+)
+
+// analysisDepth is how much an LLM analysis looks at and asks for: quick is a
+// cheap one-paragraph summary of the last hour, deep pulls a day of logs and
+// asks for root-cause hypotheses
+type analysisDepth struct {
+	name   string
+	window time.Duration // how far back logs are pulled from
+	// limitFactor scales MCP_ANALYSIS_LIMIT; promptLogs caps the logs quoted to the LLM
+	limitFactor float64
+	promptLogs  int
+	questions   string
+}
+
+var analysisDepths = map[string]analysisDepth{
+	depthQuick: {
+		name:        depthQuick,
+		window:      time.Hour,
+		limitFactor: 0.2,
+		promptLogs:  10,
+		questions: `In one short paragraph, without headings or lists, summarize the main issue
+and the services it affects. Mention a second issue only if it is serious.`,
+	},
+	depthStandard: {
+		name:        depthStandard,
+		window:      6 * time.Hour,
+		limitFactor: 1,
+		promptLogs:  50,
+		questions: `Please provide a comprehensive analysis that answers:
+1. What are the most common types of errors/issues?
+2. What patterns do you see?
+3. What are the main causes?
+4. What services are most affected?
+5. Any recommendations?
+
+Format your response in a clear, structured way with headings and bullet points. Be specific and actionable.`,
+	},
+	depthDeep: {
+		name:        depthDeep,
+		window:      24 * time.Hour,
+		limitFactor: 4,
+		promptLogs:  200,
+		questions: `Please provide an in-depth analysis that answers:
+1. What are the most common types of errors/issues, and how often does each occur?
+2. How do they develop over time: when did each start, and do bursts line up across services?
+3. Which services are affected, and which failures look like consequences of another service's?
+4. Root-cause hypotheses, most likely first: for each, the log evidence for and against it,
+   and the check that would confirm or rule it out.
+5. Recommendations, ordered by impact, separating immediate mitigation from lasting fixes.
+
+Format your response in a clear, structured way with headings and bullet points. Quote the log lines you rely on.`,
+	},
+}
+
+// depthFor returns the analysis depth for a name; "" is standard
+func depthFor(name string) (analysisDepth, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if name == "" {
+		name = depthStandard
+	}
+	if depth, ok := analysisDepths[name]; ok {
+		return depth, nil
+	}
+	return analysisDepth{}, fmt.Errorf("unknown depth %q (want %s, %s or %s)", name, depthQuick, depthStandard, depthDeep)
+}
+
+// limit is how many logs the depth pulls, given MCP_ANALYSIS_LIMIT and MCP_MAX_LIMIT
+func (d analysisDepth) limit(analysisLimit, maxLimit int) int {
+	return min(max(1, int(float64(analysisLimit)*d.limitFactor)), maxLimit)
+}
+
+// formatWindow renders a window as "6h" rather than "6h0m0s"
+func formatWindow(d time.Duration) string {
+	return strings.TrimSuffix(strings.TrimSuffix(d.String(), "0s"), "0m")
+}
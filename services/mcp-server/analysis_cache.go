@@ -0,0 +1,163 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"stackmonitor.com/mcp-server/client"
+)
+
+// The analysis cache answers a repeated LLM analysis question without asking
+// the LLM again. An answer is kept for MCP_ANALYSIS_CACHE_TTL (default 2m, 0
+// disables) under the normalized query and depth, and is reused only while
+// the data behind it is unchanged:
+//
+//   - the ERROR and WARN counts of /logs/stats are the cheap signal: if they
+//     match, the answer is returned without fetching logs at all
+//   - if they moved (e.g. a log outside the analyzed window), the logs are
+//     fetched and their hash compared, so the LLM is only asked again when
+//     the logs it would read differ
+//
+// Debug responses report "cache": "hit" or "miss" for analysis queries.
+const (
+	defaultAnalysisCacheTTL = 2 * time.Minute
+	analysisCacheMaxEntries = 256
+)
+
+// Cache outcomes reported in debug responses
+const (
+	cacheHit  = "hit"
+	cacheMiss = "miss"
+)
+
+type analysisCacheEntry struct {
+	signal   string // stats counts when the answer was made
+	dataHash string // hash of the logs the LLM read
+	result   queryResult
+	expires  time.Time
+}
+
+type analysisCache struct {
+	ttl time.Duration
+
+	mu      sync.Mutex
+	entries map[string]*analysisCacheEntry
+
+	hits, misses atomic.Uint64
+}
+
+// newAnalysisCache returns nil, a cache that never hits, when ttl is not positive
+func newAnalysisCache(ttl time.Duration) *analysisCache {
+	if ttl <= 0 {
+		return nil
+	}
+	return &analysisCache{ttl: ttl, entries: make(map[string]*analysisCacheEntry)}
+}
+
+// analysisCacheKey normalizes case and spacing, so "Analyze  errors" and
+// "analyze errors" share an answer
+func analysisCacheKey(query string, depth analysisDepth) string {
+	return depth.name + "\x00" + strings.Join(strings.Fields(strings.ToLower(query)), " ")
+}
+
+// statsSignal is the cheap change signal: the ERROR and WARN counts an
+// analysis reads from
+func statsSignal(stats *client.Stats) string {
+	return fmt.Sprintf("%d/%d", stats.Errors, stats.Warnings)
+}
+
+// hashLogs fingerprints the logs an analysis reads
+func hashLogs(logs []client.Log) string {
+	h := sha256.New()
+	for _, l := range logs {
+		fmt.Fprintf(h, "%s\x00%s\x00%s\x00%s\n", l.Timestamp.Format(time.RFC3339Nano), l.Level, l.Service, l.Message)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// bySignal returns the answer for key if the stats signal is unchanged
+func (c *analysisCache) bySignal(key, signal string, now time.Time) (queryResult, bool) {
+	if c == nil || signal == "" {
+		return queryResult{}, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry := c.live(key, now)
+	if entry == nil || entry.signal != signal {
+		return queryResult{}, false
+	}
+	c.hits.Add(1)
+	return entry.result, true
+}
+
+// byData returns the answer for key if the fetched logs are the ones it was
+// made from, and adopts the new signal so the next lookup can skip the fetch
+func (c *analysisCache) byData(key, signal, dataHash string, now time.Time) (queryResult, bool) {
+	if c == nil {
+		return queryResult{}, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry := c.live(key, now)
+	if entry == nil || entry.dataHash != dataHash {
+		c.misses.Add(1)
+		return queryResult{}, false
+	}
+	entry.signal = signal
+	c.hits.Add(1)
+	return entry.result, true
+}
+
+// live returns key's unexpired entry; the caller holds mu
+func (c *analysisCache) live(key string, now time.Time) *analysisCacheEntry {
+	entry, ok := c.entries[key]
+	if !ok {
+		return nil
+	}
+	if !now.Before(entry.expires) {
+		delete(c.entries, key)
+		return nil
+	}
+	return entry
+}
+
+// put stores an LLM answer; when the cache is full of live entries it is not stored
+func (c *analysisCache) put(key, signal, dataHash string, result queryResult, now time.Time) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.entries[key]; !ok && len(c.entries) >= analysisCacheMaxEntries {
+		for k, entry := range c.entries {
+			if !now.Before(entry.expires) {
+				delete(c.entries, k)
+			}
+		}
+		if len(c.entries) >= analysisCacheMaxEntries {
+			return
+		}
+	}
+	c.entries[key] = &analysisCacheEntry{signal: signal, dataHash: dataHash, result: result, expires: now.Add(c.ttl)}
+}
+
+// stats is the analysis_cache section of /health, nil when disabled
+func (c *analysisCache) stats() map[string]interface{} {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	entries := len(c.entries)
+	c.mu.Unlock()
+	return map[string]interface{}{
+		"ttl":     c.ttl.String(),
+		"entries": entries,
+		"hits":    c.hits.Load(),
+		"misses":  c.misses.Load(),
+	}
+}
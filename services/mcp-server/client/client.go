@@ -0,0 +1,257 @@
+// Package client is a typed Go client for the api-server's REST API, so
+// consumers share one set of request and response types instead of building
+// URLs and parsing JSON by hand.
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultMaxResponseBytes bounds response bodies unless SetMaxResponseBytes says otherwise
+const DefaultMaxResponseBytes = 16 << 20
+
+// Client calls one api-server. Timeouts come from the http.Client and the
+// per-call context.
+type Client struct {
+	baseURL  string // e.g. http://api-server:5000/api/v1
+	http     *http.Client
+	maxBytes int64
+}
+
+// New returns a client for the API under baseURL; a nil httpClient uses http.DefaultClient
+func New(baseURL string, httpClient *http.Client) *Client {
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	return &Client{baseURL: strings.TrimSuffix(baseURL, "/"), http: httpClient, maxBytes: DefaultMaxResponseBytes}
+}
+
+// SetMaxResponseBytes bounds how much of a response body is read; larger
+// responses fail with ErrResponseTooLarge instead of being buffered
+func (c *Client) SetMaxResponseBytes(n int64) {
+	if n > 0 {
+		c.maxBytes = n
+	}
+}
+
+// APIError is a non-2xx response, with the server's error envelope when it sent one
+type APIError struct {
+	StatusCode int
+	Code       string // e.g. invalid_request, query_timeout
+	Message    string
+	RequestID  string // matches the api-server's log line for the failure
+}
+
+func (e *APIError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("api-server returned %d %s", e.StatusCode, http.StatusText(e.StatusCode))
+	}
+	if e.RequestID != "" {
+		return fmt.Sprintf("api-server returned %d: %s (request %s)", e.StatusCode, e.Message, e.RequestID)
+	}
+	return fmt.Sprintf("api-server returned %d: %s", e.StatusCode, e.Message)
+}
+
+// ErrQueryFailed is returned when the api-server answers but reports that its query failed
+var ErrQueryFailed = errors.New("api-server query failed")
+
+// ErrResponseTooLarge is returned, wrapped with the path and limit, when a
+// response body exceeds the client's maximum
+var ErrResponseTooLarge = errors.New("api-server response too large")
+
+// Filter selects logs. Zero values are left to the server's defaults.
+type Filter struct {
+	Service string // a service or a SERVICE_GROUPS group
+	Level   string // ERROR, WARN, INFO, ...
+	// MinLevel keeps this level and more severe ones (min_level); ignored when Level is set
+	MinLevel string
+	Limit    int           // at most this many logs (n on /logs/tail)
+	Window   time.Duration // how far back /logs/tail looks; the server's 1h when zero
+}
+
+// Log is one log record as the API returns it
+type Log struct {
+	Timestamp  time.Time         `json:"timestamp"`
+	Level      string            `json:"level"`
+	Service    string            `json:"service"`
+	Message    string            `json:"message"`
+	TraceID    string            `json:"trace_id"`
+	AgentID    string            `json:"agent_id"`
+	IngestedAt time.Time         `json:"ingested_at"`
+	Fields     map[string]string `json:"fields"`
+}
+
+// RatePoint is one bucket of the error-rate series
+type RatePoint struct {
+	Time  time.Time `json:"time"`
+	Count uint64    `json:"count"`
+}
+
+// ErrorRate is the /metrics/error-rate response
+type ErrorRate struct {
+	Status   string      `json:"status"`
+	Count    uint64      `json:"count"` // errors in the whole range
+	Metrics  []RatePoint `json:"metrics"`
+	Services []string    `json:"services,omitempty"` // members, when the service was a group
+}
+
+// Stats is the /logs/stats response
+type Stats struct {
+	Status   string `json:"status"`
+	Total    uint64 `json:"total"`
+	Errors   uint64 `json:"errors"`
+	Warnings uint64 `json:"warnings"`
+	Info     uint64 `json:"info"`
+}
+
+// ServiceInfo is one service of the /services registry
+type ServiceInfo struct {
+	Team        string `json:"team,omitempty"`
+	Owner       string `json:"owner,omitempty"`
+	Criticality string `json:"criticality"` // critical, high, medium or low
+	Runbook     string `json:"runbook,omitempty"`
+}
+
+// logList is the body of /logs and /logs/tail
+type logList struct {
+	Logs []Log `json:"logs"`
+}
+
+// GetLogs queries GET /logs, newest first
+func (c *Client) GetLogs(ctx context.Context, f Filter) ([]Log, error) {
+	params := f.params()
+	if f.Limit > 0 {
+		params.Set("limit", strconv.Itoa(f.Limit))
+	}
+	var out logList
+	if err := c.get(ctx, "/logs", params, &out); err != nil {
+		return nil, err
+	}
+	return out.Logs, nil
+}
+
+// Tail queries GET /logs/tail: the newest logs of the last hour (or f.Window), never cached
+func (c *Client) Tail(ctx context.Context, f Filter) ([]Log, error) {
+	params := f.params()
+	if f.Limit > 0 {
+		params.Set("n", strconv.Itoa(f.Limit))
+	}
+	if f.Window > 0 {
+		params.Set("window", f.Window.String())
+	}
+	var out logList
+	if err := c.get(ctx, "/logs/tail", params, &out); err != nil {
+		return nil, err
+	}
+	return out.Logs, nil
+}
+
+// ErrorRate queries GET /metrics/error-rate; service may be empty, rangeStr is 15m, 1h, 6h, 24h or all
+func (c *Client) ErrorRate(ctx context.Context, service, rangeStr string) (*ErrorRate, error) {
+	params := Filter{Service: service}.params()
+	if rangeStr != "" {
+		params.Set("range", rangeStr)
+	}
+	var out ErrorRate
+	if err := c.get(ctx, "/metrics/error-rate", params, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// Stats queries GET /logs/stats
+func (c *Client) Stats(ctx context.Context) (*Stats, error) {
+	var out Stats
+	if err := c.get(ctx, "/logs/stats", nil, &out); err != nil {
+		return nil, err
+	}
+	// Older api-servers reported a failed stats query in the status with a 200
+	if out.Status == "error" {
+		return nil, ErrQueryFailed
+	}
+	return &out, nil
+}
+
+// Services queries GET /services: the registry's metadata by service name,
+// empty when the api-server has no SERVICE_REGISTRY_FILE
+func (c *Client) Services(ctx context.Context) (map[string]ServiceInfo, error) {
+	var out struct {
+		Services map[string]ServiceInfo `json:"services"`
+	}
+	if err := c.get(ctx, "/services", nil, &out); err != nil {
+		return nil, err
+	}
+	return out.Services, nil
+}
+
+func (f Filter) params() url.Values {
+	params := url.Values{}
+	if f.Service != "" {
+		params.Set("service", f.Service)
+	}
+	if f.Level != "" {
+		params.Set("level", f.Level)
+	} else if f.MinLevel != "" {
+		params.Set("min_level", f.MinLevel)
+	}
+	return params
+}
+
+// get fetches path with params and decodes the JSON body into out
+func (c *Client) get(ctx context.Context, path string, params url.Values, out interface{}) error {
+	u := c.baseURL + path
+	if len(params) > 0 {
+		u += "?" + params.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	tooLarge := fmt.Errorf("%s: %w (over %d bytes)", path, ErrResponseTooLarge, c.maxBytes)
+	if resp.ContentLength > c.maxBytes {
+		return tooLarge
+	}
+	// One byte past the limit tells a body of exactly maxBytes from a longer one
+	body, err := io.ReadAll(io.LimitReader(resp.Body, c.maxBytes+1))
+	if err != nil {
+		return err
+	}
+	if int64(len(body)) > c.maxBytes {
+		return tooLarge
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		apiErr := &APIError{StatusCode: resp.StatusCode}
+		var e struct {
+			Error struct {
+				Code      string `json:"code"`
+				Message   string `json:"message"`
+				RequestID string `json:"request_id"`
+			} `json:"error"`
+		}
+		if json.Unmarshal(body, &e) == nil {
+			apiErr.Code, apiErr.Message, apiErr.RequestID = e.Error.Code, e.Error.Message, e.Error.RequestID
+		}
+		return apiErr
+	}
+	if err := json.Unmarshal(body, out); err != nil {
+		return fmt.Errorf("decode %s response: %w", path, err)
+	}
+	return nil
+}
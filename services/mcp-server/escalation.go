@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// escalationRule is a combination of categories that, seen together, points at
+// a bigger incident than either alone: repeated breaker trips next to upstream
+// errors usually mean one failing dependency is taking its callers down.
+type escalationRule struct {
+	Name     string
+	Title    string
+	Emoji    string
+	Severity string
+	Requires map[string]int // category name -> minimum errors; every entry must be met
+	Steps    []string       // remediation, most urgent first
+}
+
+// escalationRules match findings by category name, so a RECOMMENDATION_CATALOG
+// that keeps the built-in names gets them too
+var escalationRules = []escalationRule{
+	{
+		Name: "cascading_failure", Title: "Likely Cascading Failure", Emoji: "🌊", Severity: "critical",
+		Requires: map[string]int{"circuit": 3, "upstream": 3},
+		Steps: []string{
+			"Find the first dependency that failed: compare the earliest upstream and breaker errors per service",
+			"Shed load or fail fast at the edge so callers stop piling retries onto it",
+			"Restore the failing dependency before resetting breakers, then reopen traffic gradually",
+			"Afterwards, review timeouts and retry budgets so one dependency can't exhaust its callers",
+		},
+	},
+	{
+		Name: "cascading_failure", Title: "Likely Cascading Failure", Emoji: "🌊", Severity: "critical",
+		Requires: map[string]int{"connection": 5, "upstream": 3},
+		Steps: []string{
+			"Check whether the unreachable backend is down or overloaded; its health decides everything else",
+			"Cut retries and shorten timeouts on its callers so connection pools aren't exhausted",
+			"Restore or scale the backend, then watch upstream errors drain before declaring recovery",
+		},
+	},
+	{
+		Name: "resource_exhaustion", Title: "Likely Resource Exhaustion", Emoji: "🔥", Severity: "critical",
+		Requires: map[string]int{"memory": 2, "connection": 3},
+		Steps: []string{
+			"Identify the instances running out of memory and restart or scale them first",
+			"Check whether connection errors come from the same hosts (GC pauses and OOM kills drop connections)",
+			"Capture a heap profile before the next restart to find the leak or oversized workload",
+		},
+	},
+}
+
+// escalation is a rule that fired for the analyzed errors
+type escalation struct {
+	Name       string         `json:"name"`
+	Title      string         `json:"title"`
+	Emoji      string         `json:"emoji,omitempty"`
+	Severity   string         `json:"severity"`
+	Categories map[string]int `json:"categories"` // contributing categories and their error counts
+	Count      int            `json:"count"`
+	Steps      []string       `json:"steps"`
+}
+
+// detectEscalations returns the rules whose thresholds the findings meet, most
+// errors first. A rule name fires once, through its first matching rule.
+func detectEscalations(rules []escalationRule, findings []categoryFinding) []escalation {
+	counts := make(map[string]int, len(findings))
+	for _, f := range findings {
+		counts[f.Category] = f.Count
+	}
+
+	var out []escalation
+	fired := make(map[string]bool)
+	for _, rule := range rules {
+		if fired[rule.Name] {
+			continue
+		}
+		e := escalation{
+			Name: rule.Name, Title: rule.Title, Emoji: rule.Emoji, Severity: rule.Severity,
+			Categories: make(map[string]int, len(rule.Requires)), Steps: rule.Steps,
+		}
+		met := true
+		for category, least := range rule.Requires {
+			if counts[category] < least {
+				met = false
+				break
+			}
+			e.Categories[category] = counts[category]
+			e.Count += counts[category]
+		}
+		if met {
+			fired[rule.Name] = true
+			out = append(out, e)
+		}
+	}
+	sort.SliceStable(out, func(i, j int) bool { return out[i].Count > out[j].Count })
+	return out
+}
+
+// describe renders the contributing categories, e.g. "circuit: 5, upstream: 4"
+func (e escalation) describe() string {
+	names := make([]string, 0, len(e.Categories))
+	for name := range e.Categories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	parts := make([]string, len(names))
+	for i, name := range names {
+		parts[i] = fmt.Sprintf("%s: %d", name, e.Categories[name])
+	}
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// normalizeRule replaces every match of pattern with a placeholder token
+type normalizeRule struct {
+	placeholder string
+	pattern     *regexp.Regexp
+}
+
+// defaultNormalizeRules strip the volatile parts of a message. Order matters:
+// UUIDs and IPs must be replaced before the generic hex and number rules eat them.
+var defaultNormalizeRules = []normalizeRule{
+	{"<uuid>", regexp.MustCompile(`[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}`)},
+	{"<ip>", regexp.MustCompile(`\b\d{1,3}(\.\d{1,3}){3}(:\d+)?\b`)},
+	{"<hex>", regexp.MustCompile(`\b(0x[0-9a-fA-F]+|[0-9a-fA-F]{8,})\b`)},
+	{"<num>", regexp.MustCompile(`\d+(\.\d+)?`)},
+}
+
+// fingerprinter turns raw messages into stable fingerprints so that
+// "refused to 10.0.0.5:5432" and "refused to 10.0.0.9:5432" group together
+type fingerprinter struct {
+	rules []normalizeRule
+}
+
+// newFingerprinter builds a fingerprinter from the defaults plus extra rules.
+// extra is one rule per line in the form "<placeholder>=<regex>"; extra rules
+// run before the defaults so they can claim more specific tokens first.
+func newFingerprinter(extra string) (*fingerprinter, error) {
+	var rules []normalizeRule
+	for _, line := range strings.Split(extra, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		parts := strings.SplitN(line, "=", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("invalid fingerprint rule %q (want <placeholder>=<regex>)", line)
+		}
+		re, err := regexp.Compile(parts[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid fingerprint regex %q: %w", parts[1], err)
+		}
+		rules = append(rules, normalizeRule{placeholder: parts[0], pattern: re})
+	}
+	return &fingerprinter{rules: append(rules, defaultNormalizeRules...)}, nil
+}
+
+// fingerprint returns the normalized form of a message
+func (f *fingerprinter) fingerprint(message string) string {
+	out := message
+	for _, rule := range f.rules {
+		out = rule.pattern.ReplaceAllString(out, rule.placeholder)
+	}
+	return strings.Join(strings.Fields(out), " ")
+}
+
+// errorGroup is one fingerprint with a representative raw message
+type errorGroup struct {
+	Fingerprint string `json:"fingerprint"`
+	Example     string `json:"example"`
+	Count       int    `json:"count"`
+}
+
+// group buckets messages by fingerprint, most frequent first.
+// The first message seen for each fingerprint is kept as its example.
+func (f *fingerprinter) group(messages []string) []errorGroup {
+	index := make(map[string]int)
+	var groups []errorGroup
+	for _, msg := range messages {
+		fp := f.fingerprint(msg)
+		i, ok := index[fp]
+		if !ok {
+			i = len(groups)
+			index[fp] = i
+			groups = append(groups, errorGroup{Fingerprint: fp, Example: msg})
+		}
+		groups[i].Count++
+	}
+	sort.SliceStable(groups, func(i, j int) bool { return groups[i].Count > groups[j].Count })
+	return groups
+}
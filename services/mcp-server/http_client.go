@@ -0,0 +1,27 @@
+package main
+
+import (
+	"net/http"
+	"time"
+)
+
+// Every api-server call goes over one *http.Client, made at startup and kept
+// on MCPServer. Go's default transport keeps only 2 idle connections per
+// host, so concurrent queries to the api-server kept dialing new ones; this
+// transport keeps up to MCP_HTTP_MAX_IDLE_CONNS (default 32) keep-alive
+// connections, closed after MCP_HTTP_IDLE_TIMEOUT (default 90s) unused.
+// TOOL_TIMEOUT bounds each request, including reading the body.
+const (
+	defaultHTTPMaxIdleConns = 32
+	defaultHTTPIdleTimeout  = 90 * time.Second
+)
+
+// newAPIHTTPClient returns the client for api-server calls
+func newAPIHTTPClient(timeout time.Duration, maxIdleConns int, idleTimeout time.Duration) *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	// Every call goes to the same host, so the per-host cap is the one that matters
+	transport.MaxIdleConns = maxIdleConns
+	transport.MaxIdleConnsPerHost = maxIdleConns
+	transport.IdleConnTimeout = idleTimeout
+	return &http.Client{Timeout: timeout, Transport: transport}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"strings"
+	"unicode"
+)
+
+// Intents the keyword router can answer without the LLM
+const (
+	intentAnalysis = "analysis"
+	intentFix      = "fix"
+	intentErrors   = "errors"
+	intentWarnings = "warnings"
+	intentMetrics  = "metrics"
+	intentLogs     = "logs"
+)
+
+// intentPriority breaks ties between equal scores, most specific first
+var intentPriority = []string{intentAnalysis, intentFix, intentErrors, intentWarnings, intentMetrics, intentLogs}
+
+// intentKeywords lists the cues for each intent. Single words match any query
+// word they prefix ("error" matches "errors"); phrases match as substrings.
+var intentKeywords = map[string][]string{
+	intentAnalysis: {"what are", "what is", "summarize", "summary", "analyze", "analysis", "most", "common", "tell me about", "explain"},
+	intentFix:      {"fix", "how to", "solution", "resolve", "recommend", "advice"},
+	intentErrors:   {"error", "issue", "problem", "sus", "fail", "broken", "break"},
+	intentWarnings: {"warn"},
+	intentMetrics:  {"metric", "rate", "error rate", "stat", "performance", "throughput"},
+	intentLogs:     {"log", "recent", "latest", "what"},
+}
+
+// defaultIntentMinScore is the lowest winning score handled by keywords;
+// anything below it is deferred to the LLM. Override with INTENT_MIN_SCORE.
+const defaultIntentMinScore = 1
+
+// intentClassification is the outcome of scoring a query
+type intentClassification struct {
+	Intent string         `json:"intent"` // empty when no intent reached the threshold
+	Score  int            `json:"score"`
+	Scores map[string]int `json:"scores"`
+}
+
+// classifyIntent tallies keyword matches per intent and picks the best one
+func classifyIntent(query string, minScore int) intentClassification {
+	queryLower := strings.ToLower(query)
+	words := strings.FieldsFunc(queryLower, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+
+	scores := make(map[string]int, len(intentKeywords))
+	for intent, keywords := range intentKeywords {
+		for _, keyword := range keywords {
+			if keywordMatches(queryLower, words, keyword) {
+				scores[intent]++
+			}
+		}
+	}
+
+	result := intentClassification{Scores: scores}
+	for _, intent := range intentPriority {
+		if scores[intent] > result.Score {
+			result.Intent, result.Score = intent, scores[intent]
+		}
+	}
+	if result.Score < minScore {
+		result.Intent = ""
+	}
+	return result
+}
+
+func keywordMatches(queryLower string, words []string, keyword string) bool {
+	if strings.Contains(keyword, " ") {
+		return strings.Contains(queryLower, keyword)
+	}
+	for _, word := range words {
+		if strings.HasPrefix(word, keyword) {
+			return true
+		}
+	}
+	return false
+}
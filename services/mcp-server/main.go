@@ -1,38 +1,75 @@
 package main
 
 import (
-	"bytes"
 	"context"
-	"encoding/json"
 	"fmt"
-	"io"
 	"log"
 	"net/http"
 	"os"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/generative-ai-go/genai"
 	"google.golang.org/api/option"
+	"stackmonitor.com/mcp-server/client"
 )
 
 const apiServerURL = "http://api-server:5000/api/v1"
 
 type MCPServer struct {
-	geminiClient *genai.Client
-	apiServerURL string
-	useLLM       bool
+	geminiClient   *genai.Client
+	api            *client.Client // typed api-server client, called through apiBreaker
+	httpClient     *http.Client   // the api client's connections, see http_client.go
+	useLLM         bool
+	fingerprints   *fingerprinter
+	intentMinScore int // below this keyword score, queries go to the LLM
+	apiBreaker     *CircuitBreaker // trips when the api-server keeps failing
+	catalog        []recommendationCategory
+	analysisCache  *analysisCache // repeated LLM analyses, nil when disabled
+	registry       serviceRegistry // the api-server's service metadata, see service_registry.go
+
+	// Result sizes for api-server queries, so similar questions get similar answers
+	logLimit      int    // recent logs (MCP_LOG_LIMIT)
+	logMinLevel   string // least severe level among recent logs, "" for all (MCP_LOG_MIN_LEVEL)
+	analysisLimit int    // ERROR/WARN pulls, listed or analyzed (MCP_ANALYSIS_LIMIT)
+	maxLimit      int    // cap on limits, including ?limit= on /mcp/recommendations (MCP_MAX_LIMIT)
+	metricsRange  string // error-rate window (MCP_METRICS_RANGE)
+}
+
+const (
+	defaultLogLimit      = 20
+	defaultLogMinLevel   = "WARN"
+	defaultAnalysisLimit = 50
+	defaultMaxLimit      = 500
+	defaultMetricsRange  = "1h"
+)
+
+// positiveIntEnv reads a positive integer setting, logging and falling back on bad values
+func positiveIntEnv(name string, def int) int {
+	v := os.Getenv(name)
+	if v == "" {
+		return def
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n <= 0 {
+		log.Printf("Ignoring invalid %s %q", name, v)
+		return def
+	}
+	return n
 }
 
 func NewMCPServer() *MCPServer {
 	apiKey := os.Getenv("GEMINI_API_KEY")
 	useLLM := apiKey != "" && os.Getenv("USE_LLM") == "true"
 
-	var client *genai.Client
+	var gemini *genai.Client
 	if useLLM {
 		ctx := context.Background()
 		var err error
-		client, err = genai.NewClient(ctx, option.WithAPIKey(apiKey))
+		gemini, err = genai.NewClient(ctx, option.WithAPIKey(apiKey))
 		if err != nil {
 			log.Printf("Failed to initialize Gemini client: %v", err)
 			useLLM = false
@@ -43,10 +80,93 @@ func NewMCPServer() *MCPServer {
 		log.Println("MCP Server initialized with keyword matching (set GEMINI_API_KEY and USE_LLM=true for LLM)")
 	}
 
+	// FINGERPRINT_RULES adds "<placeholder>=<regex>" rules (one per line) for error grouping
+	fingerprints, err := newFingerprinter(os.Getenv("FINGERPRINT_RULES"))
+	if err != nil {
+		log.Printf("Ignoring FINGERPRINT_RULES: %v", err)
+		fingerprints, _ = newFingerprinter("")
+	}
+
+	// RECOMMENDATION_CATALOG points at a JSON file replacing the built-in categories
+	catalog, err := loadRecommendationCatalog(os.Getenv("RECOMMENDATION_CATALOG"))
+	if err != nil {
+		log.Printf("Ignoring RECOMMENDATION_CATALOG: %v", err)
+		catalog = defaultRecommendationCatalog
+	}
+
+	intentMinScore := defaultIntentMinScore
+	if v := os.Getenv("INTENT_MIN_SCORE"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			intentMinScore = n
+		} else {
+			log.Printf("Ignoring invalid INTENT_MIN_SCORE %q", v)
+		}
+	}
+
+	// TOOL_TIMEOUT bounds each api-server call so a hung backend can't hang MCP queries
+	toolTimeout := 10 * time.Second
+	if v := os.Getenv("TOOL_TIMEOUT"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil && d > 0 {
+			toolTimeout = d
+		} else {
+			log.Printf("Ignoring invalid TOOL_TIMEOUT %q", v)
+		}
+	}
+
+	httpIdleTimeout := defaultHTTPIdleTimeout
+	if v := os.Getenv("MCP_HTTP_IDLE_TIMEOUT"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil && d > 0 {
+			httpIdleTimeout = d
+		} else {
+			log.Printf("Ignoring invalid MCP_HTTP_IDLE_TIMEOUT %q", v)
+		}
+	}
+	httpClient := newAPIHTTPClient(toolTimeout, positiveIntEnv("MCP_HTTP_MAX_IDLE_CONNS", defaultHTTPMaxIdleConns), httpIdleTimeout)
+
+	// MCP_MAX_RESPONSE_BYTES bounds what is read of each api-server response, so
+	// a runaway response can't exhaust memory
+	apiClient := client.New(apiServerURL, httpClient)
+	apiClient.SetMaxResponseBytes(int64(positiveIntEnv("MCP_MAX_RESPONSE_BYTES", client.DefaultMaxResponseBytes)))
+
+	maxLimit := positiveIntEnv("MCP_MAX_LIMIT", defaultMaxLimit)
+	logLimit := min(positiveIntEnv("MCP_LOG_LIMIT", defaultLogLimit), maxLimit)
+	analysisLimit := min(positiveIntEnv("MCP_ANALYSIS_LIMIT", defaultAnalysisLimit), maxLimit)
+	// Recent logs skip DEBUG/INFO chatter unless MCP_LOG_MIN_LEVEL is set empty
+	logMinLevel, ok := os.LookupEnv("MCP_LOG_MIN_LEVEL")
+	if !ok {
+		logMinLevel = defaultLogMinLevel
+	}
+	analysisCacheTTL := defaultAnalysisCacheTTL
+	if v := os.Getenv("MCP_ANALYSIS_CACHE_TTL"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil && d >= 0 {
+			analysisCacheTTL = d
+		} else {
+			log.Printf("Ignoring invalid MCP_ANALYSIS_CACHE_TTL %q", v)
+		}
+	}
+	if apiKey == "" {
+		analysisCacheTTL = 0 // nothing to save without an LLM, skip the stats lookups
+	}
+	metricsRange := os.Getenv("MCP_METRICS_RANGE")
+	if metricsRange == "" {
+		metricsRange = defaultMetricsRange
+	}
+
 	return &MCPServer{
-		geminiClient: client,
-		apiServerURL: apiServerURL,
-		useLLM:       useLLM,
+		geminiClient:   gemini,
+		api:            apiClient,
+		httpClient:     httpClient,
+		useLLM:         useLLM,
+		fingerprints:   fingerprints,
+		intentMinScore: intentMinScore,
+		apiBreaker:     NewCircuitBreaker("api-server", 5, 30*time.Second),
+		catalog:        catalog,
+		analysisCache:  newAnalysisCache(analysisCacheTTL),
+		logLimit:       logLimit,
+		logMinLevel:    strings.ToUpper(logMinLevel),
+		analysisLimit:  analysisLimit,
+		maxLimit:       maxLimit,
+		metricsRange:   metricsRange,
 	}
 }
 
@@ -54,49 +174,58 @@ func NewMCPServer() *MCPServer {
 func (mcp *MCPServer) handleMCPQuery(c *gin.Context) {
 	var req struct {
 		Query string `json:"query"`
+		Debug  bool   `json:"debug"`  // include intent scores in the response
+		Format string `json:"format"` // markdown (default), plain or slack
+		Preview int   `json:"preview"` // logs shown inline, default 3, capped at maxPreview
+		Depth  string `json:"depth"`   // LLM analysis: quick, standard (default) or deep
 	}
 	if err := c.BindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
 		return
 	}
+	rnd, err := rendererFor(req.Format)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Preview < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "preview must be a positive integer"})
+		return
+	}
+	depth, err := depthFor(req.Depth)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	r := output{renderer: rnd, preview: previewCount(req.Preview)}
 
 	query := req.Query
-	var response string
+	var result queryResult
 
-	// Check if query is asking for analysis/summary (should use LLM with data)
-	queryLower := strings.ToLower(query)
-	needsAnalysis := strings.Contains(queryLower, "what are") || strings.Contains(queryLower, "what is") ||
-		strings.Contains(queryLower, "summarize") || strings.Contains(queryLower, "summary") ||
-		strings.Contains(queryLower, "analyze") || strings.Contains(queryLower, "analysis") ||
-		strings.Contains(queryLower, "most") || strings.Contains(queryLower, "common") ||
-		strings.Contains(queryLower, "tell me about") || strings.Contains(queryLower, "explain")
-	
-	if needsAnalysis {
-		// For analysis queries, fetch data first, then pass to LLM
-		response = mcp.processAnalysisQuery(query)
-	} else {
-		// First try keyword matching for known patterns
-		keywordResponse, hasKeywordMatch := mcp.tryKeywordMatching(query)
-		if hasKeywordMatch {
-			response = keywordResponse
-		} else {
-			// No keyword match - always try LLM if API key is available
-			// This allows natural language queries to be handled by AI
-			response = mcp.processWithGemini(query)
-			
-			// If LLM failed and we have a keyword fallback, use it
-			if strings.Contains(response, "Error") || strings.Contains(response, "trouble connecting") {
-				if keywordResponse != "" {
-					response = keywordResponse + "\n\n" + response // Combine both responses
-				}
-			}
-		}
+	// Score the query against each intent; analysis fetches data and passes it to the LLM,
+	// other confident intents are answered from keywords, the rest go to the LLM
+	classification := classifyIntent(query, mcp.intentMinScore)
+	switch classification.Intent {
+	case intentAnalysis:
+		result = mcp.processAnalysisQuery(query, depth, r)
+	case "":
+		result = mcp.processWithGemini(query, r)
+	default:
+		result = mcp.processWithKeywords(query, classification, r)
 	}
 
-	c.JSON(http.StatusOK, gin.H{"response": response})
+	// status (ok/empty/error) and count let callers branch without parsing the text
+	body := gin.H{"response": result.Response, "status": result.Status, "count": result.Count}
+	if req.Debug || c.Query("debug") == "true" {
+		body["intent"] = classification
+		if result.cache != "" {
+			body["cache"] = result.cache
+		}
+	}
+	c.JSON(http.StatusOK, body)
 }
 
-func (mcp *MCPServer) processWithGemini(query string) string {
+func (mcp *MCPServer) processWithGemini(query string, r output) queryResult {
 	// Always try to initialize if API key is available (even if USE_LLM wasn't set)
 	if mcp.geminiClient == nil {
 		apiKey := os.Getenv("GEMINI_API_KEY")
@@ -105,12 +234,13 @@ func (mcp *MCPServer) processWithGemini(query string) string {
 			client, err := genai.NewClient(ctx, option.WithAPIKey(apiKey))
 			if err != nil {
 				log.Printf("Failed to initialize Gemini client: %v", err)
-				return "I'm not sure how to answer that. Try asking about:\n• 'show me errors' or 'what errors do we have?'\n• 'show warnings'\n• 'what are the recent logs?'\n• 'show metrics' or 'error rate'\n• 'how can I fix these errors?'"
+				return failedResult("I'm not sure how to answer that. Try asking about:\n• 'show me errors' or 'what errors do we have?'\n• 'show warnings'\n• 'what are the recent logs?'\n• 'show metrics' or 'error rate'\n• 'how can I fix these errors?'")
 			}
 			mcp.geminiClient = client
 			log.Println("Gemini client initialized for query")
 		} else {
-			return "I'm not sure how to answer that. Try asking about:\n• 'show me errors' or 'what errors do we have?'\n• 'show warnings'\n• 'what are the recent logs?'\n• 'show metrics' or 'error rate'\n• 'how can I fix these errors?'"
+			// Without an LLM the usage hints are the answer
+			return okResult(0, "I'm not sure how to answer that. Try asking about:\n• 'show me errors' or 'what errors do we have?'\n• 'show warnings'\n• 'what are the recent logs?'\n• 'show metrics' or 'error rate'\n• 'how can I fix these errors?'")
 		}
 	}
 
@@ -206,7 +336,7 @@ Be conversational, helpful, and technical when appropriate. If the user asks som
 	
 	if err != nil || resp == nil {
 		log.Printf("All Gemini models failed, last error: %v", err)
-		return fmt.Sprintf("I'm having trouble connecting to the AI service. Here are some things you can ask:\n\n• 'show me errors' or 'what errors do we have?'\n• 'show warnings'\n• 'what are the recent logs?'\n• 'show metrics' or 'error rate'\n• 'how can I fix these errors?'\n\nError: %v", err)
+		return failedResult(fmt.Sprintf("I'm having trouble connecting to the AI service. Here are some things you can ask:\n\n• 'show me errors' or 'what errors do we have?'\n• 'show warnings'\n• 'what are the recent logs?'\n• 'show metrics' or 'error rate'\n• 'how can I fix these errors?'\n\nError: %v", err))
 	}
 
 	// Extract response text
@@ -225,7 +355,7 @@ Be conversational, helpful, and technical when appropriate. If the user asks som
 	responseText := llmResponse.String()
 	if responseText == "" {
 		log.Printf("Empty response from Gemini")
-		return "I received an empty response from the AI service. Please try rephrasing your question or ask about 'errors', 'warnings', or 'metrics'."
+		return failedResult("I received an empty response from the AI service. Please try rephrasing your question or ask about 'errors', 'warnings', or 'metrics'.")
 	}
 	
 	log.Printf("Gemini response: %s", responseText)
@@ -239,65 +369,56 @@ Be conversational, helpful, and technical when appropriate. If the user asks som
 
 	if needsData {
 		// Try to extract tool call intent from Gemini response
-		toolCallURL, _ := mcp.extractToolFromLLMResponse(responseText, query)
-		if toolCallURL != "" {
+		if tool := mcp.extractToolFromLLMResponse(responseText, query); tool != nil {
 			// Call the tool and append results
-			toolResult, err := mcp.callTool(toolCallURL)
-			if err == nil {
-				return fmt.Sprintf("%s\n\n**Data:**\n%s", responseText, toolResult)
+			if result, err := tool(); err == nil {
+				return okResult(result.count, fmt.Sprintf("%s\n\n%s\n%s", responseText, r.bold("Data:"), prettyJSON(result.data)))
 			}
 		}
 	}
 
 	// Return the LLM response directly
-	return responseText
+	return okResult(0, responseText)
 }
 
-// Process analysis queries - fetch data and analyze with LLM
-func (mcp *MCPServer) processAnalysisQuery(query string) string {
+// Process analysis queries - fetch data and analyze with LLM; depth sets how
+// far back, how many logs and how detailed an answer
+func (mcp *MCPServer) processAnalysisQuery(query string, depth analysisDepth, r output) queryResult {
 	queryLower := strings.ToLower(query)
 	
 	// Determine what data to fetch based on query
-	var dataType string
-	var toolURL string
-	var dataJSON string
-	
-	if strings.Contains(queryLower, "error") {
-		dataType = "errors"
-		toolURL = fmt.Sprintf("%s/logs?level=ERROR&limit=50", mcp.apiServerURL)
-	} else if strings.Contains(queryLower, "warn") {
+	dataType := "errors"
+	filter := client.Filter{Level: "ERROR", Limit: depth.limit(mcp.analysisLimit, mcp.maxLimit), Window: depth.window}
+	if !strings.Contains(queryLower, "error") && strings.Contains(queryLower, "warn") {
 		dataType = "warnings"
-		toolURL = fmt.Sprintf("%s/logs?level=WARN&limit=50", mcp.apiServerURL)
-	} else {
-		// Default to errors if unclear
-		dataType = "errors"
-		toolURL = fmt.Sprintf("%s/logs?level=ERROR&limit=50", mcp.apiServerURL)
+		filter.Level = "WARN"
+	}
+
+	// An identical question over unchanged data gets the previous answer
+	cacheKey, signal := analysisCacheKey(query, depth), ""
+	if mcp.analysisCache != nil {
+		if stats, err := mcp.fetchStats(); err == nil {
+			signal = statsSignal(stats)
+		}
+		if cached, ok := mcp.analysisCache.bySignal(cacheKey, signal, time.Now()); ok {
+			cached.cache = cacheHit
+			return cached
+		}
 	}
 	
 	// Fetch the data
-	dataResult, err := mcp.callTool(toolURL)
+	logs, err := mcp.fetchTail(filter)
 	if err != nil {
-		return fmt.Sprintf("❌ Error fetching %s: %v", dataType, err)
-	}
-	dataJSON = dataResult
-	
-	// Parse to check if we have data
-	var data struct {
-		Logs []struct {
-			Level   string `json:"level"`
-			Service string `json:"service"`
-			Message string `json:"message"`
-			Timestamp string `json:"timestamp"`
-		} `json:"logs"`
-		Count int `json:"count"`
+		return failedResult(fmt.Sprintf("%sError fetching %s: %v", r.icon("❌"), dataType, err))
 	}
 	
-	if err := json.Unmarshal([]byte(dataJSON), &data); err != nil {
-		return fmt.Sprintf("❌ Error parsing data: %v", err)
+	if len(logs) == 0 {
+		return emptyResult(fmt.Sprintf("%sNo %s in the last %s. Your system looks healthy!", r.icon("✅"), dataType, formatWindow(depth.window)))
 	}
-	
-	if len(data.Logs) == 0 {
-		return fmt.Sprintf("✅ No %s found. Your system looks healthy!", dataType)
+	dataHash := hashLogs(logs)
+	if cached, ok := mcp.analysisCache.byData(cacheKey, signal, dataHash, time.Now()); ok {
+		cached.cache = cacheHit
+		return cached
 	}
 	
 	// Initialize LLM client if needed
@@ -309,12 +430,12 @@ func (mcp *MCPServer) processAnalysisQuery(query string) string {
 			if err != nil {
 				log.Printf("Failed to initialize Gemini client: %v", err)
 				// Fallback to keyword-based analysis
-				return mcp.analyzeErrorsAndRecommend(dataJSON)
+				return mcp.analyzeErrorsAndRecommend(logs, r)
 			}
 			mcp.geminiClient = client
 		} else {
 			// Fallback to keyword-based analysis
-			return mcp.analyzeErrorsAndRecommend(dataJSON)
+			return mcp.analyzeErrorsAndRecommend(logs, r)
 		}
 	}
 	
@@ -323,19 +444,12 @@ func (mcp *MCPServer) processAnalysisQuery(query string) string {
 
 The user asked: "%s"
 
-Here are the %s (total: %d):
+Here are the %s from the last %s (total: %d):
 
 %s
 
-Please provide a comprehensive analysis that answers:
-1. What are the most common types of errors/issues?
-2. What patterns do you see?
-3. What are the main causes?
-4. What services are most affected?
-5. Any recommendations?
-
-Format your response in a clear, structured way with headings and bullet points. Be specific and actionable.`, 
-		query, dataType, data.Count, mcp.formatLogsForAnalysis(data.Logs))
+%s`, 
+		query, dataType, formatWindow(depth.window), len(logs), mcp.formatLogsForAnalysis(logs, depth.promptLogs), depth.questions)
 	
 	// Get LLM response
 	ctx := context.Background()
@@ -377,7 +491,7 @@ Format your response in a clear, structured way with headings and bullet points.
 		resp, err = model.GenerateContent(ctx, genai.Text(analysisPrompt))
 		if err != nil {
 			log.Printf("LLM analysis failed: %v, using fallback", err)
-			return mcp.analyzeErrorsAndRecommend(dataJSON)
+			return mcp.analyzeErrorsAndRecommend(logs, r)
 		}
 	}
 	
@@ -396,25 +510,24 @@ Format your response in a clear, structured way with headings and bullet points.
 	
 	responseText := llmResponse.String()
 	if responseText == "" {
-		return mcp.analyzeErrorsAndRecommend(dataJSON)
+		return mcp.analyzeErrorsAndRecommend(logs, r)
 	}
 	
-	return responseText
+	// Only LLM answers are cached; the keyword fallback is cheap to redo
+	result := okResult(len(logs), responseText)
+	mcp.analysisCache.put(cacheKey, signal, dataHash, result, time.Now())
+	result.cache = cacheMiss
+	return result
 }
 
 // Format logs for analysis prompt
-func (mcp *MCPServer) formatLogsForAnalysis(logs []struct {
-	Level     string `json:"level"`
-	Service   string `json:"service"`
-	Message   string `json:"message"`
-	Timestamp string `json:"timestamp"`
-}) string {
+func (mcp *MCPServer) formatLogsForAnalysis(logs []client.Log, maxLines int) string {
 	var result strings.Builder
 	result.WriteString(fmt.Sprintf("Total logs: %d\n\n", len(logs)))
 	
 	for i, log := range logs {
-		if i >= 50 { // Limit to 50 for prompt
-			result.WriteString(fmt.Sprintf("\n... and %d more logs", len(logs)-50))
+		if i >= maxLines { // keep the prompt bounded
+			result.WriteString(fmt.Sprintf("\n... and %d more logs", len(logs)-maxLines))
 			break
 		}
 		result.WriteString(fmt.Sprintf("- [%s] %s: %s\n", log.Level, log.Service, log.Message))
@@ -423,7 +536,8 @@ func (mcp *MCPServer) formatLogsForAnalysis(logs []struct {
 	return result.String()
 }
 
-func (mcp *MCPServer) extractToolFromLLMResponse(llmResponse, originalQuery string) (string, string) {
+// extractToolFromLLMResponse picks the api-server call that backs an LLM answer, or nil for none
+func (mcp *MCPServer) extractToolFromLLMResponse(llmResponse, originalQuery string) func() (toolData, error) {
 	// Simple extraction: look for keywords in LLM response + original query
 	lowerResponse := strings.ToLower(llmResponse + " " + originalQuery)
 
@@ -432,7 +546,10 @@ func (mcp *MCPServer) extractToolFromLLMResponse(llmResponse, originalQuery stri
 		if strings.Contains(lowerResponse, "user") {
 			service = "user-service"
 		}
-		return fmt.Sprintf("%s/logs?service=%s&level=ERROR&limit=10", mcp.apiServerURL, service), "logs"
+		return func() (toolData, error) {
+			logs, err := mcp.fetchLogs(client.Filter{Service: service, Level: "ERROR", Limit: mcp.analysisLimit})
+			return toolData{logs, len(logs)}, err
+		}
 	}
 
 	if strings.Contains(lowerResponse, "metric") || strings.Contains(lowerResponse, "rate") {
@@ -440,106 +557,33 @@ func (mcp *MCPServer) extractToolFromLLMResponse(llmResponse, originalQuery stri
 		if strings.Contains(lowerResponse, "user") {
 			service = "user-service"
 		}
-		return fmt.Sprintf("%s/metrics/error-rate?service=%s&range=1h", mcp.apiServerURL, service), "metrics"
+		return func() (toolData, error) {
+			rate, err := mcp.fetchErrorRate(service)
+			if err != nil {
+				return toolData{}, err
+			}
+			return toolData{rate, int(rate.Count)}, nil
+		}
 	}
 
 	if strings.Contains(lowerResponse, "log") && strings.Contains(lowerResponse, "recent") {
-		return fmt.Sprintf("%s/logs?limit=20", mcp.apiServerURL), "logs"
-	}
-
-	return "", ""
-}
-
-func (mcp *MCPServer) callTool(url string) (string, error) {
-	resp, err := http.Get(url)
-	if err != nil {
-		return "", err
-	}
-	defer resp.Body.Close()
-
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return "", err
-	}
-
-	// Pretty print JSON
-	var prettyJSON bytes.Buffer
-	if err := json.Indent(&prettyJSON, body, "", "  "); err != nil {
-		return string(body), nil
+		return func() (toolData, error) {
+			logs, err := mcp.fetchTail(client.Filter{MinLevel: mcp.logMinLevel, Limit: mcp.logLimit})
+			return toolData{logs, len(logs)}, err
+		}
 	}
 
-	return prettyJSON.String(), nil
+	return nil
 }
 
 // Try keyword matching first, returns response and whether it matched
-func (mcp *MCPServer) tryKeywordMatching(query string) (string, bool) {
+// processWithKeywords answers a classified query by calling the matching API tool
+func (mcp *MCPServer) processWithKeywords(query string, c intentClassification, r output) queryResult {
 	queryLower := strings.ToLower(query)
-	
-	// Check for "fix" or "how to fix" queries
-	hasFixKeywords := strings.Contains(queryLower, "fix") || strings.Contains(queryLower, "how to") ||
-		strings.Contains(queryLower, "solution") || strings.Contains(queryLower, "resolve") ||
-		strings.Contains(queryLower, "recommend") || strings.Contains(queryLower, "advice")
-
-	// Enhanced Intent Recognition - handle various ways of asking about errors/issues
-	hasErrorKeywords := strings.Contains(queryLower, "error") || strings.Contains(queryLower, "errors") ||
-		strings.Contains(queryLower, "issue") || strings.Contains(queryLower, "issues") ||
-		strings.Contains(queryLower, "problem") || strings.Contains(queryLower, "problems") ||
-		strings.Contains(queryLower, "sus") || strings.Contains(queryLower, "suspicious") ||
-		strings.Contains(queryLower, "fail") || strings.Contains(queryLower, "failing") ||
-		strings.Contains(queryLower, "broken") || strings.Contains(queryLower, "break")
-
-	hasWarningKeywords := strings.Contains(queryLower, "warn") || strings.Contains(queryLower, "warning") ||
-		strings.Contains(queryLower, "warning")
-
-	hasMetricKeywords := strings.Contains(queryLower, "metric") || strings.Contains(queryLower, "rate") ||
-		strings.Contains(queryLower, "stat") || strings.Contains(queryLower, "stats") ||
-		strings.Contains(queryLower, "performance") || strings.Contains(queryLower, "throughput")
-
-	hasLogKeywords := strings.Contains(queryLower, "log") || strings.Contains(queryLower, "recent") ||
-		strings.Contains(queryLower, "latest") || 
-		(strings.Contains(queryLower, "what") && (strings.Contains(queryLower, "log") || strings.Contains(queryLower, "error") || strings.Contains(queryLower, "warning")))
-
-	// If no keywords match, return false
-	// Only match if it's clearly about logs/errors/warnings/metrics
-	if !hasFixKeywords && !hasErrorKeywords && !hasWarningKeywords && !hasMetricKeywords && !hasLogKeywords {
-		return "", false
-	}
-
-	// Process with keywords
-	response := mcp.processWithKeywords(query)
-	return response, true
-}
-
-func (mcp *MCPServer) processWithKeywords(query string) string {
-	queryLower := strings.ToLower(query)
-	var toolCallURL string
-	var response string
+	var result queryResult
 
 	log.Printf("Received query: %s", query)
 
-	// Check for "fix" or "how to fix" queries
-	hasFixKeywords := strings.Contains(queryLower, "fix") || strings.Contains(queryLower, "how to") ||
-		strings.Contains(queryLower, "solution") || strings.Contains(queryLower, "resolve") ||
-		strings.Contains(queryLower, "recommend") || strings.Contains(queryLower, "advice")
-
-	// Enhanced Intent Recognition - handle various ways of asking about errors/issues
-	hasErrorKeywords := strings.Contains(queryLower, "error") || strings.Contains(queryLower, "errors") ||
-		strings.Contains(queryLower, "issue") || strings.Contains(queryLower, "issues") ||
-		strings.Contains(queryLower, "problem") || strings.Contains(queryLower, "problems") ||
-		strings.Contains(queryLower, "sus") || strings.Contains(queryLower, "suspicious") ||
-		strings.Contains(queryLower, "fail") || strings.Contains(queryLower, "failing") ||
-		strings.Contains(queryLower, "broken") || strings.Contains(queryLower, "break")
-
-	hasWarningKeywords := strings.Contains(queryLower, "warn") || strings.Contains(queryLower, "warning") ||
-		strings.Contains(queryLower, "warning")
-
-	hasMetricKeywords := strings.Contains(queryLower, "metric") || strings.Contains(queryLower, "rate") ||
-		strings.Contains(queryLower, "stat") || strings.Contains(queryLower, "stats") ||
-		strings.Contains(queryLower, "performance") || strings.Contains(queryLower, "throughput")
-
-	hasLogKeywords := strings.Contains(queryLower, "log") || strings.Contains(queryLower, "recent") ||
-		strings.Contains(queryLower, "latest") || strings.Contains(queryLower, "what")
-
 	// Check for service-specific queries
 	service := ""
 	if strings.Contains(queryLower, "user service") || strings.Contains(queryLower, "user-service") {
@@ -553,372 +597,327 @@ func (mcp *MCPServer) processWithKeywords(query string) string {
 	}
 
 	// Build query URL based on intent
-	if hasFixKeywords && hasErrorKeywords {
+	if c.Intent == intentFix && c.Scores[intentErrors] > 0 {
 		// User wants to know how to fix errors - analyze and provide recommendations
-		toolCallURL := fmt.Sprintf("%s/logs?level=ERROR&limit=50", mcp.apiServerURL)
-		toolResult, err := mcp.callTool(toolCallURL)
+		logs, err := mcp.fetchLogs(client.Filter{Level: "ERROR", Limit: mcp.analysisLimit})
 		if err != nil {
-			response = fmt.Sprintf("❌ Error querying logs: %v", err)
+			result = failedResult(fmt.Sprintf("%sError querying logs: %v", r.icon("❌"), err))
 		} else {
-			recommendations := mcp.analyzeErrorsAndRecommend(toolResult)
-			response = fmt.Sprintf("🔧 **Error Analysis & Recommendations:**\n\n%s", recommendations)
+			result = mcp.analyzeErrorsAndRecommend(logs, r)
+			if result.Status == statusOK {
+				result.Response = fmt.Sprintf("%s%s\n\n%s", r.icon("🔧"), r.bold("Error Analysis & Recommendations:"), result.Response)
+			}
 		}
-	} else if hasFixKeywords {
+	} else if c.Intent == intentFix {
 		// User wants to fix something but didn't specify - get all errors and warnings
-		errorURL := fmt.Sprintf("%s/logs?level=ERROR&limit=30", mcp.apiServerURL)
-		warnURL := fmt.Sprintf("%s/logs?level=WARN&limit=30", mcp.apiServerURL)
-		
-		errorResult, err1 := mcp.callTool(errorURL)
-		warnResult, err2 := mcp.callTool(warnURL)
+		errorLogs, err1 := mcp.fetchLogs(client.Filter{Level: "ERROR", Limit: mcp.analysisLimit})
+		warnLogs, err2 := mcp.fetchLogs(client.Filter{Level: "WARN", Limit: mcp.analysisLimit})
 		
 		if err1 != nil && err2 != nil {
-			response = fmt.Sprintf("❌ Error querying logs: %v", err1)
+			result = failedResult(fmt.Sprintf("%sError querying logs: %v", r.icon("❌"), err1))
 		} else {
 			allIssues := ""
+			count := 0
 			if err1 == nil {
-				formatted := mcp.formatLogResponse(errorResult, "errors")
+				formatted := mcp.formatLogResponse(errorLogs, "errors", r)
 				if formatted != "" {
-					allIssues += "🔴 **Errors:**\n" + formatted + "\n\n"
+					allIssues += r.icon("🔴") + r.bold("Errors:") + "\n" + formatted + "\n\n"
+					count += len(errorLogs)
 				}
 			}
 			if err2 == nil {
-				formatted := mcp.formatLogResponse(warnResult, "warnings")
+				formatted := mcp.formatLogResponse(warnLogs, "warnings", r)
 				if formatted != "" {
-					allIssues += "⚠️ **Warnings:**\n" + formatted + "\n\n"
+					allIssues += r.icon("⚠️") + r.bold("Warnings:") + "\n" + formatted + "\n\n"
+					count += len(warnLogs)
 				}
 			}
 			
 			if allIssues == "" {
-				response = "✅ No errors or warnings found. Your system is healthy!"
+				result = emptyResult(r.icon("✅") + "No errors or warnings found. Your system is healthy!")
 			} else {
-				recommendations := mcp.analyzeErrorsAndRecommend(errorResult)
-				response = fmt.Sprintf("%s🔧 **Recommendations:**\n\n%s", allIssues, recommendations)
+				recommendations := mcp.analyzeErrorsAndRecommend(errorLogs, r)
+				result = okResult(count, fmt.Sprintf("%s%s%s\n\n%s", allIssues, r.icon("🔧"), r.bold("Recommendations:"), recommendations.Response))
 			}
 		}
-	} else if hasErrorKeywords {
+	} else if c.Intent == intentErrors {
 		// Query errors
-		if service != "" {
-			toolCallURL = fmt.Sprintf("%s/logs?service=%s&level=ERROR&limit=20", mcp.apiServerURL, service)
-		} else {
-			toolCallURL = fmt.Sprintf("%s/logs?level=ERROR&limit=20", mcp.apiServerURL)
-		}
-
-		toolResult, err := mcp.callTool(toolCallURL)
+		logs, err := mcp.fetchLogs(client.Filter{Service: service, Level: "ERROR", Limit: mcp.analysisLimit})
 		if err != nil {
-			response = fmt.Sprintf("❌ Error querying logs: %v", err)
+			result = failedResult(fmt.Sprintf("%sError querying logs: %v", r.icon("❌"), err))
 		} else {
-			// Parse the response to get count
-			var data struct {
-				Count int `json:"count"`
-			}
-			json.Unmarshal([]byte(toolResult), &data)
-			
 			// Format with API link
-			formatted := mcp.formatLogResponse(toolResult, "errors")
+			formatted := mcp.formatLogResponse(logs, "errors", r)
 			if formatted == "" {
-				response = "✅ No errors found in recent logs. Your system looks healthy!"
+				result = emptyResult(r.icon("✅") + "No errors found in recent logs. Your system looks healthy!")
 			} else {
-				response = fmt.Sprintf("🔴 **Recent Errors Found**\n\n%s", formatted)
+				result = okResult(len(logs), fmt.Sprintf("%s%s\n\n%s", r.icon("🔴"), r.bold("Recent Errors Found"), formatted))
 			}
 		}
 
-	} else if hasWarningKeywords {
+	} else if c.Intent == intentWarnings {
 		// Query warnings
-		if service != "" {
-			toolCallURL = fmt.Sprintf("%s/logs?service=%s&level=WARN&limit=20", mcp.apiServerURL, service)
-		} else {
-			toolCallURL = fmt.Sprintf("%s/logs?level=WARN&limit=20", mcp.apiServerURL)
-		}
-
-		toolResult, err := mcp.callTool(toolCallURL)
+		logs, err := mcp.fetchLogs(client.Filter{Service: service, Level: "WARN", Limit: mcp.analysisLimit})
 		if err != nil {
-			response = fmt.Sprintf("❌ Error querying logs: %v", err)
+			result = failedResult(fmt.Sprintf("%sError querying logs: %v", r.icon("❌"), err))
 		} else {
-			formatted := mcp.formatLogResponse(toolResult, "warnings")
+			formatted := mcp.formatLogResponse(logs, "warnings", r)
 			if formatted == "" {
-				response = "✅ No warnings found in recent logs."
+				result = emptyResult(r.icon("✅") + "No warnings found in recent logs.")
 			} else {
-				response = fmt.Sprintf("⚠️ **Found Warnings:**\n\n%s", formatted)
+				result = okResult(len(logs), fmt.Sprintf("%s%s\n\n%s", r.icon("⚠️"), r.bold("Found Warnings:"), formatted))
 			}
 		}
 
-	} else if hasMetricKeywords {
+	} else if c.Intent == intentMetrics {
 		// Query metrics
-		if service != "" {
-			toolCallURL = fmt.Sprintf("%s/metrics/error-rate?service=%s&range=1h", mcp.apiServerURL, service)
-		} else {
-			toolCallURL = fmt.Sprintf("%s/metrics/error-rate?range=1h", mcp.apiServerURL)
-		}
-
-		toolResult, err := mcp.callTool(toolCallURL)
+		rate, err := mcp.fetchErrorRate(service)
 		if err != nil {
-			response = fmt.Sprintf("❌ Error querying metrics: %v", err)
+			result = failedResult(fmt.Sprintf("%sError querying metrics: %v", r.icon("❌"), err))
+		} else if rate.Count == 0 {
+			result = emptyResult(fmt.Sprintf("%sNo errors recorded in the last %s.", r.icon("✅"), mcp.metricsRange))
 		} else {
-			response = fmt.Sprintf("📊 **Error Rate Metrics:**\n\n%s", toolResult)
+			result = okResult(int(rate.Count), fmt.Sprintf("%s%s\n\n%s", r.icon("📊"), r.bold("Error Rate Metrics:"), prettyJSON(rate)))
 		}
 
-	} else if hasLogKeywords || queryLower == "" {
+	} else if c.Intent == intentLogs || queryLower == "" {
 		// Query recent logs (default)
-		if service != "" {
-			toolCallURL = fmt.Sprintf("%s/logs?service=%s&limit=20", mcp.apiServerURL, service)
-		} else {
-			toolCallURL = fmt.Sprintf("%s/logs?limit=20", mcp.apiServerURL)
-		}
-
-		toolResult, err := mcp.callTool(toolCallURL)
+		logs, err := mcp.fetchTail(client.Filter{Service: service, MinLevel: mcp.logMinLevel, Limit: mcp.logLimit})
 		if err != nil {
-			response = fmt.Sprintf("❌ Error querying logs: %v", err)
+			result = failedResult(fmt.Sprintf("%sError querying logs: %v", r.icon("❌"), err))
 		} else {
-			formatted := mcp.formatLogResponse(toolResult, "logs")
+			formatted := mcp.formatLogResponse(logs, "logs", r)
 			if formatted == "" {
-				response = "📋 No recent logs found."
+				result = emptyResult(r.icon("📋") + "No recent logs found.")
 			} else {
-				response = fmt.Sprintf("📋 **Recent Logs:**\n\n%s", formatted)
+				result = okResult(len(logs), fmt.Sprintf("%s%s\n\n%s", r.icon("📋"), r.bold("Recent Logs:"), formatted))
 			}
 		}
 
 	} else {
 		// Try to get stats as a fallback
-		toolCallURL = fmt.Sprintf("%s/logs/stats", mcp.apiServerURL)
-		toolResult, err := mcp.callTool(toolCallURL)
+		stats, err := mcp.fetchStats()
 		if err != nil {
-			response = fmt.Sprintf("I'm not sure how to answer that. Try asking about:\n- 'errors' or 'issues'\n- 'warnings'\n- 'metrics' or 'stats'\n- 'recent logs'\n\nError: %v", err)
+			result = failedResult(fmt.Sprintf("I'm not sure how to answer that. Try asking about:\n- 'errors' or 'issues'\n- 'warnings'\n- 'metrics' or 'stats'\n- 'recent logs'\n\nError: %v", err))
 		} else {
-			response = fmt.Sprintf("📊 **System Status:**\n\n%s\n\nTry asking about 'errors', 'warnings', or 'recent logs' for more details.", toolResult)
+			result = okResult(int(stats.Total), fmt.Sprintf("%s%s\n\n%s\n\nTry asking about 'errors', 'warnings', or 'recent logs' for more details.", r.icon("📊"), r.bold("System Status:"), prettyJSON(stats)))
 		}
 	}
 
-	return response
+	return result
 }
 
-// Format log response to be more readable with API links
-func (mcp *MCPServer) formatLogResponse(jsonResponse, logType string) string {
-	var data struct {
-		Logs []struct {
-			Timestamp string `json:"timestamp"`
-			Level     string `json:"level"`
-			Service   string `json:"service"`
-			Message   string `json:"message"`
-			TraceID   string `json:"trace_id"`
-		} `json:"logs"`
-		Count int `json:"count"`
-	}
+// serviceCount is one line of a "by service" breakdown
+type serviceCount struct {
+	Service string
+	Count   int
+}
 
-	if err := json.Unmarshal([]byte(jsonResponse), &data); err != nil {
-		// If parsing fails, return the raw JSON
-		return jsonResponse
+// rankServices orders a breakdown most affected first, ties by name, so
+// identical data always renders in the same order
+func rankServices(counts map[string]int) []serviceCount {
+	ranked := make([]serviceCount, 0, len(counts))
+	for service, count := range counts {
+		ranked = append(ranked, serviceCount{Service: service, Count: count})
 	}
+	sort.Slice(ranked, func(i, j int) bool {
+		if ranked[i].Count != ranked[j].Count {
+			return ranked[i].Count > ranked[j].Count
+		}
+		return ranked[i].Service < ranked[j].Service
+	})
+	return ranked
+}
 
-	if len(data.Logs) == 0 {
+// Format log response to be more readable with API links
+func (mcp *MCPServer) formatLogResponse(logs []client.Log, logType string, r output) string {
+	if len(logs) == 0 {
 		return ""
 	}
+	count := len(logs)
 
 	var result strings.Builder
 	
 	// Calculate service breakdown
 	serviceCount := make(map[string]int)
-	for _, log := range data.Logs {
+	for _, log := range logs {
 		serviceCount[log.Service]++
 	}
 	
 	// Summary first
-	result.WriteString(fmt.Sprintf("## 📊 Summary\n\n"))
-	result.WriteString(fmt.Sprintf("**Total %s:** %d\n\n", logType, data.Count))
+	result.WriteString(r.heading(2, r.icon("📊")+"Summary") + "\n\n")
+	result.WriteString(fmt.Sprintf("%s %d\n\n", r.bold(fmt.Sprintf("Total %s:", logType)), count))
 	
 	if len(serviceCount) > 0 {
-		result.WriteString("**By Service:**\n")
-		for service, count := range serviceCount {
-			result.WriteString(fmt.Sprintf("- %s: %d\n", service, count))
+		result.WriteString(r.bold("By Service:") + "\n")
+		for _, sc := range rankServices(serviceCount) {
+			result.WriteString(fmt.Sprintf("- %s: %d\n", sc.Service, sc.Count))
 		}
 		result.WriteString("\n")
 	}
 
-	// Show only first 3 logs inline for preview
-	displayCount := 3
-	if len(data.Logs) < displayCount {
-		displayCount = len(data.Logs)
-	}
+	// Show only the first few logs inline for preview
+	displayCount := min(r.preview, len(logs))
 
-	result.WriteString("## 🔍 Recent Examples\n\n")
+	result.WriteString(r.heading(2, r.icon("🔍")+"Recent Examples") + "\n\n")
 	for i := 0; i < displayCount; i++ {
-		log := data.Logs[i]
+		log := logs[i]
 		// Truncate message if too long
 		message := log.Message
 		if len(message) > 120 {
 			message = message[:120] + "..."
 		}
-		result.WriteString(fmt.Sprintf("%d. `[%s]` **%s**: %s\n", i+1, log.Level, log.Service, message))
+		result.WriteString(fmt.Sprintf("%d. %s %s: %s\n", i+1, r.code("["+log.Level+"]"), r.bold(log.Service), message))
 	}
 
 	// Add API link to view all
-	if data.Count > displayCount {
-		result.WriteString(fmt.Sprintf("\n_... and **%d more %s**_\n\n", data.Count-displayCount, logType))
+	if count > displayCount {
+		result.WriteString("\n" + r.italic("... and "+r.bold(fmt.Sprintf("%d more %s", count-displayCount, logType))) + "\n\n")
 	}
 	
 	// Generate API query link based on log type
-	apiURL := fmt.Sprintf("http://localhost:5000/api/v1/logs?limit=%d", data.Count)
+	apiURL := fmt.Sprintf("http://localhost:5000/api/v1/logs?limit=%d", count)
 	if logType == "errors" {
-		apiURL = fmt.Sprintf("http://localhost:5000/api/v1/logs?level=ERROR&limit=%d", data.Count)
+		apiURL = fmt.Sprintf("http://localhost:5000/api/v1/logs?level=ERROR&limit=%d", count)
 	} else if logType == "warnings" {
-		apiURL = fmt.Sprintf("http://localhost:5000/api/v1/logs?level=WARN&limit=%d", data.Count)
+		apiURL = fmt.Sprintf("http://localhost:5000/api/v1/logs?level=WARN&limit=%d", count)
 	}
 	
-	result.WriteString("\n---\n\n")
-	result.WriteString(fmt.Sprintf("### 🔗 View Full Details\n\n"))
-	result.WriteString(fmt.Sprintf("**[📊 Open all %d %s in API (New Tab) →](%s)**\n\n", data.Count, logType, apiURL))
+	result.WriteString(r.rule())
+	result.WriteString(r.heading(3, r.icon("🔗")+"View Full Details") + "\n\n")
+	result.WriteString(r.bold(r.link(fmt.Sprintf("%sOpen all %d %s in API (New Tab) →", r.icon("📊"), count, logType), apiURL)) + "\n\n")
 	result.WriteString(fmt.Sprintf("This link opens the complete API response with all logs, timestamps, and trace IDs.\n"))
 
 	return result.String()
 }
 
 // Analyze errors and provide intelligent recommendations
-func (mcp *MCPServer) analyzeErrorsAndRecommend(jsonResponse string) string {
-	var data struct {
-		Logs []struct {
-			Level   string `json:"level"`
-			Service string `json:"service"`
-			Message string `json:"message"`
-		} `json:"logs"`
-		Count int `json:"count"`
-	}
-
-	if err := json.Unmarshal([]byte(jsonResponse), &data); err != nil {
-		return "Unable to analyze errors. Please check the logs manually."
-	}
-
-	if len(data.Logs) == 0 {
-		return "✅ No errors found. Your system is healthy!"
-	}
-
-	// Categorize errors
-	errorCategories := make(map[string][]string)
-	serviceErrors := make(map[string]int)
-
-	for _, log := range data.Logs {
-		msg := strings.ToLower(log.Message)
+// analyzeErrors categorizes error logs against the recommendation catalog
+func (mcp *MCPServer) analyzeErrors(logs []client.Log) *errorAnalysis {
+	analysis := &errorAnalysis{TotalErrors: len(logs), Services: make(map[string]int)}
+	registry := mcp.serviceRegistry()
+	var messages []string
+	var weights []int
+	for _, log := range logs {
+		messages = append(messages, log.Message)
 		service := log.Service
 		if service == "" {
 			service = "unknown"
 		}
-		serviceErrors[service]++
-
-		// Categorize by error type
-		if strings.Contains(msg, "connection") || strings.Contains(msg, "refused") || strings.Contains(msg, "timeout") {
-			errorCategories["connection"] = append(errorCategories["connection"], log.Message)
-		} else if strings.Contains(msg, "permission") || strings.Contains(msg, "access denied") || strings.Contains(msg, "forbidden") {
-			errorCategories["permission"] = append(errorCategories["permission"], log.Message)
-		} else if strings.Contains(msg, "memory") || strings.Contains(msg, "heap") || strings.Contains(msg, "outofmemory") {
-			errorCategories["memory"] = append(errorCategories["memory"], log.Message)
-		} else if strings.Contains(msg, "certificate") || strings.Contains(msg, "ssl") || strings.Contains(msg, "tls") {
-			errorCategories["certificate"] = append(errorCategories["certificate"], log.Message)
-		} else if strings.Contains(msg, "413") || strings.Contains(msg, "entity too large") || strings.Contains(msg, "payload") {
-			errorCategories["payload"] = append(errorCategories["payload"], log.Message)
-		} else if strings.Contains(msg, "502") || strings.Contains(msg, "bad gateway") || strings.Contains(msg, "upstream") {
-			errorCategories["upstream"] = append(errorCategories["upstream"], log.Message)
-		} else if strings.Contains(msg, "circuit") || strings.Contains(msg, "breaker") {
-			errorCategories["circuit"] = append(errorCategories["circuit"], log.Message)
-		} else {
-			errorCategories["other"] = append(errorCategories["other"], log.Message)
-		}
-	}
-
-	var result strings.Builder
-	result.WriteString(fmt.Sprintf("📊 **Analysis:** Found %d errors across %d service(s)\n\n", data.Count, len(serviceErrors)))
-
-	// Service breakdown
-	if len(serviceErrors) > 0 {
-		result.WriteString("**Affected Services:**\n")
-		for service, count := range serviceErrors {
-			result.WriteString(fmt.Sprintf("• %s: %d error(s)\n", service, count))
+		analysis.Services[service]++
+		weights = append(weights, criticalityWeight(registry, service))
+		if info, ok := registry[service]; ok {
+			if analysis.ServiceInfo == nil {
+				analysis.ServiceInfo = make(map[string]client.ServiceInfo)
+			}
+			analysis.ServiceInfo[service] = info
 		}
-		result.WriteString("\n")
 	}
 
-	// Category-based recommendations
-	result.WriteString("**Recommendations by Category:**\n\n")
-
-	if len(errorCategories["connection"]) > 0 {
-		result.WriteString("🔌 **Connection Issues** (" + fmt.Sprintf("%d", len(errorCategories["connection"])) + " errors):\n")
-		result.WriteString("• Check network connectivity between services\n")
-		result.WriteString("• Verify service endpoints and ports are correct\n")
-		result.WriteString("• Review firewall rules and security groups\n")
-		result.WriteString("• Check if target services are running and healthy\n\n")
-	}
-
-	if len(errorCategories["permission"]) > 0 {
-		result.WriteString("🔐 **Permission/Access Issues** (" + fmt.Sprintf("%d", len(errorCategories["permission"])) + " errors):\n")
-		result.WriteString("• Review IAM policies and access controls\n")
-		result.WriteString("• Verify API keys and credentials are valid\n")
-		result.WriteString("• Check S3 bucket policies and permissions\n")
-		result.WriteString("• Ensure service accounts have proper roles\n\n")
-	}
+	// Distinct error patterns, so variable data (IPs, IDs, counts) doesn't fragment the picture
+	analysis.Patterns = mcp.fingerprints.group(messages)
+	analysis.Findings = buildFindings(mcp.catalog, messages, weights)
+	analysis.Escalations = detectEscalations(escalationRules, analysis.Findings)
+	return analysis
+}
 
-	if len(errorCategories["memory"]) > 0 {
-		result.WriteString("💾 **Memory Issues** (" + fmt.Sprintf("%d", len(errorCategories["memory"])) + " errors):\n")
-		result.WriteString("• Increase JVM heap size (-Xmx)\n")
-		result.WriteString("• Review memory-intensive operations\n")
-		result.WriteString("• Check for memory leaks in application code\n")
-		result.WriteString("• Consider horizontal scaling or reducing load\n\n")
+func (mcp *MCPServer) analyzeErrorsAndRecommend(logs []client.Log, r output) queryResult {
+	analysis := mcp.analyzeErrors(logs)
+	if len(analysis.Services) == 0 {
+		return emptyResult(r.icon("✅") + "No errors found. Your system is healthy!")
 	}
 
-	if len(errorCategories["certificate"]) > 0 {
-		result.WriteString("🔒 **Certificate/SSL Issues** (" + fmt.Sprintf("%d", len(errorCategories["certificate"])) + " errors):\n")
-		result.WriteString("• Verify SSL certificates are valid and not expired\n")
-		result.WriteString("• Check certificate chain configuration\n")
-		result.WriteString("• Review trust store configuration\n")
-		result.WriteString("• Ensure proper certificate validation settings\n\n")
-	}
+	var result strings.Builder
+	result.WriteString(fmt.Sprintf("%s%s Found %d errors across %d service(s)\n\n", r.icon("📊"), r.bold("Analysis:"), analysis.TotalErrors, len(analysis.Services)))
 
-	if len(errorCategories["payload"]) > 0 {
-		result.WriteString("📦 **Payload Size Issues** (" + fmt.Sprintf("%d", len(errorCategories["payload"])) + " errors):\n")
-		result.WriteString("• Increase client_max_body_size in Nginx\n")
-		result.WriteString("• Review API request size limits\n")
-		result.WriteString("• Consider implementing file upload limits\n")
-		result.WriteString("• Use chunked uploads for large files\n\n")
+	// Combined incidents first: they outrank any single category's advice
+	for _, e := range analysis.Escalations {
+		result.WriteString(fmt.Sprintf("%s%s (severity: %s; %s):\n", r.icon(e.Emoji), r.bold(e.Title), e.Severity, e.describe()))
+		for i, step := range e.Steps {
+			result.WriteString(fmt.Sprintf("%d. %s\n", i+1, step))
+		}
+		result.WriteString("\n")
 	}
 
-	if len(errorCategories["upstream"]) > 0 {
-		result.WriteString("⬆️ **Upstream/Backend Issues** (" + fmt.Sprintf("%d", len(errorCategories["upstream"])) + " errors):\n")
-		result.WriteString("• Check backend service health and availability\n")
-		result.WriteString("• Review load balancer configuration\n")
-		result.WriteString("• Verify backend endpoints are correct\n")
-		result.WriteString("• Check for upstream timeout settings\n\n")
+	// Service breakdown
+	result.WriteString(r.bold("Affected Services:") + "\n")
+	for _, sc := range rankServices(analysis.Services) {
+		info, ok := analysis.ServiceInfo[sc.Service]
+		result.WriteString(fmt.Sprintf("• %s%s: %d error(s)\n", sc.Service, describeService(info, ok), sc.Count))
+		if info.Runbook != "" {
+			result.WriteString("  " + r.icon("📖") + "Runbook: " + info.Runbook + "\n")
+		}
 	}
+	result.WriteString("\n")
 
-	if len(errorCategories["circuit"]) > 0 {
-		result.WriteString("⚡ **Circuit Breaker Issues** (" + fmt.Sprintf("%d", len(errorCategories["circuit"])) + " errors):\n")
-		result.WriteString("• Review circuit breaker thresholds\n")
-		result.WriteString("• Check dependency service health\n")
-		result.WriteString("• Consider implementing retry logic with backoff\n")
-		result.WriteString("• Monitor circuit breaker state transitions\n\n")
+	groups := analysis.Patterns
+	result.WriteString(fmt.Sprintf("%s (%d distinct):\n", r.bold("Top Error Patterns"), len(groups)))
+	for i, g := range groups {
+		if i == 5 {
+			result.WriteString(fmt.Sprintf("• ...and %d more\n", len(groups)-5))
+			break
+		}
+		result.WriteString(fmt.Sprintf("• %dx %s\n  e.g. %s\n", g.Count, r.code(g.Fingerprint), g.Example))
 	}
+	result.WriteString("\n")
 
-	if len(errorCategories["other"]) > 0 {
-		result.WriteString("📝 **Other Issues** (" + fmt.Sprintf("%d", len(errorCategories["other"])) + " errors):\n")
-		result.WriteString("• Review error logs for specific patterns\n")
-		result.WriteString("• Check application configuration\n")
-		result.WriteString("• Verify dependencies and versions\n")
-		result.WriteString("• Consider enabling more detailed logging\n\n")
+	// Category-based recommendations, most severe and frequent first
+	result.WriteString(r.bold("Recommendations by Category:") + "\n\n")
+	for _, f := range analysis.Findings {
+		result.WriteString(fmt.Sprintf("%s%s (%d errors, severity: %s):\n", r.icon(f.Emoji), r.bold(f.Title), f.Count, f.Severity))
+		for _, advice := range f.Advice {
+			result.WriteString("• " + advice + "\n")
+		}
+		if f.RunbookURL != "" {
+			result.WriteString(r.icon("📖") + "Runbook: " + f.RunbookURL + "\n")
+		}
+		result.WriteString("\n")
 	}
 
-	result.WriteString("💡 **General Tips:**\n")
+	result.WriteString(r.icon("💡") + r.bold("General Tips:") + "\n")
 	result.WriteString("• Monitor error rates over time to identify trends\n")
 	result.WriteString("• Set up alerts for critical error patterns\n")
 	result.WriteString("• Review error logs during peak traffic periods\n")
 	result.WriteString("• Consider implementing automated error recovery mechanisms\n")
 
-	return result.String()
+	return okResult(analysis.TotalErrors, result.String())
+}
+
+// handleRecommendations returns the structured error analysis for the UI.
+// Optional query params: service, limit (default MCP_ANALYSIS_LIMIT, capped at MCP_MAX_LIMIT).
+func (mcp *MCPServer) handleRecommendations(c *gin.Context) {
+	limit := mcp.analysisLimit
+	if v := c.Query("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be a positive integer"})
+			return
+		}
+		limit = min(n, mcp.maxLimit)
+	}
+	logs, err := mcp.fetchLogs(client.Filter{Service: c.Query("service"), Level: "ERROR", Limit: limit})
+	if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, mcp.analyzeErrors(logs))
 }
 
 func main() {
 	mcp := NewMCPServer()
 	r := gin.Default()
 
-	// CORS middleware
+	cors, err := corsPolicyFromEnv()
+	if err != nil {
+		log.Fatalf("Invalid CORS settings: %v", err)
+	}
+
+	// CORS middleware: only allowed origins get the Access-Control headers
 	r.Use(func(c *gin.Context) {
-		c.Header("Access-Control-Allow-Origin", "*")
-		c.Header("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
-		c.Header("Access-Control-Allow-Headers", "Content-Type")
+		if cors.apply(c.Writer.Header(), c.GetHeader("Origin")) {
+			c.Header("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
+			c.Header("Access-Control-Allow-Headers", "Content-Type")
+		}
 		if c.Request.Method == "OPTIONS" {
 			c.AbortWithStatus(204)
 			return
@@ -927,11 +926,14 @@ func main() {
 	})
 
 	r.POST("/mcp/query", mcp.handleMCPQuery)
+	r.GET("/mcp/recommendations", mcp.handleRecommendations)
 	r.GET("/health", func(c *gin.Context) {
 		c.JSON(http.StatusOK, gin.H{
 			"status":     "ok",
 			"llm_enabled": mcp.useLLM,
 			"llm_provider": "gemini",
+			"api_circuit":  mcp.apiBreaker.GetState().String(),
+			"analysis_cache": mcp.analysisCache.stats(),
 		})
 	})
 
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"stackmonitor.com/mcp-server/client"
+)
+
+func TestRankServices(t *testing.T) {
+	tests := []struct {
+		name   string
+		counts map[string]int
+		want   []serviceCount
+	}{
+		{name: "empty", counts: map[string]int{}, want: []serviceCount{}},
+		{name: "by count", counts: map[string]int{"auth": 1, "payment": 7, "checkout": 3},
+			want: []serviceCount{{"payment", 7}, {"checkout", 3}, {"auth", 1}}},
+		{name: "ties by name", counts: map[string]int{"zeta": 2, "alpha": 2, "mid": 2},
+			want: []serviceCount{{"alpha", 2}, {"mid", 2}, {"zeta", 2}}},
+		{name: "mixed", counts: map[string]int{"search": 4, "billing": 9, "api": 4, "cart": 1},
+			want: []serviceCount{{"billing", 9}, {"api", 4}, {"search", 4}, {"cart", 1}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Map order is random: every run must still give the same slice
+			for i := 0; i < 20; i++ {
+				if got := rankServices(tt.counts); !slices.Equal(got, tt.want) {
+					t.Fatalf("rankServices(%v) = %v, want %v", tt.counts, got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+// breakdownLines returns the "- service: n" / "• service: n" lines that
+// follow header in a rendered response
+func breakdownLines(response, header string) []string {
+	_, rest, ok := strings.Cut(response, header+"\n")
+	if !ok {
+		return nil
+	}
+	var lines []string
+	for _, line := range strings.Split(rest, "\n") {
+		if !strings.HasPrefix(line, "- ") && !strings.HasPrefix(line, "• ") {
+			break
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// serviceLogs returns count logs for each service, interleaved
+func serviceLogs(counts map[string]int) []client.Log {
+	var logs []client.Log
+	for service, n := range counts {
+		for i := 0; i < n; i++ {
+			logs = append(logs, client.Log{Timestamp: time.Now(), Level: "ERROR", Service: service, Message: "connection refused by upstream"})
+		}
+	}
+	return logs
+}
+
+func TestServiceBreakdownOrder(t *testing.T) {
+	fingerprints, err := newFingerprinter("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mcp := &MCPServer{fingerprints: fingerprints}
+	mcp.registry.fetched = time.Now() // no api-server to fetch the registry from
+	r := output{renderer: plainRenderer{}, preview: defaultPreview}
+	counts := map[string]int{"auth": 1, "payment": 5, "checkout": 2, "billing": 2}
+
+	tests := []struct {
+		name   string
+		render func(logs []client.Log) string
+		header string
+		want   []string
+	}{
+		{
+			name:   "formatLogResponse",
+			render: func(logs []client.Log) string { return mcp.formatLogResponse(logs, "errors", r) },
+			header: "By Service:",
+			want:   []string{"- payment: 5", "- billing: 2", "- checkout: 2", "- auth: 1"},
+		},
+		{
+			name:   "analyzeErrorsAndRecommend",
+			render: func(logs []client.Log) string { return mcp.analyzeErrorsAndRecommend(logs, r).Response },
+			header: "Affected Services:",
+			want:   []string{"• payment: 5 error(s)", "• billing: 2 error(s)", "• checkout: 2 error(s)", "• auth: 1 error(s)"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			first := tt.render(serviceLogs(counts))
+			if got := breakdownLines(first, tt.header); !slices.Equal(got, tt.want) {
+				t.Fatalf("breakdown = %q, want %q", got, tt.want)
+			}
+			for i := 0; i < 10; i++ {
+				if again := breakdownLines(tt.render(serviceLogs(counts)), tt.header); !slices.Equal(again, tt.want) {
+					t.Fatalf("run %d reordered the breakdown: %q", i, again)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"stackmonitor.com/mcp-server/client"
+)
+
+// severityWeights ranks categories, and services by registry criticality;
+// findings are ordered by category weight x the errors' service weights
+var severityWeights = map[string]int{
+	"critical": 4,
+	"high":     3,
+	"medium":   2,
+	"low":      1,
+}
+
+// recommendationCategory is one entry of the recommendation catalog. A message
+// belongs to the first category with a keyword it contains (case-insensitive);
+// a category without keywords catches everything left over.
+type recommendationCategory struct {
+	Name       string   `json:"name"`
+	Title      string   `json:"title"`
+	Emoji      string   `json:"emoji,omitempty"`
+	Keywords   []string `json:"keywords,omitempty"`
+	Severity   string   `json:"severity"`
+	Confidence float64  `json:"confidence"` // how reliably the keywords identify the category, 0-1
+	RunbookURL string   `json:"runbook_url,omitempty"`
+	Advice     []string `json:"advice"`
+}
+
+// defaultRecommendationCatalog is used unless RECOMMENDATION_CATALOG points at a JSON file
+var defaultRecommendationCatalog = []recommendationCategory{
+	{
+		Name: "connection", Title: "Connection Issues", Emoji: "🔌",
+		Keywords: []string{"connection", "refused", "timeout"},
+		Severity: "high", Confidence: 0.8,
+		Advice: []string{
+			"Check network connectivity between services",
+			"Verify service endpoints and ports are correct",
+			"Review firewall rules and security groups",
+			"Check if target services are running and healthy",
+		},
+	},
+	{
+		Name: "permission", Title: "Permission/Access Issues", Emoji: "🔐",
+		Keywords: []string{"permission", "access denied", "forbidden"},
+		Severity: "high", Confidence: 0.9,
+		Advice: []string{
+			"Review IAM policies and access controls",
+			"Verify API keys and credentials are valid",
+			"Check S3 bucket policies and permissions",
+			"Ensure service accounts have proper roles",
+		},
+	},
+	{
+		Name: "memory", Title: "Memory Issues", Emoji: "💾",
+		Keywords: []string{"memory", "heap", "outofmemory"},
+		Severity: "critical", Confidence: 0.9,
+		Advice: []string{
+			"Increase JVM heap size (-Xmx)",
+			"Review memory-intensive operations",
+			"Check for memory leaks in application code",
+			"Consider horizontal scaling or reducing load",
+		},
+	},
+	{
+		Name: "certificate", Title: "Certificate/SSL Issues", Emoji: "🔒",
+		Keywords: []string{"certificate", "ssl", "tls"},
+		Severity: "critical", Confidence: 0.9,
+		Advice: []string{
+			"Verify SSL certificates are valid and not expired",
+			"Check certificate chain configuration",
+			"Review trust store configuration",
+			"Ensure proper certificate validation settings",
+		},
+	},
+	{
+		Name: "payload", Title: "Payload Size Issues", Emoji: "📦",
+		Keywords: []string{"413", "entity too large", "payload"},
+		Severity: "low", Confidence: 0.7,
+		Advice: []string{
+			"Increase client_max_body_size in Nginx",
+			"Review API request size limits",
+			"Consider implementing file upload limits",
+			"Use chunked uploads for large files",
+		},
+	},
+	{
+		Name: "upstream", Title: "Upstream/Backend Issues", Emoji: "⬆️",
+		Keywords: []string{"502", "bad gateway", "upstream"},
+		Severity: "high", Confidence: 0.8,
+		Advice: []string{
+			"Check backend service health and availability",
+			"Review load balancer configuration",
+			"Verify backend endpoints are correct",
+			"Check for upstream timeout settings",
+		},
+	},
+	{
+		Name: "circuit", Title: "Circuit Breaker Issues", Emoji: "⚡",
+		Keywords: []string{"circuit", "breaker"},
+		Severity: "medium", Confidence: 0.8,
+		Advice: []string{
+			"Review circuit breaker thresholds",
+			"Check dependency service health",
+			"Consider implementing retry logic with backoff",
+			"Monitor circuit breaker state transitions",
+		},
+	},
+	{
+		Name: "other", Title: "Other Issues", Emoji: "📝",
+		Severity: "low", Confidence: 0.3,
+		Advice: []string{
+			"Review error logs for specific patterns",
+			"Check application configuration",
+			"Verify dependencies and versions",
+			"Consider enabling more detailed logging",
+		},
+	},
+}
+
+// loadRecommendationCatalog reads a JSON array of categories from path,
+// or returns the built-in catalog when path is empty
+func loadRecommendationCatalog(path string) ([]recommendationCategory, error) {
+	if path == "" {
+		return defaultRecommendationCatalog, nil
+	}
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var catalog []recommendationCategory
+	if err := json.Unmarshal(raw, &catalog); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	if len(catalog) == 0 {
+		return nil, fmt.Errorf("%s defines no categories", path)
+	}
+	for i := range catalog {
+		cat := &catalog[i]
+		if cat.Name == "" {
+			return nil, fmt.Errorf("category %d has no name", i)
+		}
+		cat.Severity = strings.ToLower(cat.Severity)
+		if _, ok := severityWeights[cat.Severity]; !ok {
+			return nil, fmt.Errorf("category %q: unknown severity %q (want critical, high, medium or low)", cat.Name, cat.Severity)
+		}
+		if cat.Confidence < 0 || cat.Confidence > 1 {
+			return nil, fmt.Errorf("category %q: confidence %v out of range 0-1", cat.Name, cat.Confidence)
+		}
+		if cat.Title == "" {
+			cat.Title = cat.Name
+		}
+		for j, kw := range cat.Keywords {
+			cat.Keywords[j] = strings.ToLower(kw)
+		}
+	}
+	return catalog, nil
+}
+
+// categorize returns the index of the first category matching the message, or -1
+func categorize(catalog []recommendationCategory, message string) int {
+	msg := strings.ToLower(message)
+	for i, cat := range catalog {
+		if len(cat.Keywords) == 0 {
+			return i
+		}
+		for _, kw := range cat.Keywords {
+			if strings.Contains(msg, kw) {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// categoryFinding is a catalog category that matched some of the analyzed errors
+type categoryFinding struct {
+	Category   string   `json:"category"`
+	Title      string   `json:"title"`
+	Emoji      string   `json:"emoji,omitempty"`
+	Severity   string   `json:"severity"`
+	Confidence float64  `json:"confidence"`
+	RunbookURL string   `json:"runbook_url,omitempty"`
+	Count      int      `json:"count"`
+	Score      int      `json:"score"` // severity weight x the errors' criticality weights, the sort key
+	Advice     []string `json:"advice"`
+	Examples   []string `json:"examples"`
+}
+
+// maxFindingExamples caps the raw messages kept per finding
+const maxFindingExamples = 3
+
+// errorAnalysis is the structured result behind analyzeErrorsAndRecommend
+type errorAnalysis struct {
+	TotalErrors int            `json:"total_errors"`
+	Services    map[string]int `json:"services"`
+	// Registry metadata of the affected services that have it, see service_registry.go
+	ServiceInfo map[string]client.ServiceInfo `json:"service_info,omitempty"`
+	Patterns    []errorGroup                  `json:"patterns"`
+	Findings    []categoryFinding             `json:"findings"`
+	Escalations []escalation                  `json:"escalations"` // cross-category incidents, see escalation.go
+}
+
+// buildFindings buckets messages into catalog categories, highest score
+// first; weights[i] is the criticality weight of messages[i]'s service
+func buildFindings(catalog []recommendationCategory, messages []string, weights []int) []categoryFinding {
+	index := make(map[int]int)
+	var findings []categoryFinding
+	for m, msg := range messages {
+		c := categorize(catalog, msg)
+		if c < 0 {
+			continue
+		}
+		i, ok := index[c]
+		if !ok {
+			cat := catalog[c]
+			i = len(findings)
+			index[c] = i
+			findings = append(findings, categoryFinding{
+				Category:   cat.Name,
+				Title:      cat.Title,
+				Emoji:      cat.Emoji,
+				Severity:   cat.Severity,
+				Confidence: cat.Confidence,
+				RunbookURL: cat.RunbookURL,
+				Advice:     cat.Advice,
+			})
+		}
+		f := &findings[i]
+		f.Count++
+		f.Score += severityWeights[f.Severity] * weights[m]
+		if len(f.Examples) < maxFindingExamples {
+			f.Examples = append(f.Examples, msg)
+		}
+	}
+	sort.SliceStable(findings, func(i, j int) bool { return findings[i].Score > findings[j].Score })
+	return findings
+}
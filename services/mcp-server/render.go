@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Output formats for POST /mcp/query ("format"); markdown is the chat UI's
+const (
+	formatMarkdown = "markdown"
+	formatPlain    = "plain" // no emoji or markup, for terminals and plain-text clients
+	formatSlack    = "slack" // Slack mrkdwn: *bold*, <url|text> links, no headings
+)
+
+// renderer styles the canned responses. Text generated by the LLM is passed
+// through as-is.
+type renderer interface {
+	icon(emoji string) string // emoji plus a space before a label, or nothing
+	bold(text string) string
+	italic(text string) string
+	code(text string) string
+	heading(level int, text string) string // one heading line, without the newline
+	link(text, url string) string
+	rule() string // separator before the links section, including its blank lines
+}
+
+// Logs formatLogResponse lists inline, unless the request sets "preview"
+const (
+	defaultPreview = 3
+	maxPreview     = 20 // more belongs in the API link, not a chat message
+)
+
+// output is how one response is written: its renderer plus the preview size
+type output struct {
+	renderer
+	preview int
+}
+
+// previewCount applies the default and the cap to a requested preview size
+func previewCount(requested int) int {
+	if requested <= 0 {
+		return defaultPreview
+	}
+	return min(requested, maxPreview)
+}
+
+// rendererFor returns the renderer for a format name; "" is markdown
+func rendererFor(format string) (renderer, error) {
+	switch strings.ToLower(strings.TrimSpace(format)) {
+	case "", formatMarkdown:
+		return markdownRenderer{}, nil
+	case formatPlain:
+		return plainRenderer{}, nil
+	case formatSlack:
+		return slackRenderer{}, nil
+	}
+	return nil, fmt.Errorf("unknown format %q (want %s, %s or %s)", format, formatMarkdown, formatPlain, formatSlack)
+}
+
+type markdownRenderer struct{}
+
+func (markdownRenderer) icon(emoji string) string     { return emoji + " " }
+func (markdownRenderer) bold(text string) string      { return "**" + text + "**" }
+func (markdownRenderer) italic(text string) string    { return "_" + text + "_" }
+func (markdownRenderer) code(text string) string      { return "`" + text + "`" }
+func (markdownRenderer) link(text, url string) string { return "[" + text + "](" + url + ")" }
+func (markdownRenderer) rule() string                 { return "\n---\n\n" }
+func (markdownRenderer) heading(level int, text string) string {
+	return strings.Repeat("#", level) + " " + text
+}
+
+type plainRenderer struct{}
+
+func (plainRenderer) icon(string) string                { return "" }
+func (plainRenderer) bold(text string) string           { return text }
+func (plainRenderer) italic(text string) string         { return text }
+func (plainRenderer) code(text string) string           { return text }
+func (plainRenderer) heading(_ int, text string) string { return text }
+func (plainRenderer) link(text, url string) string      { return text + " " + url }
+func (plainRenderer) rule() string                      { return "\n" }
+
+type slackRenderer struct{}
+
+func (slackRenderer) icon(emoji string) string          { return emoji + " " }
+func (slackRenderer) bold(text string) string           { return "*" + text + "*" }
+func (slackRenderer) italic(text string) string         { return "_" + text + "_" }
+func (slackRenderer) code(text string) string           { return "`" + text + "`" }
+func (slackRenderer) heading(_ int, text string) string { return "*" + text + "*" }
+func (slackRenderer) link(text, url string) string      { return "<" + url + "|" + text + ">" }
+func (slackRenderer) rule() string                      { return "\n" }
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is returned (wrapped) when the breaker rejects a call; the
+// tools then answer that the api-server is unavailable instead of waiting on it
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+// CircuitBreaker guards the MCP server's calls to the api-server: after
+// maxFailures failures in a row calls are refused for resetTimeout, then a few
+// trial calls decide whether to close it again
+type CircuitBreaker struct {
+	name         string
+	maxFailures  int
+	resetTimeout time.Duration
+	halfOpenMax  int
+
+	mu            sync.RWMutex
+	state         CircuitState
+	failures      int
+	lastFailTime  time.Time
+	halfOpenCount int
+}
+
+type CircuitState int
+
+const (
+	StateClosed CircuitState = iota
+	StateOpen
+	StateHalfOpen
+)
+
+func (s CircuitState) String() string {
+	switch s {
+	case StateClosed:
+		return "CLOSED"
+	case StateOpen:
+		return "OPEN"
+	case StateHalfOpen:
+		return "HALF_OPEN"
+	default:
+		return "UNKNOWN"
+	}
+}
+
+// NewCircuitBreaker creates a new circuit breaker
+func NewCircuitBreaker(name string, maxFailures int, resetTimeout time.Duration) *CircuitBreaker {
+	return &CircuitBreaker{
+		name:         name,
+		maxFailures:  maxFailures,
+		resetTimeout: resetTimeout,
+		halfOpenMax:  3,
+		state:        StateClosed,
+	}
+}
+
+// Execute runs a function through the circuit breaker
+func (cb *CircuitBreaker) Execute(fn func() error) error {
+	if err := cb.beforeRequest(); err != nil {
+		return err
+	}
+
+	err := fn()
+	cb.afterRequest(err)
+	return err
+}
+
+func (cb *CircuitBreaker) beforeRequest() error {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	switch cb.state {
+	case StateOpen:
+		// Check if we should transition to half-open
+		if time.Since(cb.lastFailTime) > cb.resetTimeout {
+			log.Printf("Circuit breaker '%s': Transitioning to HALF_OPEN", cb.name)
+			cb.state = StateHalfOpen
+			cb.halfOpenCount = 0
+			return nil
+		}
+		return fmt.Errorf("circuit breaker '%s' is OPEN: %w", cb.name, ErrCircuitOpen)
+
+	case StateHalfOpen:
+		if cb.halfOpenCount >= cb.halfOpenMax {
+			return fmt.Errorf("circuit breaker '%s' HALF_OPEN limit reached: %w", cb.name, ErrCircuitOpen)
+		}
+		cb.halfOpenCount++
+		return nil
+
+	case StateClosed:
+		return nil
+	}
+
+	return nil
+}
+
+func (cb *CircuitBreaker) afterRequest(err error) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	if err != nil {
+		cb.failures++
+		cb.lastFailTime = time.Now()
+
+		switch cb.state {
+		case StateClosed:
+			if cb.failures >= cb.maxFailures {
+				log.Printf("Circuit breaker '%s': Too many failures (%d), opening circuit",
+					cb.name, cb.failures)
+				cb.state = StateOpen
+			}
+
+		case StateHalfOpen:
+			log.Printf("Circuit breaker '%s': Failure in HALF_OPEN, reopening", cb.name)
+			cb.state = StateOpen
+			cb.halfOpenCount = 0
+		}
+	} else {
+		// Success
+		switch cb.state {
+		case StateClosed:
+			// Reset failure count on success
+			if cb.failures > 0 {
+				cb.failures = 0
+			}
+
+		case StateHalfOpen:
+			// After successful requests in half-open, close the circuit
+			if cb.halfOpenCount >= cb.halfOpenMax {
+				log.Printf("Circuit breaker '%s': Requests successful in HALF_OPEN, closing circuit",
+					cb.name)
+				cb.state = StateClosed
+				cb.failures = 0
+				cb.halfOpenCount = 0
+			}
+		}
+	}
+}
+
+// GetState returns the current state, reported as api_circuit on /health
+func (cb *CircuitBreaker) GetState() CircuitState {
+	cb.mu.RLock()
+	defer cb.mu.RUnlock()
+	return cb.state
+}
@@ -0,0 +1,31 @@
+package main
+
+// Query outcomes, so callers can tell "nothing found" from "the query failed"
+// without parsing the human-readable response
+const (
+	statusOK    = "ok"    // data was found (or the LLM answered)
+	statusEmpty = "empty" // the query ran and matched nothing, e.g. no errors
+	statusError = "error" // the api-server or the LLM could not answer
+)
+
+// queryResult is what query processing produces: Status and Count for programs,
+// Response for the chat UI
+type queryResult struct {
+	Status   string `json:"status"`
+	Count    int    `json:"count"`
+	Response string `json:"response"`
+
+	cache string // analysis cache outcome, shown in debug responses
+}
+
+func okResult(count int, response string) queryResult {
+	return queryResult{Status: statusOK, Count: count, Response: response}
+}
+
+func emptyResult(response string) queryResult {
+	return queryResult{Status: statusEmpty, Response: response}
+}
+
+func failedResult(response string) queryResult {
+	return queryResult{Status: statusError, Response: response}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"stackmonitor.com/mcp-server/client"
+)
+
+// Error analysis weighs services by the criticality in the api-server's
+// registry (GET /services, from its SERVICE_REGISTRY_FILE): a finding's score
+// counts each error by its service's criticality, on the severity scale, so
+// errors of a critical service outrank as many of a low one. Unregistered
+// services count as medium. The registry is fetched at most every
+// serviceRegistryRefresh; when a fetch fails the last copy is kept.
+const serviceRegistryRefresh = 5 * time.Minute
+
+// unregisteredCriticality is the weight of services missing from the registry
+const unregisteredCriticality = "medium"
+
+type serviceRegistry struct {
+	mu       sync.Mutex
+	services map[string]client.ServiceInfo
+	fetched  time.Time
+}
+
+// serviceRegistry returns the registry, refreshing it when stale
+func (mcp *MCPServer) serviceRegistry() map[string]client.ServiceInfo {
+	reg := &mcp.registry
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	if !reg.fetched.IsZero() && time.Since(reg.fetched) < serviceRegistryRefresh {
+		return reg.services
+	}
+	// Also after a failure, so a missing registry isn't asked for on every analysis
+	reg.fetched = time.Now()
+	services, err := mcp.fetchServices()
+	if err != nil {
+		log.Printf("⚠️ Service registry unavailable, keeping the last copy: %v", err)
+		return reg.services
+	}
+	reg.services = services
+	return services
+}
+
+// criticalityWeight is a service's weight in finding scores
+func criticalityWeight(services map[string]client.ServiceInfo, service string) int {
+	if info, ok := services[service]; ok {
+		if w, ok := severityWeights[info.Criticality]; ok {
+			return w
+		}
+	}
+	return severityWeights[unregisteredCriticality]
+}
+
+// describeService annotates a service with its registry metadata, e.g.
+// "(critical, team payments, owner @alice)"; "" when it isn't registered
+func describeService(info client.ServiceInfo, ok bool) string {
+	if !ok {
+		return ""
+	}
+	parts := []string{info.Criticality}
+	if info.Team != "" {
+		parts = append(parts, "team "+info.Team)
+	}
+	if info.Owner != "" {
+		parts = append(parts, "owner "+info.Owner)
+	}
+	return fmt.Sprintf(" (%s)", strings.Join(parts, ", "))
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+
+	"stackmonitor.com/mcp-server/client"
+)
+
+// callTool runs one api-server call through the circuit breaker. Client errors
+// (4xx) and oversized responses are returned to the caller but don't count
+// against the breaker: the api-server is up, the query asked for too much.
+func (mcp *MCPServer) callTool(call func(ctx context.Context) error) error {
+	var callErr error
+	err := mcp.apiBreaker.Execute(func() error {
+		callErr = call(context.Background())
+		var apiErr *client.APIError
+		if (errors.As(callErr, &apiErr) && apiErr.StatusCode < 500) || errors.Is(callErr, client.ErrResponseTooLarge) {
+			return nil
+		}
+		return callErr
+	})
+	if errors.Is(err, ErrCircuitOpen) {
+		return fmt.Errorf("the log API is temporarily unavailable, please try again in a moment")
+	}
+	if errors.Is(callErr, client.ErrResponseTooLarge) {
+		log.Printf("⚠️ %v", callErr)
+		return fmt.Errorf("the log API returned more data than this server accepts; ask for fewer logs or a narrower service")
+	}
+	if err != nil {
+		return err
+	}
+	return callErr
+}
+
+func (mcp *MCPServer) fetchLogs(f client.Filter) ([]client.Log, error) {
+	var logs []client.Log
+	err := mcp.callTool(func(ctx context.Context) (err error) {
+		logs, err = mcp.api.GetLogs(ctx, f)
+		return err
+	})
+	return logs, err
+}
+
+func (mcp *MCPServer) fetchTail(f client.Filter) ([]client.Log, error) {
+	var logs []client.Log
+	err := mcp.callTool(func(ctx context.Context) (err error) {
+		logs, err = mcp.api.Tail(ctx, f)
+		return err
+	})
+	return logs, err
+}
+
+func (mcp *MCPServer) fetchErrorRate(service string) (*client.ErrorRate, error) {
+	var rate *client.ErrorRate
+	err := mcp.callTool(func(ctx context.Context) (err error) {
+		rate, err = mcp.api.ErrorRate(ctx, service, mcp.metricsRange)
+		return err
+	})
+	return rate, err
+}
+
+func (mcp *MCPServer) fetchStats() (*client.Stats, error) {
+	var stats *client.Stats
+	err := mcp.callTool(func(ctx context.Context) (err error) {
+		stats, err = mcp.api.Stats(ctx)
+		return err
+	})
+	return stats, err
+}
+
+func (mcp *MCPServer) fetchServices() (map[string]client.ServiceInfo, error) {
+	var services map[string]client.ServiceInfo
+	err := mcp.callTool(func(ctx context.Context) (err error) {
+		services, err = mcp.api.Services(ctx)
+		return err
+	})
+	return services, err
+}
+
+// toolData is the result of a tool picked for an LLM answer: data to show and how many items it holds
+type toolData struct {
+	data  interface{}
+	count int
+}
+
+// prettyJSON renders tool data for the chat response
+func prettyJSON(v interface{}) string {
+	out, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(out)
+}
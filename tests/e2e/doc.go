@@ -0,0 +1,6 @@
+// Package e2e is the end-to-end pipeline test: go-agent -> ingestion-service
+// -> ClickHouse -> api-server, each started in a container built from this
+// tree. It only builds with the e2e tag:
+//
+//	cd tests/e2e && go test -tags e2e -v -count=1 -timeout 20m .
+package e2e
@@ -0,0 +1,264 @@
+//go:build e2e
+
+package e2e
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/testcontainers/testcontainers-go"
+	tcexec "github.com/testcontainers/testcontainers-go/exec"
+	"github.com/testcontainers/testcontainers-go/network"
+	"github.com/testcontainers/testcontainers-go/wait"
+)
+
+const (
+	// Pinned so a run is reproducible; the compose stack follows latest
+	clickhouseImage = "clickhouse/clickhouse-server:24.8"
+	startupTimeout  = 3 * time.Minute  // for a service to come up once its image is built
+	deliveryTimeout = 60 * time.Second // for written lines to become queryable
+	appLog          = "/logs/application.log"
+)
+
+// stack is one isolated pipeline on its own docker network; nothing is
+// published except the api-server, on a random host port
+type stack struct {
+	api       string // http://host:port of the api-server
+	agent     testcontainers.Container
+	ingestion testcontainers.Container
+}
+
+// startContainer starts req on nw under alias and removes it when the test ends
+func startContainer(t *testing.T, ctx context.Context, nw *testcontainers.DockerNetwork, alias string, req testcontainers.ContainerRequest) testcontainers.Container {
+	t.Helper()
+	greq := testcontainers.GenericContainerRequest{ContainerRequest: req, Started: true}
+	if err := network.WithNetwork([]string{alias}, nw)(&greq); err != nil {
+		t.Fatal(err)
+	}
+	c, err := testcontainers.GenericContainer(ctx, greq)
+	if c != nil {
+		t.Cleanup(func() {
+			if err := c.Terminate(context.Background()); err != nil {
+				t.Logf("terminate %s: %v", alias, err)
+			}
+		})
+	}
+	if err != nil {
+		t.Fatalf("start %s: %v", alias, err)
+	}
+	return c
+}
+
+// service builds a container from a Dockerfile in this tree
+func service(dir string, env map[string]string, files []testcontainers.ContainerFile, waitFor wait.Strategy) testcontainers.ContainerRequest {
+	return testcontainers.ContainerRequest{
+		FromDockerfile: testcontainers.FromDockerfile{Context: dir},
+		Env:            env,
+		Files:          files,
+		WaitingFor:     waitFor,
+	}
+}
+
+// exec runs cmd in c and fails the test unless it exits 0
+func exec(t *testing.T, ctx context.Context, c testcontainers.Container, cmd ...string) {
+	t.Helper()
+	code, out, err := c.Exec(ctx, cmd, tcexec.Multiplexed())
+	if err != nil {
+		t.Fatalf("exec %q: %v", cmd, err)
+	}
+	if code != 0 {
+		output, _ := io.ReadAll(out)
+		t.Fatalf("exec %q exited %d: %s", cmd, code, output)
+	}
+}
+
+// startStack starts the same services as docker-compose.yml, configured so
+// results are deterministic: every sampling rate at 1.0 (config/config.yaml),
+// a fixed SAMPLING_SEED and durable acks
+func startStack(t *testing.T) *stack {
+	ctx := context.Background()
+	nw, err := network.New(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { nw.Remove(context.Background()) })
+
+	clickhouse := startContainer(t, ctx, nw, "clickhouse", testcontainers.ContainerRequest{
+		Image: clickhouseImage,
+		Env: map[string]string{
+			"CLICKHOUSE_DB":                        "stackmonitor",
+			"CLICKHOUSE_USER":                      "default",
+			"CLICKHOUSE_PASSWORD":                  "",
+			"CLICKHOUSE_DEFAULT_ACCESS_MANAGEMENT": "1",
+		},
+		Files: []testcontainers.ContainerFile{
+			{HostFilePath: "../../services/ingestion-service/init-db.sh", ContainerFilePath: "/init-db.sh", FileMode: 0o755},
+		},
+		WaitingFor: wait.ForExec([]string{"clickhouse-client", "--query", "SELECT 1"}).WithStartupTimeout(startupTimeout),
+	})
+	exec(t, ctx, clickhouse, "bash", "/init-db.sh")
+
+	startContainer(t, ctx, nw, "config-service", service("../../services/config-service",
+		map[string]string{"LISTEN_ADDR": ":8080"},
+		[]testcontainers.ContainerFile{{HostFilePath: "config/config.yaml", ContainerFilePath: "/config/config.yaml", FileMode: 0o644}},
+		wait.ForLog("Config server listening at").WithStartupTimeout(startupTimeout)))
+
+	s := &stack{}
+	s.ingestion = startContainer(t, ctx, nw, "ingestion-service", service("../../services/ingestion-service",
+		map[string]string{
+			"CLICKHOUSE_ADDR":     "clickhouse:9000",
+			"LISTEN_ADDR":         ":50051",
+			"HTTP_PORT":           "8082",
+			"ACK_MODE":            "durable",
+			"DEDUP_KEY_FIELDS":    "message,level,service",
+			"DEDUP_EXEMPT_LEVELS": "ERROR",
+		}, nil,
+		wait.ForLog("Ingestion server listening at").WithStartupTimeout(startupTimeout)))
+
+	api := startContainer(t, ctx, nw, "api-server", testcontainers.ContainerRequest{
+		FromDockerfile: testcontainers.FromDockerfile{Context: "../../services/api-server"},
+		Env: map[string]string{
+			"CLICKHOUSE_ADDR":     "clickhouse:9000",
+			"LISTEN_ADDR":         ":5000",
+			"QUIET_SERVICE_AFTER": "0",
+		},
+		ExposedPorts: []string{"5000/tcp"},
+		WaitingFor:   wait.ForHTTP("/health").WithPort("5000/tcp").WithStartupTimeout(startupTimeout),
+	})
+	endpoint, err := api.PortEndpoint(ctx, "5000/tcp", "http")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.api = endpoint
+
+	// The agent only tails files that exist when it starts
+	s.agent = startContainer(t, ctx, nw, "go-agent", service("../../agents/go-agent",
+		map[string]string{
+			"AGENT_ID":         "e2e-agent",
+			"CONFIG_URL":       "config-service:8080",
+			"INGESTION_URL":    "ingestion-service:50051",
+			"HTTP_PORT":        "8081",
+			"SAMPLING_SEED":    "1",
+			"CONFIG_LONG_POLL": "",
+		},
+		[]testcontainers.ContainerFile{{Reader: strings.NewReader(""), ContainerFilePath: appLog, FileMode: 0o644}},
+		wait.ForLog("Started tailing "+appLog).WithStartupTimeout(startupTimeout)))
+	return s
+}
+
+// appendLines appends lines to the file the agent tails
+func (s *stack) appendLines(t *testing.T, lines []string) {
+	t.Helper()
+	cmd := append([]string{"sh", "-c", `printf '%s\n' "$@" >> ` + appLog, "sh"}, lines...)
+	exec(t, context.Background(), s.agent, cmd...)
+}
+
+// flush sends SIGUSR1 to a service, so it sends or inserts its current batch
+// instead of waiting for the batch window
+func flush(t *testing.T, c testcontainers.Container) {
+	t.Helper()
+	exec(t, context.Background(), c, "sh", "-c", "kill -USR1 1")
+}
+
+func (s *stack) getJSON(t *testing.T, path string, params url.Values, v any) {
+	t.Helper()
+	resp, err := http.Get(s.api + "/api/v1" + path + "?" + params.Encode())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		t.Fatalf("GET %s: %v", path, err)
+	}
+}
+
+type logsResponse struct {
+	Status string `json:"status"`
+	Count  int    `json:"count"`
+	Logs   []struct {
+		Level   string `json:"level"`
+		Message string `json:"message"`
+	} `json:"logs"`
+}
+
+// rows returns the service's rows from /logs as sorted "LEVEL message" strings
+func (s *stack) rows(t *testing.T, service string) ([]string, logsResponse) {
+	var resp logsResponse
+	s.getJSON(t, "/logs", url.Values{"service": {service}, "limit": {"100"}}, &resp)
+	var rows []string
+	for _, l := range resp.Logs {
+		rows = append(rows, l.Level+" "+l.Message)
+	}
+	slices.Sort(rows)
+	return rows, resp
+}
+
+func TestPipeline(t *testing.T) {
+	s := startStack(t)
+
+	service := fmt.Sprintf("e2e-%d", time.Now().Unix())
+	ts := time.Now().UTC().Format("2006-01-02T15:04:05.000000")
+	line := func(level, message string) string {
+		return fmt.Sprintf("[%s] [%s] [%s] %s", ts, level, service, message)
+	}
+	lines := []string{
+		line("INFO", "e2e user login ok"),
+		line("WARN", "e2e slow query 1200ms"),
+		line("WARN", "e2e slow query 1200ms"), // duplicate WARN: deduplicated
+		line("ERROR", "e2e payment declined"),
+		line("ERROR", "e2e payment declined"), // duplicate ERROR: exempt, kept
+		line("DEBUG", "e2e cache miss key=42"),
+		service + " this line matches no format", // unparsed: dropped
+	}
+	want := []string{
+		"DEBUG e2e cache miss key=42",
+		"ERROR e2e payment declined",
+		"ERROR e2e payment declined",
+		"INFO e2e user login ok",
+		"WARN e2e slow query 1200ms",
+	}
+
+	s.appendLines(t, lines)
+	flush(t, s.agent)
+	for deadline := time.Now().Add(deliveryTimeout); time.Now().Before(deadline); {
+		if rows, _ := s.rows(t, service); len(rows) >= len(want) {
+			break
+		}
+		time.Sleep(2 * time.Second)
+		flush(t, s.ingestion)
+	}
+	// Give stragglers (e.g. a wrongly kept duplicate) a moment to show up
+	time.Sleep(3 * time.Second)
+
+	rows, resp := s.rows(t, service)
+	if !slices.Equal(rows, want) {
+		t.Errorf("/logs rows = %q, want %q", rows, want)
+	}
+	if resp.Status != "ok" || resp.Count != len(want) {
+		t.Errorf("/logs status/count = %s/%d, want ok/%d", resp.Status, resp.Count, len(want))
+	}
+
+	var rate struct {
+		Count int `json:"count"`
+	}
+	s.getJSON(t, "/metrics/error-rate", url.Values{"service": {service}, "range": {"15m"}}, &rate)
+	if rate.Count != 2 {
+		t.Errorf("/metrics/error-rate count = %d, want 2", rate.Count)
+	}
+
+	var tail struct {
+		Count int `json:"count"`
+	}
+	s.getJSON(t, "/logs/tail", url.Values{"service": {service}, "n": {"100"}}, &tail)
+	if tail.Count != len(want) {
+		t.Errorf("/logs/tail count = %d, want %d", tail.Count, len(want))
+	}
+}
@@ -7,13 +7,17 @@ function LogPanel({ logs: propLogs }) {
   const [autoRefresh, setAutoRefresh] = useState(true);
   const [panelSize, setPanelSize] = useState('normal'); // 'small', 'normal', 'large', 'xlarge'
   const [limit, setLimit] = useState(100);
+  const [minLevel, setMinLevel] = useState('WARN'); // '' shows every level
+
+  const logsURL = `http://localhost:5000/api/v1/logs?limit=${limit}` +
+    (minLevel ? `&min_level=${minLevel}` : '');
 
   // Polling instead of WebSocket for reliability
   useEffect(() => {
     if (!autoRefresh) return;
     
     const fetchLogs = () => {
-      fetch(`http://localhost:5000/api/v1/logs?limit=${limit}`)
+      fetch(logsURL)
         .then(res => res.json())
         .then(data => {
           if (data && data.logs && Array.isArray(data.logs)) {
@@ -31,7 +35,7 @@ function LogPanel({ logs: propLogs }) {
     fetchLogs(); // Initial fetch
     const interval = setInterval(fetchLogs, 5000); // Poll every 5 seconds
     return () => clearInterval(interval);
-  }, [autoRefresh, limit]);
+  }, [autoRefresh, logsURL]);
 
   const filteredLogs = logs.filter(log => {
     if (!filter) return true;
@@ -96,6 +100,17 @@ function LogPanel({ logs: propLogs }) {
             <option value="200">200 logs</option>
             <option value="500">500 logs</option>
           </select>
+          <select
+            value={minLevel}
+            onChange={(e) => setMinLevel(e.target.value)}
+            className="limit-select"
+            title="Least severe level to display"
+          >
+            <option value="ERROR">ERROR</option>
+            <option value="WARN">WARN and above</option>
+            <option value="INFO">INFO and above</option>
+            <option value="">All levels</option>
+          </select>
           <button 
             onClick={() => setAutoRefresh(!autoRefresh)}
             className={`refresh-toggle ${autoRefresh ? 'active' : ''}`}
@@ -105,7 +120,7 @@ function LogPanel({ logs: propLogs }) {
           </button>
           <button 
             onClick={() => {
-              fetch(`http://localhost:5000/api/v1/logs?limit=${limit}`)
+              fetch(logsURL)
                 .then(res => res.json())
                 .then(data => {
                   if (data && data.logs && Array.isArray(data.logs)) {